@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"ap-controller-go/internal/config"
+	"ap-controller-go/internal/security"
+)
+
+// runAdminJWT implements
+// "ap-controller admin-jwt -c FILE -sub NAME -role ROLE [-ttl 1h] [-tenant ID]":
+// it prints an admin JWT signed with controller.admin.jwt_secret_ref,
+// valid in every tenant unless -tenant limits it to one.
+func runAdminJWT(args []string) int {
+	fs := flag.NewFlagSet("admin-jwt", flag.ContinueOnError)
+	path := fs.String("c", defaultConfigPath(), "controller config file")
+	sub := fs.String("sub", "", "admin identity (recorded in audit events)")
+	role := fs.String("role", security.AdminReadonly, "readonly | operator | admin")
+	ttl := fs.Duration("ttl", time.Hour, "token lifetime")
+	tenant := fs.String("tenant", "", "limit the token to this tenant (default: all)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *sub == "" || !security.ValidAdminRole(*role) || *ttl <= 0 {
+		fmt.Fprintln(os.Stderr, "admin-jwt: -sub, a valid -role and a positive -ttl are required")
+		return 2
+	}
+
+	cfg, err := config.Load(*path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *path, err)
+		return 2
+	}
+	if _, ok := cfg.Tenants[*tenant]; *tenant != "" && *tenant != config.DefaultTenant && !ok {
+		fmt.Fprintf(os.Stderr, "admin-jwt: unknown tenant %q\n", *tenant)
+		return 2
+	}
+	ref := cfg.Controller.Admin.JWTSecretRef
+	if ref == "" {
+		fmt.Fprintln(os.Stderr, "admin-jwt: controller.admin.jwt_secret_ref is not set")
+		return 1
+	}
+	secret, err := config.ResolveSecret(ref)
+	if err != nil || secret == "" {
+		fmt.Fprintf(os.Stderr, "admin-jwt: resolve jwt secret: %v\n", err)
+		return 1
+	}
+
+	token, err := security.IssueTenantAdminJWT([]byte(secret), *tenant, *sub, *role, *ttl)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "admin-jwt: %v\n", err)
+		return 1
+	}
+	fmt.Println(token)
+	return 0
+}
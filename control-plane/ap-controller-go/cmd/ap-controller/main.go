@@ -2,30 +2,93 @@ package main
 
 import (
 	"context"
+	"crypto/x509"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	"ap-controller-go/internal/audit"
 	"ap-controller-go/internal/config"
+	"ap-controller-go/internal/events"
 	httpapi "ap-controller-go/internal/http"
+	"ap-controller-go/internal/leader"
+	"ap-controller-go/internal/metrics"
+	"ap-controller-go/internal/notify"
+	"ap-controller-go/internal/pki"
+	"ap-controller-go/internal/policy"
 	"ap-controller-go/internal/security"
+	"ap-controller-go/internal/server"
 	"ap-controller-go/internal/store"
+	"ap-controller-go/internal/tracing"
+	"ap-controller-go/internal/transport"
 )
 
 func main() {
-	cfgPath := os.Getenv("CONTROLLER_CONFIG")
-	if cfgPath == "" {
-		cfgPath = "/app/config/controller.yaml"
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate":
+			os.Exit(runValidate(os.Args[2:]))
+		case "admin-jwt":
+			os.Exit(runAdminJWT(os.Args[2:]))
+		case "policy":
+			os.Exit(runPolicy(os.Args[2:]))
+		case "mqtt-key":
+			os.Exit(runMQTTKey(os.Args[2:]))
+		case "serve":
+		default:
+			fmt.Fprintf(os.Stderr, "unknown command %q\n\n", os.Args[1])
+			usage()
+			os.Exit(2)
+		}
 	}
+	serve(defaultConfigPath())
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `usage: ap-controller [command]
+
+commands:
+  serve                 run the controller (default)
+  validate -c FILE      check controller.yaml and report all errors
+  admin-jwt -c FILE -sub NAME -role ROLE [-ttl 1h] [-tenant ID]
+                        print an admin JWT (controller.admin.jwt_secret_ref)
+  policy verify -keys FILE -in FILE (-headers FILE | -sig SIG -kid KID) [-state FILE]
+                        check a signed runtime policy and that it moves forward
+  policy keygen -out FILE
+                        create an Ed25519 policy signing key
+  mqtt-key -ap AP_ID    print the MQTT message keys of one AP
+`)
+}
 
+// defaultConfigPath returns $CONTROLLER_CONFIG or the container default.
+func defaultConfigPath() string {
+	if p := os.Getenv("CONTROLLER_CONFIG"); p != "" {
+		return p
+	}
+	return "/app/config/controller.yaml"
+}
+
+func serve(cfgPath string) {
 	cfg, err := config.Load(cfgPath)
 	if err != nil {
 		log.Fatalf("load config failed: %v", err)
 	}
 
+	shutdownTracing, err := tracing.Init(context.Background(), "ap-controller")
+	if err != nil {
+		log.Fatalf("init tracing failed: %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = shutdownTracing(ctx)
+	}()
+
 	// audit secret
 	secret := ""
 	if cfg.Controller.Audit.Enabled {
@@ -35,6 +98,9 @@ func main() {
 		}
 	}
 	aud := audit.New(cfg.Controller.Audit.Enabled, secret)
+	aud.Start(1024)
+	defer aud.Close()
+	metrics.SetAuditQueueDepth(aud.QueueDepth)
 
 	// redis password
 	redisPwd := ""
@@ -43,6 +109,8 @@ func main() {
 	}
 
 	st := store.New(cfg, redisPwd)
+	st.AddHook(metrics.RedisHook{})
+	metrics.SetSessionCounter(config.DefaultTenant, st.CountByRole)
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
 	if err := st.Ping(ctx); err != nil {
@@ -67,11 +135,261 @@ func main() {
 
 	pv := fmt.Sprintf("%v", cfg.Dataplane.PolicyVersion)
 
-	srv := httpapi.New(cfg, st, aud, pv, jwtIssuer)
+	// SIGTERM / SIGINT: drain in-flight requests, stop background jobs,
+	// then flush audit + traces via the deferred closers above.
+	sigCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// admin credentials, the enrollment CA and the event hubs are set up
+	// the same way for the default tenant and every configured tenant
+	adminToken := ""
+	if ref := cfg.Controller.AdminTokenRef; ref != "" {
+		// without a token only API keys and admin JWTs are accepted; a
+		// configured token that does not resolve is an error, not a
+		// silently disabled credential
+		adminToken, err = config.ResolveSecret(ref)
+		if err != nil {
+			log.Fatalf("resolve admin token failed: %v", err)
+		}
+	}
+	var adminJWTSecret []byte
+	if ref := cfg.Controller.Admin.JWTSecretRef; ref != "" {
+		secret, err := config.ResolveSecret(ref)
+		if err != nil || secret == "" {
+			log.Printf("admin jwt secret unavailable, admin JWTs rejected: %v", err)
+		} else {
+			adminJWTSecret = []byte(secret)
+		}
+	}
+	var ca *pki.CA
+	if e := cfg.Controller.Enrollment; e.Enabled {
+		ca, err = pki.LoadCA(e.CACertFile, e.CAKeyFile)
+		if err != nil {
+			log.Fatalf("load enrollment ca failed: %v", err)
+		}
+	}
+	var signer *policy.Signer
+	var publishKeys []policy.PublicKey
+	if ps := cfg.Controller.PolicySigning; ps.Enabled {
+		signer, publishKeys, err = loadPolicySigning(ps)
+		if err != nil {
+			log.Fatalf("load policy signing key failed: %v", err)
+		}
+	}
+	// one election for all tenants: the leader runs every tenant's
+	// singleton jobs
+	var el *leader.Elector
+	if ld := cfg.Controller.Leader; ld.Enabled {
+		el = leader.New(st, "jobs", replicaID(cfg),
+			time.Duration(ld.LeaseTTL)*time.Second, time.Duration(ld.RenewInterval)*time.Second)
+	}
+	setup := func(api *httpapi.Server, st *store.Store) {
+		api.SetLeader(el)
+		api.SetAdminToken(adminToken)
+		if adminJWTSecret != nil {
+			api.SetAdminJWTSecret(adminJWTSecret)
+		}
+		if ca != nil {
+			api.EnableEnrollment(ca)
+		}
+		if signer != nil {
+			api.EnablePolicySigning(signer, publishKeys...)
+		}
+		if ev := cfg.Controller.Events; ev.Enabled {
+			// the hub closes SSE streams when sigCtx ends, so they don't
+			// hold up the graceful drain
+			hub := events.NewHub(st, int64(ev.MaxLen))
+			go hub.Run(sigCtx)
+			api.EnableEvents(hub)
+		}
+	}
+
+	api := httpapi.New(cfg, st, aud, pv, jwtIssuer)
+	setup(api, st)
+
+	if sc := cfg.Controller.Sponsor; sc.Enabled {
+		n, err := notify.New(sc.Notifier)
+		if err != nil {
+			log.Fatalf("init sponsor notifier failed: %v", err)
+		}
+		secret, err := config.ResolveSecret(sc.LinkSecretRef)
+		if err != nil {
+			log.Fatalf("resolve sponsor link secret failed: %v", err)
+		}
+		api.EnableSponsor(n, []byte(secret))
+	}
+
+	if mc := cfg.Controller.MQTT; mc.Enabled {
+		mqttPwd := ""
+		if mc.PasswordRef != "" {
+			mqttPwd, err = config.ResolveSecret(mc.PasswordRef)
+			if err != nil {
+				log.Fatalf("resolve mqtt password failed: %v", err)
+			}
+		}
+		mq, err := transport.NewMQTT(transport.MQTTOptions{
+			Config:   mc,
+			Password: mqttPwd,
+			Nonces:   st,
+			Handler:  api.HandleAPMessage,
+		})
+		if err != nil {
+			log.Fatalf("init mqtt failed: %v", err)
+		}
+		defer mq.Close()
+		// runs before mq.Close, so queued events go out first
+		drain := api.EnableTransport(mq)
+		defer drain()
+
+		// the first policy publish below may miss a slow broker; the
+		// inventory monitor retries every heartbeat interval
+		wctx, wcancel := context.WithTimeout(sigCtx, 5*time.Second)
+		if err := mq.WaitConnected(wctx); err != nil {
+			log.Printf("mqtt broker %s not reachable yet, retrying in background", mc.Broker)
+		}
+		wcancel()
+	}
+
+	if err := api.PublishPolicyState(sigCtx); err != nil {
+		log.Printf("publish policy state failed: %v", err)
+	}
+
+	var handler http.Handler
+	apis := []*httpapi.Server{api}
+	stores := map[string]*store.Store{"": st}
+	if len(cfg.Tenants) == 0 {
+		handler = api.Router()
+	} else {
+		rt := httpapi.NewTenantRouter(api, ks)
+		for _, id := range cfg.TenantIDs() {
+			tapi, tst := newTenant(cfg, id, st, aud)
+			setup(tapi, tst)
+			if err := tapi.PublishPolicyState(sigCtx); err != nil {
+				log.Printf("tenant %s: publish policy state failed: %v", id, err)
+			}
+			if err := rt.Add(tapi, cfg.Tenants[id].Hosts); err != nil {
+				log.Fatalf("%v", err)
+			}
+			apis = append(apis, tapi)
+			stores[id] = tst
+			metrics.SetSessionCounter(id, tst.CountByRole)
+		}
+		handler = rt
+		log.Printf("serving %d tenants besides the default", len(cfg.Tenants))
+	}
 
 	addr := fmt.Sprintf("%s:%d", cfg.Controller.Bind.Host, cfg.Controller.Bind.Port)
-	log.Printf("starting %s on %s", cfg.Controller.Name, addr)
-	if err := http.ListenAndServe(addr, srv.Router()); err != nil {
-		log.Fatal(err)
+	srv, err := server.New(server.Options{
+		Addr:     addr,
+		Handler:  handler,
+		TLS:      cfg.Controller.TLS,
+		Timeouts: cfg.Controller.Timeouts,
+		// revoked AP certificates fail the handshake; each tenant keeps
+		// its own denylist
+		VerifyClient: pki.RevocationCheckerFor(func(c *x509.Certificate) pki.RevocationStore {
+			if id, ok := security.APIdentityFromCert(c); ok && stores[id.Tenant] != nil {
+				return stores[id.Tenant]
+			}
+			return st
+		}),
+	})
+	if err != nil {
+		log.Fatalf("init server failed: %v", err)
+	}
+
+	// SIGHUP: reload TLS certificates
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	go func() {
+		for range hup {
+			if err := srv.Reload(); err != nil {
+				log.Printf("tls reload failed: %v", err)
+			} else if srv.TLSEnabled() {
+				log.Printf("tls certificates reloaded")
+			}
+		}
+	}()
+
+	// background jobs; singleton ones only run on the leader
+	sched := leader.NewScheduler(el)
+	for _, a := range apis {
+		sched.Add(a.Jobs()...)
+	}
+	if el != nil {
+		el.OnChange = func(ch leader.Change) {
+			metrics.SetLeader(ch.Leader)
+			api.LeaderChanged(ch)
+			log.Printf("leader: %s (leader=%v, token %d)", ch.Reason, ch.Leader, ch.Token)
+		}
+	}
+
+	// the elector audits its release and jobs audit as they finish: wait
+	// for both before the deferred aud.Close, also when srv.Run fails
+	var bg sync.WaitGroup
+	defer func() {
+		stop()
+		bg.Wait()
+	}()
+	if el != nil {
+		bg.Add(1)
+		go func() {
+			defer bg.Done()
+			el.Run(sigCtx)
+		}()
+	} else {
+		metrics.SetLeader(true)
+	}
+	bg.Add(1)
+	go func() {
+		defer bg.Done()
+		sched.Run(sigCtx)
+	}()
+
+	log.Printf("starting %s on %s (tls=%v, client_auth=%s)",
+		cfg.Controller.Name, addr, srv.TLSEnabled(), cfg.Controller.TLS.ClientAuth)
+	if err := srv.Run(sigCtx); err != nil {
+		log.Printf("server error: %v", err)
+		return
+	}
+	log.Printf("shutdown complete")
+}
+
+// replicaID names this process in leader election. Replicas usually share
+// controller.id, so the host name and pid tell them apart.
+func replicaID(cfg *config.Config) string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s@%s:%d", cfg.Controller.ID, host, os.Getpid())
+}
+
+// newTenant builds the server of tenant id: its config, a store on the
+// shared redis pool under the tenant prefix, its HMAC and JWT keys and an
+// audit logger tagging every event with the tenant.
+func newTenant(cfg *config.Config, id string, st *store.Store, aud *audit.Logger) (*httpapi.Server, *store.Store) {
+	t := cfg.Tenants[id]
+	tcfg := cfg.ForTenant(id)
+	tst := st.WithConfig(tcfg)
+
+	secrets := make(map[string]string, len(t.HMACKeys))
+	for kid, ref := range t.HMACKeys {
+		secret, err := config.ResolveSecret(ref)
+		if err != nil {
+			log.Fatalf("tenant %s: resolve hmac key %s failed: %v", id, kid, err)
+		}
+		secrets[kid] = secret
 	}
+	keys, err := security.NewKeySet(t.CurrentKID, secrets)
+	if err != nil {
+		log.Fatalf("tenant %s: %v", id, err)
+	}
+	jwtSecret, err := config.ResolveSecret(t.JWTSecretRef)
+	if err != nil {
+		log.Fatalf("tenant %s: resolve jwt secret failed: %v", id, err)
+	}
+
+	pv := fmt.Sprintf("%v", tcfg.Dataplane.PolicyVersion)
+	api := httpapi.New(tcfg, tst, aud.With(map[string]any{"tenant": id}), pv,
+		security.NewJWTIssuer([]byte(jwtSecret), 15*time.Minute))
+	api.SetTenant(id, keys)
+	return api, tst
 }
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"ap-controller-go/internal/security"
+)
+
+// runMQTTKey implements "ap-controller mqtt-key -ap AP_ID": it prints the
+// MQTT message keys to provision on one AP, derived from the current
+// portal HMAC key (the same env the controller reads).
+func runMQTTKey(args []string) int {
+	fs := flag.NewFlagSet("mqtt-key", flag.ContinueOnError)
+	apID := fs.String("ap", "", "ap_id the key is for")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *apID == "" || strings.ContainsAny(*apID, "/+#") {
+		fmt.Fprintln(os.Stderr, "mqtt-key: a valid -ap is required")
+		return 2
+	}
+
+	ks, err := security.LoadPortalHMACKeySet()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "mqtt-key: load portal hmac keyset: %v\n", err)
+		return 1
+	}
+	key := ks.Keys[ks.CurrentKID]
+	fmt.Printf("kid=%s\n", ks.CurrentKID)
+	fmt.Printf("ap_key=%s\n", base64.StdEncoding.EncodeToString(security.MessageKey(key, *apID)))
+	fmt.Printf("fleet_key=%s\n", base64.StdEncoding.EncodeToString(security.MessageKey(key, "")))
+	return 0
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/textproto"
+	"os"
+	"path/filepath"
+
+	"ap-controller-go/internal/config"
+	"ap-controller-go/internal/policy"
+)
+
+// loadPolicySigning reads controller.policy_signing: the signer and the
+// further public keys to publish.
+func loadPolicySigning(ps config.PolicySigning) (*policy.Signer, []policy.PublicKey, error) {
+	raw, err := os.ReadFile(ps.KeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	priv, err := policy.ParsePrivateKey(raw)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", ps.KeyFile, err)
+	}
+	var extra []policy.PublicKey
+	for _, f := range ps.PublishKeys {
+		raw, err := os.ReadFile(f)
+		if err != nil {
+			return nil, nil, err
+		}
+		pub, err := policy.ParsePublicKey(raw)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", f, err)
+		}
+		extra = append(extra, policy.PublicKey{KID: policy.KeyID(pub), Alg: policy.SignatureAlg, Key: pub})
+	}
+	return policy.NewSigner(priv, ps.KeyID), extra, nil
+}
+
+// runPolicy implements "ap-controller policy verify|keygen ...".
+func runPolicy(args []string) int {
+	if len(args) > 0 {
+		switch args[0] {
+		case "verify":
+			return runPolicyVerify(args[1:])
+		case "keygen":
+			return runPolicyKeygen(args[1:])
+		}
+	}
+	fmt.Fprintln(os.Stderr, "usage: ap-controller policy verify|keygen [flags]")
+	return 2
+}
+
+// runPolicyVerify implements
+// "ap-controller policy verify -keys FILE -in FILE (-headers FILE | -sig SIG -kid KID) [-state FILE]":
+// it checks the signature of a runtime policy document and, with -state,
+// that it is not older than the last policy accepted, then records it.
+// Exit codes: 0 valid, 1 rejected, 2 usage or read error.
+func runPolicyVerify(args []string) int {
+	fs := flag.NewFlagSet("policy verify", flag.ContinueOnError)
+	keysPath := fs.String("keys", "", "keys JSON (GET /api/v1/policy/keys) or a PEM public key")
+	in := fs.String("in", "-", "runtime policy document, as received (- = stdin)")
+	headers := fs.String("headers", "", "response headers (curl -D) carrying the signature and key id")
+	sig := fs.String("sig", "", "signature (X-Policy-Signature)")
+	kid := fs.String("kid", "", "key id (X-Policy-Key-ID); with a PEM key: default derived from it")
+	state := fs.String("state", "", "file with the last accepted policy version, updated on success")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *keysPath == "" {
+		fmt.Fprintln(os.Stderr, "policy verify: -keys is required")
+		return 2
+	}
+
+	ks, err := readKeySet(*keysPath, *kid)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "policy verify: %s: %v\n", *keysPath, err)
+		return 2
+	}
+	body, err := readInput(*in)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "policy verify: %s: %v\n", *in, err)
+		return 2
+	}
+	if *headers != "" {
+		h, err := readHeaders(*headers)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "policy verify: %s: %v\n", *headers, err)
+			return 2
+		}
+		if *sig == "" {
+			*sig = h.Get(policy.HeaderSignature)
+		}
+		if *kid == "" {
+			*kid = h.Get(policy.HeaderKeyID)
+		}
+	}
+	if *sig == "" {
+		fmt.Fprintln(os.Stderr, "policy verify: the document is not signed (no -sig or signature header)")
+		return 1
+	}
+	if *kid == "" && len(ks.Keys) == 1 {
+		*kid = ks.Keys[0].KID
+	}
+
+	rp, err := policy.Verify(ks, body, *kid, *sig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "policy verify: %v\n", err)
+		return 1
+	}
+	if *state != "" {
+		prev, err := readState(*state)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "policy verify: %s: %v\n", *state, err)
+			return 2
+		}
+		if prev != nil {
+			if err := policy.CheckForward(*prev, rp.Version); err != nil {
+				fmt.Fprintf(os.Stderr, "policy verify: %v\n", err)
+				return 1
+			}
+		}
+		if err := writeState(*state, rp.Version); err != nil {
+			fmt.Fprintf(os.Stderr, "policy verify: %s: %v\n", *state, err)
+			return 2
+		}
+	}
+	fmt.Printf("ok version=%s checksum=%s kid=%s\n", rp.Version.Version, rp.Version.Checksum, *kid)
+	return 0
+}
+
+// runPolicyKeygen implements "ap-controller policy keygen -out FILE":
+// it writes a new PKCS#8 PEM Ed25519 private key (mode 0600) and prints
+// the public key and its derived key id.
+func runPolicyKeygen(args []string) int {
+	fs := flag.NewFlagSet("policy keygen", flag.ContinueOnError)
+	out := fs.String("out", "", "private key file to create")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *out == "" {
+		fmt.Fprintln(os.Stderr, "policy keygen: -out is required")
+		return 2
+	}
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "policy keygen: %v\n", err)
+		return 1
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "policy keygen: %v\n", err)
+		return 1
+	}
+	f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "policy keygen: %v\n", err)
+		return 1
+	}
+	err = pem.Encode(f, &pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "policy keygen: %v\n", err)
+		return 1
+	}
+
+	pubPEM, _ := policy.EncodePublicKey(pub)
+	fmt.Printf("# key_id: %s\n%s", policy.KeyID(pub), pubPEM)
+	return 0
+}
+
+// readKeySet reads a KeySet, or a single PEM public key named kid (""
+// = derived from the key).
+func readKeySet(path, kid string) (policy.KeySet, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return policy.KeySet{}, err
+	}
+	if bytes.Contains(raw, []byte("-----BEGIN")) {
+		pub, err := policy.ParsePublicKey(raw)
+		if err != nil {
+			return policy.KeySet{}, err
+		}
+		if kid == "" {
+			kid = policy.KeyID(pub)
+		}
+		return policy.KeySet{Keys: []policy.PublicKey{{KID: kid, Alg: policy.SignatureAlg, Key: pub}}}, nil
+	}
+	var ks policy.KeySet
+	if err := json.Unmarshal(raw, &ks); err != nil {
+		return policy.KeySet{}, err
+	}
+	if len(ks.Keys) == 0 {
+		return policy.KeySet{}, errors.New("no keys")
+	}
+	return ks, nil
+}
+
+func readInput(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(path)
+}
+
+// readHeaders parses a curl -D dump. With redirects it holds several
+// responses; the last one counts.
+func readHeaders(path string) (textproto.MIMEHeader, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var last textproto.MIMEHeader
+	r := textproto.NewReader(bufio.NewReader(bytes.NewReader(raw)))
+	for {
+		if _, err := r.ReadLine(); err != nil { // status line
+			break
+		}
+		h, err := r.ReadMIMEHeader()
+		if h != nil {
+			last = h
+		}
+		if err != nil {
+			break
+		}
+	}
+	if last == nil {
+		return nil, errors.New("no headers")
+	}
+	return last, nil
+}
+
+func readState(path string) (*policy.ControllerVersion, error) {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var v policy.ControllerVersion
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// writeState replaces the state file atomically.
+func writeState(path string, v policy.ControllerVersion) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".policy-state-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(raw, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"ap-controller-go/internal/config"
+)
+
+// runValidate implements "ap-controller validate -c FILE".
+// Exit codes: 0 valid, 1 invalid, 2 usage or read error.
+func runValidate(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	path := fs.String("c", defaultConfigPath(), "controller config file")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	_, err := config.Parse(*path)
+	if err == nil {
+		fmt.Printf("%s: ok\n", *path)
+		return 0
+	}
+
+	var verr *config.ValidationError
+	if !errors.As(err, &verr) {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *path, err)
+		return 2
+	}
+	for _, fe := range verr.Errors {
+		fmt.Fprintf(os.Stderr, "%s:%d:%d: %s: %s\n", *path, fe.Line, fe.Column, fe.Path, fe.Msg)
+	}
+	fmt.Fprintf(os.Stderr, "%d error(s)\n", len(verr.Errors))
+	return 1
+}
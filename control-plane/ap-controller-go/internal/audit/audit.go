@@ -22,10 +22,25 @@ func (l *Logger) Sign(payload []byte) string {
 	return hex.EncodeToString(m.Sum(nil))
 }
 
+// With returns a logger that adds fields to every event and writes
+// through l, sharing its queue and secret.
+func (l *Logger) With(fields map[string]any) *Logger {
+	return &Logger{Enabled: l.Enabled, Secret: l.Secret, fields: fields, parent: l}
+}
+
 func (l *Logger) Write(event map[string]any) {
 	if !l.Enabled {
 		return
 	}
+	for k, v := range l.fields {
+		if _, ok := event[k]; !ok {
+			event[k] = v
+		}
+	}
+	if l.parent != nil {
+		l.parent.Write(event)
+		return
+	}
 	if _, ok := event["ts"]; !ok {
 		event["ts"] = time.Now().Unix()
 	}
@@ -42,6 +57,48 @@ func (l *Logger) Write(event map[string]any) {
 
 	tmp["sig"] = sig
 	out, _ := json.Marshal(tmp)
+	line := append(out, '\n')
+
+	if l.queue != nil {
+		// blocks when full: audit events must not be dropped
+		l.queue <- line
+		return
+	}
 	// 写 stdout，docker logs 里就是 JSON（你现在就是这么看的）
-	_, _ = os.Stdout.Write(append(out, '\n'))
+	_, _ = os.Stdout.Write(line)
+}
+
+// Start switches the logger to asynchronous writes through a queue of
+// the given size, so request handlers never wait on stdout.
+// Call Close on shutdown to flush pending events.
+func (l *Logger) Start(size int) {
+	if l.queue != nil || size <= 0 {
+		return
+	}
+	l.queue = make(chan []byte, size)
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		for line := range l.queue {
+			_, _ = os.Stdout.Write(line)
+		}
+	}()
+}
+
+// Close flushes queued events and stops the writer.
+// No Write may happen after Close.
+func (l *Logger) Close() {
+	if l.queue == nil {
+		return
+	}
+	close(l.queue)
+	l.wg.Wait()
+}
+
+// QueueDepth is the number of events waiting to be written.
+func (l *Logger) QueueDepth() int {
+	if l.parent != nil {
+		return l.parent.QueueDepth()
+	}
+	return len(l.queue)
 }
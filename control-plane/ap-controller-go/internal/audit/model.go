@@ -1,6 +1,16 @@
 package audit
 
+import "sync"
+
 type Logger struct {
 	Enabled bool
 	Secret  []byte
+
+	// async mode (see Start); nil queue means synchronous writes
+	queue chan []byte
+	wg    sync.WaitGroup
+
+	// set by With: fields added to every event, written through parent
+	fields map[string]any
+	parent *Logger
 }
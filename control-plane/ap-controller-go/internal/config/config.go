@@ -10,24 +10,13 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
+// Load reads, validates and finalizes controller.yaml.
+// Secret references are resolved, so the referenced env must be present.
 func Load(path string) (*Config, error) {
-	b, err := os.ReadFile(path)
+	cfg, err := Parse(path)
 	if err != nil {
 		return nil, err
 	}
-	var cfg Config
-	if err := yaml.Unmarshal(b, &cfg); err != nil {
-		return nil, err
-	}
-	if cfg.Redis.Prefix == "" {
-		cfg.Redis.Prefix = "session:"
-	}
-	if cfg.Dataplane.LanIF == "" {
-		return nil, fmt.Errorf("dataplane.lan_if must be set")
-	}
-	if cfg.Dataplane.PortalIP == "" {
-		return nil, fmt.Errorf("dataplane.portal_ip must be set")
-	}
 
 	// Resolve controller HMAC secret
 	if cfg.Controller.HMACSecret != "" {
@@ -39,6 +28,34 @@ func Load(path string) (*Config, error) {
 		log.Printf("controller hmac secret loaded: %v", cfg.Controller.HMACSecret != "")
 	}
 
+	return cfg, nil
+}
+
+// Parse reads controller.yaml, applies defaults and runs Validate.
+// Unlike Load it does not touch secrets, so it is safe for offline checks.
+func Parse(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseBytes(b)
+}
+
+// ParseBytes is Parse for an in-memory document.
+func ParseBytes(b []byte) (*Config, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(b, &root); err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := root.Decode(&cfg); err != nil {
+		return nil, err
+	}
+	applyDeprecated(&cfg)
+	applyDefaults(&cfg)
+	if err := Validate(&cfg, &root); err != nil {
+		return nil, err
+	}
 	return &cfg, nil
 }
 
@@ -59,3 +76,179 @@ func ResolveSecret(ref string) (string, error) {
 	// future extension: file:/path, vault:..., kms:...
 	return ref, nil
 }
+
+// applyDeprecated moves settings from their old keys, with a warning.
+func applyDeprecated(cfg *Config) {
+	c := &cfg.Controller
+	if ref := c.Enrollment.AdminTokenRef; ref != "" {
+		log.Printf("config: controller.enrollment.admin_token_ref is deprecated, use controller.admin_token_ref")
+		if c.AdminTokenRef == "" {
+			c.AdminTokenRef = ref
+		}
+	}
+}
+
+// applyDefaults fills optional settings left empty in controller.yaml.
+func applyDefaults(cfg *Config) {
+	if cfg.Redis.Prefix == "" {
+		cfg.Redis.Prefix = "session:"
+	}
+
+	t := &cfg.Controller.Timeouts
+	for _, d := range []struct {
+		v   *int
+		def int
+	}{
+		{&t.ReadHeader, 5},
+		{&t.Read, 15},
+		{&t.Write, 30},
+		{&t.Idle, 120},
+		{&t.Shutdown, 20},
+	} {
+		if *d.v == 0 {
+			*d.v = d.def
+		}
+	}
+
+	if cfg.Controller.TLS.ClientAuth == "" {
+		cfg.Controller.TLS.ClientAuth = ClientAuthNone
+	}
+	if cfg.Controller.TLS.ReloadInterval == 0 {
+		cfg.Controller.TLS.ReloadInterval = 60
+	}
+
+	inv := &cfg.Controller.Inventory
+	if inv.HeartbeatInterval == 0 {
+		inv.HeartbeatInterval = 30
+	}
+	if inv.OfflineAfter == 0 {
+		inv.OfflineAfter = 3 * inv.HeartbeatInterval
+	}
+
+	if cfg.Controller.Convergence.StaleAfter == 0 {
+		cfg.Controller.Convergence.StaleAfter = 900
+	}
+	cn := &cfg.Controller.Convergence.Canary
+	if cn.MinLogins == 0 {
+		cn.MinLogins = 20
+	}
+	if cn.MaxErrorIncrease == 0 {
+		cn.MaxErrorIncrease = 10
+	}
+
+	ld := &cfg.Controller.Leader
+	if ld.LeaseTTL == 0 {
+		ld.LeaseTTL = 15
+	}
+	if ld.RenewInterval == 0 {
+		ld.RenewInterval = 5
+	}
+
+	mg := &cfg.Controller.Migration
+	if mg.BatchSize == 0 {
+		mg.BatchSize = 500
+	}
+	if mg.Steps == 0 {
+		mg.Steps = 20
+	}
+
+	if cfg.Controller.Vouchers.MaxBatch == 0 {
+		cfg.Controller.Vouchers.MaxBatch = 1000
+	}
+
+	sp := &cfg.Controller.Sponsor
+	if sp.Duration == 0 {
+		sp.Duration = 28800
+	}
+	if sp.RequestTTL == 0 {
+		sp.RequestTTL = 900
+	}
+
+	ev := &cfg.Controller.Events
+	if ev.MaxLen == 0 {
+		ev.MaxLen = 10000
+	}
+	if ev.Keepalive == 0 {
+		ev.Keepalive = 15
+	}
+
+	mq := &cfg.Controller.MQTT
+	if mq.TopicPrefix == "" {
+		mq.TopicPrefix = "ap-controller"
+	}
+	if mq.ClientID == "" {
+		mq.ClientID = cfg.Controller.ID
+	}
+
+	cfg.Controller.Batch = cfg.Controller.Batch.WithDefaults()
+
+	rl := &cfg.Controller.RateLimit
+	for _, b := range []struct {
+		b            *Bucket
+		rate, window int
+	}{{&rl.MAC, 5, 60}, {&rl.IP, 20, 60}, {&rl.Client, 600, 60}} {
+		if b.b.Window == 0 {
+			b.b.Window = b.window
+		}
+		if b.b.Rate == 0 {
+			b.b.Rate = b.rate
+		}
+		if b.b.Burst == 0 {
+			b.b.Burst = b.b.Rate
+		}
+	}
+	lo := &rl.Lockout
+	if lo.Failures == 0 {
+		lo.Failures = 5
+	}
+	if lo.Window == 0 {
+		lo.Window = 300
+	}
+	if lo.Base == 0 {
+		lo.Base = 60
+	}
+	if lo.Max == 0 {
+		lo.Max = 3600
+	}
+
+	cf := &cfg.Controller.ClientFeed
+	if cf.MaxLen == 0 {
+		cf.MaxLen = 100000
+	}
+	if cf.PageSize == 0 {
+		cf.PageSize = 1000
+	}
+
+	for id, t := range cfg.Tenants {
+		if t.CurrentKID == "" && len(t.HMACKeys) == 1 {
+			for kid := range t.HMACKeys {
+				t.CurrentKID = kid
+			}
+			cfg.Tenants[id] = t
+		}
+	}
+
+	e := &cfg.Controller.Enrollment
+	if e.CertTTL == 0 {
+		e.CertTTL = 7 * 24 * 3600
+	}
+	if e.TokenTTL == 0 {
+		e.TokenTTL = 24 * 3600
+	}
+	if e.RenewBefore == 0 {
+		e.RenewBefore = e.CertTTL / 3
+	}
+}
+
+// WithPolicy returns cfg with rules and bypass swapped in, like a tenant
+// section; nil keeps the section of cfg.
+func (c *Config) WithPolicy(rules []RoleRule, bypass *Bypass) *Config {
+	pc := *c
+	if rules != nil {
+		pc.RoleRules = rules
+	}
+	if bypass != nil {
+		pc.Bypass = *bypass
+	}
+	return &pc
+}
@@ -8,6 +8,8 @@ type Config struct {
 	RoleRules  []RoleRule         `yaml:"role_rules"`
 	Bypass     Bypass             `yaml:"bypass"`
 	Dataplane  Dataplane          `yaml:"dataplane"`
+	// Tenants served besides the top-level (default) tenant, by id
+	Tenants map[string]Tenant `yaml:"tenants"`
 }
 
 type Controller struct {
@@ -26,6 +28,320 @@ type Controller struct {
 		Algo      string `yaml:"algo"`
 	} `yaml:"audit"`
 	HMACSecret string `yaml:"hmac_secret"`
+	// AdminTokenRef is a static bearer token with the admin role for
+	// /api/v1/admin and other admin endpoints (env:/file: ref), e.g. to
+	// create the first API keys.
+	AdminTokenRef string        `yaml:"admin_token_ref"`
+	Admin         Admin         `yaml:"admin"`
+	TLS           TLS           `yaml:"tls"`
+	Timeouts      Timeouts      `yaml:"timeouts"`
+	Enrollment    Enrollment    `yaml:"enrollment"`
+	PolicySigning PolicySigning `yaml:"policy_signing"`
+	Inventory     Inventory     `yaml:"inventory"`
+	Convergence   Convergence   `yaml:"convergence"`
+	Leader        Leader        `yaml:"leader"`
+	Migration     Migration     `yaml:"migration"`
+	Vouchers      Vouchers      `yaml:"vouchers"`
+	Sponsor       Sponsor       `yaml:"sponsor"`
+	Events        Events        `yaml:"events"`
+	MQTT          MQTT          `yaml:"mqtt"`
+	Batch         Batch         `yaml:"batch"`
+	ClientFeed    ClientFeed    `yaml:"client_feed"`
+	RateLimit     RateLimit     `yaml:"rate_limit"`
+}
+
+// Tenant is a customer campus sharing this controller and redis. Its
+// sessions, nonces and other redis data live under
+// <redis.prefix>t:<id>: and its audit events carry "tenant": <id>.
+//
+// Roles, profiles, HMAC clients and the JWT key are the tenant's own;
+// role_rules, bypass and dataplane are inherited from the top level when
+// left out.
+type Tenant struct {
+	Name string `yaml:"name"`
+	// Hosts route unauthenticated requests (portal login, enrollment)
+	// by their Host header; X-Tenant: <id> works too
+	Hosts []string `yaml:"hosts"`
+	// HMACKeys are the portal / AP HMAC clients of the tenant: kid ->
+	// secret ref of the base64 key. A signed request is routed to the
+	// tenant owning its X-Portal-Kid, so kids are unique across tenants.
+	HMACKeys map[string]string `yaml:"hmac_keys"`
+	// CurrentKID signs outgoing requests and is assumed when a request
+	// has no X-Portal-Kid; may be left out with a single key
+	CurrentKID string `yaml:"current_kid"`
+	// JWTSecretRef signs the tenant's portal session JWTs
+	JWTSecretRef string `yaml:"jwt_secret_ref"`
+
+	Roles     map[string]RoleDef `yaml:"roles"`
+	Profiles  map[string]Profile `yaml:"profiles"`
+	RoleRules []RoleRule         `yaml:"role_rules"`
+	Bypass    *Bypass            `yaml:"bypass"`
+	Dataplane *Dataplane         `yaml:"dataplane"`
+}
+
+// Admin configures admin authentication besides admin_token_ref and the
+// API keys kept in redis.
+type Admin struct {
+	// JWTSecretRef is the HS256 secret of admin JWTs (env:/file: ref).
+	// Unset = admin JWTs are not accepted.
+	JWTSecretRef string `yaml:"jwt_secret_ref"`
+}
+
+// RateLimit throttles /portal/login with redis token buckets shared by
+// all replicas.
+type RateLimit struct {
+	Enabled bool `yaml:"enabled"`
+	// per client MAC, per client IP (envelope client.ip, else the
+	// request peer) and per portal client (the request peer)
+	MAC     Bucket  `yaml:"mac"`
+	IP      Bucket  `yaml:"ip"`
+	Client  Bucket  `yaml:"client"`
+	Lockout Lockout `yaml:"lockout"`
+}
+
+// Bucket allows Rate requests per Window seconds with bursts up to Burst.
+// A negative Rate disables the bucket.
+type Bucket struct {
+	Rate   int `yaml:"rate"`
+	Burst  int `yaml:"burst"`
+	Window int `yaml:"window"`
+}
+
+// Lockout blocks a MAC / IP after Failures logins with bad credentials
+// within Window seconds, for Base seconds doubling with every lockout up
+// to Max.
+// Negative Failures disables lockout.
+type Lockout struct {
+	Failures int `yaml:"failures"`
+	Window   int `yaml:"window"`
+	Base     int `yaml:"base"`
+	Max      int `yaml:"max"`
+}
+
+// ClientFeed configures GET /api/v1/ap/clients and its change log.
+type ClientFeed struct {
+	// MaxLen is roughly how many changes are kept; an AP whose cursor
+	// fell off the log gets a full snapshot
+	MaxLen int `yaml:"max_len"`
+	// PageSize is the most changes returned per delta request
+	PageSize int `yaml:"page_size"`
+}
+
+// Batch limits the bulk session endpoints.
+type Batch struct {
+	// MaxStatus is the most entries one /portal/batch_status may ask for
+	MaxStatus int `yaml:"max_status"`
+	// MaxBulk is the most MACs one bulk login / logout may carry
+	MaxBulk int `yaml:"max_bulk"`
+	// PipelineSize is how many sessions go into one redis pipeline
+	PipelineSize int `yaml:"pipeline_size"`
+}
+
+// WithDefaults fills unset (zero) batch limits.
+func (b Batch) WithDefaults() Batch {
+	if b.MaxStatus == 0 {
+		b.MaxStatus = 5000
+	}
+	if b.MaxBulk == 0 {
+		b.MaxBulk = 1000
+	}
+	if b.PipelineSize == 0 {
+		b.PipelineSize = 500
+	}
+	return b
+}
+
+// MQTT configures the optional MQTT transport to APs.
+type MQTT struct {
+	Enabled bool `yaml:"enabled"`
+	// Broker URL: tcp:// or ssl:// (mqtt:// and mqtts:// are accepted too)
+	Broker string `yaml:"broker"`
+	// ClientID is the base of the broker client id (default
+	// controller.id); each replica appends a random suffix
+	ClientID    string `yaml:"client_id"`
+	Username    string `yaml:"username"`
+	PasswordRef string `yaml:"password_ref"`
+	// CAFile verifies the broker certificate for ssl:// (system pool if unset)
+	CAFile string `yaml:"ca_file"`
+	// TopicPrefix is the root of every controller / AP topic
+	TopicPrefix string `yaml:"topic_prefix"`
+	// QoS for published and subscribed messages (0 or 1)
+	QoS int `yaml:"qos"`
+}
+
+// Events configures the AP push channel (GET /api/v1/ap/events).
+type Events struct {
+	Enabled bool `yaml:"enabled"`
+	// MaxLen is roughly how many events are kept for replay
+	MaxLen int `yaml:"max_len"`
+	// Keepalive (seconds) between SSE comments on an idle stream
+	Keepalive int `yaml:"keepalive"`
+}
+
+// Convergence configures policy rollout tracking.
+type Convergence struct {
+	// StaleAfter (seconds): an AP still not running the current policy
+	// this long after it was published raises a "policy.stale" alert.
+	// Negative disables the alert.
+	StaleAfter int `yaml:"stale_after"`
+	// Canary judges the canary APs of a staged policy rollout
+	Canary Canary `yaml:"canary"`
+}
+
+// Canary sets when the canary stage of a policy rollout counts as
+// unhealthy: more failed acks from canary APs than MaxFailedAcks, or,
+// once canary APs saw MinLogins logins, a login error rate more than
+// MaxErrorIncrease percentage points above the other APs'.
+type Canary struct {
+	MinLogins        int `yaml:"min_logins"`
+	MaxErrorIncrease int `yaml:"max_error_increase"`
+	MaxFailedAcks    int `yaml:"max_failed_acks"`
+	// AutoAbort aborts an unhealthy rollout instead of only alerting
+	AutoAbort bool `yaml:"auto_abort"`
+}
+
+// Leader configures leader election between controller replicas sharing
+// one redis. Only the leader runs singleton background jobs (offline and
+// stale policy detection); disabled, every replica runs them.
+type Leader struct {
+	Enabled bool `yaml:"enabled"`
+	// LeaseTTL (seconds): a leader that cannot renew its lease this long
+	// loses it to another replica.
+	LeaseTTL int `yaml:"lease_ttl"`
+	// RenewInterval (seconds) between renewals / campaigns; must be
+	// shorter than lease_ttl.
+	RenewInterval int `yaml:"renew_interval"`
+}
+
+// Migration configures the background upgrade of session records to the
+// current schema. Records of an older schema are also upgraded whenever
+// they are read, migration or not.
+type Migration struct {
+	Enabled bool `yaml:"enabled"`
+	// BatchSize is how many keys one SCAN step reads
+	BatchSize int `yaml:"batch_size"`
+	// Steps is how many SCAN steps one run (every heartbeat interval) takes
+	Steps int `yaml:"steps"`
+}
+
+// Vouchers configures guest vouchers.
+type Vouchers struct {
+	// MaxBatch is the most vouchers one batch may hold
+	MaxBatch int `yaml:"max_batch"`
+}
+
+// Sponsor configures sponsored guest access: a visitor names their host
+// employee on the portal, who approves or denies the request through a
+// signed link sent by the notifier.
+type Sponsor struct {
+	Enabled bool `yaml:"enabled"`
+	// Role granted to the guest's device on approval
+	Role string `yaml:"role"`
+	// Duration (seconds) of the access granted
+	Duration int `yaml:"duration"`
+	// RequestTTL (seconds) a request waits for the sponsor before it
+	// expires
+	RequestTTL int `yaml:"request_ttl"`
+	// Domains are the e-mail domains sponsors may have
+	Domains []string `yaml:"domains"`
+	// BaseURL is the controller URL sponsors reach the approval links on
+	BaseURL string `yaml:"base_url"`
+	// LinkSecretRef signs the approval links (env: ref)
+	LinkSecretRef string   `yaml:"link_secret_ref"`
+	Notifier      Notifier `yaml:"notifier"`
+}
+
+// Notifier sends messages to people, e.g. approval requests to sponsors.
+type Notifier struct {
+	// Type: file | smtp | webhook
+	Type string `yaml:"type"`
+	// File appends one JSON message per line (type file)
+	File string `yaml:"file"`
+	SMTP struct {
+		// Addr is host:port of the mail relay
+		Addr        string `yaml:"addr"`
+		From        string `yaml:"from"`
+		Username    string `yaml:"username"`
+		PasswordRef string `yaml:"password_ref"`
+	} `yaml:"smtp"`
+	Webhook struct {
+		URL string `yaml:"url"`
+		// SecretRef signs the body (X-Signature header), optional
+		SecretRef string `yaml:"secret_ref"`
+	} `yaml:"webhook"`
+}
+
+const (
+	NotifierFile    = "file"
+	NotifierSMTP    = "smtp"
+	NotifierWebhook = "webhook"
+)
+
+// Inventory configures the AP registry.
+type Inventory struct {
+	// HeartbeatInterval (seconds) is advertised to APs at registration
+	// and is also how often offline APs are detected.
+	HeartbeatInterval int `yaml:"heartbeat_interval"`
+	// OfflineAfter (seconds) without a heartbeat marks an AP offline.
+	OfflineAfter int `yaml:"offline_after"`
+}
+
+// PolicySigning signs runtime policy documents with an Ed25519 key, so
+// APs can reject tampered ones.
+type PolicySigning struct {
+	Enabled bool `yaml:"enabled"`
+	// KeyFile is the PKCS#8 PEM Ed25519 private key
+	KeyFile string `yaml:"key_file"`
+	// KeyID names the key in signatures (default: derived from the key)
+	KeyID string `yaml:"key_id"`
+	// PublishKeys are further PEM public key files published with the
+	// signing key, e.g. the next key during a rotation
+	PublishKeys []string `yaml:"publish_keys"`
+}
+
+// Enrollment configures the built-in AP certificate authority.
+type Enrollment struct {
+	Enabled    bool   `yaml:"enabled"`
+	CACertFile string `yaml:"ca_cert_file"`
+	CAKeyFile  string `yaml:"ca_key_file"`
+	// CertTTL is the lifetime (seconds) of issued AP client certificates
+	CertTTL int `yaml:"cert_ttl"`
+	// TokenTTL is how long (seconds) an unused enrollment token stays valid
+	TokenTTL int `yaml:"token_ttl"`
+	// RenewBefore is how long (seconds) before its expiry a certificate
+	// may be renewed (default cert_ttl / 3)
+	RenewBefore int `yaml:"renew_before"`
+	// Deprecated: AdminTokenRef is read as controller.admin_token_ref
+	// when that is unset.
+	AdminTokenRef string `yaml:"admin_token_ref"`
+}
+
+// TLS configures native HTTPS (and optional mutual TLS for APs).
+type TLS struct {
+	Enabled  bool   `yaml:"enabled"`
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// ClientAuth: none | request (verify if presented) | require
+	ClientAuth   string `yaml:"client_auth"`
+	ClientCAFile string `yaml:"client_ca_file"`
+	// ReloadInterval is how often (seconds) cert files are checked for changes
+	ReloadInterval int `yaml:"reload_interval"`
+}
+
+const (
+	ClientAuthNone    = "none"
+	ClientAuthRequest = "request"
+	ClientAuthRequire = "require"
+)
+
+// Timeouts for the controller http.Server, in seconds.
+type Timeouts struct {
+	ReadHeader int `yaml:"read_header"`
+	Read       int `yaml:"read"`
+	Write      int `yaml:"write"`
+	Idle       int `yaml:"idle"`
+	// Shutdown is how long in-flight requests may take to drain on SIGTERM
+	Shutdown int `yaml:"shutdown"`
 }
 
 type Redis struct {
@@ -45,21 +361,52 @@ type Profile struct {
 	VLAN          int    `yaml:"vlan"`
 	FirewallGroup string `yaml:"firewall_group"`
 	SessionTTL    int    `yaml:"session_ttl"`
+
+	// Rate limits in kbit/s, enforced by the data-plane (0 = unlimited)
+	UpstreamKbps   int `yaml:"upstream_kbps"`
+	DownstreamKbps int `yaml:"downstream_kbps"`
+	// Seconds without client traffic before the data-plane drops the client (0 = off)
+	IdleTimeout int `yaml:"idle_timeout"`
+	// Absolute session lifetime in seconds; heartbeats never extend it (0 = unlimited)
+	MaxSessionLifetime int `yaml:"max_session_lifetime"`
+	// Concurrent devices allowed per identity (0 = unlimited)
+	MaxDevices int `yaml:"max_devices"`
+	// Seconds a device is remembered after a portal login, so it is
+	// re-authorized without the portal (MAB) until then (0 = off)
+	RememberDevice int `yaml:"remember_device"`
+
+	// Data quotas in MiB, upstream + downstream (0 = unlimited)
+	SessionQuotaMB int `yaml:"session_quota_mb"`
+	DailyQuotaMB   int `yaml:"daily_quota_mb"`
+	// QuotaAction is "terminate" (default) or "throttle"
+	QuotaAction string `yaml:"quota_action"`
+	// ThrottleRole is the role a client is moved to when QuotaAction is "throttle"
+	ThrottleRole string `yaml:"throttle_role"`
 }
 
+const (
+	QuotaTerminate = "terminate"
+	QuotaThrottle  = "throttle"
+)
+
+// QuotaBytes converts a MiB quota to bytes.
+func QuotaBytes(mb int) int64 { return int64(mb) << 20 }
+
+// RoleRule and Bypass also carry json tags: staged rollouts take them
+// through the admin API.
 type RoleRule struct {
-	Name     string         `yaml:"name"`
-	Priority int            `yaml:"priority"`
-	When     map[string]any `yaml:"when"`
-	Assign   string         `yaml:"assign"`
+	Name     string         `yaml:"name" json:"name"`
+	Priority int            `yaml:"priority" json:"priority"`
+	When     map[string]any `yaml:"when" json:"when,omitempty"`
+	Assign   string         `yaml:"assign" json:"assign,omitempty"`
 }
 
 type Bypass struct {
-	Enabled      bool     `yaml:"enabled"`
-	EnforceOrder []string `yaml:"enforce_order"`
-	MacWhitelist []string `yaml:"mac_whitelist"`
-	IPWhitelist  []string `yaml:"ip_whitelist"`
-	Domains      []string `yaml:"domains"`
+	Enabled      bool     `yaml:"enabled" json:"enabled"`
+	EnforceOrder []string `yaml:"enforce_order" json:"enforce_order"`
+	MacWhitelist []string `yaml:"mac_whitelist" json:"mac_whitelist"`
+	IPWhitelist  []string `yaml:"ip_whitelist" json:"ip_whitelist"`
+	Domains      []string `yaml:"domains" json:"domains"`
 }
 
 type Dataplane struct {
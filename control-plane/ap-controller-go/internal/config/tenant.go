@@ -0,0 +1,50 @@
+package config
+
+import "regexp"
+
+// DefaultTenant names the tenant configured at the top level of
+// controller.yaml, e.g. in X-Tenant headers and admin JWT claims.
+const DefaultTenant = "default"
+
+var tenantIDRe = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+// ValidTenantID reports whether id may name a tenant.
+func ValidTenantID(id string) bool {
+	return id != DefaultTenant && tenantIDRe.MatchString(id)
+}
+
+// TenantPrefix is the redis key prefix of tenant id under prefix. "t" is
+// not a hex digit, so tenant keys never look like default-tenant sessions.
+func TenantPrefix(prefix, id string) string {
+	return prefix + "t:" + id + ":"
+}
+
+// TenantIDs returns the configured tenant ids, sorted.
+func (c *Config) TenantIDs() []string {
+	return sortedKeys(c.Tenants)
+}
+
+// ForTenant returns the config tenant id runs with: the top level with
+// the tenant's sections swapped in and its own redis prefix. It returns
+// nil for an unknown tenant.
+func (c *Config) ForTenant(id string) *Config {
+	t, ok := c.Tenants[id]
+	if !ok {
+		return nil
+	}
+	tc := *c
+	tc.Tenants = nil
+	tc.Redis.Prefix = TenantPrefix(c.Redis.Prefix, id)
+	tc.Roles = t.Roles
+	tc.Profiles = t.Profiles
+	if t.RoleRules != nil {
+		tc.RoleRules = t.RoleRules
+	}
+	if t.Bypass != nil {
+		tc.Bypass = *t.Bypass
+	}
+	if t.Dataplane != nil {
+		tc.Dataplane = *t.Dataplane
+	}
+	return &tc
+}
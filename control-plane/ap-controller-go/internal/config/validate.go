@@ -0,0 +1,676 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultRole is assigned when no role rule matches (or a matching rule
+// leaves "assign" empty). It must therefore always be defined.
+const DefaultRole = "guest"
+
+// WhenKeys are the context fields a role rule may match on.
+// roles.DecideRole evaluates exactly these keys.
+var WhenKeys = []string{"ssid", "auth", "ap_id", "radio_id", "mac", "ip"}
+
+// bypassChecks are the valid entries of bypass.enforce_order.
+var bypassChecks = []string{"mac", "ip", "domain"}
+
+// FieldError is a single semantic problem found in controller.yaml.
+type FieldError struct {
+	Path   string
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e FieldError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("line %d:%d: %s: %s", e.Line, e.Column, e.Path, e.Msg)
+	}
+	return fmt.Sprintf("%s: %s", e.Path, e.Msg)
+}
+
+// ValidationError collects every FieldError found by Validate,
+// so operators can fix all problems in one pass.
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, fe := range e.Errors {
+		msgs = append(msgs, fe.Error())
+	}
+	return fmt.Sprintf("invalid config (%d errors):\n  %s",
+		len(e.Errors), strings.Join(msgs, "\n  "))
+}
+
+// validator accumulates errors and resolves YAML positions.
+type validator struct {
+	root *yaml.Node
+	errs []FieldError
+	// base is prepended to every path (tenant sections)
+	base []any
+}
+
+// addf records an error at the node addressed by p.
+// p elements are mapping keys (string) or sequence indexes (int).
+func (v *validator) addf(p []any, format string, args ...any) {
+	if len(v.base) > 0 {
+		p = append(append([]any{}, v.base...), p...)
+	}
+	fe := FieldError{Path: pathString(p), Msg: fmt.Sprintf(format, args...)}
+	fe.Line, fe.Column = lookup(v.root, p)
+	v.errs = append(v.errs, fe)
+}
+
+// Validate performs semantic checks on a decoded config.
+// root is the YAML document the config was decoded from and is only used
+// for line numbers; it may be nil.
+func Validate(cfg *Config, root *yaml.Node) error {
+	v := &validator{root: root}
+
+	v.controller(cfg)
+	v.redis(cfg)
+	v.roles(cfg)
+	v.profiles(cfg)
+	v.rules(cfg)
+	v.bypass(cfg)
+	v.dataplane(cfg)
+	v.tenants(cfg)
+
+	if len(v.errs) == 0 {
+		return nil
+	}
+	sort.SliceStable(v.errs, func(i, j int) bool {
+		if v.errs[i].Line != v.errs[j].Line {
+			return v.errs[i].Line < v.errs[j].Line
+		}
+		return v.errs[i].Path < v.errs[j].Path
+	})
+	return &ValidationError{Errors: v.errs}
+}
+
+// ValidatePolicy checks the role_rules and bypass sections of cfg alone,
+// e.g. the candidate of a staged rollout.
+func ValidatePolicy(cfg *Config) error {
+	v := &validator{}
+	v.rules(cfg)
+	v.bypass(cfg)
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return &ValidationError{Errors: v.errs}
+}
+
+// -------------------------------------------------------------------
+// Sections
+// -------------------------------------------------------------------
+
+func (v *validator) controller(cfg *Config) {
+	c := cfg.Controller
+	if c.Bind.Port < 0 || c.Bind.Port > 65535 {
+		v.addf([]any{"controller", "bind", "port"}, "port %d out of range", c.Bind.Port)
+	}
+	if c.Audit.Enabled && c.Audit.SecretRef == "" {
+		v.addf([]any{"controller", "audit"}, "secret_ref must be set when audit is enabled")
+	}
+	if c.Audit.Algo != "" && c.Audit.Algo != "hmac-sha256" {
+		v.addf([]any{"controller", "audit", "algo"}, "unsupported algo %q", c.Audit.Algo)
+	}
+
+	for _, f := range []struct {
+		key string
+		val int
+	}{
+		{"read_header", c.Timeouts.ReadHeader},
+		{"read", c.Timeouts.Read},
+		{"write", c.Timeouts.Write},
+		{"idle", c.Timeouts.Idle},
+		{"shutdown", c.Timeouts.Shutdown},
+	} {
+		if f.val < 0 {
+			v.addf([]any{"controller", "timeouts", f.key}, "%s must not be negative", f.key)
+		}
+	}
+
+	if inv := c.Inventory; inv.HeartbeatInterval < 0 || inv.OfflineAfter < 0 {
+		v.addf([]any{"controller", "inventory"}, "heartbeat_interval and offline_after must not be negative")
+	} else if inv.OfflineAfter > 0 && inv.OfflineAfter <= inv.HeartbeatInterval {
+		v.addf([]any{"controller", "inventory", "offline_after"},
+			"offline_after (%d) must be longer than heartbeat_interval (%d)", inv.OfflineAfter, inv.HeartbeatInterval)
+	}
+
+	if ld := c.Leader; ld.LeaseTTL < 0 || ld.RenewInterval < 0 {
+		v.addf([]any{"controller", "leader"}, "lease_ttl and renew_interval must not be negative")
+	} else if ld.RenewInterval >= ld.LeaseTTL {
+		v.addf([]any{"controller", "leader", "renew_interval"},
+			"renew_interval (%d) must be shorter than lease_ttl (%d)", ld.RenewInterval, ld.LeaseTTL)
+	}
+
+	if mg := c.Migration; mg.BatchSize < 0 || mg.Steps < 0 {
+		v.addf([]any{"controller", "migration"}, "batch_size and steps must not be negative")
+	}
+
+	if c.Vouchers.MaxBatch < 0 {
+		v.addf([]any{"controller", "vouchers", "max_batch"}, "max_batch must not be negative")
+	}
+
+	if sp := c.Sponsor; sp.Enabled {
+		if _, ok := cfg.Roles[sp.Role]; !ok {
+			v.addf([]any{"controller", "sponsor", "role"}, "unknown role %q", sp.Role)
+		}
+		if sp.Duration < 0 || sp.RequestTTL < 0 {
+			v.addf([]any{"controller", "sponsor"}, "duration and request_ttl must not be negative")
+		}
+		if len(sp.Domains) == 0 {
+			// otherwise any address could be sent approval links
+			v.addf([]any{"controller", "sponsor", "domains"}, "domains must be set when sponsored access is enabled")
+		}
+		if u, err := url.Parse(sp.BaseURL); sp.BaseURL == "" || err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			v.addf([]any{"controller", "sponsor", "base_url"}, "base_url must be a URL like https://controller.example")
+		}
+		if sp.LinkSecretRef == "" {
+			v.addf([]any{"controller", "sponsor", "link_secret_ref"}, "link_secret_ref must be set when sponsored access is enabled")
+		}
+		n := sp.Notifier
+		switch n.Type {
+		case NotifierFile:
+			if n.File == "" {
+				v.addf([]any{"controller", "sponsor", "notifier", "file"}, "file must be set for the file notifier")
+			}
+		case NotifierSMTP:
+			if n.SMTP.Addr == "" || n.SMTP.From == "" {
+				v.addf([]any{"controller", "sponsor", "notifier", "smtp"}, "addr and from must be set for the smtp notifier")
+			}
+		case NotifierWebhook:
+			if u, err := url.Parse(n.Webhook.URL); err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+				v.addf([]any{"controller", "sponsor", "notifier", "webhook", "url"}, "url must be an http(s) URL")
+			}
+		default:
+			v.addf([]any{"controller", "sponsor", "notifier", "type"}, "unknown notifier type %q (allowed: %s, %s, %s)",
+				n.Type, NotifierFile, NotifierSMTP, NotifierWebhook)
+		}
+	}
+
+	if c.Events.MaxLen < 0 || c.Events.Keepalive < 0 {
+		v.addf([]any{"controller", "events"}, "max_len and keepalive must not be negative")
+	}
+
+	if e := c.Enrollment; e.Enabled {
+		if e.CACertFile == "" || e.CAKeyFile == "" {
+			v.addf([]any{"controller", "enrollment"}, "ca_cert_file and ca_key_file must be set when enrollment is enabled")
+		}
+		if c.AdminTokenRef == "" && c.Admin.JWTSecretRef == "" {
+			// API keys need one of them to be created in the first place
+			v.addf([]any{"controller", "admin_token_ref"}, "admin_token_ref or admin.jwt_secret_ref must be set when enrollment is enabled")
+		}
+		if e.CertTTL < 0 {
+			v.addf([]any{"controller", "enrollment", "cert_ttl"}, "cert_ttl must not be negative")
+		}
+		if e.TokenTTL < 0 {
+			v.addf([]any{"controller", "enrollment", "token_ttl"}, "token_ttl must not be negative")
+		}
+		if e.RenewBefore <= 0 || e.RenewBefore > e.CertTTL {
+			v.addf([]any{"controller", "enrollment", "renew_before"}, "renew_before must be 1..cert_ttl")
+		}
+	}
+
+	if ps := c.PolicySigning; ps.Enabled {
+		if ps.KeyFile == "" {
+			v.addf([]any{"controller", "policy_signing"}, "key_file must be set when policy signing is enabled")
+		}
+		if ps.KeyID != "" && !validKeyID(ps.KeyID) {
+			v.addf([]any{"controller", "policy_signing", "key_id"}, "invalid key_id %q (letters, digits, '.', '_' and '-', at most 64)", ps.KeyID)
+		}
+		for i, f := range ps.PublishKeys {
+			if strings.TrimSpace(f) == "" {
+				v.addf([]any{"controller", "policy_signing", "publish_keys", i}, "empty key file")
+			}
+		}
+	}
+
+	if cn := c.Convergence.Canary; cn.MinLogins < 0 || cn.MaxFailedAcks < 0 {
+		v.addf([]any{"controller", "convergence", "canary"}, "min_logins and max_failed_acks must not be negative")
+	}
+	if cn := c.Convergence.Canary; cn.MaxErrorIncrease < 0 || cn.MaxErrorIncrease > 100 {
+		v.addf([]any{"controller", "convergence", "canary", "max_error_increase"}, "max_error_increase must be 0..100")
+	}
+
+	if b := c.Batch; b.MaxStatus < 0 || b.MaxBulk < 0 || b.PipelineSize < 0 {
+		v.addf([]any{"controller", "batch"}, "max_status, max_bulk and pipeline_size must not be negative")
+	}
+
+	if rl := c.RateLimit; rl.Enabled {
+		for _, nb := range []struct {
+			name string
+			b    Bucket
+		}{{"mac", rl.MAC}, {"ip", rl.IP}, {"client", rl.Client}} {
+			name, b := nb.name, nb.b
+			if b.Burst < 0 || b.Window < 0 {
+				v.addf([]any{"controller", "rate_limit", name}, "burst and window must not be negative")
+			} else if b.Rate > 0 && (b.Window == 0 || b.Burst == 0) {
+				v.addf([]any{"controller", "rate_limit", name}, "window and burst must be set when rate is")
+			}
+		}
+		lo := rl.Lockout
+		if lo.Window < 0 || lo.Base < 0 || lo.Max < 0 {
+			v.addf([]any{"controller", "rate_limit", "lockout"}, "window, base and max must not be negative")
+		} else if lo.Max < lo.Base {
+			v.addf([]any{"controller", "rate_limit", "lockout", "max"}, "max (%d) must not be below base (%d)", lo.Max, lo.Base)
+		}
+	}
+
+	if cf := c.ClientFeed; cf.MaxLen < 0 || cf.PageSize < 0 {
+		v.addf([]any{"controller", "client_feed"}, "max_len and page_size must not be negative")
+	}
+
+	if m := c.MQTT; m.Enabled {
+		if u, err := url.Parse(m.Broker); m.Broker == "" || err != nil || u.Host == "" {
+			v.addf([]any{"controller", "mqtt", "broker"}, "broker must be a URL like tcp://host:1883")
+		} else {
+			switch u.Scheme {
+			case "tcp", "ssl", "tls", "mqtt", "mqtts":
+			default:
+				v.addf([]any{"controller", "mqtt", "broker"}, "unsupported broker scheme %q (allowed: tcp, ssl, mqtt, mqtts)", u.Scheme)
+			}
+		}
+		if m.QoS < 0 || m.QoS > 1 {
+			v.addf([]any{"controller", "mqtt", "qos"}, "qos must be 0 or 1")
+		}
+		if strings.ContainsAny(m.TopicPrefix, "+#") || strings.HasPrefix(m.TopicPrefix, "/") || strings.HasSuffix(m.TopicPrefix, "/") {
+			v.addf([]any{"controller", "mqtt", "topic_prefix"}, "topic_prefix %q must not contain wildcards or leading/trailing '/'", m.TopicPrefix)
+		}
+	}
+
+	t := c.TLS
+	switch t.ClientAuth {
+	case "", ClientAuthNone, ClientAuthRequest, ClientAuthRequire:
+	default:
+		v.addf([]any{"controller", "tls", "client_auth"}, "unknown client_auth %q (allowed: %s, %s, %s)",
+			t.ClientAuth, ClientAuthNone, ClientAuthRequest, ClientAuthRequire)
+	}
+	if !t.Enabled {
+		return
+	}
+	if t.CertFile == "" || t.KeyFile == "" {
+		v.addf([]any{"controller", "tls"}, "cert_file and key_file must be set when tls is enabled")
+	}
+	if t.ClientAuth != "" && t.ClientAuth != ClientAuthNone && t.ClientCAFile == "" {
+		v.addf([]any{"controller", "tls"}, "client_ca_file must be set when client_auth is %s", t.ClientAuth)
+	}
+	if t.ReloadInterval < 0 {
+		v.addf([]any{"controller", "tls", "reload_interval"}, "reload_interval must not be negative")
+	}
+}
+
+func (v *validator) redis(cfg *Config) {
+	if cfg.Redis.Port < 0 || cfg.Redis.Port > 65535 {
+		v.addf([]any{"redis", "port"}, "port %d out of range", cfg.Redis.Port)
+	}
+	if cfg.Redis.DB < 0 {
+		v.addf([]any{"redis", "db"}, "db must not be negative")
+	}
+}
+
+func (v *validator) roles(cfg *Config) {
+	if len(cfg.Roles) == 0 {
+		v.addf([]any{"roles"}, "at least one role must be defined")
+		return
+	}
+	if _, ok := cfg.Roles[DefaultRole]; !ok {
+		v.addf([]any{"roles"}, "default role %q must be defined", DefaultRole)
+	}
+	for _, name := range sortedKeys(cfg.Roles) {
+		r := cfg.Roles[name]
+		p := []any{"roles", name, "profile"}
+		if r.Profile == "" {
+			v.addf([]any{"roles", name}, "profile must be set")
+			continue
+		}
+		if _, ok := cfg.Profiles[r.Profile]; !ok {
+			v.addf(p, "unknown profile %q", r.Profile)
+		}
+	}
+}
+
+func (v *validator) profiles(cfg *Config) {
+	for _, name := range sortedKeys(cfg.Profiles) {
+		pr := cfg.Profiles[name]
+		if pr.SessionTTL <= 0 {
+			v.addf([]any{"profiles", name, "session_ttl"}, "session_ttl must be > 0")
+		}
+		if pr.VLAN < 0 || pr.VLAN > 4094 {
+			v.addf([]any{"profiles", name, "vlan"}, "vlan %d out of range 0-4094", pr.VLAN)
+		}
+		if pr.FirewallGroup == "" {
+			v.addf([]any{"profiles", name}, "firewall_group must be set")
+		}
+		for _, f := range []struct {
+			key string
+			val int
+		}{
+			{"upstream_kbps", pr.UpstreamKbps},
+			{"downstream_kbps", pr.DownstreamKbps},
+			{"idle_timeout", pr.IdleTimeout},
+			{"max_session_lifetime", pr.MaxSessionLifetime},
+			{"max_devices", pr.MaxDevices},
+			{"remember_device", pr.RememberDevice},
+			{"session_quota_mb", pr.SessionQuotaMB},
+			{"daily_quota_mb", pr.DailyQuotaMB},
+		} {
+			if f.val < 0 {
+				v.addf([]any{"profiles", name, f.key}, "%s must not be negative", f.key)
+			}
+		}
+		switch pr.QuotaAction {
+		case "", QuotaTerminate:
+		case QuotaThrottle:
+			if pr.ThrottleRole == "" {
+				v.addf([]any{"profiles", name}, "throttle_role must be set when quota_action is throttle")
+			} else if r, ok := cfg.Roles[pr.ThrottleRole]; !ok {
+				v.addf([]any{"profiles", name, "throttle_role"}, "unknown role %q", pr.ThrottleRole)
+			} else if r.Profile == name {
+				v.addf([]any{"profiles", name, "throttle_role"}, "role %q uses this profile, throttling would not change anything", pr.ThrottleRole)
+			}
+		default:
+			v.addf([]any{"profiles", name, "quota_action"}, "unknown quota_action %q (allowed: %s, %s)", pr.QuotaAction, QuotaTerminate, QuotaThrottle)
+		}
+	}
+}
+
+func (v *validator) rules(cfg *Config) {
+	seen := map[string]int{}
+	for i, r := range cfg.RoleRules {
+		base := []any{"role_rules", i}
+
+		if r.Name == "" {
+			v.addf(base, "name must be set")
+		} else if prev, dup := seen[r.Name]; dup {
+			v.addf(append(base, "name"), "duplicate rule name %q (first at role_rules[%d])", r.Name, prev)
+		} else {
+			seen[r.Name] = i
+		}
+
+		if r.Assign != "" {
+			if _, ok := cfg.Roles[r.Assign]; !ok {
+				v.addf(append(base, "assign"), "unknown role %q", r.Assign)
+			}
+		}
+
+		for _, k := range sortedKeys(r.When) {
+			wp := append(append([]any{}, base...), "when", k)
+			if !contains(WhenKeys, k) {
+				v.addf(wp, "unknown match key %q (allowed: %s)", k, strings.Join(WhenKeys, ", "))
+				continue
+			}
+			v.pattern(wp, r.When[k])
+		}
+	}
+}
+
+// pattern checks a when-value: a string or a list of strings,
+// each a valid path.Match pattern.
+func (v *validator) pattern(p []any, val any) {
+	switch t := val.(type) {
+	case nil:
+	case string:
+		if _, err := path.Match(t, ""); err != nil {
+			v.addf(p, "bad pattern %q: %v", t, err)
+		}
+	case []any:
+		for i, it := range t {
+			v.pattern(append(append([]any{}, p...), i), it)
+		}
+	default:
+		v.addf(p, "must be a string or list of strings, got %T", val)
+	}
+}
+
+func (v *validator) bypass(cfg *Config) {
+	b := cfg.Bypass
+	for i, c := range b.EnforceOrder {
+		if !contains(bypassChecks, c) {
+			v.addf([]any{"bypass", "enforce_order", i}, "unknown check %q (allowed: %s)", c, strings.Join(bypassChecks, ", "))
+		}
+	}
+	for i, m := range b.MacWhitelist {
+		if _, err := net.ParseMAC(m); err != nil {
+			v.addf([]any{"bypass", "mac_whitelist", i}, "invalid mac %q", m)
+		}
+	}
+	for i, ip := range b.IPWhitelist {
+		if !validIPOrCIDR(ip) {
+			v.addf([]any{"bypass", "ip_whitelist", i}, "invalid ip or cidr %q", ip)
+		}
+	}
+	for i, d := range b.Domains {
+		// the data plane strips a leading "*." or "." itself
+		d = strings.TrimPrefix(strings.TrimPrefix(d, "*"), ".")
+		if !ValidHostname(strings.TrimSuffix(d, ".")) {
+			v.addf([]any{"bypass", "domains", i}, "invalid domain %q", d)
+		}
+	}
+}
+
+func (v *validator) dataplane(cfg *Config) {
+	d := cfg.Dataplane
+	if d.LanIF == "" {
+		v.addf([]any{"dataplane"}, "lan_if must be set")
+	}
+	if d.PortalIP == "" {
+		v.addf([]any{"dataplane"}, "portal_ip must be set")
+	} else if net.ParseIP(d.PortalIP) == nil {
+		v.addf([]any{"dataplane", "portal_ip"}, "invalid ip %q", d.PortalIP)
+	}
+	if d.PolicyVersion < 0 {
+		v.addf([]any{"dataplane", "policy_version"}, "policy_version must not be negative")
+	}
+}
+
+// tenants checks each tenant and the tenant-level sections it sets
+// against the config it runs with (see Config.ForTenant).
+func (v *validator) tenants(cfg *Config) {
+	if len(cfg.Tenants) == 0 {
+		return
+	}
+	if cfg.Controller.MQTT.Enabled {
+		v.addf([]any{"controller", "mqtt", "enabled"}, "the mqtt transport is not supported with tenants")
+	}
+	if cfg.Controller.Sponsor.Enabled {
+		v.addf([]any{"controller", "sponsor", "enabled"}, "sponsored access is not supported with tenants")
+	}
+
+	kids := map[string]string{}
+	hosts := map[string]string{}
+	for _, id := range cfg.TenantIDs() {
+		t := cfg.Tenants[id]
+		p := []any{"tenants", id}
+		if !ValidTenantID(id) {
+			v.addf(p, "invalid tenant id %q (lowercase letters, digits and '-', at most 32, not %q)", id, DefaultTenant)
+		}
+
+		if len(t.HMACKeys) == 0 {
+			v.addf(p, "hmac_keys must not be empty")
+		}
+		for _, kid := range sortedKeys(t.HMACKeys) {
+			if prev, dup := kids[kid]; dup {
+				v.addf(append(p, "hmac_keys", kid), "kid %q is already used by tenant %q", kid, prev)
+			} else {
+				kids[kid] = id
+			}
+			if t.HMACKeys[kid] == "" {
+				v.addf(append(p, "hmac_keys", kid), "secret ref must be set")
+			}
+		}
+		if t.CurrentKID == "" && len(t.HMACKeys) > 1 {
+			v.addf(p, "current_kid must be set with more than one hmac key")
+		} else if _, ok := t.HMACKeys[t.CurrentKID]; t.CurrentKID != "" && !ok {
+			v.addf(append(p, "current_kid"), "unknown kid %q", t.CurrentKID)
+		}
+		if t.JWTSecretRef == "" {
+			v.addf(p, "jwt_secret_ref must be set")
+		}
+		for i, h := range t.Hosts {
+			h = strings.ToLower(h)
+			if prev, dup := hosts[h]; dup {
+				v.addf(append(p, "hosts", i), "host %q is already used by tenant %q", h, prev)
+			} else {
+				hosts[h] = id
+			}
+		}
+
+		tc := cfg.ForTenant(id)
+		tv := &validator{root: v.root, base: p}
+		tv.roles(tc)
+		tv.profiles(tc)
+		tv.rules(tc)
+		if t.Bypass != nil {
+			tv.bypass(tc)
+		}
+		if t.Dataplane != nil {
+			tv.dataplane(tc)
+		}
+		v.errs = append(v.errs, tv.errs...)
+	}
+}
+
+// -------------------------------------------------------------------
+// Helpers
+// -------------------------------------------------------------------
+
+// lookup walks the YAML node tree along p and returns the position of the
+// deepest node reached, so errors on missing fields point at their parent.
+// Scalars report their value; mappings and sequences report their key.
+func lookup(root *yaml.Node, p []any) (line, col int) {
+	if root == nil {
+		return 0, 0
+	}
+	n := root
+	if n.Kind == yaml.DocumentNode && len(n.Content) > 0 {
+		n = n.Content[0]
+	}
+	line, col = n.Line, n.Column
+	for _, seg := range p {
+		key, val := child(n, seg)
+		if val == nil {
+			break
+		}
+		pos := val
+		if key != nil && val.Kind != yaml.ScalarNode {
+			pos = key
+		}
+		line, col = pos.Line, pos.Column
+		n = val
+	}
+	return line, col
+}
+
+// child returns the key and value node for a mapping key (string)
+// or the element for a sequence index (int, key is nil).
+func child(n *yaml.Node, seg any) (key, val *yaml.Node) {
+	switch s := seg.(type) {
+	case string:
+		if n.Kind != yaml.MappingNode {
+			return nil, nil
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if n.Content[i].Value == s {
+				return n.Content[i], n.Content[i+1]
+			}
+		}
+	case int:
+		if n.Kind == yaml.SequenceNode && s >= 0 && s < len(n.Content) {
+			return nil, n.Content[s]
+		}
+	}
+	return nil, nil
+}
+
+func pathString(p []any) string {
+	var b strings.Builder
+	for i, seg := range p {
+		switch s := seg.(type) {
+		case int:
+			b.WriteString("[" + strconv.Itoa(s) + "]")
+		default:
+			if i > 0 {
+				b.WriteByte('.')
+			}
+			fmt.Fprint(&b, s)
+		}
+	}
+	return b.String()
+}
+
+func validKeyID(s string) bool {
+	if s == "" || len(s) > 64 {
+		return false
+	}
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidHostname reports whether s is a lowercase DNS name of at least
+// two labels, each 1-63 characters of [a-z0-9-] with no leading or
+// trailing hyphen. Bypass domains reach dnsmasq and shell on the APs,
+// so nothing else is let through.
+func ValidHostname(s string) bool {
+	if len(s) > 253 {
+		return false
+	}
+	labels := strings.Split(s, ".")
+	if len(labels) < 2 {
+		return false
+	}
+	for _, l := range labels {
+		if len(l) == 0 || len(l) > 63 || l[0] == '-' || l[len(l)-1] == '-' {
+			return false
+		}
+		for i := 0; i < len(l); i++ {
+			c := l[i]
+			if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func validIPOrCIDR(s string) bool {
+	if net.ParseIP(s) != nil {
+		return true
+	}
+	_, _, err := net.ParseCIDR(s)
+	return err == nil
+}
+
+func contains(list []string, s string) bool {
+	for _, it := range list {
+		if it == s {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,304 @@
+// Package events fans controller changes out to connected APs.
+//
+// Events are appended to a redis stream (so every replica sees them and
+// reconnecting APs can replay what they missed) and a single reader per
+// replica dispatches them to in-process subscribers (SSE connections).
+package events
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ap-controller-go/internal/store"
+)
+
+// Event types.
+const (
+	PolicyChanged    = "policy.changed"
+	BypassChanged    = "bypass.changed"
+	SessionCreated   = "session.created"
+	SessionRefreshed = "session.refreshed"
+	SessionDeleted   = "session.deleted"
+	CertRevoked      = "cert.revoked"
+)
+
+// Event is one change pushed to APs. APID targets a single AP, Site all
+// APs of a site; neither means every AP.
+type Event struct {
+	ID   string         `json:"id,omitempty"`
+	Type string         `json:"type"`
+	APID string         `json:"ap_id,omitempty"`
+	Site string         `json:"site,omitempty"`
+	TS   int64          `json:"ts"`
+	Data map[string]any `json:"data,omitempty"`
+}
+
+// For reports whether ev is meant for the given AP.
+func (ev Event) For(apID, site string) bool {
+	if ev.APID != "" {
+		return ev.APID == apID
+	}
+	return ev.Site == "" || ev.Site == site
+}
+
+// Stream is the part of store.Store the hub needs.
+type Stream interface {
+	AppendEvent(ctx context.Context, data []byte, maxLen int64) (string, error)
+	AppendEvents(ctx context.Context, data [][]byte, maxLen int64) ([]string, error)
+	EventsAfter(ctx context.Context, after string, count int64) ([]store.StreamEntry, error)
+	ReadEvents(ctx context.Context, after string, count int64, block time.Duration) ([]store.StreamEntry, error)
+	EventBounds(ctx context.Context) (first, last string, err error)
+}
+
+const (
+	readBlock  = 5 * time.Second
+	readBatch  = 256
+	subBuffer  = 256
+	replayPage = 500
+)
+
+// Hub publishes events and dispatches the stream to subscribers.
+type Hub struct {
+	st     Stream
+	maxLen int64
+
+	mu     sync.Mutex
+	subs   map[*Subscription]struct{}
+	closed bool
+}
+
+// NewHub creates a hub keeping about maxLen events for replay.
+func NewHub(st Stream, maxLen int64) *Hub {
+	return &Hub{st: st, maxLen: maxLen, subs: map[*Subscription]struct{}{}}
+}
+
+// Publish appends ev to the stream and returns its id.
+func (h *Hub) Publish(ctx context.Context, ev Event) (string, error) {
+	if ev.TS == 0 {
+		ev.TS = time.Now().Unix()
+	}
+	ev.ID = ""
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return "", err
+	}
+	return h.st.AppendEvent(ctx, b, h.maxLen)
+}
+
+// PublishAll appends evs in one round trip and returns their ids.
+func (h *Hub) PublishAll(ctx context.Context, evs []Event) ([]string, error) {
+	now := time.Now().Unix()
+	data := make([][]byte, len(evs))
+	for i, ev := range evs {
+		if ev.TS == 0 {
+			ev.TS = now
+		}
+		ev.ID = ""
+		b, err := json.Marshal(ev)
+		if err != nil {
+			return nil, err
+		}
+		data[i] = b
+	}
+	return h.st.AppendEvents(ctx, data, h.maxLen)
+}
+
+// Run reads the stream and dispatches new entries until ctx is done,
+// then closes every subscription.
+func (h *Hub) Run(ctx context.Context) {
+	defer h.closeAll()
+
+	last := "0-0"
+	if _, l, err := h.st.EventBounds(ctx); err == nil && l != "" {
+		last = l
+	}
+
+	for ctx.Err() == nil {
+		entries, err := h.st.ReadEvents(ctx, last, readBatch, readBlock)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("events: read failed: %v", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		for _, e := range entries {
+			last = e.ID
+			ev, ok := decode(e)
+			if ok {
+				h.dispatch(ev)
+			}
+		}
+	}
+}
+
+func decode(e store.StreamEntry) (Event, bool) {
+	var ev Event
+	if err := json.Unmarshal(e.Data, &ev); err != nil {
+		return ev, false
+	}
+	ev.ID = e.ID
+	return ev, true
+}
+
+func (h *Hub) dispatch(ev Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subs {
+		if !sub.filter(ev) {
+			continue
+		}
+		select {
+		case sub.c <- ev:
+		default:
+			// too slow: drop it; the AP reconnects with Last-Event-ID
+			delete(h.subs, sub)
+			sub.overflow = true
+			close(sub.c)
+		}
+	}
+}
+
+func (h *Hub) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for sub := range h.subs {
+		delete(h.subs, sub)
+		close(sub.c)
+	}
+}
+
+// Subscription receives live events for one AP.
+type Subscription struct {
+	h        *Hub
+	c        chan Event
+	filter   func(Event) bool
+	overflow bool
+
+	// Replay holds the events missed since the requested id.
+	Replay []Event
+	// Resync is set when events after the requested id were already
+	// trimmed from the stream: the AP must do a full resync.
+	Resync bool
+
+	lastReplayed string
+}
+
+// Subscribe registers a subscriber for events passing filter. If lastID
+// is set, the events after it are loaded into Replay; Next never returns
+// an event already in Replay.
+func (h *Hub) Subscribe(ctx context.Context, lastID string, filter func(Event) bool) (*Subscription, error) {
+	sub := &Subscription{h: h, c: make(chan Event, subBuffer), filter: filter}
+
+	// register before reading the replay so nothing falls in between
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return nil, ErrClosed
+	}
+	h.subs[sub] = struct{}{}
+	h.mu.Unlock()
+
+	if lastID == "" {
+		return sub, nil
+	}
+
+	first, _, err := h.st.EventBounds(ctx)
+	if err != nil {
+		sub.Close()
+		return nil, err
+	}
+	if first != "" && CompareIDs(lastID, first) < 0 {
+		sub.Resync = true
+	}
+
+	after := lastID
+	for {
+		entries, err := h.st.EventsAfter(ctx, after, replayPage)
+		if err != nil {
+			sub.Close()
+			return nil, err
+		}
+		for _, e := range entries {
+			after = e.ID
+			if ev, ok := decode(e); ok && filter(ev) {
+				sub.Replay = append(sub.Replay, ev)
+			}
+		}
+		if len(entries) < replayPage {
+			break
+		}
+	}
+	sub.lastReplayed = after
+	return sub, nil
+}
+
+// ErrClosed is returned by Next once the subscription was closed (hub
+// shutdown, Close, or dropped for being too slow).
+var ErrClosed = errors.New("subscription closed")
+
+// Next waits for the next live event. It returns ctx.Err() when ctx is
+// done and ErrClosed when the subscription was closed.
+func (s *Subscription) Next(ctx context.Context) (Event, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return Event{}, ctx.Err()
+		case ev, ok := <-s.c:
+			if !ok {
+				return Event{}, ErrClosed
+			}
+			if s.lastReplayed != "" && CompareIDs(ev.ID, s.lastReplayed) <= 0 {
+				continue
+			}
+			return ev, nil
+		}
+	}
+}
+
+// Overflowed reports whether the subscriber was dropped for being slow.
+func (s *Subscription) Overflowed() bool {
+	s.h.mu.Lock()
+	defer s.h.mu.Unlock()
+	return s.overflow
+}
+
+// Close unregisters the subscription.
+func (s *Subscription) Close() {
+	s.h.mu.Lock()
+	defer s.h.mu.Unlock()
+	if _, ok := s.h.subs[s]; ok {
+		delete(s.h.subs, s)
+		close(s.c)
+	}
+}
+
+// CompareIDs orders redis stream ids ("<ms>-<seq>").
+func CompareIDs(a, b string) int {
+	am, as := splitID(a)
+	bm, bs := splitID(b)
+	if c := cmp.Compare(am, bm); c != 0 {
+		return c
+	}
+	return cmp.Compare(as, bs)
+}
+
+func splitID(id string) (ms, seq uint64) {
+	m, s, _ := strings.Cut(id, "-")
+	ms, _ = strconv.ParseUint(m, 10, 64)
+	seq, _ = strconv.ParseUint(s, 10, 64)
+	return ms, seq
+}
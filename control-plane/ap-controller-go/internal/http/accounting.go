@@ -0,0 +1,201 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"ap-controller-go/internal/config"
+	"ap-controller-go/internal/events"
+	"ap-controller-go/internal/store"
+)
+
+// -------------------------------------------------------------------
+// Accounting ingest + quota enforcement
+// -------------------------------------------------------------------
+
+const (
+	acctStart   = "start"
+	acctInterim = "interim"
+	acctStop    = "stop"
+)
+
+// accountingIngest accepts per-MAC counters from an AP.
+//
+// Counters are cumulative since the client appeared on that AP; the
+// controller derives deltas itself, so a lost interim record only delays
+// accounting instead of losing traffic. A stop record closes the AP-side
+// counter baseline but keeps the session (the client may have roamed).
+func (s *Server) accountingIngest(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req AccountingReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, 400, map[string]any{"error": "bad_json"})
+		return
+	}
+	apID, code, errName := apCaller(r, req.APID)
+	if errName != "" {
+		writeJSON(w, code, map[string]any{"error": errName})
+		return
+	}
+	req.APID = apID
+
+	now := time.Now()
+	out := make([]AccountingResult, 0, len(req.Records))
+	for _, rec := range req.Records {
+		out = append(out, s.account(ctx, req.APID, rec, now))
+	}
+
+	writeJSON(w, 200, map[string]any{"results": out})
+}
+
+func (s *Server) account(ctx context.Context, apID string, rec AccountingRecord, now time.Time) AccountingResult {
+	mac := macNorm(rec.MAC)
+	res := AccountingResult{MAC: mac, Status: "ok"}
+
+	switch rec.Type {
+	case acctStart, acctInterim, acctStop:
+	default:
+		res.Status, res.Reason = "error", "bad_type"
+		return res
+	}
+
+	if rec.BytesIn < 0 || rec.BytesOut < 0 || rec.PacketsIn < 0 || rec.PacketsOut < 0 {
+		res.Status, res.Reason = "error", "bad_counters"
+		return res
+	}
+	raw := store.Counters{
+		BytesIn:    rec.BytesIn,
+		BytesOut:   rec.BytesOut,
+		PacketsIn:  rec.PacketsIn,
+		PacketsOut: rec.PacketsOut,
+	}
+
+	var delta store.Counters
+	sess, err := s.st.UpdateSession(ctx, mac, func(sess *store.SessionV2) error {
+		delta = sess.Usage.Accumulate(apID, raw, now.Unix())
+		if rec.Type == acctStop {
+			sess.Usage.Last.APID = ""
+			sess.Usage.Last.Raw = store.Counters{}
+		}
+		return nil
+	})
+	if err != nil {
+		res.Status, res.Reason = "error", "store"
+		return res
+	}
+	if sess == nil {
+		res.Status = "no_session"
+		return res
+	}
+
+	daily, err := s.st.AddDailyUsage(ctx, usageIdentity(sess), now, delta)
+	if err != nil {
+		res.Status, res.Reason = "error", "store"
+		return res
+	}
+
+	_, profile := s.profileFor(sess.Role)
+
+	reason := quotaExceeded(profile, sess.Usage.Total, daily)
+	if sess.Auth.Voucher != "" {
+		if vr := s.voucherUsage(ctx, sess, delta); reason == "" {
+			reason = vr
+		}
+	}
+	if reason != "" {
+		res.Status, res.Reason = s.applyQuota(ctx, sess, profile, reason), reason
+		return res
+	}
+
+	if profile.IdleTimeout > 0 && now.Unix()-sess.Usage.Active > int64(profile.IdleTimeout) {
+		s.terminateSession(ctx, sess, "idle_timeout")
+		res.Status = "idle_timeout"
+	}
+	return res
+}
+
+// usageIdentity is the key daily usage is accounted under.
+// Sessions without an identity are accounted per device.
+func usageIdentity(sess *store.SessionV2) string {
+	if sess.Auth.Identity != "" {
+		return sess.Auth.Identity
+	}
+	return sess.MAC
+}
+
+// quotaExceeded returns "session_quota" / "daily_quota" or "". The
+// quota of a voucher is checked by voucherUsage.
+func quotaExceeded(p config.Profile, session, daily store.Counters) string {
+	if p.SessionQuotaMB > 0 && session.Bytes() >= config.QuotaBytes(p.SessionQuotaMB) {
+		return "session_quota"
+	}
+	if p.DailyQuotaMB > 0 && daily.Bytes() >= config.QuotaBytes(p.DailyQuotaMB) {
+		return "daily_quota"
+	}
+	return ""
+}
+
+// dailyQuotaUsed checks the daily quota before a new session is created.
+func (s *Server) dailyQuotaUsed(ctx context.Context, identity, mac string, p config.Profile) string {
+	if p.DailyQuotaMB <= 0 {
+		return ""
+	}
+	if identity == "" {
+		identity = mac
+	}
+	daily, err := s.st.DailyUsage(ctx, identity, time.Now())
+	if err != nil {
+		return ""
+	}
+	return quotaExceeded(config.Profile{DailyQuotaMB: p.DailyQuotaMB}, store.Counters{}, daily)
+}
+
+// applyQuota terminates or throttles a session that ran out of quota and
+// returns the accounting status ("terminated" / "throttled").
+func (s *Server) applyQuota(ctx context.Context, sess *store.SessionV2, p config.Profile, reason string) string {
+	if p.QuotaAction != config.QuotaThrottle {
+		s.terminateSession(ctx, sess, reason)
+		return "terminated"
+	}
+
+	fromRole := sess.Role
+	toProfileName, toProfile := s.profileFor(p.ThrottleRole)
+	updated, err := s.st.UpdateSession(ctx, sess.MAC, func(cur *store.SessionV2) error {
+		cur.Role = p.ThrottleRole
+		cur.Profile = toProfileName
+		cur.ApplyProfile(toProfile)
+		cur.Usage.QuotaExceeded = reason
+		return nil
+	})
+	// the session now lives by the throttle profile's session_ttl
+	ttl := 0
+	if updated != nil {
+		ttl, _ = sessionTTL(updated, toProfile, time.Now().Unix())
+		if _, rerr := s.st.Refresh(ctx, sess.MAC, ttl); err == nil {
+			err = rerr
+		}
+	}
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+
+	s.audit.Write(map[string]any{
+		"event":     "portal.quota",
+		"mac":       sess.MAC,
+		"identity":  sess.Auth.Identity,
+		"reason":    reason,
+		"action":    config.QuotaThrottle,
+		"from_role": fromRole,
+		"to_role":   p.ThrottleRole,
+		"bytes":     sess.Usage.Total.Bytes(),
+		"result":    result,
+	})
+	if updated != nil {
+		s.publishSession(ctx, events.SessionRefreshed, updated, ttl, "")
+	}
+	return "throttled"
+}
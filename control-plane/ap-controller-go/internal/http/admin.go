@@ -0,0 +1,309 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"ap-controller-go/internal/security"
+	"ap-controller-go/internal/store"
+	"ap-controller-go/internal/tracing"
+)
+
+// -------------------------------------------------------------------
+// Admin authentication / RBAC
+// -------------------------------------------------------------------
+
+// SetAdminToken sets the static bearer token accepted by admin endpoints
+// (role admin). Unlike API keys it is valid in every tenant.
+func (s *Server) SetAdminToken(token string) {
+	s.adminToken = token
+}
+
+// SetAdminJWTSecret enables admin JWTs signed with secret.
+func (s *Server) SetAdminJWTSecret(secret []byte) {
+	s.adminJWTSecret = secret
+}
+
+// adminIdentity authenticates the bearer credential of r: an API key,
+// the static admin token or an admin JWT.
+func (s *Server) adminIdentity(r *http.Request) (security.AdminIdentity, string) {
+	token, ok := security.BearerToken(r.Header.Get("Authorization"))
+	if !ok {
+		return security.AdminIdentity{}, "missing_token"
+	}
+
+	if id, secret, ok := security.ParseAdminKey(token); ok {
+		ctx := r.Context()
+		k, err := s.st.GetAdminKey(ctx, id)
+		if err != nil {
+			return security.AdminIdentity{}, "store_error"
+		}
+		now := time.Now().Unix()
+		switch {
+		case k == nil || !security.EqualToken(security.HashAdminSecret(secret), k.Hash):
+			return security.AdminIdentity{}, "bad_key"
+		case k.Revoked != 0:
+			return security.AdminIdentity{}, "key_revoked"
+		case k.Expires != 0 && k.Expires <= now:
+			return security.AdminIdentity{}, "key_expired"
+		}
+		if err := s.st.TouchAdminKey(ctx, id, now); err != nil {
+			log.Printf("admin: touch key %s failed: %v", id, err)
+		}
+		// keys live in the tenant's keyspace, so they only work there
+		return security.AdminIdentity{Subject: k.Name, Role: k.Role, Method: "api_key", KeyID: k.ID, Tenant: s.tenantID()}, ""
+	}
+
+	if security.EqualToken(token, s.adminToken) {
+		return security.AdminIdentity{Subject: "admin_token", Role: security.AdminAdmin, Method: "token"}, ""
+	}
+	if s.adminJWTSecret != nil && strings.Count(token, ".") == 2 {
+		if id, err := security.VerifyAdminJWT(s.adminJWTSecret, token); err == nil {
+			if id.Tenant != "" && id.Tenant != s.tenantID() {
+				return security.AdminIdentity{}, "wrong_tenant"
+			}
+			return id, ""
+		}
+		return security.AdminIdentity{}, "bad_jwt"
+	}
+	return security.AdminIdentity{}, "bad_token"
+}
+
+// adminAuth authenticates admin routes and puts the caller's identity in
+// the request context. Routes then require a role with requireAdmin.
+func (s *Server) adminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, reason := s.adminIdentity(r)
+		if reason != "" {
+			s.audit.Write(map[string]any{
+				"event":    "admin.auth",
+				"method":   r.Method,
+				"path":     r.URL.Path,
+				"peer":     remoteHost(r),
+				"reason":   reason,
+				"trace_id": tracing.TraceID(r.Context()),
+				"result":   "fail",
+			})
+			if reason == "wrong_tenant" {
+				writeJSON(w, 403, map[string]any{"error": "wrong_tenant", "tenant": s.tenantID()})
+				return
+			}
+			writeJSON(w, 401, map[string]any{"error": "unauthorized"})
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(security.WithAdminIdentity(r.Context(), id)))
+	})
+}
+
+// requireAdmin rejects callers whose role lacks the permissions of role.
+func (s *Server) requireAdmin(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id, _ := security.AdminIdentityFrom(r.Context())
+			if !security.AdminRoleAllows(id.Role, role) {
+				s.auditAdmin(r, map[string]any{
+					"event":         "admin.denied",
+					"method":        r.Method,
+					"path":          r.URL.Path,
+					"required_role": role,
+					"result":        "forbidden",
+				})
+				writeJSON(w, 403, map[string]any{"error": "forbidden", "required_role": role})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// auditAdmin writes ev with the admin identity of r.
+func (s *Server) auditAdmin(r *http.Request, ev map[string]any) {
+	if id, ok := security.AdminIdentityFrom(r.Context()); ok {
+		ev["admin"] = id.Subject
+		ev["admin_role"] = id.Role
+		ev["admin_auth"] = id.Method
+		if id.KeyID != "" {
+			ev["admin_key"] = id.KeyID
+		}
+	}
+	if _, ok := ev["trace_id"]; !ok {
+		ev["trace_id"] = tracing.TraceID(r.Context())
+	}
+	s.audit.Write(ev)
+}
+
+// adminSubject names the caller in records (created_by, revoked_by).
+func adminSubject(r *http.Request) string {
+	if id, ok := security.AdminIdentityFrom(r.Context()); ok {
+		return id.Subject
+	}
+	return "admin"
+}
+
+func (s *Server) adminWhoami(w http.ResponseWriter, r *http.Request) {
+	id, _ := security.AdminIdentityFrom(r.Context())
+	writeJSON(w, 200, id)
+}
+
+// adminGetSession returns the session of any MAC; the portal-facing
+// /portal/status/{mac} only answers for the signed client MAC.
+func (s *Server) adminGetSession(w http.ResponseWriter, r *http.Request) {
+	mac := macNorm(chi.URLParam(r, "mac"))
+	sess, ttl, err := s.st.GetSessionFull(r.Context(), mac)
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": "store_error"})
+		return
+	}
+	if sess == nil {
+		writeJSON(w, 404, map[string]any{"error": "not_found", "mac": mac})
+		return
+	}
+	resp := s.buildSessionResp(sess, ttl)
+	resp["mac"] = mac
+	resp["ap_id"] = sess.AP.APID
+	resp["auth_method"] = sess.Auth.Method
+	resp["created"] = sess.TS.Created
+	writeJSON(w, 200, resp)
+}
+
+// -------------------------------------------------------------------
+// API keys
+// -------------------------------------------------------------------
+
+type adminKeyView struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Role      string `json:"role"`
+	Status    string `json:"status"` // active | revoked | expired
+	CreatedBy string `json:"created_by"`
+	Created   int64  `json:"created"`
+	Expires   int64  `json:"expires,omitempty"`
+	LastUsed  int64  `json:"last_used,omitempty"`
+	Revoked   int64  `json:"revoked,omitempty"`
+	RevokedBy string `json:"revoked_by,omitempty"`
+}
+
+func keyView(k store.AdminKey, now int64) adminKeyView {
+	status := "active"
+	switch {
+	case k.Revoked != 0:
+		status = "revoked"
+	case k.Expires != 0 && k.Expires <= now:
+		status = "expired"
+	}
+	return adminKeyView{
+		ID:        k.ID,
+		Name:      k.Name,
+		Role:      k.Role,
+		Status:    status,
+		CreatedBy: k.CreatedBy,
+		Created:   k.Created,
+		Expires:   k.Expires,
+		LastUsed:  k.LastUsed,
+		Revoked:   k.Revoked,
+		RevokedBy: k.RevokedBy,
+	}
+}
+
+// adminKeyCreate issues an API key. The secret is only in this response.
+func (s *Server) adminKeyCreate(w http.ResponseWriter, r *http.Request) {
+	var req AdminKeyReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, 400, map[string]any{"error": "bad_json"})
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		writeJSON(w, 422, map[string]any{"error": "name_required"})
+		return
+	}
+	if !security.ValidAdminRole(req.Role) {
+		writeJSON(w, 422, map[string]any{"error": "unknown_role"})
+		return
+	}
+	if req.TTL < 0 {
+		writeJSON(w, 422, map[string]any{"error": "bad_ttl"})
+		return
+	}
+
+	id, secret, token, err := security.NewAdminKey()
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": "keygen_failed"})
+		return
+	}
+	now := time.Now().Unix()
+	k := store.AdminKey{
+		ID:        id,
+		Name:      req.Name,
+		Role:      req.Role,
+		Hash:      security.HashAdminSecret(secret),
+		CreatedBy: adminSubject(r),
+		Created:   now,
+	}
+	if req.TTL > 0 {
+		k.Expires = now + int64(req.TTL)
+	}
+	ok, err := s.st.CreateAdminKey(r.Context(), k)
+	if err != nil || !ok {
+		writeJSON(w, 500, map[string]any{"error": "store_error"})
+		return
+	}
+
+	s.auditAdmin(r, map[string]any{
+		"event":   "admin.key_create",
+		"key_id":  k.ID,
+		"name":    k.Name,
+		"role":    k.Role,
+		"expires": k.Expires,
+		"result":  "ok",
+	})
+
+	writeJSON(w, 200, map[string]any{
+		"key":     token,
+		"id":      k.ID,
+		"name":    k.Name,
+		"role":    k.Role,
+		"expires": k.Expires,
+	})
+}
+
+func (s *Server) adminKeyList(w http.ResponseWriter, r *http.Request) {
+	keys, err := s.st.ListAdminKeys(r.Context())
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": "store_error"})
+		return
+	}
+	now := time.Now().Unix()
+	out := make([]adminKeyView, len(keys))
+	for i, k := range keys {
+		out[i] = keyView(k, now)
+	}
+	writeJSON(w, 200, map[string]any{"keys": out})
+}
+
+func (s *Server) adminKeyRevoke(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	k, err := s.st.RevokeAdminKey(r.Context(), id, adminSubject(r), time.Now().Unix())
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": "store_error"})
+		return
+	}
+	if k == nil {
+		writeJSON(w, 404, map[string]any{"error": "not_found"})
+		return
+	}
+
+	s.auditAdmin(r, map[string]any{
+		"event":  "admin.key_revoke",
+		"key_id": k.ID,
+		"name":   k.Name,
+		"role":   k.Role,
+		"result": "ok",
+	})
+	writeJSON(w, 200, keyView(*k, time.Now().Unix()))
+}
@@ -0,0 +1,446 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"ap-controller-go/internal/events"
+	"ap-controller-go/internal/security"
+	"ap-controller-go/internal/store"
+	"ap-controller-go/internal/tracing"
+)
+
+// -------------------------------------------------------------------
+// Batch status / bulk session operations
+// -------------------------------------------------------------------
+
+type batchItem struct {
+	MAC           string         `json:"mac"`
+	Authorized    bool           `json:"authorized"`
+	Role          *string        `json:"role,omitempty"`
+	TTL           *int           `json:"ttl,omitempty"`
+	PolicyVersion *string        `json:"policy_version,omitempty"`
+	Profile       map[string]any `json:"profile,omitempty"`
+}
+
+// pages calls fn for consecutive slices of at most size items.
+func pages[T any](items []T, size int, fn func([]T) error) error {
+	for len(items) > 0 {
+		n := min(size, len(items))
+		if err := fn(items[:n]); err != nil {
+			return err
+		}
+		items = items[n:]
+	}
+	return nil
+}
+
+// batchBodyLimit bounds the body of a batch request of at most n entries
+// (a few dozen bytes each, with room for whitespace).
+func batchBodyLimit(n int) int64 {
+	return 4096 + int64(n)*256
+}
+
+// decodeLimited decodes a JSON body of at most limit bytes into v. It
+// writes the error response and returns false on failure.
+func decodeLimited(w http.ResponseWriter, r *http.Request, v any, limit int64) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeJSON(w, 413, map[string]any{"error": "body_too_large", "max_bytes": limit})
+		} else {
+			writeJSON(w, 400, map[string]any{"error": "bad_json"})
+		}
+		return false
+	}
+	return true
+}
+
+// validMAC accepts a normalized colon-separated 48-bit MAC.
+func validMAC(m string) bool {
+	hw, err := net.ParseMAC(m)
+	return err == nil && len(hw) == 6 && hw.String() == m
+}
+
+// portalBatchStatus is batch status for a portal signer, which (as for
+// GET /portal/status/{mac}) may only ask about its own client. APs use
+// POST /api/v1/ap/batch_status, ops tooling
+// POST /api/v1/admin/sessions/batch_status.
+func (s *Server) portalBatchStatus(w http.ResponseWriter, r *http.Request) {
+	req, ok := s.decodeBatch(w, r)
+	if !ok {
+		return
+	}
+	self, _ := r.Context().Value(security.CtxKeyClientMAC).(string)
+	for _, e := range req.Entries {
+		if macNorm(e.MAC) != macNorm(self) {
+			writeJSON(w, 403, map[string]any{"error": "mac_mismatch"})
+			return
+		}
+	}
+	s.batchStatus(w, r, req.Entries, nil)
+}
+
+// apBatchStatus is batch status for an AP: sessions bound to another AP
+// read as not authorized.
+func (s *Server) apBatchStatus(w http.ResponseWriter, r *http.Request) {
+	req, ok := s.decodeBatch(w, r)
+	if !ok {
+		return
+	}
+	apID, code, errName := apCaller(r, req.APID)
+	if errName != "" {
+		writeJSON(w, code, map[string]any{"error": errName})
+		return
+	}
+	s.batchStatus(w, r, req.Entries, func(sess *store.SessionV2) bool {
+		return sess.AP.APID == "" || sess.AP.APID == apID
+	})
+}
+
+// adminBatchStatus is batch status across all clients (readonly).
+func (s *Server) adminBatchStatus(w http.ResponseWriter, r *http.Request) {
+	req, ok := s.decodeBatch(w, r)
+	if !ok {
+		return
+	}
+	s.batchStatus(w, r, req.Entries, nil)
+}
+
+// decodeBatch reads a batch status request within the configured size.
+func (s *Server) decodeBatch(w http.ResponseWriter, r *http.Request) (BatchReq, bool) {
+	limits := s.cfg.Controller.Batch.WithDefaults()
+
+	var req BatchReq
+	if !decodeLimited(w, r, &req, batchBodyLimit(limits.MaxStatus)) {
+		return req, false
+	}
+	if len(req.Entries) > limits.MaxStatus {
+		writeJSON(w, 413, map[string]any{"error": "too_many_entries", "max": limits.MaxStatus})
+		return req, false
+	}
+	return req, true
+}
+
+// batchStatus returns the session state of many MACs; sessions visible
+// rejects (nil = all visible) read as not authorized. Sessions are read
+// page by page with pipelined MGET / TTL and the JSON array is
+// streamed, so large syncs neither pay a round trip per MAC nor buffer
+// the whole response.
+//
+// A store error must not read as "not authorized": it fails the request
+// with 500 before the first page, and aborts the connection (leaving the
+// JSON incomplete) after it.
+func (s *Server) batchStatus(w http.ResponseWriter, r *http.Request, entries []BatchEntry, visible func(*store.SessionV2) bool) {
+	ctx := r.Context()
+	limits := s.cfg.Controller.Batch.WithDefaults()
+
+	macs := make([]string, len(entries))
+	for i, e := range entries {
+		macs[i] = macNorm(e.MAC)
+	}
+
+	n := min(limits.PipelineSize, len(macs))
+	res, err := s.st.GetSessions(ctx, macs[:n])
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": "store_error"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+
+	_, _ = io.WriteString(w, `{"results":[`)
+	first, sent := true, 0
+	write := func(res []store.SessionStatus) {
+		sent += len(res)
+		for _, st := range res {
+			if !first {
+				_, _ = io.WriteString(w, ",")
+			}
+			first = false
+			if st.Session != nil && visible != nil && !visible(st.Session) {
+				st.Session = nil
+			}
+			_ = enc.Encode(s.batchItem(st))
+		}
+		_ = rc.Flush()
+	}
+	write(res)
+	err = pages(macs[n:], limits.PipelineSize, func(page []string) error {
+		res, err := s.st.GetSessions(ctx, page)
+		if err != nil {
+			return err
+		}
+		write(res)
+		return ctx.Err()
+	})
+	if err != nil {
+		log.Printf("batch_status: aborted after %d entries: %v", sent, err)
+		panic(http.ErrAbortHandler)
+	}
+	_, _ = io.WriteString(w, "]}\n")
+}
+
+func (s *Server) batchItem(st store.SessionStatus) batchItem {
+	if st.Session == nil {
+		return batchItem{MAC: st.MAC}
+	}
+	role := st.Session.Role
+	pv := st.Session.PolicyVersion
+	ttl := st.TTL
+	resp := s.buildSessionResp(st.Session, ttl)
+	return batchItem{
+		MAC:           st.MAC,
+		Authorized:    true,
+		Role:          &role,
+		TTL:           &ttl,
+		PolicyVersion: &pv,
+		Profile:       resp["profile"].(map[string]any),
+	}
+}
+
+// bulkMACs normalizes and de-duplicates macs. Invalid entries are
+// returned separately.
+func bulkMACs(raw []string) (macs, invalid []string) {
+	seen := make(map[string]bool, len(raw))
+	for _, r := range raw {
+		m := macNorm(r)
+		if !validMAC(m) {
+			invalid = append(invalid, r)
+			continue
+		}
+		if !seen[m] {
+			seen[m] = true
+			macs = append(macs, m)
+		}
+	}
+	return macs, invalid
+}
+
+// adminBulkLogin pre-authorizes a list of MACs with a role, replacing
+// their sessions. The sessions carry no identity, so per-identity device
+// and daily quota limits don't apply: the operator decides.
+func (s *Server) adminBulkLogin(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	limits := s.cfg.Controller.Batch.WithDefaults()
+
+	var req BulkLoginReq
+	if !decodeLimited(w, r, &req, batchBodyLimit(limits.MaxBulk)) {
+		return
+	}
+	if _, ok := s.cfg.Roles[req.Role]; !ok {
+		writeJSON(w, 422, map[string]any{"error": "unknown_role"})
+		return
+	}
+	if req.TTL < 0 {
+		writeJSON(w, 422, map[string]any{"error": "bad_ttl"})
+		return
+	}
+	if len(req.MACs) > limits.MaxBulk {
+		writeJSON(w, 413, map[string]any{"error": "too_many_macs", "max": limits.MaxBulk})
+		return
+	}
+	macs, invalid := bulkMACs(req.MACs)
+	if len(macs) == 0 {
+		writeJSON(w, 422, map[string]any{"error": "macs_required", "invalid": invalid})
+		return
+	}
+	// denylisted devices are left out, not authorized by the operator
+	denied := s.deniedMACs(ctx, macs, "bulk_login")
+	if len(denied) > 0 {
+		macs = without(macs, denied)
+	}
+	if len(macs) == 0 {
+		writeJSON(w, 403, map[string]any{"error": "denylisted", "denied": denied})
+		return
+	}
+
+	source := req.Source
+	if source == "" {
+		source = "admin"
+	}
+	profileName, profile := s.profileFor(req.Role)
+	if req.TTL > 0 {
+		profile.SessionTTL = req.TTL
+	}
+	now := time.Now().Unix()
+
+	writes := make([]store.SessionWrite, len(macs))
+	changes := make([]store.ClientChange, len(macs))
+	evs := make([]events.Event, 0, len(macs))
+	for i, m := range macs {
+		sess := store.SessionV2{
+			Schema:        store.SessionSchema,
+			MAC:           m,
+			Role:          req.Role,
+			Profile:       profileName,
+			PolicyVersion: s.policyVersion,
+		}
+		sess.AP.APID = req.APID
+		sess.ApplyProfile(profile)
+		sess.Auth.Method = "bulk"
+		sess.Auth.Source = source
+		sess.TS.Created = now
+		if profile.MaxSessionLifetime > 0 {
+			sess.TS.Expires = now + int64(profile.MaxSessionLifetime)
+		}
+		ttl, _ := sessionTTL(&sess, profile, now)
+		writes[i] = store.SessionWrite{Session: sess, TTL: ttl}
+		changes[i] = s.clientChange(events.SessionCreated, &sess, ttl)
+		if s.pushing() {
+			evs = append(evs, sessionEvent(events.SessionCreated, &sess, ttl, ""))
+		}
+	}
+
+	var replaced []*store.SessionV2
+	err := pages(writes, limits.PipelineSize, func(page []store.SessionWrite) error {
+		res, err := s.st.SetSessions(ctx, page)
+		for _, old := range res {
+			if old != nil {
+				replaced = append(replaced, old)
+			}
+		}
+		return err
+	})
+	// the bulk sessions carry no identity: free the device slots of the
+	// sessions they replaced
+	for _, old := range replaced {
+		if old.Auth.Identity != "" {
+			_ = s.st.RemoveDevice(ctx, old.Auth.Identity, old.MAC)
+		}
+	}
+	result := "ok"
+	if err != nil {
+		result = "store_error"
+	}
+	s.auditAdmin(r, map[string]any{
+		"event":    "portal.bulk_login",
+		"role":     req.Role,
+		"source":   source,
+		"ap_id":    req.APID,
+		"ttl":      writes[0].TTL,
+		"count":    len(macs),
+		"macs":     macs,
+		"invalid":  len(invalid),
+		"denied":   len(denied),
+		"trace_id": tracing.TraceID(ctx),
+		"result":   result,
+	})
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": "store_error"})
+		return
+	}
+
+	s.logClients(ctx, changes)
+	s.publishAll(ctx, evs)
+
+	writeJSON(w, 200, map[string]any{
+		"role":       req.Role,
+		"ttl":        writes[0].TTL,
+		"authorized": len(macs),
+		"invalid":    nonNil(invalid),
+		"denied":     nonNil(denied),
+	})
+}
+
+// without returns macs minus drop, in order.
+func without(macs, drop []string) []string {
+	skip := make(map[string]bool, len(drop))
+	for _, m := range drop {
+		skip[m] = true
+	}
+	out := macs[:0:0]
+	for _, m := range macs {
+		if !skip[m] {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// adminBulkLogout ends the sessions of a list of MACs.
+func (s *Server) adminBulkLogout(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	limits := s.cfg.Controller.Batch.WithDefaults()
+
+	var req BulkLogoutReq
+	if !decodeLimited(w, r, &req, batchBodyLimit(limits.MaxBulk)) {
+		return
+	}
+	if len(req.MACs) > limits.MaxBulk {
+		writeJSON(w, 413, map[string]any{"error": "too_many_macs", "max": limits.MaxBulk})
+		return
+	}
+	macs, invalid := bulkMACs(req.MACs)
+	reason := req.Reason
+	if reason == "" {
+		reason = "admin_logout"
+	}
+
+	var deleted []*store.SessionV2
+	err := pages(macs, limits.PipelineSize, func(page []string) error {
+		res, err := s.st.DeleteSessions(ctx, page)
+		for _, sess := range res {
+			if sess != nil {
+				deleted = append(deleted, sess)
+			}
+		}
+		return err
+	})
+
+	loggedOut := make([]string, 0, len(deleted))
+	changes := make([]store.ClientChange, 0, len(deleted))
+	evs := make([]events.Event, 0, len(deleted))
+	for _, sess := range deleted {
+		loggedOut = append(loggedOut, sess.MAC)
+		changes = append(changes, s.clientChange(events.SessionDeleted, sess, 0))
+		if sess.Auth.Identity != "" {
+			_ = s.st.RemoveDevice(ctx, sess.Auth.Identity, sess.MAC)
+		}
+		if s.pushing() {
+			evs = append(evs, sessionEvent(events.SessionDeleted, sess, 0, reason))
+		}
+	}
+	s.logClients(ctx, changes)
+	s.publishAll(ctx, evs)
+
+	result := "ok"
+	if err != nil {
+		result = "store_error"
+	}
+	s.auditAdmin(r, map[string]any{
+		"event":    "portal.bulk_logout",
+		"reason":   reason,
+		"count":    len(loggedOut),
+		"macs":     loggedOut,
+		"invalid":  len(invalid),
+		"trace_id": tracing.TraceID(ctx),
+		"result":   result,
+	})
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": "store_error", "logged_out": loggedOut})
+		return
+	}
+
+	writeJSON(w, 200, map[string]any{
+		"logged_out": loggedOut,
+		"not_found":  len(macs) - len(loggedOut),
+		"invalid":    nonNil(invalid),
+	})
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
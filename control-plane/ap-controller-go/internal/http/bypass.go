@@ -0,0 +1,149 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"ap-controller-go/internal/policy"
+	"ap-controller-go/internal/store"
+)
+
+// -------------------------------------------------------------------
+// Dynamic bypass entries
+// -------------------------------------------------------------------
+
+const bypassReasonMax = 200
+
+// adminBypassList lists the active dynamic bypass entries; ?site= and
+// ?ap_id= keep the ones applying there.
+func (s *Server) adminBypassList(w http.ResponseWriter, r *http.Request) {
+	entries, err := s.st.ListBypass(r.Context(), time.Now().Unix())
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": "store_error"})
+		return
+	}
+	site, apID := r.URL.Query().Get("site"), r.URL.Query().Get("ap_id")
+	if site != "" || apID != "" {
+		kept := entries[:0]
+		for _, e := range entries {
+			if (policy.BypassEntry{Scope: e.Scope, Site: e.Site, APID: e.APID}).AppliesTo(site, apID) {
+				kept = append(kept, e)
+			}
+		}
+		entries = kept
+	}
+	writeJSON(w, 200, map[string]any{"entries": entries})
+}
+
+// adminBypassAdd adds (or replaces) a dynamic bypass entry and publishes
+// the new runtime policy.
+func (s *Server) adminBypassAdd(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req BypassReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, 400, map[string]any{"error": "bad_json"})
+		return
+	}
+	if active, _, _ := s.rolloutConfigs(ctx); !active.Bypass.Enabled {
+		// the APs ignore every bypass list
+		writeJSON(w, 409, map[string]any{"error": "bypass_disabled"})
+		return
+	}
+	value, ok := store.ParseBypassValue(req.Type, req.Value)
+	if !ok {
+		writeJSON(w, 422, map[string]any{"error": "bad_value"})
+		return
+	}
+	req.Site, req.APID = strings.TrimSpace(req.Site), strings.TrimSpace(req.APID)
+	switch req.Scope {
+	case "", policy.ScopeGlobal:
+		req.Scope = policy.ScopeGlobal
+		ok = req.Site == "" && req.APID == ""
+	case policy.ScopeSite:
+		ok = req.Site != "" && req.APID == ""
+	case policy.ScopeAP:
+		ok = req.APID != "" && req.Site == ""
+	default:
+		ok = false
+	}
+	if !ok {
+		writeJSON(w, 422, map[string]any{"error": "bad_scope"})
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" || len(req.Reason) > bypassReasonMax {
+		writeJSON(w, 422, map[string]any{"error": "reason_required"})
+		return
+	}
+	if req.TTL < 0 {
+		writeJSON(w, 422, map[string]any{"error": "bad_ttl"})
+		return
+	}
+
+	now := time.Now().Unix()
+	e := store.BypassEntry{
+		ID:        store.BypassEntryID(req.Type, req.Scope, req.Site, req.APID, value),
+		Type:      req.Type,
+		Value:     value,
+		Scope:     req.Scope,
+		Site:      req.Site,
+		APID:      req.APID,
+		Reason:    req.Reason,
+		CreatedBy: adminSubject(r),
+		Created:   now,
+	}
+	if req.TTL > 0 {
+		e.Expires = now + int64(req.TTL)
+	}
+	if err := s.st.AddBypass(ctx, e); err != nil {
+		writeJSON(w, 500, map[string]any{"error": "store_error"})
+		return
+	}
+
+	s.auditAdmin(r, map[string]any{
+		"event":   "admin.bypass_add",
+		"id":      e.ID,
+		"type":    e.Type,
+		"value":   e.Value,
+		"scope":   e.Scope,
+		"site":    e.Site,
+		"ap_id":   e.APID,
+		"reason":  e.Reason,
+		"expires": e.Expires,
+		"result":  "ok",
+	})
+	s.policyChanged(ctx)
+	writeJSON(w, 200, e)
+}
+
+// adminBypassRemove removes a dynamic bypass entry. Static entries of
+// the config can't be removed here.
+func (s *Server) adminBypassRemove(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	e, err := s.st.RemoveBypass(ctx, chi.URLParam(r, "id"))
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": "store_error"})
+		return
+	}
+	if e == nil {
+		writeJSON(w, 404, map[string]any{"error": "not_found"})
+		return
+	}
+	s.auditAdmin(r, map[string]any{
+		"event":  "admin.bypass_remove",
+		"id":     e.ID,
+		"type":   e.Type,
+		"value":  e.Value,
+		"scope":  e.Scope,
+		"site":   e.Site,
+		"ap_id":  e.APID,
+		"result": "ok",
+	})
+	s.policyChanged(ctx)
+	writeJSON(w, 200, map[string]any{"id": e.ID, "removed": true})
+}
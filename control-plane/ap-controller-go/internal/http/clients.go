@@ -0,0 +1,159 @@
+package httpapi
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"ap-controller-go/internal/events"
+	"ap-controller-go/internal/store"
+)
+
+// -------------------------------------------------------------------
+// Authorized-client feed (data-plane ipset sync)
+// -------------------------------------------------------------------
+
+var cursorRe = regexp.MustCompile(`^\d+-\d+$`)
+
+// clientGroup is the firewall group (ipset) a session belongs in.
+func (s *Server) clientGroup(sess *store.SessionV2) string {
+	if sess.Attrs.FirewallGroup != "" {
+		return sess.Attrs.FirewallGroup
+	}
+	name := sess.Profile
+	if name == "" {
+		name = s.cfg.Roles[sess.Role].Profile
+	}
+	return s.cfg.Profiles[name].FirewallGroup
+}
+
+// clientChange maps a session event type to a change-log entry.
+func (s *Server) clientChange(typ string, sess *store.SessionV2, ttl int) store.ClientChange {
+	c := store.ClientChange{MAC: sess.MAC, APID: sess.AP.APID, Group: s.clientGroup(sess), TS: time.Now().Unix()}
+	switch typ {
+	case events.SessionCreated:
+		c.Op, c.TTL = store.ClientAdd, ttl
+	case events.SessionRefreshed:
+		c.Op, c.TTL = store.ClientRefresh, ttl
+	default:
+		c.Op = store.ClientRemove
+	}
+	return c
+}
+
+// logClients appends to the client change log. Failures are logged
+// only: an AP that misses a change resyncs from a full snapshot once its
+// cursor falls off the log, and the ipset timeout bounds the damage.
+func (s *Server) logClients(ctx context.Context, changes []store.ClientChange) {
+	maxLen := int64(s.cfg.Controller.ClientFeed.MaxLen)
+	if maxLen <= 0 {
+		maxLen = 100000
+	}
+	if err := s.st.AppendClientChanges(ctx, changes, maxLen); err != nil {
+		log.Printf("clients: log %d changes failed: %v", len(changes), err)
+	}
+}
+
+type feedClient struct {
+	MAC string `json:"mac"`
+	TTL int    `json:"ttl"`
+}
+
+// forAP reports whether an AP gets c: the clients of a session go to its
+// AP only (or to every AP if it has none), removals to every AP, as the
+// client may have roamed since the session was logged.
+func forAP(c store.ClientChange, apID string) bool {
+	return c.Op == store.ClientRemove || c.APID == "" || c.APID == apID
+}
+
+// apClients returns the authorized clients of the calling AP grouped by
+// firewall group.
+// With ?since=<cursor> it returns only the changes after cursor; when the
+// cursor is no longer in the change log the response falls back to a
+// full snapshot ("mode": "full") and the AP rebuilds its ipsets. That
+// includes the "0-0" cursor of an empty log once changes arrive: a trimmed
+// log looks the same.
+func (s *Server) apClients(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	q := r.URL.Query()
+
+	apID, code, errName := apCaller(r, q.Get("ap_id"))
+	if errName != "" {
+		writeJSON(w, code, map[string]any{"error": errName})
+		return
+	}
+	since := q.Get("since")
+	if since != "" && !cursorRe.MatchString(since) {
+		writeJSON(w, 400, map[string]any{"error": "bad_cursor"})
+		return
+	}
+	limit := s.cfg.Controller.ClientFeed.PageSize
+	if limit <= 0 {
+		limit = 1000
+	}
+	if n, err := strconv.Atoi(q.Get("limit")); err == nil && n > 0 && n < limit {
+		limit = n
+	}
+
+	first, last, err := s.st.ClientLogBounds(ctx)
+	if err != nil {
+		writeJSON(w, 503, map[string]any{"error": "store_error"})
+		return
+	}
+	if last == "" {
+		last = "0-0"
+	}
+
+	if since != "" && (first == "" || events.CompareIDs(since, first) >= 0) &&
+		events.CompareIDs(since, last) <= 0 {
+		entries, err := s.st.ClientChangesAfter(ctx, since, int64(limit))
+		if err != nil {
+			writeJSON(w, 503, map[string]any{"error": "store_error"})
+			return
+		}
+		// the cursor moves past the changes of other APs too
+		cursor := since
+		changes := make([]store.ClientChange, 0, len(entries))
+		for _, e := range entries {
+			if forAP(e.ClientChange, apID) {
+				changes = append(changes, e.ClientChange)
+			}
+			cursor = e.ID
+		}
+		writeJSON(w, 200, map[string]any{
+			"mode":    "delta",
+			"cursor":  cursor,
+			"changes": changes,
+			"more":    len(entries) == limit,
+		})
+		return
+	}
+
+	// the cursor is read before the scan: changes made during it are
+	// replayed by the next delta, and re-applying them is harmless
+	groups := map[string][]feedClient{}
+	err = s.st.ScanSessions(ctx, func(page []store.SessionStatus) {
+		for _, st := range page {
+			if st.Session == nil || st.TTL <= 0 {
+				continue
+			}
+			if a := st.Session.AP.APID; a != "" && a != apID {
+				continue
+			}
+			g := s.clientGroup(st.Session)
+			groups[g] = append(groups[g], feedClient{MAC: st.MAC, TTL: st.TTL})
+		}
+	})
+	if err != nil {
+		writeJSON(w, 503, map[string]any{"error": "store_error"})
+		return
+	}
+	writeJSON(w, 200, map[string]any{
+		"mode":   "full",
+		"cursor": last,
+		"groups": groups,
+	})
+}
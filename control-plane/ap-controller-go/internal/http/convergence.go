@@ -0,0 +1,352 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"ap-controller-go/internal/policy"
+	"ap-controller-go/internal/store"
+)
+
+// -------------------------------------------------------------------
+// Policy convergence
+// -------------------------------------------------------------------
+
+const (
+	policyApplied = "applied"
+	policyFailed  = "failed"
+
+	convCurrent = "current"
+	convLagging = "lagging"
+	convFailed  = "failed"
+)
+
+// policyTargets are the policies APs should converge to: the active
+// one, and the candidate on the canary APs of a running rollout.
+type policyTargets struct {
+	active, candidate             policy.ControllerVersion
+	published, candidatePublished int64
+	rollout                       *store.Rollout
+}
+
+// policyTargets returns the current targets and when each was first
+// published.
+func (s *Server) policyTargets(ctx context.Context, now int64) policyTargets {
+	var t policyTargets
+	rp, _ := s.runtimePolicy(ctx)
+	t.active, t.published = rp.Version, s.policyPublished(ctx, rp.Version.Checksum, now)
+	if ro, cp, err := s.candidatePolicy(ctx); err == nil && ro != nil {
+		t.rollout = ro
+		t.candidate, t.candidatePublished = cp.Version, s.policyPublished(ctx, cp.Version.Checksum, now)
+	}
+	return t
+}
+
+// policyPublished returns when checksum was published, or now if the
+// publish job has not recorded it yet.
+func (s *Server) policyPublished(ctx context.Context, checksum string, now int64) int64 {
+	published, ok, err := s.st.PolicyPublished(ctx, checksum)
+	if err != nil || !ok {
+		return now
+	}
+	return published
+}
+
+// canary reports whether rec is a canary of the running rollout.
+func (t policyTargets) canary(rec store.APRecord) bool {
+	return t.rollout != nil && t.rollout.Canary.Selects(t.rollout.ID, rec.Site, rec.APID)
+}
+
+// forAP returns the policy rec should run and when it was published.
+func (t policyTargets) forAP(rec store.APRecord) (policy.ControllerVersion, int64) {
+	if t.canary(rec) {
+		return t.candidate, t.candidatePublished
+	}
+	return t.active, t.published
+}
+
+// convergenceState classifies an AP against the current checksum.
+func convergenceState(rec store.APRecord, checksum string) string {
+	switch {
+	case rec.PolicyChecksum == checksum:
+		return convCurrent
+	case rec.PolicyStatus == policyFailed && rec.FailedChecksum == checksum:
+		return convFailed
+	default:
+		return convLagging
+	}
+}
+
+// policyAck records that an AP applied (or failed to apply) a policy.
+func (s *Server) policyAck(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req PolicyAckReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, 400, map[string]any{"error": "bad_json"})
+		return
+	}
+	apID, code, errName := apCaller(r, req.APID)
+	if errName != "" {
+		writeJSON(w, code, map[string]any{"error": errName})
+		return
+	}
+	if errName := checkPolicyAck(req); errName != "" {
+		writeJSON(w, 422, map[string]any{"error": errName})
+		return
+	}
+
+	rec, state, cur, err := s.ackPolicy(ctx, apID, req)
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": "store_error"})
+		return
+	}
+	if rec == nil {
+		writeJSON(w, 404, map[string]any{"error": "not_registered"})
+		return
+	}
+
+	writeJSON(w, 200, map[string]any{
+		"ap_id":            apID,
+		"state":            state,
+		"current_checksum": cur.Checksum,
+	})
+}
+
+// checkPolicyAck returns the error name for an invalid ack, or "".
+func checkPolicyAck(req PolicyAckReq) string {
+	if req.Checksum == "" {
+		return "checksum_required"
+	}
+	if req.Status != policyApplied && req.Status != policyFailed {
+		return "bad_status"
+	}
+	return ""
+}
+
+// ackPolicy records an ack on the AP record and audits it. rec is nil
+// when the AP is not registered.
+func (s *Server) ackPolicy(ctx context.Context, apID string, req PolicyAckReq) (*store.APRecord, string, policy.ControllerVersion, error) {
+	now := time.Now().Unix()
+	rec, err := s.st.UpdateAP(ctx, apID, func(rec *store.APRecord, exists bool) error {
+		if !exists {
+			return store.ErrSkipUpdate
+		}
+		rec.PolicyStatus = req.Status
+		rec.PolicyAckAt = now
+		if req.Status == policyApplied {
+			rec.PolicyChecksum = req.Checksum
+			rec.PolicyVersion = req.Version
+			rec.PolicyError = ""
+			rec.FailedChecksum = ""
+		} else {
+			rec.PolicyError = req.Error
+			rec.FailedChecksum = req.Checksum
+		}
+		return nil
+	})
+	if err != nil || rec == nil {
+		return nil, "", policy.ControllerVersion{}, err
+	}
+
+	cur, _ := s.policyTargets(ctx, now).forAP(*rec)
+	state := convergenceState(*rec, cur.Checksum)
+
+	result := "ok"
+	if req.Status == policyFailed {
+		result = "fail"
+	}
+	s.audit.Write(map[string]any{
+		"event":    "policy.ack",
+		"ap_id":    apID,
+		"site":     rec.Site,
+		"checksum": req.Checksum,
+		"version":  req.Version,
+		"status":   req.Status,
+		"error":    req.Error,
+		"state":    state,
+		"result":   result,
+	})
+
+	return rec, state, cur, nil
+}
+
+// CheckStalePolicies raises one "policy.stale" alert per AP and checksum
+// when an AP is still not current stale_after seconds after its policy
+// (the candidate on canary APs) was published. It returns the ap_ids
+// alerted.
+func (s *Server) CheckStalePolicies(ctx context.Context, now time.Time) ([]string, error) {
+	staleAfter := s.cfg.Controller.Convergence.StaleAfter
+	if staleAfter < 0 {
+		return nil, nil
+	}
+	targets := s.policyTargets(ctx, now.Unix())
+
+	aps, err := s.st.ListAPs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var alerted []string
+	for _, ap := range aps {
+		cur, published := targets.forAP(ap)
+		if now.Unix()-published < int64(staleAfter) {
+			continue
+		}
+		if convergenceState(ap, cur.Checksum) == convCurrent || ap.StaleAlerted == cur.Checksum {
+			continue
+		}
+		rec, err := s.st.UpdateAP(ctx, ap.APID, func(rec *store.APRecord, exists bool) error {
+			if !exists || rec.PolicyChecksum == cur.Checksum || rec.StaleAlerted == cur.Checksum {
+				return store.ErrSkipUpdate
+			}
+			rec.StaleAlerted = cur.Checksum
+			return nil
+		})
+		if err != nil || rec == nil {
+			continue
+		}
+		alerted = append(alerted, ap.APID)
+
+		state := convergenceState(*rec, cur.Checksum)
+		log.Printf("ap %s still on policy %q (%s), current %q published %ds ago",
+			rec.APID, rec.PolicyChecksum, state, cur.Checksum, now.Unix()-published)
+		s.auditJob(ctx, map[string]any{
+			"event":            "policy.stale",
+			"severity":         "alert",
+			"ap_id":            rec.APID,
+			"site":             rec.Site,
+			"state":            state,
+			"checksum":         rec.PolicyChecksum,
+			"current_checksum": cur.Checksum,
+			"published":        published,
+			"offline":          rec.Offline,
+			"policy_error":     rec.PolicyError,
+			"result":           "ok",
+		})
+	}
+	return alerted, nil
+}
+
+// -------------------------------------------------------------------
+// Admin
+// -------------------------------------------------------------------
+
+type convergenceAP struct {
+	APID     string `json:"ap_id"`
+	Site     string `json:"site,omitempty"`
+	Checksum string `json:"checksum,omitempty"`
+	Version  string `json:"version,omitempty"`
+	Error    string `json:"error,omitempty"`
+	AckAt    int64  `json:"ack_at,omitempty"`
+	Offline  bool   `json:"offline,omitempty"`
+	Canary   bool   `json:"canary,omitempty"`
+}
+
+type policyVersionCount struct {
+	Checksum  string `json:"checksum"`
+	Version   string `json:"version,omitempty"`
+	APs       int    `json:"aps"`
+	Current   bool   `json:"current"`
+	Candidate bool   `json:"candidate,omitempty"`
+}
+
+// adminPolicyConvergence reports which APs run the current policy (the
+// candidate on canary APs). ?site= restricts the report to one site.
+func (s *Server) adminPolicyConvergence(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	now := time.Now().Unix()
+
+	aps, err := s.st.ListAPs(ctx)
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": "store_error"})
+		return
+	}
+	targets := s.policyTargets(ctx, now)
+	site := r.URL.Query().Get("site")
+	staleAfter := s.cfg.Controller.Convergence.StaleAfter
+	offlineAfter := int64(s.cfg.Controller.Inventory.OfflineAfter)
+
+	groups := map[string][]convergenceAP{
+		convCurrent: {},
+		convLagging: {},
+		convFailed:  {},
+	}
+	versions := map[string]*policyVersionCount{}
+	overdue := false
+	for _, ap := range aps {
+		if site != "" && ap.Site != site {
+			continue
+		}
+		cur, published := targets.forAP(ap)
+		state := convergenceState(ap, cur.Checksum)
+		if state != convCurrent && staleAfter >= 0 && now-published >= int64(staleAfter) {
+			overdue = true
+		}
+		groups[state] = append(groups[state], convergenceAP{
+			APID:     ap.APID,
+			Site:     ap.Site,
+			Checksum: ap.PolicyChecksum,
+			Version:  ap.PolicyVersion,
+			Error:    ap.PolicyError,
+			AckAt:    ap.PolicyAckAt,
+			Offline:  ap.Offline || now-ap.LastSeen > offlineAfter,
+			Canary:   targets.canary(ap),
+		})
+
+		v := versions[ap.PolicyChecksum]
+		if v == nil {
+			v = &policyVersionCount{
+				Checksum:  ap.PolicyChecksum,
+				Current:   ap.PolicyChecksum == targets.active.Checksum,
+				Candidate: targets.rollout != nil && ap.PolicyChecksum == targets.candidate.Checksum,
+			}
+			versions[ap.PolicyChecksum] = v
+		}
+		if ap.PolicyVersion != "" {
+			v.Version = ap.PolicyVersion
+		}
+		v.APs++
+	}
+
+	byVersion := make([]policyVersionCount, 0, len(versions))
+	for _, v := range versions {
+		byVersion = append(byVersion, *v)
+	}
+	sort.Slice(byVersion, func(i, j int) bool {
+		if byVersion[i].APs != byVersion[j].APs {
+			return byVersion[i].APs > byVersion[j].APs
+		}
+		return byVersion[i].Checksum < byVersion[j].Checksum
+	})
+
+	out := map[string]any{
+		"current": map[string]any{
+			"version":   targets.active.Version,
+			"checksum":  targets.active.Checksum,
+			"published": targets.published,
+		},
+		"stale_after": staleAfter,
+		"overdue":     overdue,
+		"summary": map[string]int{
+			convCurrent: len(groups[convCurrent]),
+			convLagging: len(groups[convLagging]),
+			convFailed:  len(groups[convFailed]),
+		},
+		"aps":      groups,
+		"versions": byVersion,
+	}
+	if targets.rollout != nil {
+		out["candidate"] = map[string]any{
+			"rollout":   targets.rollout.ID,
+			"version":   targets.candidate.Version,
+			"checksum":  targets.candidate.Checksum,
+			"published": targets.candidatePublished,
+		}
+	}
+	writeJSON(w, 200, out)
+}
@@ -0,0 +1,171 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"ap-controller-go/internal/store"
+	"ap-controller-go/internal/tracing"
+)
+
+// -------------------------------------------------------------------
+// Client denylist
+// -------------------------------------------------------------------
+
+const denyReasonMax = 200
+
+// denied checks mac against the denylist. On a hit it audits a security
+// event, ends the client's session and returns the entry. A store error
+// lets the client through, as the other login checks do.
+func (s *Server) denied(ctx context.Context, mac, via, ssid string) *store.DenyEntry {
+	e, err := s.st.DenyMatch(ctx, mac, time.Now().Unix())
+	if err != nil {
+		log.Printf("denylist: %v", err)
+		return nil
+	}
+	if e == nil {
+		return nil
+	}
+	s.denyHit(ctx, mac, e, via, ssid)
+	return e
+}
+
+// deniedMACs is denied for many MACs in one lookup; it returns the ones
+// on the denylist. A store error lets them through.
+func (s *Server) deniedMACs(ctx context.Context, macs []string, via string) []string {
+	entries, err := s.st.DenyMatches(ctx, macs, time.Now().Unix())
+	if err != nil {
+		log.Printf("denylist: %v", err)
+		return nil
+	}
+	var hit []string
+	for i, e := range entries {
+		if e != nil {
+			s.denyHit(ctx, macs[i], e, via, "")
+			hit = append(hit, macs[i])
+		}
+	}
+	return hit
+}
+
+// denyHit audits a denylist hit of mac and ends its session.
+func (s *Server) denyHit(ctx context.Context, mac string, e *store.DenyEntry, via, ssid string) {
+	s.audit.Write(map[string]any{
+		"event":    "security.denylist_hit",
+		"severity": "alert",
+		"mac":      mac,
+		"pattern":  e.Pattern,
+		"kind":     e.Kind,
+		"reason":   e.Reason,
+		"via":      via,
+		"ssid":     ssid,
+		"trace_id": tracing.TraceID(ctx),
+		"result":   "denied",
+	})
+	if sess, _, _ := s.st.GetSessionFull(ctx, mac); sess != nil {
+		s.terminateSession(ctx, sess, "denylisted")
+	}
+}
+
+// adminDenyList lists the active denylist entries.
+func (s *Server) adminDenyList(w http.ResponseWriter, r *http.Request) {
+	entries, err := s.st.ListDenylist(r.Context(), time.Now().Unix())
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": "store_error"})
+		return
+	}
+	writeJSON(w, 200, map[string]any{"entries": entries})
+}
+
+// adminDenyAdd denylists a MAC or OUI. A session of a denylisted MAC
+// ends at once; OUI matches end at their next login or heartbeat.
+func (s *Server) adminDenyAdd(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req DenyReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, 400, map[string]any{"error": "bad_json"})
+		return
+	}
+	pattern, kind, ok := store.ParseDenyPattern(req.Pattern)
+	if !ok {
+		writeJSON(w, 422, map[string]any{"error": "bad_pattern"})
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" || len(req.Reason) > denyReasonMax {
+		writeJSON(w, 422, map[string]any{"error": "reason_required"})
+		return
+	}
+	if req.TTL < 0 {
+		writeJSON(w, 422, map[string]any{"error": "bad_ttl"})
+		return
+	}
+
+	now := time.Now().Unix()
+	e := store.DenyEntry{
+		Pattern:   pattern,
+		Kind:      kind,
+		Reason:    req.Reason,
+		CreatedBy: adminSubject(r),
+		Created:   now,
+	}
+	if req.TTL > 0 {
+		e.Expires = now + int64(req.TTL)
+	}
+	if err := s.st.AddDeny(ctx, e); err != nil {
+		writeJSON(w, 500, map[string]any{"error": "store_error"})
+		return
+	}
+	terminated := false
+	if kind == store.DenyMAC {
+		if sess, _, _ := s.st.GetSessionFull(ctx, pattern); sess != nil {
+			s.terminateSession(ctx, sess, "denylisted")
+			terminated = true
+		}
+	}
+
+	s.auditAdmin(r, map[string]any{
+		"event":      "admin.denylist_add",
+		"pattern":    e.Pattern,
+		"kind":       e.Kind,
+		"reason":     e.Reason,
+		"expires":    e.Expires,
+		"terminated": terminated,
+		"result":     "ok",
+	})
+	s.policyChanged(ctx)
+	writeJSON(w, 200, e)
+}
+
+// adminDenyRemove lifts a denylist entry.
+func (s *Server) adminDenyRemove(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	pattern, _, ok := store.ParseDenyPattern(chi.URLParam(r, "pattern"))
+	if !ok {
+		writeJSON(w, 422, map[string]any{"error": "bad_pattern"})
+		return
+	}
+	removed, err := s.st.RemoveDeny(ctx, pattern)
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": "store_error"})
+		return
+	}
+	if !removed {
+		writeJSON(w, 404, map[string]any{"error": "not_found"})
+		return
+	}
+	s.auditAdmin(r, map[string]any{
+		"event":   "admin.denylist_remove",
+		"pattern": pattern,
+		"result":  "ok",
+	})
+	s.policyChanged(ctx)
+	writeJSON(w, 200, map[string]any{"pattern": pattern, "removed": true})
+}
@@ -0,0 +1,371 @@
+package httpapi
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"ap-controller-go/internal/events"
+	"ap-controller-go/internal/pki"
+	"ap-controller-go/internal/security"
+	"ap-controller-go/internal/store"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// -------------------------------------------------------------------
+// AP enrollment CA
+// -------------------------------------------------------------------
+
+// crlValidity is the NextUpdate window of served CRLs; APs and proxies
+// should refetch at least this often.
+const crlValidity = time.Hour
+
+// EnableEnrollment turns on the enrollment endpoints. Token creation and
+// revocation require the admin token (see SetAdminToken).
+func (s *Server) EnableEnrollment(ca *pki.CA) {
+	s.ca = ca
+}
+
+func (s *Server) enrollRoutes(r chi.Router) {
+	if s.ca == nil {
+		return
+	}
+
+	// public: the token / client certificate is the credential
+	r.Post("/api/v1/enroll", s.enroll)
+	r.Post("/api/v1/enroll/renew", s.enrollRenew)
+	r.Get("/api/v1/pki/ca", s.pkiCA)
+	r.Get("/api/v1/pki/crl", s.pkiCRL)
+
+	r.Group(func(ar chi.Router) {
+		ar.Use(s.adminAuth)
+		ar.Use(s.requireAdmin(security.AdminAdmin))
+
+		ar.Post("/api/v1/enroll/tokens", s.enrollTokenCreate)
+		ar.Post("/api/v1/pki/revoke", s.pkiRevoke)
+	})
+}
+
+func newEnrollToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// enrollTokenCreate issues a one-time token binding the next enrollment
+// to ap_id / site.
+func (s *Server) enrollTokenCreate(w http.ResponseWriter, r *http.Request) {
+	var req EnrollTokenReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, 400, map[string]any{"error": "bad_json"})
+		return
+	}
+	if !pki.ValidName(req.APID) || (req.Site != "" && !pki.ValidName(req.Site)) {
+		writeJSON(w, 422, map[string]any{"error": "invalid_ap_id_or_site"})
+		return
+	}
+
+	ttl := s.cfg.Controller.Enrollment.TokenTTL
+	if req.TTL > 0 && req.TTL < ttl {
+		ttl = req.TTL
+	}
+
+	token, err := newEnrollToken()
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": "internal_error"})
+		return
+	}
+	now := time.Now().Unix()
+	t := store.EnrollToken{
+		APID:      req.APID,
+		Site:      req.Site,
+		CreatedBy: adminSubject(r),
+		Created:   now,
+		Expires:   now + int64(ttl),
+	}
+	if err := s.st.CreateEnrollToken(r.Context(), token, t); err != nil {
+		writeJSON(w, 500, map[string]any{"error": "store_error"})
+		return
+	}
+
+	s.auditAdmin(r, map[string]any{
+		"event":      "pki.token_create",
+		"ap_id":      t.APID,
+		"site":       t.Site,
+		"expires":    t.Expires,
+		"created_by": t.CreatedBy,
+		"result":     "ok",
+	})
+
+	writeJSON(w, 200, map[string]any{
+		"token":      token,
+		"ap_id":      t.APID,
+		"site":       t.Site,
+		"expires_at": t.Expires,
+	})
+}
+
+// enroll exchanges a one-time token and a CSR for a client certificate.
+func (s *Server) enroll(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req EnrollReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, 400, map[string]any{"error": "bad_json"})
+		return
+	}
+	if req.Token == "" {
+		writeJSON(w, 422, map[string]any{"error": "token_required"})
+		return
+	}
+
+	// parse the CSR first so a malformed request does not burn the token
+	csr, err := pki.ParseCSR([]byte(req.CSR))
+	if err != nil {
+		s.auditEnrollFail("enroll", "", "bad_csr")
+		writeJSON(w, 422, map[string]any{"error": "bad_csr", "detail": err.Error()})
+		return
+	}
+
+	t, err := s.st.ConsumeEnrollToken(ctx, req.Token)
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": "store_error"})
+		return
+	}
+	if t == nil {
+		s.auditEnrollFail("enroll", "", "invalid_token")
+		writeJSON(w, 403, map[string]any{"error": "invalid_token"})
+		return
+	}
+
+	s.issue(ctx, w, csr, t.APID, t.Site, "enroll", nil)
+}
+
+// enrollRenew issues a fresh certificate to an AP authenticated by its
+// current (unexpired, unrevoked) client certificate, within renew_before
+// seconds of its expiry. The renewal revokes the old certificate, so a
+// stolen one cannot be renewed alongside the AP's own.
+func (s *Server) enrollRenew(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, ok := security.APIdentityFrom(ctx)
+	if !ok || r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		writeJSON(w, 401, map[string]any{"error": "client_cert_required"})
+		return
+	}
+	peer := r.TLS.VerifiedChains[0][0]
+	renewAt := peer.NotAfter.Add(-time.Duration(s.cfg.Controller.Enrollment.RenewBefore) * time.Second)
+	if time.Now().Before(renewAt) {
+		s.auditEnrollFail("renew", id.APID, "too_early")
+		writeJSON(w, 409, map[string]any{"error": "too_early", "renew_after": renewAt.Unix()})
+		return
+	}
+
+	var req RenewReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, 400, map[string]any{"error": "bad_json"})
+		return
+	}
+	csr, err := pki.ParseCSR([]byte(req.CSR))
+	if err != nil {
+		s.auditEnrollFail("renew", id.APID, "bad_csr")
+		writeJSON(w, 422, map[string]any{"error": "bad_csr", "detail": err.Error()})
+		return
+	}
+
+	old, err := s.st.Cert(ctx, id.Serial)
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": "store_error"})
+		return
+	}
+	if old == nil {
+		old = &store.CertRecord{Serial: id.Serial, APID: id.APID, Site: id.Site, NotAfter: peer.NotAfter.Unix()}
+	}
+
+	s.issue(ctx, w, csr, id.APID, id.Site, "renew", old)
+}
+
+// issue signs csr for apID. renewed is the certificate being renewed
+// (nil for an enrollment); it is revoked as superseded before the new
+// one is handed out.
+func (s *Server) issue(ctx context.Context, w http.ResponseWriter, csr *x509.CertificateRequest, apID, site, method string, renewed *store.CertRecord) {
+	ttl := time.Duration(s.cfg.Controller.Enrollment.CertTTL) * time.Second
+	iss, err := s.ca.Issue(csr, apID, site, s.tenant, ttl)
+	if err != nil {
+		s.auditEnrollFail(method, apID, "issue_failed")
+		writeJSON(w, 500, map[string]any{"error": "issue_failed"})
+		return
+	}
+
+	if renewed != nil {
+		old := *renewed
+		old.Revoked = time.Now().Unix()
+		old.Reason = "superseded"
+		old.RevokedBy = "renew:" + iss.Serial
+		ok, err := s.st.SupersedeCert(ctx, old)
+		if err != nil {
+			writeJSON(w, 500, map[string]any{"error": "store_error"})
+			return
+		}
+		if !ok {
+			// renewed or revoked meanwhile; the new certificate is dropped
+			s.auditEnrollFail(method, apID, "cert_revoked")
+			writeJSON(w, 403, map[string]any{"error": "cert_revoked"})
+			return
+		}
+	}
+
+	rec := store.CertRecord{
+		Serial:   iss.Serial,
+		APID:     apID,
+		Site:     site,
+		Method:   method,
+		Issued:   time.Now().Unix(),
+		NotAfter: iss.Cert.NotAfter.Unix(),
+	}
+	_ = s.st.RecordCert(ctx, rec)
+
+	ev := map[string]any{
+		"event":     "pki.issue",
+		"method":    method,
+		"ap_id":     apID,
+		"site":      site,
+		"serial":    iss.Serial,
+		"not_after": rec.NotAfter,
+		"result":    "ok",
+	}
+	if renewed != nil {
+		ev["renewed_from"] = renewed.Serial
+	}
+	s.audit.Write(ev)
+
+	writeJSON(w, 200, map[string]any{
+		"ap_id":       apID,
+		"site":        site,
+		"serial":      iss.Serial,
+		"not_after":   rec.NotAfter,
+		"certificate": string(iss.PEM),
+		"ca":          string(s.ca.CertPEM()),
+	})
+}
+
+func (s *Server) auditEnrollFail(method, apID, reason string) {
+	s.audit.Write(map[string]any{
+		"event":  "pki.issue",
+		"method": method,
+		"ap_id":  apID,
+		"reason": reason,
+		"result": "fail",
+	})
+}
+
+// pkiRevoke puts a certificate, or with ap_id every certificate issued
+// to that AP, on the revocation list. Serials of certificates unknown to
+// this controller are accepted too (kept for one certificate lifetime),
+// so a leaked cert can always be blocked.
+func (s *Server) pkiRevoke(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req RevokeReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, 400, map[string]any{"error": "bad_json"})
+		return
+	}
+	serial := strings.TrimLeft(strings.ToLower(strings.ReplaceAll(req.Serial, ":", "")), "0")
+	switch {
+	case serial == "" && req.APID == "":
+		writeJSON(w, 422, map[string]any{"error": "serial_required"})
+		return
+	case serial != "" && req.APID != "":
+		writeJSON(w, 422, map[string]any{"error": "serial_or_ap_id"})
+		return
+	}
+
+	now := time.Now().Unix()
+	var recs []store.CertRecord
+	if req.APID != "" {
+		var err error
+		if recs, err = s.st.APCerts(ctx, req.APID); err != nil {
+			writeJSON(w, 500, map[string]any{"error": "store_error"})
+			return
+		}
+	} else {
+		rec, err := s.st.Cert(ctx, serial)
+		if err != nil {
+			writeJSON(w, 500, map[string]any{"error": "store_error"})
+			return
+		}
+		if rec == nil {
+			rec = &store.CertRecord{
+				Serial:   serial,
+				NotAfter: now + int64(s.cfg.Controller.Enrollment.CertTTL),
+			}
+		}
+		recs = []store.CertRecord{*rec}
+	}
+
+	serials := make([]string, 0, len(recs))
+	for _, rec := range recs {
+		rec.Revoked = now
+		rec.Reason = req.Reason
+		rec.RevokedBy = adminSubject(r)
+		if err := s.st.RevokeCert(ctx, rec); err != nil {
+			writeJSON(w, 500, map[string]any{"error": "store_error"})
+			return
+		}
+		serials = append(serials, rec.Serial)
+
+		s.auditAdmin(r, map[string]any{
+			"event":      "pki.revoke",
+			"serial":     rec.Serial,
+			"ap_id":      rec.APID,
+			"site":       rec.Site,
+			"reason":     rec.Reason,
+			"revoked_by": rec.RevokedBy,
+			"result":     "ok",
+		})
+
+		s.publish(ctx, events.Event{
+			Type: events.CertRevoked,
+			APID: rec.APID,
+			Data: map[string]any{"serial": rec.Serial, "reason": rec.Reason},
+		})
+	}
+
+	if req.APID != "" {
+		writeJSON(w, 200, map[string]any{"ap_id": req.APID, "revoked": serials})
+		return
+	}
+	writeJSON(w, 200, map[string]any{"serial": serial, "revoked": true, "ap_id": recs[0].APID})
+}
+
+func (s *Server) pkiCA(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	_, _ = w.Write(s.ca.CertPEM())
+}
+
+func (s *Server) pkiCRL(w http.ResponseWriter, r *http.Request) {
+	recs, err := s.st.RevokedCerts(r.Context())
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": "store_error"})
+		return
+	}
+	entries := make([]pki.Revocation, 0, len(recs))
+	for _, rec := range recs {
+		entries = append(entries, pki.Revocation{Serial: rec.Serial, RevokedAt: time.Unix(rec.Revoked, 0)})
+	}
+	der, err := s.ca.CRL(entries, crlValidity)
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": "crl_failed"})
+		return
+	}
+	w.Header().Set("Content-Type", "application/pkix-crl")
+	_, _ = w.Write(der)
+}
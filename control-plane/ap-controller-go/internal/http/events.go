@@ -0,0 +1,299 @@
+package httpapi
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"ap-controller-go/internal/events"
+	"ap-controller-go/internal/metrics"
+	"ap-controller-go/internal/policy"
+	"ap-controller-go/internal/security"
+	"ap-controller-go/internal/store"
+)
+
+// -------------------------------------------------------------------
+// AP push channel (Server-Sent Events)
+// -------------------------------------------------------------------
+
+// EnableEvents turns on GET /api/v1/ap/events and event publishing.
+func (s *Server) EnableEvents(h *events.Hub) {
+	s.events = h
+}
+
+// pushing reports whether any AP push channel is enabled.
+func (s *Server) pushing() bool {
+	return s.events != nil || s.transport != nil
+}
+
+// publish appends ev to the event stream and forwards it over the
+// transport. Failures are logged only: APs still converge through polling.
+func (s *Server) publish(ctx context.Context, ev events.Event) {
+	if s.events != nil {
+		id, err := s.events.Publish(ctx, ev)
+		if err != nil {
+			log.Printf("events: publish %s failed: %v", ev.Type, err)
+		}
+		ev.ID = id
+	}
+	s.forward(ev)
+}
+
+// publishAll is publish for many events, appended in one round trip.
+func (s *Server) publishAll(ctx context.Context, evs []events.Event) {
+	if len(evs) == 0 {
+		return
+	}
+	if s.events != nil {
+		ids, err := s.events.PublishAll(ctx, evs)
+		if err != nil {
+			log.Printf("events: publish %d events failed: %v", len(evs), err)
+		}
+		for i := range ids {
+			evs[i].ID = ids[i]
+		}
+	}
+	for _, ev := range evs {
+		s.forward(ev)
+	}
+}
+
+// publishSession records a session change in the client change log and
+// announces it to the AP serving the client.
+func (s *Server) publishSession(ctx context.Context, typ string, sess *store.SessionV2, ttl int, reason string) {
+	if sess == nil {
+		return
+	}
+	s.logClients(ctx, []store.ClientChange{s.clientChange(typ, sess, ttl)})
+	if !s.pushing() {
+		return
+	}
+	s.publish(ctx, sessionEvent(typ, sess, ttl, reason))
+}
+
+// refreshDue reports whether a heartbeat refresh of mac should be
+// announced. APs hold a client for the announced ttl, so one refresh per
+// half ttl keeps them current without a stream entry per heartbeat.
+func (s *Server) refreshDue(ctx context.Context, mac string, ttl int) bool {
+	if ttl < 2 {
+		return true
+	}
+	ok, err := s.st.SetNX(ctx, s.st.RawKey("refreshed", mac), "1", time.Duration(ttl/2)*time.Second)
+	return err != nil || ok
+}
+
+func sessionEvent(typ string, sess *store.SessionV2, ttl int, reason string) events.Event {
+	data := map[string]any{
+		"mac":  sess.MAC,
+		"role": sess.Role,
+	}
+	if typ == events.SessionDeleted {
+		data["reason"] = reason
+	} else {
+		data["ttl"] = ttl
+		data["vlan"] = sess.Attrs.VLAN
+		data["firewall_group"] = sess.Attrs.FirewallGroup
+		data["upstream_kbps"] = sess.Attrs.UpstreamKbps
+		data["downstream_kbps"] = sess.Attrs.DownstreamKbps
+		if sess.TS.Expires > 0 {
+			data["expires_at"] = sess.TS.Expires
+		}
+	}
+	return events.Event{Type: typ, APID: sess.AP.APID, Data: data}
+}
+
+func bypassChecksum(b policy.RuntimeBypass) string {
+	raw, _ := json.Marshal(b)
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// PublishPolicyState records when the active and candidate policies
+// were first published, emits policy.changed / bypass.changed when the
+// runtime policy differs from the last one announced (by any replica),
+// keeps the retained policy notices of the transport current and
+// exports the active checksum as a metric.
+func (s *Server) PublishPolicyState(ctx context.Context) error {
+	// an AP must not lose denylist entries because of a failed read
+	rp, err := s.runtimePolicy(ctx)
+	if err != nil {
+		return err
+	}
+	// the metric is process-wide, so it shows the default tenant
+	if s.tenant == "" {
+		if old, _ := s.servedPolicy.Load().(string); old != rp.Version.Checksum {
+			metrics.SetPolicyInfo(rp.Version.Version, rp.Version.Checksum, s.cfg.Dataplane.PolicyVersion)
+			s.servedPolicy.Store(rp.Version.Checksum)
+		}
+	}
+	// convergence deadlines start here, not when an AP first asks
+	now := time.Now().Unix()
+	if _, err := s.st.RecordPolicyPublished(ctx, rp.Version.Checksum, now); err != nil {
+		return err
+	}
+	ro, cp, err := s.candidatePolicy(ctx)
+	if err != nil {
+		return err
+	}
+	if ro != nil {
+		if _, err := s.st.RecordPolicyPublished(ctx, cp.Version.Checksum, now); err != nil {
+			return err
+		}
+	}
+	if !s.pushing() {
+		return nil
+	}
+
+	// canary APs refetch when the candidate or their selection changes
+	candidate, rollout, cstate := "", "", ""
+	if ro != nil {
+		candidate, rollout = cp.Version.Checksum, ro.ID
+		cstate = candidate + "@" + strconv.FormatInt(ro.Updated, 10)
+	}
+
+	if s.transport != nil {
+		// retried on the next call: the checksums only advance on success
+		if err := s.sendPolicy(ctx, rp, candidate, rollout, cstate); err != nil {
+			log.Printf("transport: %v", err)
+		}
+	}
+	if s.events == nil {
+		return nil
+	}
+
+	// replicas on another config (a rolling deploy) announce separately
+	// instead of flipping each other's state every tick
+	gen := policy.BuildRuntimePolicy(s.cfg).Version.Checksum
+	old, err := s.st.SwapPolicyState(ctx, "checksum", gen, rp.Version.Checksum)
+	if err != nil {
+		return err
+	}
+	oldC, err := s.st.SwapPolicyState(ctx, "candidate", gen, cstate)
+	if err != nil {
+		return err
+	}
+	if old != rp.Version.Checksum || oldC != cstate {
+		data := map[string]any{
+			"version":           rp.Version.Version,
+			"checksum":          rp.Version.Checksum,
+			"previous_checksum": old,
+		}
+		if cstate != "" || oldC != "" {
+			data["candidate_checksum"] = candidate
+			data["rollout"] = rollout
+		}
+		s.publish(ctx, events.Event{Type: events.PolicyChanged, Data: data})
+	}
+
+	bsum := bypassChecksum(rp.Bypass)
+	oldB, err := s.st.SwapPolicyState(ctx, "bypass", gen, bsum)
+	if err != nil {
+		return err
+	}
+	if oldB != bsum {
+		// a notice only: the lists an AP gets depend on its site and id,
+		// so it refetches the signed runtime policy
+		s.publish(ctx, events.Event{Type: events.BypassChanged, Data: map[string]any{
+			"checksum": bsum,
+		}})
+	}
+	return nil
+}
+
+// apEvents streams events for the calling AP. Reconnecting clients send
+// Last-Event-ID (or ?last_event_id=) and get the missed events first; a
+// "resync" event means the gap is no longer in the stream.
+func (s *Server) apEvents(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if s.events == nil {
+		writeJSON(w, 404, map[string]any{"error": "events_disabled"})
+		return
+	}
+	apID, code, errName := apCaller(r, r.URL.Query().Get("ap_id"))
+	if errName != "" {
+		writeJSON(w, code, map[string]any{"error": errName})
+		return
+	}
+	// the site selects events for other APs, so it comes from the
+	// certificate or the registered record, never from the caller
+	site := ""
+	if id, ok := security.APIdentityFrom(ctx); ok && id.Site != "" {
+		site = id.Site
+	} else if rec, _ := s.st.GetAP(ctx, apID); rec != nil {
+		site = rec.Site
+	}
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+
+	sub, err := s.events.Subscribe(ctx, lastID, func(ev events.Event) bool {
+		return ev.For(apID, site)
+	})
+	if err != nil {
+		writeJSON(w, 503, map[string]any{"error": "events_unavailable"})
+		return
+	}
+	defer sub.Close()
+
+	// the stream outlives the server write timeout
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(200)
+
+	fmt.Fprint(w, "retry: 3000\n\n")
+	if sub.Resync {
+		writeSSE(w, events.Event{Type: "resync", TS: time.Now().Unix(), Data: map[string]any{"reason": "events_trimmed"}})
+	}
+	for _, ev := range sub.Replay {
+		writeSSE(w, ev)
+	}
+	if rc.Flush() != nil {
+		return
+	}
+
+	keepalive := time.Duration(s.cfg.Controller.Events.Keepalive) * time.Second
+	for {
+		nctx, cancel := context.WithTimeout(ctx, keepalive)
+		ev, err := sub.Next(nctx)
+		cancel()
+
+		switch {
+		case err == nil:
+			writeSSE(w, ev)
+		case errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil:
+			fmt.Fprint(w, ": ping\n\n")
+		default:
+			if sub.Overflowed() {
+				log.Printf("events: ap %s dropped (too slow), it will resume from its last id", apID)
+			}
+			return
+		}
+		if rc.Flush() != nil {
+			return
+		}
+	}
+}
+
+// writeSSE writes one event frame; the stream id becomes the SSE id.
+func writeSSE(w http.ResponseWriter, ev events.Event) {
+	b, _ := json.Marshal(ev)
+	if ev.ID != "" {
+		fmt.Fprintf(w, "id: %s\n", ev.ID)
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, b)
+}
@@ -3,17 +3,28 @@ package httpapi
 import (
 	"encoding/json"
 	"net/http"
+	"strings"
+	"time"
 
-	"ap-controller-go/internal/policy"
+	"ap-controller-go/internal/config"
+	"ap-controller-go/internal/events"
+	"ap-controller-go/internal/metrics"
 	"ap-controller-go/internal/roles"
 	"ap-controller-go/internal/security"
 	"ap-controller-go/internal/store"
+	"ap-controller-go/internal/tracing"
 
 	"github.com/go-chi/chi/v5"
 )
 
 func (s *Server) Router() http.Handler {
 	r := chi.NewRouter()
+	r.Use(tracing.Middleware)
+	r.Use(metrics.Middleware)
+	r.Use(security.APIdentityMiddleware)
+	if s.keys != nil {
+		r.Use(s.tenantKeys)
+	}
 
 	registerSwagger(r)
 
@@ -29,14 +40,101 @@ func (s *Server) Router() http.Handler {
 		writeJSON(w, 200, map[string]any{
 			"status":     "ok",
 			"redis_ping": err == nil,
+			"leader":     s.leaderStatus(),
 		})
 	})
 
+	// metrics need a readonly admin credential (e.g. an API key)
+	r.With(s.adminAuth, s.requireAdmin(security.AdminReadonly)).Handle("/metrics", metrics.Handler())
+
+	// runtime policy verification keys
+	r.Get("/api/v1/policy/keys", s.policyKeysHandler)
+
 	// ========================
 	// Portal login (NO HMAC)
 	// ========================
 	r.Post("/portal/login", s.portalLogin)
 
+	// ========================
+	// AP / data-plane APIs (HMAC or mTLS, no client context)
+	// ========================
+	r.Group(func(ar chi.Router) {
+		ar.Use(security.APAuthMiddleware(s.st))
+
+		ar.Post("/api/v1/accounting", s.accountingIngest)
+		ar.Post("/api/v1/ap/register", s.apRegister)
+		ar.Post("/api/v1/ap/heartbeat", s.apHeartbeat)
+		ar.Post("/api/v1/ap/policy/ack", s.policyAck)
+		ar.Get("/api/v1/ap/events", s.apEvents)
+		ar.Get("/api/v1/ap/clients", s.apClients)
+		ar.Post("/api/v1/ap/batch_status", s.apBatchStatus)
+
+		// Policy runtime
+		ar.Get("/api/v1/policy/runtime", s.runtimeHandler)
+		ar.Post("/api/v1/ap/mab", s.apMAB)
+	})
+
+	// ========================
+	// Admin APIs (API key / admin token / admin JWT, per-route role)
+	// ========================
+	r.Route("/api/v1/admin", func(ar chi.Router) {
+		ar.Use(s.adminAuth)
+
+		ar.Group(func(ro chi.Router) {
+			ro.Use(s.requireAdmin(security.AdminReadonly))
+			ro.Get("/whoami", s.adminWhoami)
+			ro.Get("/aps", s.adminListAPs)
+			ro.Get("/aps/{ap_id}", s.adminGetAP)
+			ro.Get("/sessions/{mac}", s.adminGetSession)
+			ro.Post("/sessions/batch_status", s.adminBatchStatus)
+			ro.Get("/known-devices/{mac}", s.adminGetKnownDevice)
+			ro.Get("/policy/convergence", s.adminPolicyConvergence)
+			ro.Get("/migrations/sessions", s.adminGetMigration)
+			ro.Get("/vouchers", s.adminVoucherList)
+			ro.Get("/vouchers/{batch}", s.adminVoucherBatch)
+			ro.Get("/denylist", s.adminDenyList)
+			ro.Get("/bypass", s.adminBypassList)
+			ro.Get("/policy/runtime", s.runtimeHandler)
+			ro.Get("/policy/rollout", s.adminRolloutGet)
+		})
+		ar.Group(func(op chi.Router) {
+			op.Use(s.requireAdmin(security.AdminOperator))
+			op.Post("/sessions/bulk_login", s.adminBulkLogin)
+			op.Post("/sessions/bulk_logout", s.adminBulkLogout)
+			op.Delete("/known-devices/{mac}", s.adminForgetDevice)
+			op.Post("/denylist", s.adminDenyAdd)
+			op.Delete("/denylist/{pattern}", s.adminDenyRemove)
+			op.Post("/bypass", s.adminBypassAdd)
+			op.Delete("/bypass/{id}", s.adminBypassRemove)
+			op.Post("/policy/rollout/abort", s.adminRolloutAbort)
+			op.Post("/vouchers", s.adminVoucherCreate)
+			op.Get("/vouchers/{batch}/export", s.adminVoucherExport)
+			op.Delete("/vouchers/{batch}", s.adminVoucherRevoke)
+			op.Delete("/vouchers/{batch}/{id}", s.adminVoucherRevoke)
+		})
+		ar.Group(func(ad chi.Router) {
+			ad.Use(s.requireAdmin(security.AdminAdmin))
+			ad.Delete("/aps/{ap_id}", s.adminDeleteAP)
+			ad.Get("/keys", s.adminKeyList)
+			ad.Post("/keys", s.adminKeyCreate)
+			ad.Delete("/keys/{id}", s.adminKeyRevoke)
+			ad.Post("/migrations/sessions", s.adminStartMigration)
+			ad.Post("/policy/rollout", s.adminRolloutCreate)
+			ad.Post("/policy/rollout/canary", s.adminRolloutCanary)
+			ad.Post("/policy/rollout/promote", s.adminRolloutPromote)
+		})
+	})
+
+	// ========================
+	// AP enrollment CA (token / client cert / admin token)
+	// ========================
+	s.enrollRoutes(r)
+
+	// ========================
+	// Sponsored guest access (portal / signed sponsor links)
+	// ========================
+	s.sponsorRoutes(r)
+
 	// ========================
 	// Protected APIs (HMAC required)
 	// ========================
@@ -50,13 +148,12 @@ func (s *Server) Router() http.Handler {
 		// Portal APIs (post-login)
 		pr.Post("/portal/heartbeat", s.portalHeartbeat)
 		pr.Post("/portal/logout", s.portalLogout)
+		// known devices skip the login page
+		pr.Post("/portal/mab", s.portalMAB)
 
-		// Ops APIs
+		// Ops APIs (the signed client only)
 		pr.Get("/portal/status/{mac}", s.portalStatus)
 		pr.Post("/portal/batch_status", s.portalBatchStatus)
-
-		// Policy runtime
-		pr.Get("/api/v1/policy/runtime", policy.RuntimeHandler(s.cfg))
 	})
 
 	return r
@@ -70,6 +167,12 @@ func (s *Server) Router() http.Handler {
 func (s *Server) portalLogin(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
+	if s.throttled(w, r, "", portalClient(r)) {
+		return
+	}
+	// before the body is read: the signature covers it
+	signed := security.PortalSigned(r, s.st)
+
 	var req PortalContextReq
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		writeJSON(w, 400, map[string]any{"authorized": false, "error": "bad_json"})
@@ -77,30 +180,95 @@ func (s *Server) portalLogin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	mac := macNorm(req.Client.MAC)
-	if mac == "" {
+	limited := loginSubjects(mac, req.Client.IP)
+	if s.throttled(w, r, mac, limited...) {
+		return
+	}
+	// only the canonical form matches the denylist
+	if !validMAC(mac) {
 		writeJSON(w, 422, map[string]any{"authorized": false, "error": "mac_required"})
 		return
 	}
+	if s.denied(ctx, mac, "login", req.Wireless.SSID) != nil {
+		metrics.LoginOutcome("denylisted", "", "", ssidLabel(s.cfg, req.Wireless.SSID))
+		writeJSON(w, 403, map[string]any{"authorized": false, "error": "denylisted"})
+		return
+	}
 
-	decision := roles.DecideRole(s.cfg, map[string]string{
-		"mac":      mac,
-		"ssid":     req.Wireless.SSID,
-		"ap_id":    req.Access.APID,
-		"radio_id": req.Wireless.RadioID,
-		"ip":       req.Client.IP,
-		"os":       req.Client.OS,
-	}, "guest")
+	// a voucher brings its own role instead of the role rules
+	var voucher *store.Voucher
+	if code := strings.TrimSpace(req.User.Voucher); code != "" {
+		v, reason := s.lookupVoucher(ctx, code)
+		if reason != "" {
+			s.rejectVoucher(w, r, mac, "", reason, req.Wireless.SSID, limited)
+			return
+		}
+		voucher = v
+	}
+
+	// the role rules of a canary AP are the candidate's; a store error
+	// falls back to the config file
+	pv, _ := s.policyFor(ctx, "", req.Access.APID)
+
+	var decision roles.Decision
+	if voucher != nil {
+		decision = roles.Decision{Role: voucher.Role, MatchedRule: authVoucher}
+	} else {
+		decision = s.decideRole(ctx, pv.cfg, map[string]string{
+			"mac":      mac,
+			"ssid":     req.Wireless.SSID,
+			"ap_id":    req.Access.APID,
+			"radio_id": req.Wireless.RadioID,
+			"ip":       req.Client.IP,
+			"os":       req.Client.OS,
+		})
+	}
 
 	role := decision.Role
-	roleDef := s.cfg.Roles[role]
-	profile := s.cfg.Profiles[roleDef.Profile]
-	ttl := profile.SessionTTL
+	profileName, profile := s.profileFor(role)
+
+	identity := loginIdentity(req.User.Identity, signed, voucher)
+
+	// daily quota already used up: refuse or start throttled
+	if reason := s.dailyQuotaUsed(ctx, identity, mac, profile); reason != "" {
+		if profile.QuotaAction != config.QuotaThrottle {
+			s.audit.Write(map[string]any{
+				"event":    "portal.login",
+				"mac":      mac,
+				"role":     role,
+				"identity": identity,
+				"result":   reason,
+			})
+			s.loginOutcome(ctx, pv, "quota_exceeded", role, decision.MatchedRule, req.Wireless.SSID)
+			writeJSON(w, 403, map[string]any{"authorized": false, "error": "quota_exceeded"})
+			return
+		}
+		role = profile.ThrottleRole
+		profileName, profile = s.profileFor(role)
+	}
+	if ok, err := s.claimDevice(ctx, identity, mac, profile); err == nil && !ok {
+		s.audit.Write(map[string]any{
+			"event":       "portal.login",
+			"mac":         mac,
+			"role":        role,
+			"identity":    identity,
+			"max_devices": profile.MaxDevices,
+			"result":      "device_limit",
+		})
+		s.loginOutcome(ctx, pv, "device_limit", role, decision.MatchedRule, req.Wireless.SSID)
+		writeJSON(w, 403, map[string]any{
+			"authorized":  false,
+			"error":       "device_limit_exceeded",
+			"max_devices": profile.MaxDevices,
+		})
+		return
+	}
 
 	sess := store.SessionV2{
-		Schema:        2,
+		Schema:        store.SessionSchema,
 		MAC:           mac,
 		Role:          role,
-		Profile:       roleDef.Profile,
+		Profile:       profileName,
 		PolicyVersion: s.policyVersion,
 	}
 
@@ -109,17 +277,56 @@ func (s *Server) portalLogin(w http.ResponseWriter, r *http.Request) {
 	sess.AP.APID = req.Access.APID
 	sess.AP.SSID = req.Wireless.SSID
 	sess.AP.RadioID = req.Wireless.RadioID
-	sess.Attrs.VLAN = profile.VLAN
-	sess.Attrs.FirewallGroup = profile.FirewallGroup
+	sess.ApplyProfile(profile)
 	sess.Auth.Method = "portal"
 	sess.Auth.Source = req.Meta.Source
+	sess.Auth.Identity = identity
+
+	now := time.Now().Unix()
+	sess.TS.Created = now
+	if profile.MaxSessionLifetime > 0 {
+		sess.TS.Expires = now + int64(profile.MaxSessionLifetime)
+	}
+	if voucher != nil {
+		// the last check: it takes a use of the voucher
+		first, reason := s.redeemVoucher(ctx, voucher, mac)
+		if reason != "" {
+			// give back the slot claimed above; a voucher refusing mac
+			// never had a session on it or has ended them all
+			if profile.MaxDevices > 0 {
+				_ = s.st.RemoveDevice(ctx, identity, mac)
+			}
+			s.rejectVoucher(w, r, mac, voucher.ID, reason, req.Wireless.SSID, limited)
+			return
+		}
+		sess.Auth.Method = authVoucher
+		sess.Auth.Voucher = voucher.ID
+		if end := first + int64(voucher.Duration); voucher.Duration > 0 && (sess.TS.Expires == 0 || end < sess.TS.Expires) {
+			sess.TS.Expires = end
+		}
+	}
+	ttl, _ := sessionTTL(&sess, profile, now)
 
 	_ = s.st.SetSession(ctx, sess, ttl)
+	if identity != "" {
+		_ = s.st.AddDevice(ctx, identity, mac, ttl)
+	}
+	s.publishSession(ctx, events.SessionCreated, &sess, ttl, "")
+
+	// vouchers bring their own limits; only a portal login is remembered
+	deviceToken := ""
+	if sess.Auth.Method == "portal" {
+		deviceToken = s.rememberDevice(ctx, &sess, decision.Role, now)
+	}
 
 	s.audit.Write(map[string]any{
 		"event":      "portal.login",
 		"mac":        mac,
 		"role":       role,
+		"identity":   identity,
+		"auth":       sess.Auth.Method,
+		"voucher":    sess.Auth.Voucher,
+		"remembered": deviceToken != "",
 		"ttl":        ttl,
 		"rule":       decision.MatchedRule,
 		"ap_id":      req.Access.APID,
@@ -127,11 +334,13 @@ func (s *Server) portalLogin(w http.ResponseWriter, r *http.Request) {
 		"radio_id":   req.Wireless.RadioID,
 		"source":     req.Meta.Source,
 		"policy_ver": s.policyVersion,
+		"trace_id":   tracing.TraceID(ctx),
 		"result":     "ok",
 	})
 
 	sess2, ttl2, _ := s.st.GetSessionFull(ctx, mac)
 	if sess2 == nil {
+		s.loginOutcome(ctx, pv, "store_error", role, decision.MatchedRule, req.Wireless.SSID)
 		writeJSON(w, 200, map[string]any{"authorized": false})
 		return
 	}
@@ -139,6 +348,7 @@ func (s *Server) portalLogin(w http.ResponseWriter, r *http.Request) {
 	// issue JWT (NEW)
 	token, exp, err := s.jwtIssuer.Issue(ctx, mac)
 	if err != nil {
+		s.loginOutcome(ctx, pv, "token_error", role, decision.MatchedRule, req.Wireless.SSID)
 		writeJSON(w, 500, map[string]any{
 			"authorized": false,
 			"error":      "issue_token_failed",
@@ -146,7 +356,9 @@ func (s *Server) portalLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	writeJSON(w, 200, map[string]any{
+	s.loginOutcome(ctx, pv, "ok", role, decision.MatchedRule, req.Wireless.SSID)
+	s.loginSucceeded(ctx, limited...)
+	out := map[string]any{
 		"authorized": true,
 		"session":    s.buildSessionResp(sess2, ttl2),
 		"token": map[string]any{
@@ -154,7 +366,12 @@ func (s *Server) portalLogin(w http.ResponseWriter, r *http.Request) {
 			"expires_in":   exp,
 			"token_type":   "Bearer",
 		},
-	})
+	}
+	if deviceToken != "" {
+		// for the portal cookie: links the device if its MAC changes
+		out["device_token"] = deviceToken
+	}
+	writeJSON(w, 200, out)
 
 }
 
@@ -169,25 +386,43 @@ func (s *Server) portalHeartbeat(w http.ResponseWriter, r *http.Request) {
 	}
 
 	mac := macNorm(req.Client.MAC)
-	if mac == "" {
+	if !validMAC(mac) {
 		writeJSON(w, 422, map[string]any{"authorized": false, "error": "mac_required"})
 		return
 	}
 
+	if s.denied(ctx, mac, "heartbeat", req.Wireless.SSID) != nil {
+		writeJSON(w, 200, map[string]any{"authorized": false, "error": "denylisted"})
+		return
+	}
 	sess, _, err := s.st.GetSessionFull(ctx, mac)
 	if err != nil || sess == nil {
 		writeJSON(w, 200, map[string]any{"authorized": false})
 		return
 	}
 
-	roleDef := s.cfg.Roles[sess.Role]
-	profile := s.cfg.Profiles[roleDef.Profile]
+	_, profile := s.profileFor(sess.Role)
 
-	ok, _ := s.st.Refresh(ctx, mac, profile.SessionTTL)
+	ttl, alive := sessionTTL(sess, profile, time.Now().Unix())
+	if !alive {
+		s.terminateSession(ctx, sess, "lifetime_exceeded")
+		writeJSON(w, 200, map[string]any{"authorized": false, "error": "session_lifetime_exceeded"})
+		return
+	}
+	if allowed, err := s.deviceAllowed(ctx, sess.Auth.Identity, mac, profile); err == nil && !allowed {
+		s.terminateSession(ctx, sess, "device_limit")
+		writeJSON(w, 200, map[string]any{"authorized": false, "error": "device_limit_exceeded"})
+		return
+	}
+
+	ok, _ := s.st.Refresh(ctx, mac, ttl)
 	if !ok {
 		writeJSON(w, 200, map[string]any{"authorized": false})
 		return
 	}
+	if s.refreshDue(ctx, mac, ttl) {
+		s.publishSession(ctx, events.SessionRefreshed, sess, ttl, "")
+	}
 
 	sess2, ttl2, _ := s.st.GetSessionFull(ctx, mac)
 	writeJSON(w, 200, s.buildSessionResp(sess2, ttl2))
@@ -209,7 +444,14 @@ func (s *Server) portalLogout(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	sess, _, _ := s.st.GetSessionFull(ctx, mac)
 	existed, _ := s.st.Delete(ctx, mac)
+	if sess != nil && sess.Auth.Identity != "" {
+		_ = s.st.RemoveDevice(ctx, sess.Auth.Identity, mac)
+	}
+	if existed {
+		s.publishSession(ctx, events.SessionDeleted, sess, 0, "logout")
+	}
 
 	s.audit.Write(map[string]any{
 		"event":   "portal.logout",
@@ -230,6 +472,13 @@ func (s *Server) portalStatus(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	mac := macNorm(chi.URLParam(r, "mac"))
 
+	// a portal signer only sees its own client; ops tooling uses
+	// GET /api/v1/admin/sessions/{mac}
+	if self, _ := ctx.Value(security.CtxKeyClientMAC).(string); macNorm(self) != mac {
+		writeJSON(w, 403, map[string]any{"error": "mac_mismatch"})
+		return
+	}
+
 	sess, ttl, _ := s.st.GetSessionFull(ctx, mac)
 	if sess == nil {
 		writeJSON(w, 200, map[string]any{
@@ -243,54 +492,6 @@ func (s *Server) portalStatus(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, 200, s.buildSessionResp(sess, ttl))
 }
 
-func (s *Server) portalBatchStatus(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-
-	var req BatchReq
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeJSON(w, 400, map[string]any{"error": "bad_json"})
-		return
-	}
-
-	type Item struct {
-		MAC           string         `json:"mac"`
-		Authorized    bool           `json:"authorized"`
-		Role          *string        `json:"role,omitempty"`
-		TTL           *int           `json:"ttl,omitempty"`
-		PolicyVersion *string        `json:"policy_version,omitempty"`
-		Profile       map[string]any `json:"profile,omitempty"`
-	}
-
-	out := make([]Item, 0, len(req.Entries))
-
-	for _, e := range req.Entries {
-		m := macNorm(e.MAC)
-		sess, ttl, _ := s.st.GetSessionFull(ctx, m)
-		if sess == nil {
-			out = append(out, Item{MAC: m, Authorized: false})
-			continue
-		}
-
-		role := sess.Role
-		pv := sess.PolicyVersion
-		ttl2 := ttl
-
-		resp := s.buildSessionResp(sess, ttl2)
-		profile := resp["profile"].(map[string]any)
-
-		out = append(out, Item{
-			MAC:           m,
-			Authorized:    true,
-			Role:          &role,
-			TTL:           &ttl2,
-			PolicyVersion: &pv,
-			Profile:       profile,
-		})
-	}
-
-	writeJSON(w, 200, map[string]any{"results": out})
-}
-
 // ---------------------------------------------------
 // Portal Context Verify (auth_request backend)
 // ---------------------------------------------------
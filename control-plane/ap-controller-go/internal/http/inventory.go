@@ -0,0 +1,346 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"ap-controller-go/internal/leader"
+	"ap-controller-go/internal/security"
+	"ap-controller-go/internal/store"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// -------------------------------------------------------------------
+// AP inventory
+// -------------------------------------------------------------------
+
+const (
+	apOnline  = "online"
+	apOffline = "offline"
+)
+
+// apCaller resolves the ap_id an AP request speaks for. A certificate-
+// authenticated AP may only speak for itself; its ap_id is used when the
+// body omits one. On failure it returns the status and error name.
+func apCaller(r *http.Request, claimed string) (apID string, code int, errName string) {
+	if id, ok := security.APIdentityFrom(r.Context()); ok {
+		if claimed == "" {
+			claimed = id.APID
+		}
+		if claimed != id.APID {
+			return "", 403, "ap_id_mismatch"
+		}
+	}
+	if claimed == "" {
+		return "", 422, "ap_id_required"
+	}
+	return claimed, 0, ""
+}
+
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// apRegister creates or refreshes an AP record.
+func (s *Server) apRegister(w http.ResponseWriter, r *http.Request) {
+	s.apReport(w, r, true)
+}
+
+// apHeartbeat refreshes a registered AP. Unknown APs get 404 so they
+// re-register (e.g. after the inventory was cleared).
+func (s *Server) apHeartbeat(w http.ResponseWriter, r *http.Request) {
+	s.apReport(w, r, false)
+}
+
+func (s *Server) apReport(w http.ResponseWriter, r *http.Request, register bool) {
+	ctx := r.Context()
+
+	var req APHeartbeatReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, 400, map[string]any{"error": "bad_json"})
+		return
+	}
+	apID, code, errName := apCaller(r, req.APID)
+	if errName != "" {
+		writeJSON(w, code, map[string]any{"error": errName})
+		return
+	}
+	// the certificate is authoritative for the site as well
+	if id, ok := security.APIdentityFrom(ctx); ok && id.Site != "" {
+		req.Site = id.Site
+	}
+
+	rec, created, err := s.reportAP(ctx, apID, req, remoteHost(r), register)
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": "store_error"})
+		return
+	}
+	if rec == nil {
+		writeJSON(w, 404, map[string]any{"error": "not_registered"})
+		return
+	}
+
+	inv := s.cfg.Controller.Inventory
+	writeJSON(w, 200, map[string]any{
+		"ap_id":              rec.APID,
+		"registered":         created,
+		"heartbeat_interval": inv.HeartbeatInterval,
+		"offline_after":      inv.OfflineAfter,
+	})
+}
+
+// reportAP applies a register / heartbeat report to the inventory and
+// audits registrations and recoveries. rec is nil when an unknown AP
+// sent a heartbeat (register false).
+func (s *Server) reportAP(ctx context.Context, apID string, req APHeartbeatReq, addr string, register bool) (*store.APRecord, bool, error) {
+	now := time.Now().Unix()
+	var created, cameBack bool
+	var wasOfflineSince int64
+	rec, err := s.st.UpdateAP(ctx, apID, func(rec *store.APRecord, exists bool) error {
+		if !exists && !register {
+			return store.ErrSkipUpdate
+		}
+		created = !exists
+		cameBack = rec.Offline
+		wasOfflineSince = rec.OfflineSince
+
+		if !exists {
+			rec.FirstSeen = now
+		}
+		rec.LastSeen = now
+		rec.Offline, rec.OfflineSince = false, 0
+		if addr != "" {
+			rec.Addr = addr
+		}
+		rec.Uptime = req.Uptime
+
+		set := func(dst *string, v string) {
+			if v != "" {
+				*dst = v
+			}
+		}
+		set(&rec.Site, req.Site)
+		set(&rec.Hostname, req.Hostname)
+		set(&rec.Firmware, req.Firmware)
+		set(&rec.LanIF, req.LanIF)
+		set(&rec.PolicyChecksum, req.PolicyChecksum)
+		return nil
+	})
+	if err != nil || rec == nil {
+		return nil, false, err
+	}
+
+	switch {
+	case created:
+		s.audit.Write(map[string]any{
+			"event":    "ap.register",
+			"ap_id":    rec.APID,
+			"site":     rec.Site,
+			"hostname": rec.Hostname,
+			"firmware": rec.Firmware,
+			"addr":     rec.Addr,
+			"result":   "ok",
+		})
+	case cameBack:
+		s.audit.Write(map[string]any{
+			"event":         "ap.online",
+			"ap_id":         rec.APID,
+			"site":          rec.Site,
+			"offline_since": wasOfflineSince,
+			"result":        "ok",
+		})
+	}
+
+	return rec, created, nil
+}
+
+// CheckOfflineAPs marks APs without a recent heartbeat offline and
+// raises one "ap.offline" alert per transition. Concurrent replicas race
+// on the record, so each transition is reported once.
+// It returns the ap_ids that went offline.
+func (s *Server) CheckOfflineAPs(ctx context.Context, now time.Time) ([]string, error) {
+	cutoff := now.Unix() - int64(s.cfg.Controller.Inventory.OfflineAfter)
+	stale, err := s.st.StaleAPs(ctx, cutoff)
+	if err != nil {
+		return nil, err
+	}
+
+	var went []string
+	for _, apID := range stale {
+		marked := false
+		rec, err := s.st.UpdateAP(ctx, apID, func(rec *store.APRecord, exists bool) error {
+			if !exists || rec.LastSeen > cutoff {
+				return store.ErrSkipUpdate
+			}
+			// a record already offline but missing from the offline set
+			// is rewritten once to index it, without a second alert
+			marked = rec.Offline
+			if !marked {
+				rec.Offline = true
+				rec.OfflineSince = now.Unix()
+			}
+			return nil
+		})
+		if err != nil || rec == nil || marked {
+			continue
+		}
+		went = append(went, apID)
+
+		log.Printf("ap %s (site %s) offline, last seen %ds ago", apID, rec.Site, now.Unix()-rec.LastSeen)
+		s.auditJob(ctx, map[string]any{
+			"event":     "ap.offline",
+			"severity":  "alert",
+			"ap_id":     apID,
+			"site":      rec.Site,
+			"last_seen": rec.LastSeen,
+			"result":    "ok",
+		})
+	}
+	return went, nil
+}
+
+// Jobs returns the periodic jobs of s, run every heartbeat interval:
+// offline detection, stale policy and canary checks and the session
+// migration once per cluster (on the leader), policy publishing on every
+// replica, since each replica keeps its own broker connection.
+func (s *Server) Jobs() []leader.Job {
+	every := time.Duration(s.cfg.Controller.Inventory.HeartbeatInterval) * time.Second
+	name := func(n string) string {
+		if s.tenant != "" {
+			return s.tenant + "/" + n
+		}
+		return n
+	}
+	jobs := []leader.Job{
+		{Name: name("inventory.offline"), Every: every, Singleton: true, Run: func(ctx context.Context) error {
+			_, err := s.CheckOfflineAPs(ctx, time.Now())
+			return err
+		}},
+		{Name: name("policy.stale"), Every: every, Singleton: true, Run: func(ctx context.Context) error {
+			_, err := s.CheckStalePolicies(ctx, time.Now())
+			return err
+		}},
+		{Name: name("policy.canary"), Every: every, Singleton: true, Run: func(ctx context.Context) error {
+			_, err := s.CheckCanary(ctx, time.Now())
+			return err
+		}},
+		{Name: name("policy.publish"), Every: every, Run: s.PublishPolicyState},
+	}
+	if s.cfg.Controller.Migration.Enabled {
+		jobs = append(jobs, leader.Job{Name: name("sessions.migrate"), Every: every, Singleton: true, Run: func(ctx context.Context) error {
+			_, err := s.MigrateSessions(ctx)
+			return err
+		}})
+	}
+	if s.sponsor != nil {
+		jobs = append(jobs, leader.Job{Name: name("sponsor.expire"), Every: every, Singleton: true, Run: func(ctx context.Context) error {
+			_, err := s.ExpireSponsorRequests(ctx, time.Now())
+			return err
+		}})
+	}
+	return jobs
+}
+
+// -------------------------------------------------------------------
+// Admin
+// -------------------------------------------------------------------
+
+// apView is an APRecord with derived status for admin responses.
+type apView struct {
+	store.APRecord
+	Status       string `json:"status"`
+	HeartbeatAge int64  `json:"heartbeat_age"`
+	Clients      int    `json:"clients"`
+}
+
+func (s *Server) apView(rec store.APRecord, clients map[string]int, now int64) apView {
+	v := apView{
+		APRecord:     rec,
+		Status:       apOnline,
+		HeartbeatAge: now - rec.LastSeen,
+		Clients:      clients[rec.APID],
+	}
+	if rec.Offline || v.HeartbeatAge > int64(s.cfg.Controller.Inventory.OfflineAfter) {
+		v.Status = apOffline
+	}
+	return v
+}
+
+// adminListAPs lists the inventory, optionally filtered by ?site= and
+// ?status=online|offline.
+func (s *Server) adminListAPs(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	aps, err := s.st.ListAPs(ctx)
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": "store_error"})
+		return
+	}
+	clients, err := s.st.CountByAP(ctx)
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": "store_error"})
+		return
+	}
+
+	site, status := r.URL.Query().Get("site"), r.URL.Query().Get("status")
+	now := time.Now().Unix()
+	out := make([]apView, 0, len(aps))
+	summary := map[string]int{apOnline: 0, apOffline: 0}
+	for _, rec := range aps {
+		v := s.apView(rec, clients, now)
+		if (site != "" && v.Site != site) || (status != "" && v.Status != status) {
+			continue
+		}
+		summary[v.Status]++
+		out = append(out, v)
+	}
+
+	writeJSON(w, 200, map[string]any{
+		"aps":     out,
+		"total":   len(out),
+		"summary": summary,
+	})
+}
+
+func (s *Server) adminGetAP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	rec, err := s.st.GetAP(ctx, chi.URLParam(r, "ap_id"))
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": "store_error"})
+		return
+	}
+	if rec == nil {
+		writeJSON(w, 404, map[string]any{"error": "not_found"})
+		return
+	}
+	clients, _ := s.st.CountByAP(ctx)
+	writeJSON(w, 200, s.apView(*rec, clients, time.Now().Unix()))
+}
+
+func (s *Server) adminDeleteAP(w http.ResponseWriter, r *http.Request) {
+	apID := chi.URLParam(r, "ap_id")
+	ok, err := s.st.DeleteAP(r.Context(), apID)
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": "store_error"})
+		return
+	}
+	if !ok {
+		writeJSON(w, 404, map[string]any{"error": "not_found"})
+		return
+	}
+	s.auditAdmin(r, map[string]any{
+		"event":  "ap.delete",
+		"ap_id":  apID,
+		"result": "ok",
+	})
+	writeJSON(w, 200, map[string]any{"ap_id": apID, "deleted": true})
+}
@@ -0,0 +1,54 @@
+package httpapi
+
+import (
+	"context"
+
+	"ap-controller-go/internal/leader"
+)
+
+// -------------------------------------------------------------------
+// Leader election
+// -------------------------------------------------------------------
+
+// SetLeader reports el in /healthz. Jobs are gated by the scheduler, not
+// by the server.
+func (s *Server) SetLeader(el *leader.Elector) {
+	s.leader = el
+}
+
+func (s *Server) leaderStatus() leader.Status {
+	if s.leader == nil {
+		// without election every replica runs every job
+		return leader.Status{Leader: true, ID: s.cfg.Controller.ID}
+	}
+	return s.leader.Status()
+}
+
+// LeaderChanged audits a leadership change of this replica
+// (leader.OnChange).
+func (s *Server) LeaderChanged(ch leader.Change) {
+	ev := map[string]any{
+		"event":           "leader.elected",
+		"id":              ch.ID,
+		"token":           ch.Token,
+		"previous_holder": ch.Previous.Holder,
+		"previous_token":  ch.Previous.Token,
+		"reason":          ch.Reason,
+		"result":          "ok",
+	}
+	if !ch.Leader {
+		ev["event"] = "leader.lost"
+		ev["token"] = ch.Previous.Token
+		ev["holder"] = ch.Holder
+	}
+	s.audit.Write(ev)
+}
+
+// auditJob writes ev of a background job with the fencing token it ran
+// under, so events of a deposed leader can be told apart.
+func (s *Server) auditJob(ctx context.Context, ev map[string]any) {
+	if t := leader.TokenFrom(ctx); t != 0 {
+		ev["leader_token"] = t
+	}
+	s.audit.Write(ev)
+}
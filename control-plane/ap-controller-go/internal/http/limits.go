@@ -0,0 +1,97 @@
+package httpapi
+
+import (
+	"context"
+	"strings"
+
+	"ap-controller-go/internal/config"
+	"ap-controller-go/internal/events"
+	"ap-controller-go/internal/store"
+)
+
+// -------------------------------------------------------------------
+// Profile limits (lifetime / device count)
+// -------------------------------------------------------------------
+
+// profileFor resolves a role to its profile name and definition.
+func (s *Server) profileFor(role string) (string, config.Profile) {
+	name := s.cfg.Roles[role].Profile
+	return name, s.cfg.Profiles[name]
+}
+
+// sessionTTL returns the key TTL for sess, capped by its absolute
+// expiry. ok is false once the session lifetime is used up.
+func sessionTTL(sess *store.SessionV2, p config.Profile, now int64) (ttl int, ok bool) {
+	ttl = p.SessionTTL
+	if sess.TS.Expires == 0 {
+		return ttl, true
+	}
+	left := int(sess.TS.Expires - now)
+	if left <= 0 {
+		return 0, false
+	}
+	if left < ttl {
+		ttl = left
+	}
+	return ttl, true
+}
+
+// loginIdentity is the identity a login counts against for device limits
+// and daily quotas: the voucher for voucher logins, otherwise the user the
+// portal asserted in a signed request. The body of an unsigned login is the
+// client's word, so its identity is ignored and the login is anonymous.
+func loginIdentity(asserted string, signed bool, v *store.Voucher) string {
+	if v != nil {
+		return "voucher:" + v.ID
+	}
+	if !signed {
+		return ""
+	}
+	return strings.TrimSpace(asserted)
+}
+
+// deviceAllowed reports whether mac may hold a session for identity.
+// Devices are ranked by login time; only the oldest MaxDevices are allowed,
+// so lowering the limit evicts the newest devices on their next heartbeat.
+func (s *Server) deviceAllowed(ctx context.Context, identity, mac string, p config.Profile) (bool, error) {
+	if p.MaxDevices <= 0 || identity == "" {
+		return true, nil
+	}
+	devices, err := s.st.ActiveDevices(ctx, identity)
+	if err != nil {
+		return false, err
+	}
+	for i, d := range devices {
+		if d == mac {
+			return i < p.MaxDevices, nil
+		}
+	}
+	return len(devices) < p.MaxDevices, nil
+}
+
+// claimDevice takes a device slot of identity for mac at login. The
+// check and the claim are atomic, unlike deviceAllowed.
+func (s *Server) claimDevice(ctx context.Context, identity, mac string, p config.Profile) (bool, error) {
+	if p.MaxDevices <= 0 || identity == "" {
+		return true, nil
+	}
+	return s.st.ClaimDevice(ctx, identity, mac, p.MaxDevices, p.SessionTTL)
+}
+
+// terminateSession removes a session the controller decided to end.
+func (s *Server) terminateSession(ctx context.Context, sess *store.SessionV2, reason string) {
+	_, _ = s.st.Delete(ctx, sess.MAC)
+	if sess.Auth.Identity != "" {
+		_ = s.st.RemoveDevice(ctx, sess.Auth.Identity, sess.MAC)
+	}
+	s.publishSession(ctx, events.SessionDeleted, sess, 0, reason)
+
+	s.audit.Write(map[string]any{
+		"event":    "portal.terminate",
+		"mac":      sess.MAC,
+		"role":     sess.Role,
+		"identity": sess.Auth.Identity,
+		"reason":   reason,
+		"result":   "ok",
+	})
+}
@@ -0,0 +1,289 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"ap-controller-go/internal/config"
+	"ap-controller-go/internal/events"
+	"ap-controller-go/internal/metrics"
+	"ap-controller-go/internal/security"
+	"ap-controller-go/internal/store"
+	"ap-controller-go/internal/tracing"
+)
+
+// -------------------------------------------------------------------
+// Known devices / MAC authentication bypass
+// -------------------------------------------------------------------
+
+const authMAB = "mab"
+
+// mabAttempt is a re-authorization request from an AP or the portal.
+type mabAttempt struct {
+	MAC     string
+	Token   string
+	APID    string
+	SSID    string
+	RadioID string
+	// Source is "ap" or "portal"
+	Source string
+}
+
+// rememberDevice records a device after an interactive login with role,
+// if the role's profile remembers devices. It returns the device token
+// for the portal cookie ("" = not remembered).
+func (s *Server) rememberDevice(ctx context.Context, sess *store.SessionV2, role string, now int64) string {
+	_, profile := s.profileFor(role)
+	if profile.RememberDevice <= 0 {
+		return ""
+	}
+	token, err := security.NewDeviceToken()
+	if err != nil {
+		return ""
+	}
+	err = s.st.RememberDevice(ctx, store.KnownDevice{
+		MAC:       sess.MAC,
+		Identity:  sess.Auth.Identity,
+		Role:      role,
+		SSID:      sess.AP.SSID,
+		Auth:      now,
+		Until:     now + int64(profile.RememberDevice),
+		TokenHash: security.HashDeviceToken(token),
+	})
+	if err != nil {
+		return ""
+	}
+	return token
+}
+
+// knownDevice finds the record a attempt may use. A randomized MAC is
+// only trusted together with the device token linked to it; the token
+// also finds the device after its MAC changed.
+func (s *Server) knownDevice(ctx context.Context, a mabAttempt) (*store.KnownDevice, string, error) {
+	hash := ""
+	if a.Token != "" {
+		hash = security.HashDeviceToken(a.Token)
+	}
+	d, err := s.st.GetKnownDevice(ctx, a.MAC)
+	if err != nil {
+		return nil, "store_error", err
+	}
+	if d != nil && (!security.RandomizedMAC(a.MAC) || (hash != "" && d.TokenHash == hash)) {
+		return d, "", nil
+	}
+	if hash != "" {
+		if d, err = s.st.KnownDeviceByToken(ctx, hash); err != nil {
+			return nil, "store_error", err
+		}
+		if d != nil {
+			return d, "", nil
+		}
+	}
+	if security.RandomizedMAC(a.MAC) && hash == "" {
+		return nil, "token_required", nil
+	}
+	return nil, "not_known", nil
+}
+
+// mabAuthorize re-authorizes a known device with the role it last logged
+// in with. Quota and device limits apply as for a login.
+func (s *Server) mabAuthorize(ctx context.Context, a mabAttempt) (*store.SessionV2, int, string) {
+	now := time.Now().Unix()
+	if s.denied(ctx, a.MAC, authMAB, a.SSID) != nil {
+		return nil, 0, "denylisted"
+	}
+	d, reason, _ := s.knownDevice(ctx, a)
+	if d == nil {
+		return nil, 0, reason
+	}
+
+	// the profile may have stopped remembering devices, or shortened it
+	role := d.Role
+	_, known := s.cfg.Roles[role]
+	profileName, profile := s.profileFor(role)
+	if !known || profile.RememberDevice <= 0 || now > d.Auth+int64(profile.RememberDevice) {
+		return nil, 0, "not_remembered"
+	}
+	if reason := s.dailyQuotaUsed(ctx, d.Identity, a.MAC, profile); reason != "" {
+		if profile.QuotaAction != config.QuotaThrottle {
+			return nil, 0, "quota_exceeded"
+		}
+		role = profile.ThrottleRole
+		profileName, profile = s.profileFor(role)
+	}
+	if ok, err := s.claimDevice(ctx, d.Identity, a.MAC, profile); err == nil && !ok {
+		return nil, 0, "device_limit"
+	}
+
+	sess := store.SessionV2{
+		Schema:        store.SessionSchema,
+		MAC:           a.MAC,
+		Role:          role,
+		Profile:       profileName,
+		PolicyVersion: s.policyVersion,
+	}
+	sess.Rule.Name = authMAB
+	sess.AP.APID = a.APID
+	sess.AP.SSID = a.SSID
+	sess.AP.RadioID = a.RadioID
+	sess.ApplyProfile(profile)
+	sess.Auth.Method = authMAB
+	sess.Auth.Source = a.Source
+	sess.Auth.Identity = d.Identity
+	sess.TS.Created = now
+	if profile.MaxSessionLifetime > 0 {
+		sess.TS.Expires = now + int64(profile.MaxSessionLifetime)
+	}
+	ttl, _ := sessionTTL(&sess, profile, now)
+
+	if err := s.st.SetSession(ctx, sess, ttl); err != nil {
+		return nil, 0, "store_error"
+	}
+	if d.Identity != "" {
+		_ = s.st.AddDevice(ctx, d.Identity, a.MAC, ttl)
+	}
+	_, _ = s.st.RecordMAB(ctx, *d, a.MAC, now)
+	s.publishSession(ctx, events.SessionCreated, &sess, ttl, "")
+	return &sess, ttl, ""
+}
+
+// mab runs an attempt, audits it and writes the response.
+func (s *Server) mab(w http.ResponseWriter, r *http.Request, a mabAttempt, withToken bool) {
+	ctx := r.Context()
+	a.MAC = macNorm(a.MAC)
+	if !validMAC(a.MAC) {
+		writeJSON(w, 422, map[string]any{"authorized": false, "error": "mac_required"})
+		return
+	}
+
+	sess, ttl, reason := s.mabAuthorize(ctx, a)
+	result, role, identity := "ok", "", ""
+	if sess != nil {
+		role, identity = sess.Role, sess.Auth.Identity
+	} else {
+		result = reason
+	}
+	s.audit.Write(map[string]any{
+		"event":    "portal.mab",
+		"mac":      a.MAC,
+		"role":     role,
+		"identity": identity,
+		"source":   a.Source,
+		"ttl":      ttl,
+		"ap_id":    a.APID,
+		"ssid":     a.SSID,
+		"linked":   a.Token != "",
+		"trace_id": tracing.TraceID(ctx),
+		"result":   result,
+	})
+	metrics.LoginOutcome(result, role, authMAB, ssidLabel(s.cfg, a.SSID))
+
+	switch {
+	case reason == "store_error":
+		writeJSON(w, 500, map[string]any{"authorized": false, "error": reason})
+		return
+	case sess == nil:
+		writeJSON(w, 403, map[string]any{"authorized": false, "error": reason})
+		return
+	}
+
+	out := map[string]any{
+		"authorized": true,
+		"session":    s.buildSessionResp(sess, ttl),
+	}
+	if withToken {
+		token, exp, err := s.jwtIssuer.Issue(ctx, a.MAC)
+		if err != nil {
+			writeJSON(w, 500, map[string]any{"authorized": false, "error": "issue_token_failed"})
+			return
+		}
+		out["token"] = map[string]any{
+			"access_token": token,
+			"expires_in":   exp,
+			"token_type":   "Bearer",
+		}
+	}
+	writeJSON(w, 200, out)
+}
+
+// apMAB re-authorizes a device associating with the calling AP.
+func (s *Server) apMAB(w http.ResponseWriter, r *http.Request) {
+	var req MABReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, 400, map[string]any{"error": "bad_json"})
+		return
+	}
+	apID, code, errName := apCaller(r, req.APID)
+	if errName != "" {
+		writeJSON(w, code, map[string]any{"error": errName})
+		return
+	}
+	s.mab(w, r, mabAttempt{
+		MAC:     req.MAC,
+		Token:   req.DeviceToken,
+		APID:    apID,
+		SSID:    req.SSID,
+		RadioID: req.RadioID,
+		Source:  "ap",
+	}, false)
+}
+
+// portalMAB re-authorizes a device opening the portal, before the login
+// page is shown. The portal passes the device token of its cookie.
+func (s *Server) portalMAB(w http.ResponseWriter, r *http.Request) {
+	var req PortalContextReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, 400, map[string]any{"authorized": false, "error": "bad_json"})
+		return
+	}
+	s.mab(w, r, mabAttempt{
+		MAC:     req.Client.MAC,
+		Token:   req.User.DeviceToken,
+		APID:    req.Access.APID,
+		SSID:    req.Wireless.SSID,
+		RadioID: req.Wireless.RadioID,
+		Source:  "portal",
+	}, true)
+}
+
+// adminGetKnownDevice shows what the controller remembers about a device.
+func (s *Server) adminGetKnownDevice(w http.ResponseWriter, r *http.Request) {
+	d, err := s.st.GetKnownDevice(r.Context(), macNorm(chi.URLParam(r, "mac")))
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": "store_error"})
+		return
+	}
+	if d == nil {
+		writeJSON(w, 404, map[string]any{"error": "not_found"})
+		return
+	}
+	d.TokenHash = ""
+	writeJSON(w, 200, d)
+}
+
+// adminForgetDevice forgets a device: its next connection goes through
+// the portal again. A current session is left alone.
+func (s *Server) adminForgetDevice(w http.ResponseWriter, r *http.Request) {
+	mac := macNorm(chi.URLParam(r, "mac"))
+	d, err := s.st.ForgetDevice(r.Context(), mac)
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": "store_error"})
+		return
+	}
+	if d == nil {
+		writeJSON(w, 404, map[string]any{"error": "not_found"})
+		return
+	}
+	s.auditAdmin(r, map[string]any{
+		"event":    "admin.device_forget",
+		"mac":      mac,
+		"identity": d.Identity,
+		"role":     d.Role,
+		"result":   "ok",
+	})
+	writeJSON(w, 200, map[string]any{"mac": mac, "forgotten": true})
+}
@@ -0,0 +1,97 @@
+package httpapi
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"ap-controller-go/internal/store"
+)
+
+// -------------------------------------------------------------------
+// Session schema migration
+// -------------------------------------------------------------------
+
+// MigrateSessions continues the session migration pass for up to
+// controller.migration.steps SCAN steps, saving progress after each. A
+// new pass starts when none ran yet for the current schema; a finished
+// pass is not repeated until an admin restarts it.
+func (s *Server) MigrateSessions(ctx context.Context) (*store.MigrationProgress, error) {
+	p, err := s.st.GetMigration(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil || p.Schema < store.SessionSchema {
+		p = &store.MigrationProgress{Schema: store.SessionSchema, Started: time.Now().Unix()}
+	}
+	if p.Done() || p.Schema > store.SessionSchema {
+		// finished, or started by a newer controller that owns it
+		return p, nil
+	}
+
+	mc := s.cfg.Controller.Migration
+	for i := 0; i < mc.Steps && !p.Done(); i++ {
+		if ctx.Err() != nil {
+			break
+		}
+		if err := s.st.MigrateSessions(ctx, p, int64(mc.BatchSize)); err != nil {
+			return p, err
+		}
+		if err := s.st.SaveMigration(ctx, *p); err != nil {
+			return p, err
+		}
+	}
+
+	if !p.Done() {
+		log.Printf("sessions: migration to schema %d: %d scanned, %d upgraded", p.Schema, p.Scanned, p.Upgraded)
+		return p, nil
+	}
+	log.Printf("sessions: migration to schema %d done: %d scanned, %d upgraded, %d newer, %d failed",
+		p.Schema, p.Scanned, p.Upgraded, p.Newer, p.Failed)
+	s.auditJob(ctx, map[string]any{
+		"event":    "session.migrated",
+		"schema":   p.Schema,
+		"scanned":  p.Scanned,
+		"upgraded": p.Upgraded,
+		"newer":    p.Newer,
+		"failed":   p.Failed,
+		"duration": p.Finished - p.Started,
+		"result":   "ok",
+	})
+	return p, nil
+}
+
+// adminGetMigration reports the progress of the session migration.
+func (s *Server) adminGetMigration(w http.ResponseWriter, r *http.Request) {
+	p, err := s.st.GetMigration(r.Context())
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": "store_error"})
+		return
+	}
+	writeJSON(w, 200, map[string]any{
+		"schema":   store.SessionSchema,
+		"enabled":  s.cfg.Controller.Migration.Enabled,
+		"progress": p,
+	})
+}
+
+// adminStartMigration starts a new pass, e.g. after legacy writers were
+// switched off. The leader picks it up at its next run.
+func (s *Server) adminStartMigration(w http.ResponseWriter, r *http.Request) {
+	p := store.MigrationProgress{Schema: store.SessionSchema, Started: time.Now().Unix()}
+	if err := s.st.SaveMigration(r.Context(), p); err != nil {
+		writeJSON(w, 500, map[string]any{"error": "store_error"})
+		return
+	}
+	s.auditAdmin(r, map[string]any{
+		"event":  "admin.migration_start",
+		"schema": p.Schema,
+		"result": "ok",
+	})
+	writeJSON(w, 202, map[string]any{
+		"schema":   store.SessionSchema,
+		"enabled":  s.cfg.Controller.Migration.Enabled,
+		"progress": p,
+	})
+}
@@ -1,10 +1,18 @@
 package httpapi
 
 import (
+	"sync/atomic"
+
 	"ap-controller-go/internal/audit"
 	"ap-controller-go/internal/config"
+	"ap-controller-go/internal/events"
+	"ap-controller-go/internal/leader"
+	"ap-controller-go/internal/notify"
+	"ap-controller-go/internal/pki"
+	"ap-controller-go/internal/policy"
 	"ap-controller-go/internal/security"
 	"ap-controller-go/internal/store"
+	"ap-controller-go/internal/transport"
 )
 
 // Server controller http server
@@ -16,6 +24,47 @@ type Server struct {
 	policyVersion string
 	//
 	jwtIssuer *security.JWTIssuer // NEW
+
+	// static admin bearer token ("" = none) and admin JWT secret (nil =
+	// admin JWTs rejected); API keys live in redis
+	adminToken     string
+	adminJWTSecret []byte
+
+	// tenant this server serves ("" = the top-level default tenant) and
+	// its HMAC keys (nil = security.PortalHMACProvider)
+	tenant string
+	keys   *security.KeySet
+
+	// leader election shared by all tenants (nil = disabled, this
+	// replica runs every job)
+	leader *leader.Elector
+
+	// AP enrollment CA (nil = enrollment disabled)
+	ca *pki.CA
+
+	// AP push channel (nil = events disabled)
+	events *events.Hub
+
+	// sponsor notifier and approval link key (nil = sponsored access
+	// disabled)
+	sponsor       notify.Notifier
+	sponsorSecret []byte
+
+	// runtime policy signer and the keys published for it (nil =
+	// policies are not signed)
+	signer     *policy.Signer
+	policyKeys policy.KeySet
+
+	// broker transport to APs (nil = disabled), the queue request
+	// handlers send through and the policy / bypass checksums this
+	// replica last sent on it
+	transport  transport.Transport
+	outbox     *transport.Queue
+	sentPolicy atomic.Value
+	sentBypass atomic.Value
+
+	// active checksum last exported as the policy_info metric
+	servedPolicy atomic.Value
 }
 
 // -------------------------------------------------------------------
@@ -31,6 +80,15 @@ type PortalContextReq struct {
 		OS  string `json:"os,omitempty" example:"iOS"`
 	} `json:"client"`
 
+	User struct {
+		Identity string `json:"identity,omitempty" example:"alice@corp.example"`
+		// Voucher is a guest voucher code; its batch decides the role
+		Voucher string `json:"voucher,omitempty" example:"7KQ4-M2XD-9RT5"`
+		// DeviceToken is the known-device token of the portal cookie; it
+		// is required to re-authorize a randomized MAC
+		DeviceToken string `json:"device_token,omitempty"`
+	} `json:"user"`
+
 	Wireless struct {
 		SSID    string `json:"ssid,omitempty" example:"GuestWiFi"`
 		RadioID string `json:"radio_id,omitempty" example:"radio-1"`
@@ -64,11 +122,198 @@ type BatchEntry struct {
 
 // BatchReq batch portal status request
 type BatchReq struct {
+	// APID is the calling AP (POST /api/v1/ap/batch_status only)
+	APID    string       `json:"ap_id,omitempty" example:"ap-01"`
 	Entries []BatchEntry `json:"entries"`
 }
 
+// AdminKeyReq creates an admin API key. TTL (seconds) 0 = no expiry.
+type AdminKeyReq struct {
+	Name string `json:"name" example:"noc-dashboard"`
+	Role string `json:"role" example:"readonly"`
+	TTL  int    `json:"ttl,omitempty" example:"7776000"`
+}
+
+// DenyReq denylists a MAC ("aa:bb:cc:dd:ee:ff") or an OUI ("aa:bb:cc").
+// TTL (seconds) 0 = until removed.
+type DenyReq struct {
+	Pattern string `json:"pattern" example:"aa:bb:cc:dd:ee:ff"`
+	Reason  string `json:"reason" example:"port scanning"`
+	TTL     int    `json:"ttl,omitempty" example:"86400"`
+}
+
+// BypassReq adds a dynamic bypass entry. Type is mac, ip or domain;
+// Scope is global (default), site (with Site) or ap (with APID). TTL
+// (seconds) 0 = until removed.
+type BypassReq struct {
+	Type   string `json:"type" example:"mac"`
+	Value  string `json:"value" example:"aa:bb:cc:dd:ee:ff"`
+	Scope  string `json:"scope,omitempty" example:"site"`
+	Site   string `json:"site,omitempty" example:"hq"`
+	APID   string `json:"ap_id,omitempty" example:"ap-01"`
+	Reason string `json:"reason" example:"lobby printer"`
+	TTL    int    `json:"ttl,omitempty" example:"604800"`
+}
+
+// RolloutReq starts a staged rollout: a candidate role_rules and / or
+// bypass section (as in controller.yaml; left out = the active one),
+// served to the canary APs only until promoted.
+type RolloutReq struct {
+	RoleRules []config.RoleRule `json:"role_rules"`
+	Bypass    *config.Bypass    `json:"bypass,omitempty"`
+	Canary    store.Canary      `json:"canary"`
+	Note      string            `json:"note,omitempty" example:"guest SSID to the new VLAN"`
+}
+
+// RolloutEndReq promotes or aborts the running rollout. Force promotes
+// an unhealthy canary.
+type RolloutEndReq struct {
+	Reason string `json:"reason,omitempty" example:"canary clean for 24h"`
+	Force  bool   `json:"force,omitempty"`
+}
+
+// BulkLoginReq pre-authorizes a list of MACs with one role.
+type BulkLoginReq struct {
+	Role string   `json:"role" example:"guest"`
+	MACs []string `json:"macs"`
+	// TTL in seconds (default: the profile's session_ttl); the profile's
+	// max_session_lifetime still applies
+	TTL  int    `json:"ttl,omitempty" example:"3600"`
+	APID string `json:"ap_id,omitempty" example:"ap-01"`
+	// Source labels the sessions (auth.source, default "admin")
+	Source string `json:"source,omitempty" example:"event-2024"`
+}
+
+// BulkLogoutReq ends the sessions of a list of MACs.
+type BulkLogoutReq struct {
+	MACs   []string `json:"macs"`
+	Reason string   `json:"reason,omitempty" example:"event_ended"`
+}
+
+// VoucherBatchReq creates a batch of guest vouchers. ValidFrom /
+// ValidUntil are unix seconds (0 = now / open); ValidFor (seconds)
+// may replace ValidUntil.
+type VoucherBatchReq struct {
+	Name       string `json:"name" example:"front-desk-2024-06"`
+	Role       string `json:"role" example:"guest"`
+	Count      int    `json:"count" example:"50"`
+	ValidFrom  int64  `json:"valid_from,omitempty" example:"1717200000"`
+	ValidUntil int64  `json:"valid_until,omitempty" example:"1719792000"`
+	ValidFor   int    `json:"valid_for,omitempty" example:"2592000"`
+	// Duration (seconds) a voucher grants from its first use
+	Duration int `json:"duration,omitempty" example:"86400"`
+	// Devices per voucher (0 = unlimited)
+	Devices int `json:"devices,omitempty" example:"2"`
+	// QuotaMB per voucher over all its devices (0 = unlimited)
+	QuotaMB int `json:"quota_mb,omitempty" example:"2048"`
+}
+
+// SponsorReq asks the named employee to approve a visitor's device. The
+// client, access and wireless parts are those of a login request.
+type SponsorReq struct {
+	PortalContextReq
+	// Sponsor is the host employee's e-mail address
+	Sponsor string `json:"sponsor" example:"bob@corp.example"`
+	// Guest is the visitor's name as shown to the sponsor
+	Guest string `json:"guest" example:"Alice Example"`
+	Note  string `json:"note,omitempty" example:"meeting at 10:00, room 4.12"`
+}
+
 // ErrorResponse standard error response
 type ErrorResponse struct {
 	Code    string `json:"code" example:"bad_request"`
 	Message string `json:"message" example:"invalid mac"`
 }
+
+// -------------------------------------------------------------------
+// Data-plane accounting
+// -------------------------------------------------------------------
+
+// AccountingRecord is one client's cumulative counters as seen by the AP.
+type AccountingRecord struct {
+	MAC        string `json:"mac" example:"aa:bb:cc:dd:ee:ff"`
+	Type       string `json:"type" example:"interim"` // start | interim | stop
+	BytesIn    int64  `json:"bytes_in" example:"1048576"`
+	BytesOut   int64  `json:"bytes_out" example:"524288"`
+	PacketsIn  int64  `json:"packets_in" example:"1200"`
+	PacketsOut int64  `json:"packets_out" example:"900"`
+}
+
+// AccountingReq is a batch of accounting records from one AP.
+type AccountingReq struct {
+	APID    string             `json:"ap_id" example:"ap-123"`
+	Records []AccountingRecord `json:"records"`
+}
+
+// AccountingResult reports what the controller did with one record.
+type AccountingResult struct {
+	MAC    string `json:"mac"`
+	Status string `json:"status" example:"ok"` // ok | no_session | terminated | throttled | idle_timeout | error
+	Reason string `json:"reason,omitempty"`
+}
+
+// -------------------------------------------------------------------
+// AP inventory
+// -------------------------------------------------------------------
+
+// APHeartbeatReq is sent by APs on register and on every heartbeat.
+// Empty fields in a heartbeat keep their registered value.
+type APHeartbeatReq struct {
+	APID           string `json:"ap_id"`
+	Site           string `json:"site,omitempty"`
+	Hostname       string `json:"hostname,omitempty"`
+	Firmware       string `json:"firmware,omitempty"`
+	LanIF          string `json:"lan_if,omitempty"`
+	Uptime         int64  `json:"uptime,omitempty"`
+	PolicyChecksum string `json:"policy_checksum,omitempty"`
+}
+
+// PolicyAckReq reports the outcome of applying a runtime policy.
+type PolicyAckReq struct {
+	APID     string `json:"ap_id"`
+	Checksum string `json:"checksum"`
+	Version  string `json:"version,omitempty"`
+	Status   string `json:"status"` // applied | failed
+	Error    string `json:"error,omitempty"`
+}
+
+// MABReq asks to re-authorize a known device when it associates.
+type MABReq struct {
+	MAC     string `json:"mac" example:"aa:bb:cc:dd:ee:ff"`
+	APID    string `json:"ap_id,omitempty" example:"ap-123"`
+	SSID    string `json:"ssid,omitempty" example:"GuestWiFi"`
+	RadioID string `json:"radio_id,omitempty" example:"radio-1"`
+	// DeviceToken links a randomized MAC to its known device
+	DeviceToken string `json:"device_token,omitempty"`
+}
+
+// -------------------------------------------------------------------
+// AP enrollment
+// -------------------------------------------------------------------
+
+// EnrollTokenReq asks for a one-time enrollment token for an AP.
+type EnrollTokenReq struct {
+	APID string `json:"ap_id"`
+	Site string `json:"site,omitempty"`
+	// TTL overrides enrollment.token_ttl (seconds)
+	TTL int `json:"ttl,omitempty"`
+}
+
+// EnrollReq exchanges a token and a PEM CSR for a client certificate.
+type EnrollReq struct {
+	Token string `json:"token"`
+	CSR   string `json:"csr"`
+}
+
+// RenewReq is sent over mTLS with the AP's current certificate.
+type RenewReq struct {
+	CSR string `json:"csr"`
+}
+
+// RevokeReq puts a certificate serial (hex), or every certificate
+// issued to an AP, on the revocation list.
+type RevokeReq struct {
+	Serial string `json:"serial,omitempty"`
+	APID   string `json:"ap_id,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
@@ -0,0 +1,159 @@
+package httpapi
+
+import (
+	"context"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"ap-controller-go/internal/config"
+	"ap-controller-go/internal/metrics"
+	"ap-controller-go/internal/store"
+	"ap-controller-go/internal/tracing"
+)
+
+// -------------------------------------------------------------------
+// Login rate limiting / lockout
+// -------------------------------------------------------------------
+
+// loginSubjects returns the MAC and client IP limits of a login. The IP
+// is the one in the portal envelope: the request peer is usually the
+// portal itself and is limited as the "client" scope.
+func loginSubjects(mac, clientIP string) []store.RateSubject {
+	var subs []store.RateSubject
+	if mac != "" {
+		subs = append(subs, store.RateSubject{Scope: "mac", ID: mac})
+	}
+	if ip := net.ParseIP(clientIP); ip != nil {
+		subs = append(subs, store.RateSubject{Scope: "ip", ID: ip.String()})
+	}
+	return subs
+}
+
+// portalClient is the portal client (request peer) subject.
+func portalClient(r *http.Request) store.RateSubject {
+	return store.RateSubject{Scope: "client", ID: remoteHost(r)}
+}
+
+func bucketFor(rl config.RateLimit, scope string) config.Bucket {
+	switch scope {
+	case "mac":
+		return rl.MAC
+	case "ip":
+		return rl.IP
+	default:
+		return rl.Client
+	}
+}
+
+// throttled enforces lockouts and then the token buckets of subs, which
+// are taken together: a request refused by one costs nothing in the
+// others. When one applies it answers 429 with Retry-After, audits the
+// decision and returns true. Redis errors fail open.
+func (s *Server) throttled(w http.ResponseWriter, r *http.Request, mac string, subs ...store.RateSubject) bool {
+	rl := s.cfg.Controller.RateLimit
+	if !rl.Enabled || len(subs) == 0 {
+		return false
+	}
+	ctx := r.Context()
+
+	locks, err := s.st.LockedFor(ctx, subs)
+	if err != nil {
+		log.Printf("ratelimit: lockout check failed: %v", err)
+		return false
+	}
+	for i, d := range locks {
+		if d > 0 {
+			s.rejectThrottled(w, r, subs[i], mac, "locked_out", d)
+			return true
+		}
+	}
+
+	var buckets []store.RateBucket
+	for _, sub := range subs {
+		b := bucketFor(rl, sub.Scope)
+		if b.Rate <= 0 {
+			continue
+		}
+		buckets = append(buckets, store.RateBucket{
+			Subject: sub, Rate: b.Rate, Burst: b.Burst, Window: time.Duration(b.Window) * time.Second,
+		})
+	}
+	i, wait, err := s.st.TakeTokens(ctx, buckets)
+	if err != nil {
+		log.Printf("ratelimit: buckets failed: %v", err)
+		return false
+	}
+	if i >= 0 {
+		s.rejectThrottled(w, r, buckets[i].Subject, mac, "rate_limited", wait)
+		return true
+	}
+	return false
+}
+
+func (s *Server) rejectThrottled(w http.ResponseWriter, r *http.Request, sub store.RateSubject, mac, reason string, wait time.Duration) {
+	secs := max(1, int(math.Ceil(wait.Seconds())))
+	if reason == "locked_out" {
+		metrics.RateLimited(sub.Scope, "lockout")
+	} else {
+		metrics.RateLimited(sub.Scope, "rate")
+	}
+	s.audit.Write(map[string]any{
+		"event":       "portal.rate_limited",
+		"path":        r.URL.Path,
+		"scope":       sub.Scope,
+		"key":         sub.ID,
+		"mac":         mac,
+		"peer":        remoteHost(r),
+		"retry_after": secs,
+		"trace_id":    tracing.TraceID(r.Context()),
+		"result":      reason,
+	})
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	writeJSON(w, 429, map[string]any{"authorized": false, "error": reason, "retry_after": secs})
+}
+
+// loginFailed counts a login with bad credentials (an unknown voucher
+// code) against subs; at the threshold they are locked out, which is
+// audited. Policy refusals (quota, device limit, sponsor) are no guesses
+// and are not counted.
+func (s *Server) loginFailed(ctx context.Context, reason string, subs ...store.RateSubject) {
+	rl := s.cfg.Controller.RateLimit
+	lo := rl.Lockout
+	if !rl.Enabled || lo.Failures <= 0 {
+		return
+	}
+	for _, sub := range subs {
+		d, err := s.st.RecordFailure(ctx, sub, lo.Failures,
+			time.Duration(lo.Window)*time.Second, time.Duration(lo.Base)*time.Second, time.Duration(lo.Max)*time.Second)
+		if err != nil {
+			log.Printf("ratelimit: record failure failed: %v", err)
+			continue
+		}
+		if d > 0 {
+			s.audit.Write(map[string]any{
+				"event":    "portal.lockout",
+				"scope":    sub.Scope,
+				"key":      sub.ID,
+				"reason":   reason,
+				"failures": lo.Failures,
+				"lockout":  int(d.Seconds()),
+				"trace_id": tracing.TraceID(ctx),
+				"result":   "locked_out",
+			})
+		}
+	}
+}
+
+// loginSucceeded resets the failure counters of subs.
+func (s *Server) loginSucceeded(ctx context.Context, subs ...store.RateSubject) {
+	if !s.cfg.Controller.RateLimit.Enabled || len(subs) == 0 {
+		return
+	}
+	if err := s.st.ClearFailures(ctx, subs); err != nil {
+		log.Printf("ratelimit: clear failures failed: %v", err)
+	}
+}
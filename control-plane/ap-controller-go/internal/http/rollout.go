@@ -0,0 +1,569 @@
+package httpapi
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"ap-controller-go/internal/config"
+	"ap-controller-go/internal/metrics"
+	"ap-controller-go/internal/policy"
+	"ap-controller-go/internal/store"
+)
+
+// -------------------------------------------------------------------
+// Staged policy rollout
+// -------------------------------------------------------------------
+
+const rolloutNoteMax = 200
+
+// Canary health problems.
+const (
+	canaryFailedAcks  = "failed_acks"
+	canaryLoginErrors = "login_errors"
+)
+
+// policyView is the policy an AP runs with. rollout is the rollout in
+// its canary stage (nil = none), canary whether the AP is one of its
+// canaries.
+type policyView struct {
+	cfg     *config.Config
+	rollout *store.Rollout
+	canary  bool
+}
+
+// policySections is the checksum of the role_rules and bypass sections
+// of cfg, the part of the policy a rollout changes.
+func policySections(cfg *config.Config) string {
+	raw, _ := json.Marshal(struct {
+		RoleRules []config.RoleRule
+		Bypass    config.Bypass
+	}{cfg.RoleRules, cfg.Bypass})
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
+
+func newRolloutID() (string, error) {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "ro_" + hex.EncodeToString(b), nil
+}
+
+// rolloutConfigs returns the active config and the rollout in its
+// canary stage, if any. The active config is the config file with the
+// promoted policy of the last rollout, unless the file's sections
+// changed since.
+func (s *Server) rolloutConfigs(ctx context.Context) (*config.Config, *store.Rollout, error) {
+	promoted, ro, err := s.st.PolicyRollout(ctx)
+	if err != nil {
+		return s.cfg, nil, err
+	}
+	active := s.cfg
+	if promoted != nil && promoted.Base == policySections(s.cfg) {
+		active = s.cfg.WithPolicy(promoted.RoleRules, &promoted.Bypass)
+	}
+	if ro != nil && ro.Stage != store.RolloutCanary {
+		ro = nil
+	}
+	return active, ro, nil
+}
+
+// policyFor returns the policy the AP apID of site runs with. The site
+// is looked up in the inventory if the canary selects sites.
+func (s *Server) policyFor(ctx context.Context, site, apID string) (policyView, error) {
+	active, ro, err := s.rolloutConfigs(ctx)
+	if err != nil || ro == nil {
+		return policyView{cfg: active}, err
+	}
+	if site == "" && apID != "" && len(ro.Canary.Sites) > 0 {
+		if rec, _ := s.st.GetAP(ctx, apID); rec != nil {
+			site = rec.Site
+		}
+	}
+	pv := policyView{cfg: active, rollout: ro, canary: ro.Canary.Selects(ro.ID, site, apID)}
+	if pv.canary {
+		pv.cfg = active.WithPolicy(ro.RoleRules, ro.Bypass)
+	}
+	return pv, nil
+}
+
+// candidatePolicy builds the candidate runtime policy of the rollout in
+// its canary stage, as seen by an AP no scoped bypass entry applies to.
+// ro is nil when no canary runs.
+func (s *Server) candidatePolicy(ctx context.Context) (*store.Rollout, policy.RuntimePolicy, error) {
+	active, ro, err := s.rolloutConfigs(ctx)
+	if err != nil || ro == nil {
+		return nil, policy.RuntimePolicy{}, err
+	}
+	rp, err := s.buildRuntime(ctx, active.WithPolicy(ro.RoleRules, ro.Bypass), policy.Dynamic{Rollout: ro.ID})
+	return ro, rp, err
+}
+
+// loginOutcome records a login result in the metrics and, while a
+// canary runs, in the login counters of the rollout.
+func (s *Server) loginOutcome(ctx context.Context, pv policyView, result, role, rule, ssid string) {
+	metrics.LoginOutcome(result, role, rule, ssidLabel(pv.cfg, ssid))
+	if pv.rollout == nil {
+		return
+	}
+	if err := s.st.CountRolloutLogin(ctx, pv.rollout.ID, pv.canary, result != "ok"); err != nil {
+		log.Printf("rollout: count login: %v", err)
+	}
+}
+
+// canaryHealth is how the canary APs of a rollout fare. Error rates are
+// percentages of logins.
+type canaryHealth struct {
+	APs               int                 `json:"aps"`
+	Applied           int                 `json:"applied"`
+	Failed            int                 `json:"failed"`
+	Pending           int                 `json:"pending"`
+	FailedAPs         []string            `json:"failed_aps,omitempty"`
+	Logins            store.RolloutLogins `json:"logins"`
+	CanaryErrorRate   float64             `json:"canary_error_rate"`
+	BaselineErrorRate float64             `json:"baseline_error_rate"`
+	Healthy           bool                `json:"healthy"`
+	Problems          []string            `json:"problems,omitempty"`
+}
+
+// canaryHealth judges the canary APs of ro against the candidate
+// checksum and convergence.canary.
+func (s *Server) canaryHealth(ctx context.Context, ro *store.Rollout, checksum string) (canaryHealth, error) {
+	aps, err := s.st.ListAPs(ctx)
+	if err != nil {
+		return canaryHealth{}, err
+	}
+	logins, err := s.st.RolloutLoginCounts(ctx, ro.ID)
+	if err != nil {
+		return canaryHealth{}, err
+	}
+
+	h := canaryHealth{Logins: logins}
+	for _, ap := range aps {
+		if !ro.Canary.Selects(ro.ID, ap.Site, ap.APID) {
+			continue
+		}
+		h.APs++
+		switch convergenceState(ap, checksum) {
+		case convCurrent:
+			h.Applied++
+		case convFailed:
+			h.Failed++
+			h.FailedAPs = append(h.FailedAPs, ap.APID)
+		default:
+			h.Pending++
+		}
+	}
+
+	rate := func(errs, n int64) float64 {
+		if n == 0 {
+			return 0
+		}
+		return float64(errs) * 100 / float64(n)
+	}
+	c := s.cfg.Controller.Convergence.Canary
+	h.CanaryErrorRate = rate(logins.CanaryErrors, logins.Canary)
+	h.BaselineErrorRate = rate(logins.BaselineErrors, logins.Baseline)
+	baseline := h.BaselineErrorRate
+	if logins.Baseline < int64(c.MinLogins) {
+		// too few logins elsewhere to compare with
+		baseline = 0
+	}
+
+	if h.Failed > c.MaxFailedAcks {
+		h.Problems = append(h.Problems, canaryFailedAcks)
+	}
+	if logins.Canary >= int64(c.MinLogins) && h.CanaryErrorRate-baseline > float64(c.MaxErrorIncrease) {
+		h.Problems = append(h.Problems, canaryLoginErrors)
+	}
+	h.Healthy = len(h.Problems) == 0
+	return h, nil
+}
+
+// endRollout ends the canary stage of ro: promoted (with the policy to
+// promote) or aborted.
+func (s *Server) endRollout(ctx context.Context, ro *store.Rollout, stage, by, reason string, promoted *store.PromotedPolicy) (*store.Rollout, error) {
+	now := time.Now().Unix()
+	next := *ro
+	next.Stage = stage
+	next.EndedBy = by
+	next.Ended = now
+	next.Updated = now
+	next.Reason = reason
+	return s.st.SetRollout(ctx, ro.ID, next, promoted)
+}
+
+// CheckCanary judges the canary of a running rollout. An unhealthy
+// canary raises one "policy.canary_unhealthy" alert per rollout or, with
+// convergence.canary.auto_abort, is aborted. It returns the problems
+// found.
+func (s *Server) CheckCanary(ctx context.Context, now time.Time) ([]string, error) {
+	ro, cand, err := s.candidatePolicy(ctx)
+	if err != nil || ro == nil {
+		return nil, err
+	}
+	h, err := s.canaryHealth(ctx, ro, cand.Version.Checksum)
+	if err != nil || h.Healthy {
+		return nil, err
+	}
+
+	ev := map[string]any{
+		"severity":            "alert",
+		"rollout":             ro.ID,
+		"problems":            h.Problems,
+		"failed_aps":          h.FailedAPs,
+		"canary_logins":       h.Logins.Canary,
+		"canary_error_rate":   h.CanaryErrorRate,
+		"baseline_error_rate": h.BaselineErrorRate,
+		"result":              "ok",
+	}
+	if s.cfg.Controller.Convergence.Canary.AutoAbort {
+		ended, err := s.endRollout(ctx, ro, store.RolloutAborted, "auto_abort", strings.Join(h.Problems, ","), nil)
+		if errors.Is(err, store.ErrRolloutChanged) {
+			return h.Problems, nil
+		}
+		if err != nil {
+			return h.Problems, err
+		}
+		log.Printf("rollout %s aborted: canary unhealthy (%s)", ro.ID, ended.Reason)
+		ev["event"] = "policy.rollout"
+		ev["from"] = store.RolloutCanary
+		ev["stage"] = store.RolloutAborted
+		ev["reason"] = ended.Reason
+		s.auditJob(ctx, ev)
+		s.policyChanged(ctx)
+		return h.Problems, nil
+	}
+
+	if ro.Alerted != 0 {
+		return h.Problems, nil
+	}
+	next := *ro
+	next.Alerted = now.Unix()
+	if _, err := s.st.SetRollout(ctx, ro.ID, next, nil); err != nil {
+		if errors.Is(err, store.ErrRolloutChanged) {
+			return h.Problems, nil
+		}
+		return h.Problems, err
+	}
+	log.Printf("rollout %s: canary unhealthy (%s)", ro.ID, strings.Join(h.Problems, ", "))
+	ev["event"] = "policy.canary_unhealthy"
+	s.auditJob(ctx, ev)
+	return h.Problems, nil
+}
+
+// -------------------------------------------------------------------
+// Admin
+// -------------------------------------------------------------------
+
+// normCanary trims and deduplicates a canary selection. It reports false
+// if the selection is empty or the percentage out of range.
+func normCanary(c store.Canary) (store.Canary, bool) {
+	clean := func(l []string) []string {
+		var out []string
+		for _, v := range l {
+			if v = strings.TrimSpace(v); v != "" && !slices.Contains(out, v) {
+				out = append(out, v)
+			}
+		}
+		return out
+	}
+	c.APIDs, c.Sites = clean(c.APIDs), clean(c.Sites)
+	return c, c.Percent >= 0 && c.Percent <= 100 && !c.Empty()
+}
+
+// rolloutResp is ro with, while its canary runs, the candidate version
+// and the canary health.
+func (s *Server) rolloutResp(ctx context.Context, ro *store.Rollout) (map[string]any, error) {
+	out := map[string]any{"rollout": ro}
+	if ro.Stage != store.RolloutCanary {
+		return out, nil
+	}
+	cur, cand, err := s.candidatePolicy(ctx)
+	if err != nil || cur == nil || cur.ID != ro.ID {
+		return out, err
+	}
+	published := s.policyPublished(ctx, cand.Version.Checksum, time.Now().Unix())
+	h, err := s.canaryHealth(ctx, cur, cand.Version.Checksum)
+	if err != nil {
+		return nil, err
+	}
+	out["candidate"] = map[string]any{
+		"version":   cand.Version.Version,
+		"checksum":  cand.Version.Checksum,
+		"published": published,
+	}
+	out["health"] = h
+	return out, nil
+}
+
+func (s *Server) writeRollout(w http.ResponseWriter, r *http.Request, ro *store.Rollout) {
+	out, err := s.rolloutResp(r.Context(), ro)
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": "store_error"})
+		return
+	}
+	writeJSON(w, 200, out)
+}
+
+// adminRolloutGet shows the running (or last) rollout.
+func (s *Server) adminRolloutGet(w http.ResponseWriter, r *http.Request) {
+	_, ro, err := s.st.PolicyRollout(r.Context())
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": "store_error"})
+		return
+	}
+	if ro == nil {
+		writeJSON(w, 404, map[string]any{"error": "no_rollout"})
+		return
+	}
+	s.writeRollout(w, r, ro)
+}
+
+// adminRolloutCreate starts the canary stage of a candidate policy.
+func (s *Server) adminRolloutCreate(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req RolloutReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, 400, map[string]any{"error": "bad_json"})
+		return
+	}
+	if req.RoleRules == nil && req.Bypass == nil {
+		writeJSON(w, 422, map[string]any{"error": "empty_candidate"})
+		return
+	}
+	canary, ok := normCanary(req.Canary)
+	if !ok {
+		writeJSON(w, 422, map[string]any{"error": "bad_canary"})
+		return
+	}
+	req.Note = strings.TrimSpace(req.Note)
+	if len(req.Note) > rolloutNoteMax {
+		writeJSON(w, 422, map[string]any{"error": "bad_note"})
+		return
+	}
+
+	active, running, err := s.rolloutConfigs(ctx)
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": "store_error"})
+		return
+	}
+	if running != nil {
+		writeJSON(w, 409, map[string]any{"error": "rollout_running", "rollout": running.ID})
+		return
+	}
+	cand := active.WithPolicy(req.RoleRules, req.Bypass)
+	if err := config.ValidatePolicy(cand); err != nil {
+		var details []string
+		var ve *config.ValidationError
+		if errors.As(err, &ve) {
+			for _, fe := range ve.Errors {
+				details = append(details, fe.Error())
+			}
+		}
+		writeJSON(w, 422, map[string]any{"error": "invalid_policy", "details": details})
+		return
+	}
+	if policySections(cand) == policySections(active) {
+		writeJSON(w, 422, map[string]any{"error": "no_change"})
+		return
+	}
+
+	id, err := newRolloutID()
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": "internal_error"})
+		return
+	}
+	now := time.Now().Unix()
+	ro, err := s.st.SetRollout(ctx, "", store.Rollout{
+		ID:        id,
+		Stage:     store.RolloutCanary,
+		RoleRules: req.RoleRules,
+		Bypass:    req.Bypass,
+		Canary:    canary,
+		Note:      req.Note,
+		CreatedBy: adminSubject(r),
+		Created:   now,
+		Updated:   now,
+	}, nil)
+	if errors.Is(err, store.ErrRolloutChanged) {
+		writeJSON(w, 409, map[string]any{"error": "rollout_running"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": "store_error"})
+		return
+	}
+
+	s.auditAdmin(r, map[string]any{
+		"event":      "admin.policy_rollout",
+		"rollout":    ro.ID,
+		"from":       "",
+		"stage":      ro.Stage,
+		"role_rules": ro.RoleRules != nil,
+		"bypass":     ro.Bypass != nil,
+		"ap_ids":     canary.APIDs,
+		"sites":      canary.Sites,
+		"percent":    canary.Percent,
+		"note":       ro.Note,
+		"result":     "ok",
+	})
+	s.policyChanged(ctx)
+	s.writeRollout(w, r, ro)
+}
+
+// adminRolloutCanary changes the canary APs of the running rollout,
+// e.g. to widen it step by step.
+func (s *Server) adminRolloutCanary(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req store.Canary
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, 400, map[string]any{"error": "bad_json"})
+		return
+	}
+	canary, ok := normCanary(req)
+	if !ok {
+		writeJSON(w, 422, map[string]any{"error": "bad_canary"})
+		return
+	}
+	_, ro, err := s.rolloutConfigs(ctx)
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": "store_error"})
+		return
+	}
+	if ro == nil {
+		writeJSON(w, 409, map[string]any{"error": "no_canary"})
+		return
+	}
+
+	next := *ro
+	next.Canary = canary
+	next.Updated = time.Now().Unix()
+	// judged afresh on the new canary APs
+	next.Alerted = 0
+	stored, err := s.st.SetRollout(ctx, ro.ID, next, nil)
+	if errors.Is(err, store.ErrRolloutChanged) {
+		writeJSON(w, 409, map[string]any{"error": "no_canary"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": "store_error"})
+		return
+	}
+
+	s.auditAdmin(r, map[string]any{
+		"event":            "admin.policy_canary",
+		"rollout":          ro.ID,
+		"ap_ids":           canary.APIDs,
+		"sites":            canary.Sites,
+		"percent":          canary.Percent,
+		"previous_ap_ids":  ro.Canary.APIDs,
+		"previous_sites":   ro.Canary.Sites,
+		"previous_percent": ro.Canary.Percent,
+		"result":           "ok",
+	})
+	s.policyChanged(ctx)
+	s.writeRollout(w, r, stored)
+}
+
+// adminRolloutPromote makes the candidate the active policy of every
+// AP. An unhealthy canary needs "force".
+func (s *Server) adminRolloutPromote(w http.ResponseWriter, r *http.Request) {
+	s.adminRolloutEnd(w, r, store.RolloutPromoted)
+}
+
+// adminRolloutAbort drops the candidate; the canary APs go back to the
+// active policy.
+func (s *Server) adminRolloutAbort(w http.ResponseWriter, r *http.Request) {
+	s.adminRolloutEnd(w, r, store.RolloutAborted)
+}
+
+func (s *Server) adminRolloutEnd(w http.ResponseWriter, r *http.Request, stage string) {
+	ctx := r.Context()
+
+	var req RolloutEndReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeJSON(w, 400, map[string]any{"error": "bad_json"})
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if len(req.Reason) > rolloutNoteMax {
+		writeJSON(w, 422, map[string]any{"error": "bad_reason"})
+		return
+	}
+	active, ro, err := s.rolloutConfigs(ctx)
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": "store_error"})
+		return
+	}
+	if ro == nil {
+		writeJSON(w, 409, map[string]any{"error": "no_canary"})
+		return
+	}
+
+	var promoted *store.PromotedPolicy
+	var problems []string
+	if stage == store.RolloutPromoted {
+		cur, cand, err := s.candidatePolicy(ctx)
+		if err != nil || cur == nil {
+			writeJSON(w, 500, map[string]any{"error": "store_error"})
+			return
+		}
+		h, err := s.canaryHealth(ctx, cur, cand.Version.Checksum)
+		if err != nil {
+			writeJSON(w, 500, map[string]any{"error": "store_error"})
+			return
+		}
+		problems = h.Problems
+		if !h.Healthy && !req.Force {
+			writeJSON(w, 409, map[string]any{"error": "canary_unhealthy", "problems": h.Problems})
+			return
+		}
+		cfg := active.WithPolicy(ro.RoleRules, ro.Bypass)
+		promoted = &store.PromotedPolicy{
+			Rollout:    ro.ID,
+			Base:       policySections(s.cfg),
+			RoleRules:  cfg.RoleRules,
+			Bypass:     cfg.Bypass,
+			PromotedBy: adminSubject(r),
+			Promoted:   time.Now().Unix(),
+		}
+	}
+
+	ended, err := s.endRollout(ctx, ro, stage, adminSubject(r), req.Reason, promoted)
+	if errors.Is(err, store.ErrRolloutChanged) {
+		writeJSON(w, 409, map[string]any{"error": "no_canary"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": "store_error"})
+		return
+	}
+
+	s.auditAdmin(r, map[string]any{
+		"event":    "admin.policy_rollout",
+		"rollout":  ended.ID,
+		"from":     store.RolloutCanary,
+		"stage":    ended.Stage,
+		"reason":   ended.Reason,
+		"forced":   req.Force && len(problems) > 0,
+		"problems": problems,
+		"logins":   ended.Logins,
+		"result":   "ok",
+	})
+	s.policyChanged(ctx)
+	s.writeRollout(w, r, ended)
+}
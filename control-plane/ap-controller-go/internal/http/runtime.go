@@ -0,0 +1,116 @@
+package httpapi
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"ap-controller-go/internal/config"
+	"ap-controller-go/internal/policy"
+	"ap-controller-go/internal/security"
+)
+
+// -------------------------------------------------------------------
+// Runtime policy
+// -------------------------------------------------------------------
+
+// EnablePolicySigning signs runtime policy responses with signer and
+// publishes its key, followed by extra (e.g. the next key of a
+// rotation), on GET /api/v1/policy/keys.
+func (s *Server) EnablePolicySigning(signer *policy.Signer, extra ...policy.PublicKey) {
+	s.signer = signer
+	s.policyKeys = policy.KeySet{Keys: append([]policy.PublicKey{signer.PublicKey()}, extra...)}
+}
+
+// policyKeysHandler publishes the verification keys. They are public,
+// like a CA certificate.
+func (s *Server) policyKeysHandler(w http.ResponseWriter, r *http.Request) {
+	if s.signer == nil {
+		writeJSON(w, 404, map[string]any{"error": "signing_disabled"})
+		return
+	}
+	w.Header().Set("Cache-Control", "max-age=300")
+	writeJSON(w, 200, s.policyKeys)
+}
+
+// runtimePolicy builds the runtime policy with the store-backed entries,
+// as seen by an AP no site or AP scoped bypass entry applies to. Its
+// version is the one of every AP's policy but the canaries of a staged
+// rollout.
+func (s *Server) runtimePolicy(ctx context.Context) (policy.RuntimePolicy, error) {
+	return s.runtimePolicyFor(ctx, "", "")
+}
+
+// runtimePolicyFor builds the runtime policy of the AP apID of site:
+// the candidate policy if it is a canary of a staged rollout.
+func (s *Server) runtimePolicyFor(ctx context.Context, site, apID string) (policy.RuntimePolicy, error) {
+	pv, err := s.policyFor(ctx, site, apID)
+	if err != nil {
+		return policy.BuildRuntimePolicy(s.cfg), err
+	}
+	dyn := policy.Dynamic{Site: site, APID: apID}
+	if pv.canary {
+		dyn.Rollout = pv.rollout.ID
+	}
+	return s.buildRuntime(ctx, pv.cfg, dyn)
+}
+
+// buildRuntime builds the runtime policy of cfg with the store-backed
+// entries added to dyn.
+func (s *Server) buildRuntime(ctx context.Context, cfg *config.Config, dyn policy.Dynamic) (policy.RuntimePolicy, error) {
+	now := time.Now().Unix()
+
+	denied, err := s.st.ListDenylist(ctx, now)
+	if err != nil {
+		return policy.BuildRuntimePolicy(s.cfg), err
+	}
+	for _, e := range denied {
+		dyn.Denylist = append(dyn.Denylist, policy.RuntimeDeny{Pattern: e.Pattern, Kind: e.Kind, Expires: e.Expires})
+	}
+	bypass, err := s.st.ListBypass(ctx, now)
+	if err != nil {
+		return policy.BuildRuntimePolicy(s.cfg), err
+	}
+	for _, e := range bypass {
+		dyn.Bypass = append(dyn.Bypass, policy.BypassEntry{
+			Type:    e.Type,
+			Value:   e.Value,
+			Scope:   e.Scope,
+			Site:    e.Site,
+			APID:    e.APID,
+			Expires: e.Expires,
+		})
+	}
+	return policy.Build(cfg, dyn), nil
+}
+
+// runtimeHandler serves the runtime policy. APs pass ?ap_id= (and
+// ?site=, else the site of the AP's inventory record) to get the bypass
+// entries scoped to them.
+func (s *Server) runtimeHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	apID, site := r.URL.Query().Get("ap_id"), r.URL.Query().Get("site")
+	if id, ok := security.APIdentityFrom(ctx); ok {
+		apID, site = id.APID, id.Site
+	}
+	if site == "" && apID != "" {
+		if rec, _ := s.st.GetAP(ctx, apID); rec != nil {
+			site = rec.Site
+		}
+	}
+	rp, err := s.runtimePolicyFor(ctx, site, apID)
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": "store_error"})
+		return
+	}
+	policy.WriteRuntime(w, rp, s.signer)
+}
+
+// policyChanged pushes the new runtime policy after an admin change
+// instead of waiting for the next policy.publish run.
+func (s *Server) policyChanged(ctx context.Context) {
+	if err := s.PublishPolicyState(ctx); err != nil {
+		log.Printf("policy: publish: %v", err)
+	}
+}
@@ -0,0 +1,509 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"ap-controller-go/internal/events"
+	"ap-controller-go/internal/notify"
+	"ap-controller-go/internal/security"
+	"ap-controller-go/internal/store"
+	"ap-controller-go/internal/tracing"
+)
+
+// -------------------------------------------------------------------
+// Sponsored guest access
+// -------------------------------------------------------------------
+
+const authSponsor = "sponsor"
+
+// sponsor link actions
+const (
+	sponsorApprove = "approve"
+	sponsorDeny    = "deny"
+)
+
+// EnableSponsor enables sponsored guest access: requests are sent to
+// sponsors through n with approval links signed by secret.
+func (s *Server) EnableSponsor(n notify.Notifier, secret []byte) {
+	s.sponsor = n
+	s.sponsorSecret = secret
+}
+
+func (s *Server) sponsorRoutes(r chi.Router) {
+	if s.sponsor == nil {
+		return
+	}
+
+	// public like /portal/login: the portal submits and polls, the
+	// signed link is the sponsor's credential
+	r.Post("/portal/sponsor", s.sponsorRequest)
+	r.Get("/portal/sponsor/{id}", s.sponsorStatus)
+	r.Get("/portal/sponsor/{id}/{action}", s.sponsorConfirm)
+	r.Post("/portal/sponsor/{id}/{action}", s.sponsorDecide)
+}
+
+// sponsorRequest creates a pending request and sends the sponsor the
+// approve / deny links. A device that already waits gets its pending
+// request back instead of notifying the sponsor again.
+func (s *Server) sponsorRequest(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	sc := s.cfg.Controller.Sponsor
+
+	if s.throttled(w, r, "", portalClient(r)) {
+		return
+	}
+
+	var req SponsorReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, 400, map[string]any{"error": "bad_json"})
+		return
+	}
+	mac := macNorm(req.Client.MAC)
+	limited := loginSubjects(mac, req.Client.IP)
+	if s.throttled(w, r, mac, limited...) {
+		return
+	}
+	if !validMAC(mac) {
+		writeJSON(w, 422, map[string]any{"error": "mac_required"})
+		return
+	}
+	if s.denied(ctx, mac, "sponsor", req.Wireless.SSID) != nil {
+		writeJSON(w, 403, map[string]any{"error": "denylisted"})
+		return
+	}
+
+	guest := strings.TrimSpace(req.Guest)
+	if guest == "" || len(guest) > 100 {
+		writeJSON(w, 422, map[string]any{"error": "guest_required"})
+		return
+	}
+	note := strings.TrimSpace(req.Note)
+	if len(note) > 500 {
+		writeJSON(w, 422, map[string]any{"error": "note_too_long"})
+		return
+	}
+	sponsor, ok := s.sponsorAddress(req.Sponsor)
+	if !ok {
+		s.audit.Write(map[string]any{
+			"event":   "sponsor.request",
+			"mac":     mac,
+			"guest":   guest,
+			"sponsor": strings.TrimSpace(req.Sponsor),
+			"result":  "sponsor_not_allowed",
+		})
+		writeJSON(w, 422, map[string]any{"error": "sponsor_not_allowed"})
+		return
+	}
+
+	now := time.Now().Unix()
+	if p, err := s.st.PendingSponsorRequest(ctx, mac, now); err != nil {
+		writeJSON(w, 500, map[string]any{"error": "store_error"})
+		return
+	} else if p != nil {
+		writeJSON(w, 202, s.sponsorView(ctx, p, now))
+		return
+	}
+
+	id, err := security.NewSponsorRequestID()
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": "keygen_failed"})
+		return
+	}
+	sr := store.SponsorRequest{
+		ID:       id,
+		MAC:      mac,
+		IP:       req.Client.IP,
+		APID:     req.Access.APID,
+		SSID:     req.Wireless.SSID,
+		Guest:    guest,
+		Note:     note,
+		Sponsor:  sponsor,
+		Role:     sc.Role,
+		Duration: sc.Duration,
+		Created:  now,
+		Expires:  now + int64(sc.RequestTTL),
+	}
+	if err := s.st.CreateSponsorRequest(ctx, sr); err != nil {
+		writeJSON(w, 500, map[string]any{"error": "store_error"})
+		return
+	}
+	sr.Status = store.SponsorPending
+
+	result := "ok"
+	if err := s.sponsor.Notify(ctx, s.sponsorMessage(&sr)); err != nil {
+		// nobody could ever approve it
+		log.Printf("sponsor: notify %s failed: %v", sponsor, err)
+		_ = s.st.DeleteSponsorRequest(ctx, sr)
+		result = "notify_failed"
+	}
+	s.audit.Write(map[string]any{
+		"event":    "sponsor.request",
+		"id":       sr.ID,
+		"mac":      mac,
+		"guest":    guest,
+		"sponsor":  sponsor,
+		"role":     sr.Role,
+		"ap_id":    sr.APID,
+		"ssid":     sr.SSID,
+		"expires":  sr.Expires,
+		"trace_id": tracing.TraceID(ctx),
+		"result":   result,
+	})
+	if result != "ok" {
+		writeJSON(w, 502, map[string]any{"error": result})
+		return
+	}
+	writeJSON(w, 202, s.sponsorView(ctx, &sr, now))
+}
+
+// sponsorAddress returns the bare address of a typed sponsor e-mail if
+// its domain is one of controller.sponsor.domains.
+func (s *Server) sponsorAddress(in string) (string, bool) {
+	a, err := mail.ParseAddress(strings.TrimSpace(in))
+	if err != nil {
+		return "", false
+	}
+	at := strings.LastIndexByte(a.Address, '@')
+	if at < 1 {
+		return "", false
+	}
+	addr := strings.ToLower(a.Address)
+	domain := addr[at+1:]
+	return addr, slices.ContainsFunc(s.cfg.Controller.Sponsor.Domains, func(d string) bool {
+		return strings.EqualFold(d, domain)
+	})
+}
+
+// sponsorLink is the signed URL of action on request sr.
+func (s *Server) sponsorLink(sr *store.SponsorRequest, action string) string {
+	q := url.Values{}
+	q.Set("exp", strconv.FormatInt(sr.Expires, 10))
+	q.Set("sig", security.SignSponsorLink(s.sponsorSecret, sr.ID, action, sr.Expires))
+	base := strings.TrimRight(s.cfg.Controller.Sponsor.BaseURL, "/")
+	return base + "/portal/sponsor/" + sr.ID + "/" + action + "?" + q.Encode()
+}
+
+func (s *Server) sponsorMessage(sr *store.SponsorRequest) notify.Message {
+	approve, deny := s.sponsorLink(sr, sponsorApprove), s.sponsorLink(sr, sponsorDeny)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s asks for Wi-Fi access and named you as their host.\n\n", sr.Guest)
+	if sr.Note != "" {
+		fmt.Fprintf(&b, "Note: %s\n", sr.Note)
+	}
+	fmt.Fprintf(&b, "Device: %s\n", sr.MAC)
+	if sr.SSID != "" {
+		fmt.Fprintf(&b, "Network: %s\n", sr.SSID)
+	}
+	fmt.Fprintf(&b, "Access: %s\n\n", humanDuration(sr.Duration))
+	fmt.Fprintf(&b, "Approve: %s\n\nDeny: %s\n\n", approve, deny)
+	fmt.Fprintf(&b, "The request expires at %s.\n", formatUnix(sr.Expires))
+
+	return notify.Message{
+		Kind:    "sponsor.request",
+		To:      sr.Sponsor,
+		Subject: "Wi-Fi access request from " + sr.Guest,
+		Text:    b.String(),
+		Data: map[string]any{
+			"id":          sr.ID,
+			"guest":       sr.Guest,
+			"note":        sr.Note,
+			"mac":         sr.MAC,
+			"ap_id":       sr.APID,
+			"ssid":        sr.SSID,
+			"duration":    sr.Duration,
+			"expires":     sr.Expires,
+			"approve_url": approve,
+			"deny_url":    deny,
+		},
+	}
+}
+
+// sponsorView is what the waiting portal sees. A pending request past
+// its expiry shows as expired before the expiry job records it.
+func (s *Server) sponsorView(ctx context.Context, sr *store.SponsorRequest, now int64) map[string]any {
+	status := sr.Status
+	if status == store.SponsorPending && now > sr.Expires {
+		status = store.SponsorExpired
+	}
+	out := map[string]any{
+		"id":         sr.ID,
+		"status":     status,
+		"expires_at": sr.Expires,
+		"authorized": false,
+	}
+	if sr.Decided != 0 {
+		out["decided_at"] = sr.Decided
+	}
+	if status == store.SponsorApproved {
+		sess, ttl, err := s.st.GetSessionFull(ctx, sr.MAC)
+		if err == nil && sess != nil && sess.Auth.Sponsor == sr.ID {
+			out["authorized"] = true
+			out["session"] = s.buildSessionResp(sess, ttl)
+		}
+	}
+	return out
+}
+
+// sponsorStatus is polled by the portal while the guest waits.
+func (s *Server) sponsorStatus(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	sr, err := s.st.GetSponsorRequest(ctx, chi.URLParam(r, "id"))
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": "store_error"})
+		return
+	}
+	if sr == nil {
+		writeJSON(w, 404, map[string]any{"error": "not_found"})
+		return
+	}
+	writeJSON(w, 200, s.sponsorView(ctx, sr, time.Now().Unix()))
+}
+
+// sponsorLinkRequest checks the signed link of r and loads its request.
+// It writes the error page and returns nil if the link is not usable.
+func (s *Server) sponsorLinkRequest(w http.ResponseWriter, r *http.Request) (*store.SponsorRequest, string) {
+	id, action := chi.URLParam(r, "id"), chi.URLParam(r, "action")
+	exp, _ := strconv.ParseInt(r.URL.Query().Get("exp"), 10, 64)
+	if (action != sponsorApprove && action != sponsorDeny) ||
+		!security.VerifySponsorLink(s.sponsorSecret, id, action, exp, r.URL.Query().Get("sig"), time.Now().Unix()) {
+		sponsorPage(w, 403, sponsorPageData{Title: "Link not valid", Text: "This link is invalid or has expired."})
+		return nil, ""
+	}
+	sr, err := s.st.GetSponsorRequest(r.Context(), id)
+	if err != nil {
+		sponsorPage(w, 500, sponsorPageData{Title: "Try again later", Text: "The request could not be loaded."})
+		return nil, ""
+	}
+	if sr == nil {
+		sponsorPage(w, 404, sponsorPageData{Title: "Request not found", Text: "This request no longer exists."})
+		return nil, ""
+	}
+	return sr, action
+}
+
+// sponsorConfirm shows the sponsor what they are about to decide. The
+// decision itself is a POST, so link scanners opening the mail cannot
+// approve or deny anything.
+func (s *Server) sponsorConfirm(w http.ResponseWriter, r *http.Request) {
+	sr, action := s.sponsorLinkRequest(w, r)
+	if sr == nil {
+		return
+	}
+	if sr.Status != store.SponsorPending {
+		sponsorPage(w, 409, decidedPage(sr))
+		return
+	}
+	d := sponsorPageData{
+		Title:   "Wi-Fi access for " + sr.Guest,
+		Request: sr,
+		Access:  humanDuration(sr.Duration),
+		Action:  r.URL.RequestURI(),
+	}
+	if action == sponsorApprove {
+		d.Button = "Approve access"
+	} else {
+		d.Button = "Deny access"
+	}
+	sponsorPage(w, 200, d)
+}
+
+// sponsorDecide records the sponsor's decision and, on approval,
+// authorizes the guest's device.
+func (s *Server) sponsorDecide(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	sr, action := s.sponsorLinkRequest(w, r)
+	if sr == nil {
+		return
+	}
+
+	want := store.SponsorDenied
+	if action == sponsorApprove {
+		want = store.SponsorApproved
+	}
+	now := time.Now().Unix()
+	status, changed, err := s.st.DecideSponsorRequest(ctx, sr.ID, want, now)
+	if err != nil {
+		sponsorPage(w, 500, sponsorPageData{Title: "Try again later", Text: "The decision could not be saved."})
+		return
+	}
+	sr.Status = status
+	if !changed {
+		sponsorPage(w, 409, decidedPage(sr))
+		return
+	}
+	sr.Decided = now
+
+	ev := map[string]any{
+		"event":    "sponsor." + status,
+		"id":       sr.ID,
+		"mac":      sr.MAC,
+		"guest":    sr.Guest,
+		"sponsor":  sr.Sponsor,
+		"trace_id": tracing.TraceID(ctx),
+		"result":   "ok",
+	}
+	if status == store.SponsorApproved {
+		ttl, err := s.grantSponsored(ctx, sr, now)
+		ev["role"], ev["ttl"] = sr.Role, ttl
+		switch {
+		case errors.Is(err, errDenylisted):
+			// a blocked device is never let in: the approval becomes a
+			// denial
+			ev["event"], ev["reason"] = "sponsor."+store.SponsorDenied, "denylisted"
+			if ok, err := s.st.ReopenSponsorRequest(ctx, sr.ID, now); err == nil && ok {
+				sr.Status, _, _ = s.st.DecideSponsorRequest(ctx, sr.ID, store.SponsorDenied, now)
+			}
+			s.audit.Write(ev)
+			sponsorPage(w, 403, sponsorPageData{Title: "Access denied", Text: "This device is blocked from the network."})
+			return
+		case err != nil:
+			ev["result"] = "store_error"
+			// pending again, so the sponsor can retry the link
+			if _, err := s.st.ReopenSponsorRequest(ctx, sr.ID, now); err != nil {
+				log.Printf("sponsor: reopen %s failed: %v", sr.ID, err)
+			}
+		}
+	}
+	s.audit.Write(ev)
+	if ev["result"] != "ok" {
+		sponsorPage(w, 500, sponsorPageData{Title: "Try again later", Text: "The device could not be authorized."})
+		return
+	}
+	sponsorPage(w, 200, decidedPage(sr))
+}
+
+// errDenylisted refuses to authorize a device on the denylist.
+var errDenylisted = errors.New("device denylisted")
+
+// grantSponsored authorizes the device of an approved request for the
+// sponsor duration; the session needs no heartbeat to last that long.
+// A denylisted device gets errDenylisted.
+func (s *Server) grantSponsored(ctx context.Context, sr *store.SponsorRequest, now int64) (int, error) {
+	if s.denied(ctx, sr.MAC, "sponsor", sr.SSID) != nil {
+		return 0, errDenylisted
+	}
+	profileName, profile := s.profileFor(sr.Role)
+	sess := store.SessionV2{
+		Schema:        store.SessionSchema,
+		MAC:           sr.MAC,
+		Role:          sr.Role,
+		Profile:       profileName,
+		PolicyVersion: s.policyVersion,
+	}
+	sess.AP.APID = sr.APID
+	sess.AP.SSID = sr.SSID
+	sess.ApplyProfile(profile)
+	sess.Auth.Method = authSponsor
+	sess.Auth.Sponsor = sr.ID
+	sess.TS.Created = now
+	sess.TS.Expires = now + int64(sr.Duration)
+	if profile.MaxSessionLifetime > 0 && profile.MaxSessionLifetime < sr.Duration {
+		sess.TS.Expires = now + int64(profile.MaxSessionLifetime)
+	}
+	profile.SessionTTL = sr.Duration
+	ttl, _ := sessionTTL(&sess, profile, now)
+
+	if err := s.st.SetSession(ctx, sess, ttl); err != nil {
+		return 0, err
+	}
+	s.publishSession(ctx, events.SessionCreated, &sess, ttl, "")
+	return ttl, nil
+}
+
+// ExpireSponsorRequests records requests nobody decided on in time. It
+// returns how many expired.
+func (s *Server) ExpireSponsorRequests(ctx context.Context, now time.Time) (int, error) {
+	ids, err := s.st.DueSponsorRequests(ctx, now.Unix())
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, id := range ids {
+		status, changed, err := s.st.DecideSponsorRequest(ctx, id, store.SponsorExpired, now.Unix())
+		if err != nil {
+			return n, err
+		}
+		if !changed || status != store.SponsorExpired {
+			continue
+		}
+		n++
+		ev := map[string]any{"event": "sponsor.expired", "id": id, "result": "ok"}
+		if sr, err := s.st.GetSponsorRequest(ctx, id); err == nil && sr != nil {
+			ev["mac"], ev["guest"], ev["sponsor"] = sr.MAC, sr.Guest, sr.Sponsor
+		}
+		s.auditJob(ctx, ev)
+	}
+	return n, nil
+}
+
+// -------------------------------------------------------------------
+// Sponsor pages
+// -------------------------------------------------------------------
+
+type sponsorPageData struct {
+	Title   string
+	Text    string
+	Request *store.SponsorRequest
+	Access  string
+	// Action and Button make the page a confirmation form
+	Action string
+	Button string
+}
+
+func decidedPage(sr *store.SponsorRequest) sponsorPageData {
+	switch sr.Status {
+	case store.SponsorApproved:
+		return sponsorPageData{Title: "Access approved", Text: sr.Guest + " is now connected."}
+	case store.SponsorDenied:
+		return sponsorPageData{Title: "Access denied", Text: "The request of " + sr.Guest + " was denied."}
+	case store.SponsorExpired:
+		return sponsorPageData{Title: "Request expired", Text: "The request of " + sr.Guest + " has expired."}
+	}
+	return sponsorPageData{Title: "Request closed", Text: "This request can no longer be decided."}
+}
+
+func sponsorPage(w http.ResponseWriter, code int, d sponsorPageData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.WriteHeader(code)
+	if err := sponsorPageTmpl.Execute(w, d); err != nil {
+		log.Printf("sponsor page failed: %v", err)
+	}
+}
+
+var sponsorPageTmpl = template.Must(template.New("sponsor").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width">
+<title>{{.Title}}</title>
+<style>
+body{font-family:sans-serif;max-width:32em;margin:2em auto;padding:0 1em}
+dt{color:#666;font-size:9pt}dd{margin:0 0 2mm}
+button{font-size:12pt;padding:2mm 6mm}
+</style></head><body>
+<h1>{{.Title}}</h1>
+{{with .Text}}<p>{{.}}</p>{{end}}
+{{with .Request}}<dl>
+<dt>Guest</dt><dd>{{.Guest}}</dd>
+{{with .Note}}<dt>Note</dt><dd>{{.}}</dd>{{end}}
+<dt>Device</dt><dd>{{.MAC}}</dd>
+{{with .SSID}}<dt>Network</dt><dd>{{.}}</dd>{{end}}
+{{with $.Access}}<dt>Access</dt><dd>{{.}}</dd>{{end}}
+</dl>{{end}}
+{{if .Action}}<form method="post" action="{{.Action}}"><button type="submit">{{.Button}}</button></form>{{end}}
+</body></html>
+`))
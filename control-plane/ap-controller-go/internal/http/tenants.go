@@ -0,0 +1,152 @@
+package httpapi
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"ap-controller-go/internal/config"
+	"ap-controller-go/internal/security"
+)
+
+// -------------------------------------------------------------------
+// Tenants
+// -------------------------------------------------------------------
+
+// SetTenant makes s serve tenant id (see config.ForTenant) with its own
+// HMAC keys. Call it before Router.
+func (s *Server) SetTenant(id string, keys *security.KeySet) {
+	s.tenant = id
+	s.keys = keys
+}
+
+// tenantID names the tenant of s in responses and token claims.
+func (s *Server) tenantID() string {
+	return tenantName(s.tenant)
+}
+
+// tenantKeys verifies signed requests against the tenant's HMAC keys.
+func (s *Server) tenantKeys(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(security.WithKeySet(r.Context(), s.keys)))
+	})
+}
+
+// TenantRouter serves several tenants on one listener. Each tenant is a
+// Server with its own config, keyspace and keys; the router only picks
+// which one handles a request, the tenant's Server still authenticates
+// it. The tenant is taken from, in order:
+//
+//   - the tenant of a verified AP client certificate
+//   - the owner of the X-Portal-Kid of a signed request
+//   - an X-Tenant header (admin API, enrollment, login)
+//   - the Host header (portal login)
+//
+// and is the default tenant otherwise. An X-Tenant header that disagrees
+// with the certificate or kid is rejected.
+type TenantRouter struct {
+	tenants map[string]http.Handler
+	byKID   map[string]string
+	byHost  map[string]string
+}
+
+// NewTenantRouter routes to def, the default tenant, until tenants are
+// added. keys are the default tenant's HMAC keys; their kids are
+// reserved. Like Add it builds the Server's router, so enable optional
+// features first.
+func NewTenantRouter(def *Server, keys *security.KeySet) *TenantRouter {
+	t := &TenantRouter{
+		tenants: map[string]http.Handler{"": def.Router()},
+		byKID:   map[string]string{},
+		byHost:  map[string]string{},
+	}
+	if keys != nil {
+		for kid := range keys.Keys {
+			t.byKID[kid] = ""
+		}
+	}
+	return t
+}
+
+// Add routes requests of s's tenant (see SetTenant), also for hosts.
+func (t *TenantRouter) Add(s *Server, hosts []string) error {
+	if s.tenant == "" || s.keys == nil {
+		return fmt.Errorf("tenant server without SetTenant")
+	}
+	if _, dup := t.tenants[s.tenant]; dup {
+		return fmt.Errorf("tenant %s added twice", s.tenant)
+	}
+	for kid := range s.keys.Keys {
+		if prev, dup := t.byKID[kid]; dup {
+			return fmt.Errorf("tenant %s: hmac kid %q is already used by tenant %q", s.tenant, kid, tenantName(prev))
+		}
+		t.byKID[kid] = s.tenant
+	}
+	for _, h := range hosts {
+		t.byHost[strings.ToLower(h)] = s.tenant
+	}
+	t.tenants[s.tenant] = s.Router()
+	return nil
+}
+
+func tenantName(id string) string {
+	if id == "" {
+		return config.DefaultTenant
+	}
+	return id
+}
+
+func (t *TenantRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id, errCode := t.resolve(r)
+	if errCode != "" {
+		code := 403
+		if errCode == "unknown_tenant" {
+			code = 404
+		}
+		writeJSON(w, code, map[string]any{"error": errCode})
+		return
+	}
+	t.tenants[id].ServeHTTP(w, r)
+}
+
+// resolve returns the tenant of r ("" = default) or an error code.
+func (t *TenantRouter) resolve(r *http.Request) (string, string) {
+	hint := r.Header.Get("X-Tenant")
+	if hint == config.DefaultTenant {
+		hint = ""
+	}
+	if _, ok := t.tenants[hint]; !ok {
+		return "", "unknown_tenant"
+	}
+
+	// credentials bind the tenant; the header may only confirm it
+	bound, ok := "", false
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		var ap security.APIdentity
+		if ap, ok = security.APIdentityFromCert(r.TLS.VerifiedChains[0][0]); ok {
+			bound = ap.Tenant
+		}
+	}
+	if kid := r.Header.Get("X-Portal-Kid"); !ok && kid != "" {
+		bound, ok = t.byKID[kid]
+	}
+	if ok {
+		if _, known := t.tenants[bound]; !known {
+			return "", "unknown_tenant"
+		}
+		if r.Header.Get("X-Tenant") != "" && hint != bound {
+			return "", "tenant_mismatch"
+		}
+		return bound, ""
+	}
+
+	if r.Header.Get("X-Tenant") != "" {
+		return hint, ""
+	}
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return t.byHost[strings.ToLower(host)], ""
+}
@@ -0,0 +1,151 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"ap-controller-go/internal/events"
+	"ap-controller-go/internal/policy"
+	"ap-controller-go/internal/transport"
+)
+
+// -------------------------------------------------------------------
+// Broker transport (MQTT)
+// -------------------------------------------------------------------
+
+const (
+	transportSendTimeout = 2 * time.Second
+	// transportQueueSize bounds the messages waiting for the broker
+	transportQueueSize = 1024
+)
+
+var (
+	errAPIDMismatch  = errors.New("ap_id does not match topic")
+	errNotRegistered = errors.New("ap not registered")
+)
+
+// EnableTransport forwards AP-bound events over t and publishes the
+// policy / bypass checksums as retained messages. AP status and acks
+// received on t are passed to HandleAPMessage by the transport.
+//
+// Events are sent through a queue, so requests don't wait for the
+// broker; the returned function drains it and must be called before t
+// is closed.
+func (s *Server) EnableTransport(t transport.Transport) (drain func()) {
+	s.transport = t
+	s.outbox = transport.NewQueue(t, transportQueueSize, transportSendTimeout)
+	return s.outbox.Close
+}
+
+// apCommand is a session command; the event fields are inlined.
+type apCommand struct {
+	Cmd string `json:"cmd,omitempty"`
+	events.Event
+}
+
+// forward sends ev to its APs over the transport. policy.changed and
+// bypass.changed are covered by the retained notices (sendPolicy).
+func (s *Server) forward(ev events.Event) {
+	if s.transport == nil {
+		return
+	}
+	if ev.TS == 0 {
+		ev.TS = time.Now().Unix()
+	}
+	msg := transport.Message{Target: transport.Target{APID: ev.APID, Site: ev.Site}}
+	switch ev.Type {
+	case events.SessionCreated, events.SessionRefreshed:
+		msg.Kind, msg.Body = transport.KindSession, apCommand{Cmd: "authorize", Event: ev}
+	case events.SessionDeleted:
+		msg.Kind, msg.Body = transport.KindSession, apCommand{Cmd: "revoke", Event: ev}
+	case events.CertRevoked:
+		msg.Kind, msg.Body = transport.KindCert, apCommand{Event: ev}
+	default:
+		return
+	}
+
+	if err := s.outbox.Enqueue(msg); err != nil {
+		log.Printf("transport: send %s failed: %v", ev.Type, err)
+	}
+}
+
+// sendPolicy publishes the policy and bypass checksums as retained
+// messages when they differ from what this replica sent last. They are
+// only refetch notices: the policy itself is signed and scoped to the AP
+// and comes from GET /api/v1/policy/runtime, while anything on the fleet
+// topics can be forged by any AP. The policy notice names the candidate
+// too, so canary APs running it are not told to go back to the active
+// policy. Retained publishes are idempotent, so replicas don't need to
+// coordinate.
+func (s *Server) sendPolicy(ctx context.Context, rp policy.RuntimePolicy, candidate, rollout, cstate string) error {
+	notice := map[string]any{"version": rp.Version.Version, "checksum": rp.Version.Checksum}
+	if cstate != "" {
+		notice["candidate_checksum"] = candidate
+		notice["rollout"] = rollout
+	}
+	bsum := bypassChecksum(rp.Bypass)
+	for _, m := range []struct {
+		sent *atomic.Value
+		sum  string
+		kind string
+		body any
+	}{
+		{&s.sentPolicy, rp.Version.Checksum + " " + cstate, transport.KindPolicy, notice},
+		{&s.sentBypass, bsum, transport.KindBypass, map[string]any{"checksum": bsum}},
+	} {
+		if old, _ := m.sent.Load().(string); old == m.sum {
+			continue
+		}
+		sctx, cancel := context.WithTimeout(ctx, transportSendTimeout)
+		err := s.transport.Send(sctx, transport.Message{Kind: m.kind, Retain: true, Body: m.body})
+		cancel()
+		if err != nil {
+			return fmt.Errorf("send %s: %w", m.kind, err)
+		}
+		m.sent.Store(m.sum)
+	}
+	return nil
+}
+
+// HandleAPMessage processes a verified AP message from the transport:
+// status reports update the inventory (registering unknown APs, as there
+// is no 404 to answer with) and acks feed policy convergence.
+func (s *Server) HandleAPMessage(ctx context.Context, in transport.Inbound) error {
+	switch in.Kind {
+	case transport.KindStatus:
+		var req APHeartbeatReq
+		if err := json.Unmarshal(in.Body, &req); err != nil {
+			return err
+		}
+		if req.APID != "" && req.APID != in.APID {
+			return errAPIDMismatch
+		}
+		_, _, err := s.reportAP(ctx, in.APID, req, "", true)
+		return err
+
+	case transport.KindAck:
+		var req PolicyAckReq
+		if err := json.Unmarshal(in.Body, &req); err != nil {
+			return err
+		}
+		if req.APID != "" && req.APID != in.APID {
+			return errAPIDMismatch
+		}
+		if errName := checkPolicyAck(req); errName != "" {
+			return errors.New(errName)
+		}
+		rec, _, _, err := s.ackPolicy(ctx, in.APID, req)
+		if err == nil && rec == nil {
+			err = errNotRegistered
+		}
+		return err
+
+	default:
+		return fmt.Errorf("unknown message kind %q", in.Kind)
+	}
+}
@@ -3,11 +3,16 @@ package httpapi
 import (
 	"ap-controller-go/internal/audit"
 	"ap-controller-go/internal/config"
+	"ap-controller-go/internal/roles"
 	"ap-controller-go/internal/security"
 	"ap-controller-go/internal/store"
+	"ap-controller-go/internal/tracing"
+	"context"
 	"encoding/json"
 	"net/http"
 	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 func New(
@@ -36,6 +41,21 @@ func macNorm(m string) string {
 	return strings.ToLower(strings.TrimSpace(m))
 }
 
+// decideRole runs roles.DecideRole with the role rules of cfg inside a
+// span.
+func (s *Server) decideRole(ctx context.Context, cfg *config.Config, attrs map[string]string) roles.Decision {
+	_, span := tracing.Start(ctx, "roles.DecideRole")
+	defer span.End()
+
+	d := roles.DecideRole(cfg, attrs, config.DefaultRole)
+	span.SetAttributes(
+		attribute.String("role", d.Role),
+		attribute.String("rule", d.MatchedRule),
+		attribute.String("ssid", attrs["ssid"]),
+	)
+	return d
+}
+
 // -------------------------------------------------------------------
 // Response Helpers
 // -------------------------------------------------------------------
@@ -55,10 +75,51 @@ func (s *Server) buildSessionResp(sess *store.SessionV2, ttl int) map[string]any
 		"role":           role,
 		"ttl":            ttl,
 		"policy_version": sess.PolicyVersion,
+		"identity":       sess.Auth.Identity,
+		"expires_at":     sess.TS.Expires,
 		"profile": map[string]any{
-			"name":           profileName,
-			"vlan":           profile.VLAN,
-			"firewall_group": profile.FirewallGroup,
+			"name":                 profileName,
+			"vlan":                 profile.VLAN,
+			"firewall_group":       profile.FirewallGroup,
+			"upstream_kbps":        profile.UpstreamKbps,
+			"downstream_kbps":      profile.DownstreamKbps,
+			"idle_timeout":         profile.IdleTimeout,
+			"max_session_lifetime": profile.MaxSessionLifetime,
+			"max_devices":          profile.MaxDevices,
+		},
+		"usage": map[string]any{
+			"bytes_in":            sess.Usage.Total.BytesIn,
+			"bytes_out":           sess.Usage.Total.BytesOut,
+			"packets_in":          sess.Usage.Total.PacketsIn,
+			"packets_out":         sess.Usage.Total.PacketsOut,
+			"session_quota_bytes": config.QuotaBytes(profile.SessionQuotaMB),
+			"daily_quota_bytes":   config.QuotaBytes(profile.DailyQuotaMB),
+			"quota_exceeded":      sess.Usage.QuotaExceeded,
+			"last_active":         sess.Usage.Active,
 		},
 	}
 }
+
+// ssidLabel bounds the ssid metric label, which comes from the request:
+// only SSIDs named literally in cfg's role_rules are kept, anything else
+// is "other".
+func ssidLabel(cfg *config.Config, ssid string) string {
+	if ssid == "" {
+		return ""
+	}
+	for _, r := range cfg.RoleRules {
+		switch p := r.When["ssid"].(type) {
+		case string:
+			if p == ssid {
+				return ssid
+			}
+		case []any:
+			for _, it := range p {
+				if it == ssid {
+					return ssid
+				}
+			}
+		}
+	}
+	return "other"
+}
@@ -0,0 +1,484 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"ap-controller-go/internal/config"
+	"ap-controller-go/internal/metrics"
+	"ap-controller-go/internal/security"
+	"ap-controller-go/internal/store"
+	"ap-controller-go/internal/tracing"
+)
+
+// -------------------------------------------------------------------
+// Guest vouchers
+// -------------------------------------------------------------------
+
+const authVoucher = "voucher"
+
+// voucher statuses in admin views
+const (
+	voucherUnused  = "unused"
+	voucherActive  = "active"
+	voucherExpired = "expired"
+	voucherRevoked = "revoked"
+	voucherQuota   = "quota_exceeded"
+)
+
+// lookupVoucher resolves a typed code. It does not take a use; that is
+// redeemVoucher, once the other login checks passed.
+func (s *Server) lookupVoucher(ctx context.Context, code string) (*store.Voucher, string) {
+	norm, ok := security.NormalizeVoucherCode(code)
+	if !ok {
+		return nil, "voucher_invalid"
+	}
+	v, err := s.st.VoucherByCode(ctx, norm)
+	if err != nil {
+		return nil, "store_error"
+	}
+	if v == nil {
+		return nil, "voucher_invalid"
+	}
+	return v, ""
+}
+
+// redeemVoucher takes a use of v for mac and returns when v was first
+// redeemed.
+func (s *Server) redeemVoucher(ctx context.Context, v *store.Voucher, mac string) (int64, string) {
+	res, first, err := s.st.RedeemVoucher(ctx, v.ID, mac, time.Now().Unix())
+	switch {
+	case err != nil:
+		return 0, "store_error"
+	case res == store.VoucherNotFound:
+		return 0, "voucher_invalid"
+	case res != store.VoucherOK:
+		return 0, "voucher_" + res
+	}
+	return first, ""
+}
+
+// rejectVoucher answers a login refused for its voucher. Only unknown
+// codes count as login failures; a used-up voucher is no guess.
+func (s *Server) rejectVoucher(w http.ResponseWriter, r *http.Request, mac, voucherID, reason, ssid string, limited []store.RateSubject) {
+	ctx := r.Context()
+	s.audit.Write(map[string]any{
+		"event":    "portal.login",
+		"mac":      mac,
+		"auth":     authVoucher,
+		"voucher":  voucherID,
+		"ssid":     ssid,
+		"trace_id": tracing.TraceID(ctx),
+		"result":   reason,
+	})
+	metrics.LoginOutcome(reason, "", authVoucher, ssidLabel(s.cfg, ssid))
+	if reason == "store_error" {
+		writeJSON(w, 500, map[string]any{"authorized": false, "error": reason})
+		return
+	}
+	if reason == "voucher_invalid" {
+		s.loginFailed(ctx, reason, limited...)
+	}
+	writeJSON(w, 403, map[string]any{"authorized": false, "error": reason})
+}
+
+// voucherStatus classifies v at now.
+func voucherStatus(v *store.Voucher, now int64) string {
+	switch {
+	case v.Revoked != 0:
+		return voucherRevoked
+	case v.QuotaMB > 0 && v.Bytes() >= config.QuotaBytes(v.QuotaMB):
+		return voucherQuota
+	case v.FirstUsed == 0 && v.ValidUntil > 0 && now > v.ValidUntil:
+		return voucherExpired
+	case v.FirstUsed == 0:
+		return voucherUnused
+	case v.Duration > 0 && now >= v.FirstUsed+int64(v.Duration):
+		return voucherExpired
+	}
+	return voucherActive
+}
+
+// voucherUsage adds the traffic of a voucher session to its voucher and
+// returns "voucher_quota" once the voucher's quota is used up.
+func (s *Server) voucherUsage(ctx context.Context, sess *store.SessionV2, delta store.Counters) string {
+	total, quotaMB, err := s.st.AddVoucherUsage(ctx, sess.Auth.Voucher, delta)
+	if err != nil {
+		log.Printf("voucher %s: add usage failed: %v", sess.Auth.Voucher, err)
+		return ""
+	}
+	if quotaMB > 0 && total >= config.QuotaBytes(quotaMB) && sess.Usage.QuotaExceeded == "" {
+		return "voucher_quota"
+	}
+	return ""
+}
+
+// -------------------------------------------------------------------
+// Admin
+// -------------------------------------------------------------------
+
+type voucherView struct {
+	ID        string   `json:"id"`
+	Status    string   `json:"status"`
+	Devices   []string `json:"devices"`
+	FirstUsed int64    `json:"first_used,omitempty"`
+	Expires   int64    `json:"expires,omitempty"`
+	Bytes     int64    `json:"bytes"`
+	Revoked   int64    `json:"revoked,omitempty"`
+	RevokedBy string   `json:"revoked_by,omitempty"`
+}
+
+// voucherReport sums up the vouchers of a batch.
+type voucherReport struct {
+	Total    int            `json:"total"`
+	Status   map[string]int `json:"status"`
+	Devices  int            `json:"devices"`
+	Bytes    int64          `json:"bytes"`
+	LastUsed int64          `json:"last_used,omitempty"`
+}
+
+func newVoucherView(v *store.Voucher, now int64) voucherView {
+	vv := voucherView{
+		ID:        v.ID,
+		Status:    voucherStatus(v, now),
+		Devices:   v.MACs,
+		FirstUsed: v.FirstUsed,
+		Bytes:     v.Bytes(),
+		Revoked:   v.Revoked,
+		RevokedBy: v.RevokedBy,
+	}
+	if vv.Devices == nil {
+		vv.Devices = []string{}
+	}
+	if v.FirstUsed != 0 && v.Duration > 0 {
+		vv.Expires = v.FirstUsed + int64(v.Duration)
+	}
+	return vv
+}
+
+func reportVouchers(vs []store.Voucher, now int64) voucherReport {
+	rep := voucherReport{Total: len(vs), Status: map[string]int{}}
+	for i := range vs {
+		v := &vs[i]
+		rep.Status[voucherStatus(v, now)]++
+		rep.Devices += len(v.MACs)
+		rep.Bytes += v.Bytes()
+		if v.FirstUsed > rep.LastUsed {
+			rep.LastUsed = v.FirstUsed
+		}
+	}
+	return rep
+}
+
+// adminVoucherCreate creates a batch. The codes are in the response and
+// in the export (operator role), never in list views.
+func (s *Server) adminVoucherCreate(w http.ResponseWriter, r *http.Request) {
+	var req VoucherBatchReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, 400, map[string]any{"error": "bad_json"})
+		return
+	}
+	now := time.Now().Unix()
+	if req.ValidFor > 0 && req.ValidUntil == 0 {
+		from := req.ValidFrom
+		if from == 0 {
+			from = now
+		}
+		req.ValidUntil = from + int64(req.ValidFor)
+	}
+	switch {
+	case req.Count <= 0 || req.Count > s.cfg.Controller.Vouchers.MaxBatch:
+		writeJSON(w, 422, map[string]any{"error": "bad_count", "max_batch": s.cfg.Controller.Vouchers.MaxBatch})
+		return
+	case s.cfg.Roles[req.Role].Profile == "":
+		writeJSON(w, 422, map[string]any{"error": "unknown_role"})
+		return
+	case req.ValidFrom < 0 || req.ValidUntil < 0 || req.ValidFor < 0 || req.Duration < 0 || req.Devices < 0 || req.QuotaMB < 0:
+		writeJSON(w, 422, map[string]any{"error": "bad_terms"})
+		return
+	case req.ValidUntil != 0 && (req.ValidUntil <= req.ValidFrom || req.ValidUntil <= now):
+		writeJSON(w, 422, map[string]any{"error": "bad_validity"})
+		return
+	}
+
+	batchID, err := security.NewVoucherID("vb_")
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": "keygen_failed"})
+		return
+	}
+	b := store.VoucherBatch{
+		ID:         batchID,
+		Name:       strings.TrimSpace(req.Name),
+		Role:       req.Role,
+		ValidFrom:  req.ValidFrom,
+		ValidUntil: req.ValidUntil,
+		Duration:   req.Duration,
+		Devices:    req.Devices,
+		QuotaMB:    req.QuotaMB,
+		Count:      req.Count,
+		CreatedBy:  adminSubject(r),
+		Created:    now,
+	}
+
+	var ids, codes []string
+	for attempt := 0; ; attempt++ {
+		ids, codes, err = newVoucherCodes(req.Count)
+		if err == nil {
+			err = s.st.CreateVoucherBatch(r.Context(), b, ids, codes)
+		}
+		if !errors.Is(err, store.ErrVoucherCodeTaken) || attempt == 2 {
+			break
+		}
+	}
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": "store_error"})
+		return
+	}
+
+	s.auditAdmin(r, map[string]any{
+		"event":       "admin.voucher_create",
+		"batch":       b.ID,
+		"name":        b.Name,
+		"role":        b.Role,
+		"count":       b.Count,
+		"valid_from":  b.ValidFrom,
+		"valid_until": b.ValidUntil,
+		"duration":    b.Duration,
+		"devices":     b.Devices,
+		"quota_mb":    b.QuotaMB,
+		"result":      "ok",
+	})
+
+	out := make([]map[string]any, len(ids))
+	for i := range ids {
+		out[i] = map[string]any{"id": ids[i], "code": security.FormatVoucherCode(codes[i])}
+	}
+	writeJSON(w, 200, map[string]any{"batch": b, "vouchers": out})
+}
+
+func newVoucherCodes(n int) (ids, codes []string, err error) {
+	ids, codes = make([]string, n), make([]string, n)
+	for i := 0; i < n; i++ {
+		if ids[i], err = security.NewVoucherID("v_"); err != nil {
+			return nil, nil, err
+		}
+		if codes[i], err = security.NewVoucherCode(); err != nil {
+			return nil, nil, err
+		}
+	}
+	return ids, codes, nil
+}
+
+// adminVoucherList lists batches with a usage report each.
+func (s *Server) adminVoucherList(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	batches, err := s.st.ListVoucherBatches(ctx)
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": "store_error"})
+		return
+	}
+	now := time.Now().Unix()
+	out := make([]map[string]any, 0, len(batches))
+	for _, b := range batches {
+		vs, err := s.st.BatchVouchers(ctx, b.ID)
+		if err != nil {
+			writeJSON(w, 500, map[string]any{"error": "store_error"})
+			return
+		}
+		out = append(out, map[string]any{"batch": b, "report": reportVouchers(vs, now)})
+	}
+	writeJSON(w, 200, map[string]any{"batches": out})
+}
+
+// batchVouchers loads the batch of the {batch} URL parameter; it has
+// answered the request when ok is false.
+func (s *Server) batchVouchers(w http.ResponseWriter, r *http.Request) (*store.VoucherBatch, []store.Voucher, bool) {
+	ctx := r.Context()
+	b, err := s.st.GetVoucherBatch(ctx, chi.URLParam(r, "batch"))
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": "store_error"})
+		return nil, nil, false
+	}
+	if b == nil {
+		writeJSON(w, 404, map[string]any{"error": "not_found"})
+		return nil, nil, false
+	}
+	vs, err := s.st.BatchVouchers(ctx, b.ID)
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": "store_error"})
+		return nil, nil, false
+	}
+	return b, vs, true
+}
+
+// adminVoucherBatch shows a batch, its report and every voucher.
+func (s *Server) adminVoucherBatch(w http.ResponseWriter, r *http.Request) {
+	b, vs, ok := s.batchVouchers(w, r)
+	if !ok {
+		return
+	}
+	now := time.Now().Unix()
+	views := make([]voucherView, len(vs))
+	for i := range vs {
+		views[i] = newVoucherView(&vs[i], now)
+	}
+	writeJSON(w, 200, map[string]any{"batch": b, "report": reportVouchers(vs, now), "vouchers": views})
+}
+
+// adminVoucherExport exports the codes of a batch as CSV (default) or as
+// printable HTML (?format=html).
+func (s *Server) adminVoucherExport(w http.ResponseWriter, r *http.Request) {
+	b, vs, ok := s.batchVouchers(w, r)
+	if !ok {
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "csv" && format != "html" {
+		writeJSON(w, 422, map[string]any{"error": "bad_format"})
+		return
+	}
+	s.auditAdmin(r, map[string]any{
+		"event":  "admin.voucher_export",
+		"batch":  b.ID,
+		"format": format,
+		"count":  len(vs),
+		"result": "ok",
+	})
+
+	now := time.Now().Unix()
+	if format == "html" {
+		cards := make([]voucherCard, len(vs))
+		for i := range vs {
+			cards[i] = voucherCard{Code: security.FormatVoucherCode(vs[i].Code), Status: voucherStatus(&vs[i], now)}
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := voucherPrintTmpl.Execute(w, map[string]any{
+			"Batch":      b,
+			"Cards":      cards,
+			"Duration":   humanDuration(b.Duration),
+			"ValidUntil": formatUnix(b.ValidUntil),
+		}); err != nil {
+			log.Printf("voucher export %s failed: %v", b.ID, err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="vouchers-`+b.ID+`.csv"`)
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"id", "code", "role", "status", "valid_from", "valid_until", "duration", "devices", "quota_mb", "first_used", "bytes"})
+	for i := range vs {
+		v := &vs[i]
+		_ = cw.Write([]string{
+			v.ID, security.FormatVoucherCode(v.Code), v.Role, voucherStatus(v, now),
+			formatUnix(v.ValidFrom), formatUnix(v.ValidUntil), strconv.Itoa(v.Duration),
+			strconv.Itoa(v.Devices), strconv.Itoa(v.QuotaMB), formatUnix(v.FirstUsed),
+			strconv.FormatInt(v.Bytes(), 10),
+		})
+	}
+	cw.Flush()
+}
+
+// adminVoucherRevoke revokes one voucher ({id}) or a whole batch and
+// ends the sessions created from them.
+func (s *Server) adminVoucherRevoke(w http.ResponseWriter, r *http.Request) {
+	b, vs, ok := s.batchVouchers(w, r)
+	if !ok {
+		return
+	}
+	var ids []string
+	if id := chi.URLParam(r, "id"); id != "" {
+		for _, v := range vs {
+			if v.ID == id {
+				ids = append(ids, id)
+			}
+		}
+		if len(ids) == 0 {
+			writeJSON(w, 404, map[string]any{"error": "not_found"})
+			return
+		}
+	} else {
+		for _, v := range vs {
+			ids = append(ids, v.ID)
+		}
+	}
+
+	ctx := r.Context()
+	revoked, err := s.st.RevokeVouchers(ctx, ids, adminSubject(r), time.Now().Unix())
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": "store_error"})
+		return
+	}
+	ended := 0
+	for _, v := range revoked {
+		for _, mac := range v.MACs {
+			sess, _, err := s.st.GetSessionFull(ctx, mac)
+			if err == nil && sess != nil && sess.Auth.Voucher == v.ID {
+				s.terminateSession(ctx, sess, "voucher_revoked")
+				ended++
+			}
+		}
+	}
+
+	s.auditAdmin(r, map[string]any{
+		"event":    "admin.voucher_revoke",
+		"batch":    b.ID,
+		"vouchers": len(revoked),
+		"sessions": ended,
+		"result":   "ok",
+	})
+	writeJSON(w, 200, map[string]any{"batch": b.ID, "revoked": len(revoked), "sessions_ended": ended})
+}
+
+// -------------------------------------------------------------------
+// Printable export
+// -------------------------------------------------------------------
+
+type voucherCard struct {
+	Code   string
+	Status string
+}
+
+func formatUnix(ts int64) string {
+	if ts == 0 {
+		return ""
+	}
+	return time.Unix(ts, 0).UTC().Format("2006-01-02 15:04 UTC")
+}
+
+func humanDuration(sec int) string {
+	if sec <= 0 {
+		return ""
+	}
+	return (time.Duration(sec) * time.Second).String()
+}
+
+var voucherPrintTmpl = template.Must(template.New("vouchers").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Wi-Fi vouchers {{.Batch.Name}}</title>
+<style>
+body{font-family:sans-serif;margin:1cm}
+.grid{display:grid;grid-template-columns:repeat(3,1fr);gap:4mm}
+.card{border:1px dashed #888;padding:4mm;break-inside:avoid}
+.code{font:bold 16pt monospace;letter-spacing:1px;margin:2mm 0}
+.terms{font-size:9pt;color:#444}
+.void{opacity:.35;text-decoration:line-through}
+</style></head><body>
+<div class="grid">
+{{range .Cards}}<div class="card{{if or (eq .Status "revoked") (eq .Status "expired")}} void{{end}}">
+<div>Wi-Fi access{{if $.Batch.Name}} &middot; {{$.Batch.Name}}{{end}}</div>
+<div class="code">{{.Code}}</div>
+<div class="terms">{{if $.Duration}}Valid {{$.Duration}} from first use. {{end}}{{if $.Batch.Devices}}Up to {{$.Batch.Devices}} device(s). {{end}}{{if $.Batch.QuotaMB}}{{$.Batch.QuotaMB}} MiB. {{end}}{{if $.ValidUntil}}Redeem by {{$.ValidUntil}}.{{end}}</div>
+</div>
+{{end}}</div>
+</body></html>
+`))
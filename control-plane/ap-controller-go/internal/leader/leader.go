@@ -0,0 +1,228 @@
+// Package leader elects one controller replica to run singleton
+// background jobs, using a lease in redis with fencing tokens.
+package leader
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"ap-controller-go/internal/store"
+)
+
+// ErrNotLeader is returned by Fence when this replica does not hold the
+// lease (any more).
+var ErrNotLeader = errors.New("not the leader")
+
+// Store is the part of store.Store the elector needs.
+type Store interface {
+	AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (store.Lease, error)
+	ReleaseLease(ctx context.Context, name, holder string, token int64) error
+	CheckLease(ctx context.Context, name, holder string, token int64) (bool, error)
+	WithFence(ctx context.Context, name, holder string, token int64) context.Context
+}
+
+// Status is the view of one replica on the election, as in /healthz.
+type Status struct {
+	Enabled bool   `json:"enabled"`
+	Leader  bool   `json:"is_leader"`
+	ID      string `json:"id"`
+	// Holder / Token of the lease as last seen ("" while unknown)
+	Holder string `json:"holder,omitempty"`
+	Token  int64  `json:"token,omitempty"`
+	// Since is when this replica became leader (unix seconds)
+	Since int64 `json:"since,omitempty"`
+}
+
+// Change reports a leadership change of this replica; Reason is
+// "elected", "taken_over" (another holder has the lease), "expired" (the
+// lease could not be renewed in time) or "released".
+type Change struct {
+	Status
+	Reason string
+	// Previous is the lease before the change
+	Previous store.Lease
+}
+
+// Elector campaigns for the lease name on behalf of replica id.
+type Elector struct {
+	st    Store
+	name  string
+	id    string
+	ttl   time.Duration
+	renew time.Duration
+
+	// OnChange is called on every leadership change (set before Run)
+	OnChange func(Change)
+
+	mu      sync.RWMutex
+	lease   store.Lease
+	leader  bool
+	since   time.Time
+	expires time.Time // local deadline of our lease
+}
+
+// New returns an elector for lease name. renew must be well below ttl:
+// a leader that cannot renew for ttl steps down on its own.
+func New(st Store, name, id string, ttl, renew time.Duration) *Elector {
+	return &Elector{st: st, name: name, id: id, ttl: ttl, renew: renew}
+}
+
+// Run campaigns every renew interval until ctx is done, then releases
+// the lease so another replica can take over without waiting for it.
+func (e *Elector) Run(ctx context.Context) {
+	t := time.NewTicker(e.renew)
+	defer t.Stop()
+	for {
+		if err := e.Campaign(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("leader: campaign failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			e.release()
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Campaign runs one round: renew or take the lease, or learn who holds
+// it. On a redis error a leader stays leader until its lease would have
+// expired.
+func (e *Elector) Campaign(ctx context.Context) error {
+	start := time.Now()
+	l, err := e.st.AcquireLease(ctx, e.name, e.id, e.ttl)
+
+	e.mu.Lock()
+	prev := e.lease
+	var ch *Change
+	switch {
+	case err != nil:
+		if e.leader && !start.Before(e.expires) {
+			e.leader = false
+			ch = &Change{Reason: "expired", Previous: prev}
+		}
+	case l.Holder == e.id:
+		e.lease = l
+		// measured from before the call: redis started the ttl later
+		e.expires = start.Add(l.TTL)
+		if !e.leader || prev.Token != l.Token {
+			e.leader, e.since = true, start
+			ch = &Change{Reason: "elected", Previous: prev}
+		}
+	default:
+		e.lease = l
+		if e.leader {
+			e.leader = false
+			ch = &Change{Reason: "taken_over", Previous: prev}
+		}
+	}
+	if ch != nil {
+		ch.Status = e.statusLocked()
+	}
+	e.mu.Unlock()
+
+	if ch != nil && e.OnChange != nil {
+		e.OnChange(*ch)
+	}
+	return err
+}
+
+func (e *Elector) release() {
+	e.mu.Lock()
+	was, l := e.leader, e.lease
+	e.leader = false
+	st := e.statusLocked()
+	e.mu.Unlock()
+	if !was {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := e.st.ReleaseLease(ctx, e.name, e.id, l.Token); err != nil {
+		log.Printf("leader: release failed: %v", err)
+	}
+	if e.OnChange != nil {
+		e.OnChange(Change{Status: st, Reason: "released", Previous: l})
+	}
+}
+
+// IsLeader reports whether this replica holds the lease. It turns false
+// as soon as the lease may have expired, even before the next campaign.
+func (e *Elector) IsLeader() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.leader && time.Now().Before(e.expires)
+}
+
+// Token is the fencing token of the current leadership (0 if not leader).
+func (e *Elector) Token() int64 {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if !e.leader {
+		return 0
+	}
+	return e.lease.Token
+}
+
+// Fence confirms in redis that this replica still holds the lease with
+// token. Jobs call it right before acting on shared state.
+func (e *Elector) Fence(ctx context.Context, token int64) error {
+	ok, err := e.st.CheckLease(ctx, e.name, e.id, token)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotLeader
+	}
+	return nil
+}
+
+// fenced returns ctx with token as fencing token, for the job and for
+// the store writes it makes.
+func (e *Elector) fenced(ctx context.Context, token int64) context.Context {
+	return e.st.WithFence(WithToken(ctx, token), e.name, e.id, token)
+}
+
+// Status returns this replica's view of the election.
+func (e *Elector) Status() Status {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	st := e.statusLocked()
+	st.Leader = st.Leader && time.Now().Before(e.expires)
+	return st
+}
+
+func (e *Elector) statusLocked() Status {
+	st := Status{
+		Enabled: true,
+		Leader:  e.leader,
+		ID:      e.id,
+		Holder:  e.lease.Holder,
+		Token:   e.lease.Token,
+	}
+	if e.leader {
+		st.Since = e.since.Unix()
+	}
+	return st
+}
+
+// -------------------------------------------------------------------
+// Fencing token in job contexts
+// -------------------------------------------------------------------
+
+type ctxKey struct{}
+
+// WithToken stores the fencing token a singleton job runs under.
+func WithToken(ctx context.Context, token int64) context.Context {
+	return context.WithValue(ctx, ctxKey{}, token)
+}
+
+// TokenFrom returns the fencing token of a singleton job (0 otherwise).
+func TokenFrom(ctx context.Context) int64 {
+	t, _ := ctx.Value(ctxKey{}).(int64)
+	return t
+}
@@ -0,0 +1,102 @@
+package leader
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Job is a periodic background task.
+type Job struct {
+	Name  string
+	Every time.Duration
+	// Singleton jobs run only on the leader, with its fencing token in
+	// the context (TokenFrom) and their store writes fenced by it; the
+	// others run on every replica.
+	Singleton bool
+	Run       func(ctx context.Context) error
+}
+
+// Scheduler runs jobs on their intervals. With a nil elector (leader
+// election disabled) this replica runs singleton jobs too.
+type Scheduler struct {
+	el   *Elector
+	mu   sync.Mutex
+	jobs []Job
+}
+
+// NewScheduler returns a scheduler gated by el (may be nil).
+func NewScheduler(el *Elector) *Scheduler {
+	return &Scheduler{el: el}
+}
+
+// Add registers j; call it before Run.
+func (s *Scheduler) Add(jobs ...Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs = append(s.jobs, jobs...)
+}
+
+// Run runs every job on its own ticker until ctx is done.
+func (s *Scheduler) Run(ctx context.Context) {
+	s.mu.Lock()
+	jobs := append([]Job(nil), s.jobs...)
+	s.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, j := range jobs {
+		wg.Add(1)
+		go func(j Job) {
+			defer wg.Done()
+			t := time.NewTicker(j.Every)
+			defer t.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-t.C:
+					s.run(ctx, j)
+				}
+			}
+		}(j)
+	}
+	wg.Wait()
+}
+
+// RunAll runs every job once, as if all were due, and returns the names
+// of those that ran.
+func (s *Scheduler) RunAll(ctx context.Context) []string {
+	s.mu.Lock()
+	jobs := append([]Job(nil), s.jobs...)
+	s.mu.Unlock()
+
+	var ran []string
+	for _, j := range jobs {
+		if s.run(ctx, j) {
+			ran = append(ran, j.Name)
+		}
+	}
+	return ran
+}
+
+// run runs j if this replica may; a singleton job first re-checks the
+// lease in redis, so a replica that lost it unnoticed does nothing, and
+// its writes are refused (store.ErrFenced) once it loses it mid-job.
+func (s *Scheduler) run(ctx context.Context, j Job) bool {
+	if j.Singleton && s.el != nil {
+		token := s.el.Token()
+		if token == 0 || !s.el.IsLeader() {
+			return false
+		}
+		if err := s.el.Fence(ctx, token); err != nil {
+			log.Printf("scheduler: skip %s: %v", j.Name, err)
+			return false
+		}
+		ctx = s.el.fenced(ctx, token)
+	}
+	if err := j.Run(ctx); err != nil {
+		log.Printf("scheduler: %s failed: %v", j.Name, err)
+	}
+	return true
+}
@@ -0,0 +1,216 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "ap_controller"
+
+// Registry holds every controller metric. A private registry keeps
+// /metrics free of collectors registered by third-party packages.
+var Registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by chi route pattern, method and status.",
+	}, []string{"route", "method", "status"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by chi route pattern and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	logins = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "portal_logins_total",
+		Help:      "Portal login outcomes by result, role, matched rule and SSID (\"other\" unless named in role_rules).",
+	}, []string{"result", "role", "rule", "ssid"})
+
+	hmacFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "hmac_verify_failures_total",
+		Help:      "Rejected signed requests by reason.",
+	}, []string{"reason"})
+
+	rateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_total",
+		Help:      "Throttled portal logins by scope (mac / ip / client) and reason (rate / lockout).",
+	}, []string{"scope", "reason"})
+
+	redisDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "redis_command_duration_seconds",
+		Help:      "Redis command latency by command (pipelines count as one).",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"command"})
+
+	policyInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "policy_info",
+		Help:      "Currently served runtime policy (value is always 1).",
+	}, []string{"version", "checksum", "policy_version"})
+
+	isLeader = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "leader",
+		Help:      "1 while this replica holds the leader lease and runs singleton jobs.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests, httpDuration, logins, hmacFailures, rateLimited, redisDuration, policyInfo, isLeader,
+		sourceCollector{},
+	)
+}
+
+// Handler serves the registry in Prometheus text format.
+// A failing source (e.g. redis down) only drops its own series.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{
+		ErrorHandling: promhttp.ContinueOnError,
+	})
+}
+
+// -------------------------------------------------------------------
+// Recorders
+// -------------------------------------------------------------------
+
+// ObserveRequest records one served HTTP request.
+func ObserveRequest(route, method string, status int, d time.Duration) {
+	httpRequests.WithLabelValues(route, method, strconv.Itoa(status)).Inc()
+	httpDuration.WithLabelValues(route, method).Observe(d.Seconds())
+}
+
+// LoginOutcome records the result of a /portal/login call.
+func LoginOutcome(result, role, rule, ssid string) {
+	logins.WithLabelValues(result, role, rule, ssid).Inc()
+}
+
+// HMACFailure records a rejected signed request.
+func HMACFailure(reason string) {
+	hmacFailures.WithLabelValues(reason).Inc()
+}
+
+// RateLimited records a throttled request.
+func RateLimited(scope, reason string) {
+	rateLimited.WithLabelValues(scope, reason).Inc()
+}
+
+// ObserveRedis records the latency of one redis command or pipeline.
+func ObserveRedis(cmd string, d time.Duration) {
+	redisDuration.WithLabelValues(cmd).Observe(d.Seconds())
+}
+
+// SetPolicyInfo publishes the policy currently served to APs.
+func SetPolicyInfo(version, checksum string, policyVersion int) {
+	policyInfo.Reset()
+	policyInfo.WithLabelValues(version, checksum, strconv.Itoa(policyVersion)).Set(1)
+}
+
+// SetLeader publishes whether this replica is the leader.
+func SetLeader(leader bool) {
+	if leader {
+		isLeader.Set(1)
+	} else {
+		isLeader.Set(0)
+	}
+}
+
+// -------------------------------------------------------------------
+// Scrape-time gauges
+// -------------------------------------------------------------------
+
+// Sources for gauges computed at scrape time. They are installed by main
+// once the audit logger and store exist; unset sources export nothing.
+var (
+	sourcesMu     sync.RWMutex
+	auditDepth    func() int
+	sessionCounts = map[string]func(ctx context.Context) (map[string]int, error){}
+)
+
+// SetAuditQueueDepth exposes the audit queue length, read on each scrape.
+func SetAuditQueueDepth(depth func() int) {
+	sourcesMu.Lock()
+	defer sourcesMu.Unlock()
+	auditDepth = depth
+}
+
+// SetSessionCounter exposes the active sessions of tenant per role,
+// counted on each scrape by count (bounded by a short timeout). Every
+// tenant store has its own counter; a nil count removes it.
+func SetSessionCounter(tenant string, count func(ctx context.Context) (map[string]int, error)) {
+	sourcesMu.Lock()
+	defer sourcesMu.Unlock()
+	if count == nil {
+		delete(sessionCounts, tenant)
+		return
+	}
+	sessionCounts[tenant] = count
+}
+
+var sessionsDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "", "sessions_active"),
+	"Active sessions in the store by tenant and role.",
+	[]string{"tenant", "role"}, nil,
+)
+
+var auditDepthDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "", "audit_queue_depth"),
+	"Audit events waiting to be written.",
+	nil, nil,
+)
+
+// sourceCollector reads the scrape-time sources.
+type sourceCollector struct{}
+
+func (sourceCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- sessionsDesc
+	ch <- auditDepthDesc
+}
+
+func (sourceCollector) Collect(ch chan<- prometheus.Metric) {
+	sourcesMu.RLock()
+	depth := auditDepth
+	counts := make(map[string]func(ctx context.Context) (map[string]int, error), len(sessionCounts))
+	for tenant, count := range sessionCounts {
+		counts[tenant] = count
+	}
+	sourcesMu.RUnlock()
+
+	if depth != nil {
+		ch <- prometheus.MustNewConstMetric(auditDepthDesc, prometheus.GaugeValue, float64(depth()))
+	}
+	if len(counts) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	for tenant, count := range counts {
+		byRole, err := count(ctx)
+		if err != nil {
+			ch <- prometheus.NewInvalidMetric(sessionsDesc, err)
+			continue
+		}
+		for role, n := range byRole {
+			ch <- prometheus.MustNewConstMetric(sessionsDesc, prometheus.GaugeValue, float64(n), tenant, role)
+		}
+	}
+}
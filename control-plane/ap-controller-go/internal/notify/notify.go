@@ -0,0 +1,250 @@
+// Package notify sends messages to people, such as approval requests to
+// guest sponsors.
+//
+// A Notifier is chosen by controller.sponsor.notifier.type: a webhook
+// hands the message to an existing mail or chat gateway, smtp sends a
+// plain text mail, and file appends JSON lines for development and tests.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"tracing"
+
+	"ap-controller-go/internal/config"
+)
+
+// Message is one notification. Text is the human readable body; Data
+// carries the same content for machines (webhooks, file).
+type Message struct {
+	Kind    string         `json:"kind"`
+	To      string         `json:"to"`
+	Subject string         `json:"subject"`
+	Text    string         `json:"text"`
+	Data    map[string]any `json:"data,omitempty"`
+}
+
+// Notifier delivers messages.
+type Notifier interface {
+	Notify(ctx context.Context, msg Message) error
+}
+
+// New builds the notifier configured by n, resolving its secrets.
+func New(n config.Notifier) (Notifier, error) {
+	switch n.Type {
+	case config.NotifierFile:
+		return NewFile(n.File), nil
+	case config.NotifierSMTP:
+		pwd := ""
+		if n.SMTP.PasswordRef != "" {
+			var err error
+			if pwd, err = config.ResolveSecret(n.SMTP.PasswordRef); err != nil {
+				return nil, fmt.Errorf("resolve smtp password: %w", err)
+			}
+		}
+		return &SMTP{Addr: n.SMTP.Addr, From: n.SMTP.From, Username: n.SMTP.Username, Password: pwd}, nil
+	case config.NotifierWebhook:
+		secret := ""
+		if n.Webhook.SecretRef != "" {
+			var err error
+			if secret, err = config.ResolveSecret(n.Webhook.SecretRef); err != nil {
+				return nil, fmt.Errorf("resolve webhook secret: %w", err)
+			}
+		}
+		return NewWebhook(n.Webhook.URL, []byte(secret)), nil
+	}
+	return nil, fmt.Errorf("unknown notifier type %q", n.Type)
+}
+
+// -------------------------------------------------------------------
+// File
+// -------------------------------------------------------------------
+
+// File appends each message as one JSON line to a file. It stands in for
+// a real sender in development; tail the file to click the links.
+type File struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewFile(path string) *File {
+	return &File{path: path}
+}
+
+func (f *File) Notify(_ context.Context, msg Message) error {
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fh, err := os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := fh.Write(append(line, '\n')); err != nil {
+		fh.Close()
+		return err
+	}
+	return fh.Close()
+}
+
+// -------------------------------------------------------------------
+// SMTP
+// -------------------------------------------------------------------
+
+// smtpTimeout bounds one mail when SMTP.Timeout is unset.
+const smtpTimeout = 10 * time.Second
+
+// SMTP sends a plain text mail through a relay. Username enables PLAIN
+// auth, which net/smtp only allows over TLS or to localhost. The whole
+// conversation ends at the ctx deadline or after Timeout, whichever is
+// first.
+type SMTP struct {
+	Addr     string
+	From     string
+	Username string
+	Password string
+	Timeout  time.Duration
+}
+
+func (s *SMTP) Notify(ctx context.Context, msg Message) error {
+	if strings.ContainsAny(msg.To+msg.Subject, "\r\n") {
+		return errors.New("smtp: header injection")
+	}
+	host, _, _ := strings.Cut(s.Addr, ":")
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", s.From)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Text, "\n", "\r\n"))
+
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = smtpTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	// the deadline bounds every read and write; a cancelled ctx closes
+	// the connection under a blocked one
+	deadline, _ := ctx.Deadline()
+	_ = conn.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	return sendMail(c, host, auth, s.From, msg.To, []byte(b.String()))
+}
+
+// sendMail is smtp.SendMail on an open client.
+func sendMail(c *smtp.Client, host string, auth smtp.Auth, from, to string, body []byte) error {
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("smtp: server doesn't support AUTH")
+		}
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(body); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// -------------------------------------------------------------------
+// Webhook
+// -------------------------------------------------------------------
+
+// Webhook POSTs the message as JSON. With a secret the body is signed:
+// X-Signature: sha256=<hex hmac of the body>.
+type Webhook struct {
+	url    string
+	secret []byte
+	client *http.Client
+}
+
+func NewWebhook(url string, secret []byte) *Webhook {
+	return &Webhook{url: url, secret: secret, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (h *Webhook) Notify(ctx context.Context, msg Message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	tracing.Inject(req)
+	if len(h.secret) > 0 {
+		req.Header.Set("X-Signature", "sha256="+Sign(h.secret, body))
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook: status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Sign is the hex HMAC-SHA256 of body, as sent in X-Signature.
+func Sign(secret, body []byte) string {
+	m := hmac.New(sha256.New, secret)
+	m.Write(body)
+	return hex.EncodeToString(m.Sum(nil))
+}
@@ -0,0 +1,232 @@
+// Package pki is the controller's built-in AP enrollment CA: it signs
+// short-lived client certificates from AP CSRs and builds the CRL.
+package pki
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"os"
+	"regexp"
+	"time"
+
+	"ap-controller-go/internal/security"
+)
+
+// backdate tolerates AP clocks running slightly behind the controller.
+const backdate = 5 * time.Minute
+
+// CA signs AP client certificates.
+type CA struct {
+	cert    *x509.Certificate
+	certPEM []byte
+	key     crypto.Signer
+}
+
+// Issued is a freshly signed certificate.
+type Issued struct {
+	Cert   *x509.Certificate
+	PEM    []byte
+	Serial string
+}
+
+var nameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// ValidName reports whether s may be used as ap_id / site.
+// Names end up in a SAN URI, so the charset is kept strict.
+func ValidName(s string) bool {
+	return nameRe.MatchString(s)
+}
+
+// SerialString formats a serial the way APIdentity.Serial does.
+func SerialString(n *big.Int) string {
+	return n.Text(16)
+}
+
+// LoadCA reads a PEM CA certificate and its private key
+// (PKCS#8, SEC1 EC or PKCS#1 RSA).
+func LoadCA(certFile, keyFile string) (*CA, error) {
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("%s: no CERTIFICATE block", certFile)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if !cert.IsCA {
+		return nil, fmt.Errorf("%s: not a CA certificate", certFile)
+	}
+
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := parseKey(keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", keyFile, err)
+	}
+	if !publicKeyEqual(cert.PublicKey, key.Public()) {
+		return nil, errors.New("ca key does not match ca certificate")
+	}
+
+	return &CA{
+		cert:    cert,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}),
+		key:     key,
+	}, nil
+}
+
+func parseKey(b []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("no PEM block")
+	}
+	if k, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		if s, ok := k.(crypto.Signer); ok {
+			return s, nil
+		}
+		return nil, errors.New("unsupported key type")
+	}
+	if k, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return k, nil
+	}
+	if k, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return k, nil
+	}
+	return nil, errors.New("unsupported private key encoding")
+}
+
+func publicKeyEqual(a, b crypto.PublicKey) bool {
+	type equaler interface{ Equal(crypto.PublicKey) bool }
+	e, ok := a.(equaler)
+	return ok && e.Equal(b)
+}
+
+// Certificate returns the CA certificate.
+func (ca *CA) Certificate() *x509.Certificate {
+	return ca.cert
+}
+
+// CertPEM returns the CA certificate in PEM form (for AP trust stores).
+func (ca *CA) CertPEM() []byte {
+	return ca.certPEM
+}
+
+// ParseCSR decodes a PEM CSR and checks its self-signature.
+func ParseCSR(b []byte) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, errors.New("no CERTIFICATE REQUEST block")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("csr signature: %w", err)
+	}
+	switch k := csr.PublicKey.(type) {
+	case *ecdsa.PublicKey, ed25519.PublicKey:
+	case *rsa.PublicKey:
+		if k.N.BitLen() < 2048 {
+			return nil, errors.New("rsa key shorter than 2048 bits")
+		}
+	default:
+		return nil, errors.New("unsupported public key type")
+	}
+	return csr, nil
+}
+
+// Issue signs a client certificate for csr's key bound to apID / site
+// and tenant ("" = default tenant). Only the key is taken from the CSR:
+// the subject and SAN are set by the controller (CN=ap_id, OU=site, URI
+// ap://site/ap_id?tenant=tenant), never by the AP.
+// The lifetime is capped by the CA's own expiry.
+func (ca *CA) Issue(csr *x509.CertificateRequest, apID, site, tenant string, ttl time.Duration) (*Issued, error) {
+	if !ValidName(apID) || (site != "" && !ValidName(site)) {
+		return nil, errors.New("invalid ap_id or site")
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	notAfter := now.Add(ttl)
+	if notAfter.After(ca.cert.NotAfter) {
+		notAfter = ca.cert.NotAfter
+	}
+
+	subject := pkix.Name{CommonName: apID}
+	if site != "" {
+		subject.OrganizationalUnit = []string{site}
+	}
+	san := &url.URL{Scheme: security.APIdentityURIScheme, Host: site, Path: "/" + apID}
+	if tenant != "" {
+		san.RawQuery = url.Values{"tenant": {tenant}}.Encode()
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      subject,
+		URIs:         []*url.URL{san},
+		NotBefore:    now.Add(-backdate),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, csr.PublicKey, ca.key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &Issued{
+		Cert:   cert,
+		PEM:    pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		Serial: SerialString(serial),
+	}, nil
+}
+
+// Revocation is one CRL entry.
+type Revocation struct {
+	Serial    string
+	RevokedAt time.Time
+}
+
+// CRL returns a DER-encoded CRL valid for validity. The CRL number is the
+// issue time, so it only moves forward across restarts and replicas.
+func (ca *CA) CRL(entries []Revocation, validity time.Duration) ([]byte, error) {
+	now := time.Now()
+	list := make([]x509.RevocationListEntry, 0, len(entries))
+	for _, e := range entries {
+		n, ok := new(big.Int).SetString(e.Serial, 16)
+		if !ok {
+			continue
+		}
+		list = append(list, x509.RevocationListEntry{SerialNumber: n, RevocationTime: e.RevokedAt})
+	}
+	return x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:                    big.NewInt(now.UnixNano()),
+		ThisUpdate:                now,
+		NextUpdate:                now.Add(validity),
+		RevokedCertificateEntries: list,
+	}, ca.cert, ca.key)
+}
@@ -0,0 +1,43 @@
+package pki
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"log"
+	"time"
+)
+
+// ErrRevoked is returned by the revocation check for a denylisted cert.
+var ErrRevoked = errors.New("certificate revoked")
+
+// RevocationStore is the part of store.Store the checker needs.
+type RevocationStore interface {
+	IsRevoked(ctx context.Context, serial string) (bool, error)
+}
+
+// RevocationChecker returns a server.Options.VerifyClient hook that
+// rejects revoked client certificates during the TLS handshake.
+// It fails closed: if the denylist cannot be read the handshake fails.
+func RevocationChecker(st RevocationStore) func(*x509.Certificate) error {
+	return RevocationCheckerFor(func(*x509.Certificate) RevocationStore { return st })
+}
+
+// RevocationCheckerFor is RevocationChecker with the denylist chosen per
+// certificate, e.g. by tenant.
+func RevocationCheckerFor(pick func(*x509.Certificate) RevocationStore) func(*x509.Certificate) error {
+	return func(cert *x509.Certificate) error {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		revoked, err := pick(cert).IsRevoked(ctx, SerialString(cert.SerialNumber))
+		if err != nil {
+			log.Printf("tls: revocation check failed: %v", err)
+			return err
+		}
+		if revoked {
+			return ErrRevoked
+		}
+		return nil
+	}
+}
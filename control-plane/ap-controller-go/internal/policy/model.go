@@ -11,6 +11,8 @@ type RuntimePolicy struct {
 	Roles      map[string]RuntimeRole    `json:"roles"`
 	Profiles   map[string]RuntimeProfile `json:"profiles"`
 	Bypass     RuntimeBypass             `json:"bypass"`
+	Denylist   []RuntimeDeny             `json:"denylist"`
+	Rollout    string                    `json:"rollout,omitempty"` // candidate served to canary APs
 	Dataplane  RuntimeDataplane          `json:"dataplane"`
 }
 
@@ -40,9 +42,16 @@ type RuntimeRole struct {
 }
 
 type RuntimeProfile struct {
-	VLAN          int    `json:"vlan"`
-	FirewallGroup string `json:"firewall_group"`
-	SessionTTL    int    `json:"session_ttl"`
+	VLAN               int    `json:"vlan"`
+	FirewallGroup      string `json:"firewall_group"`
+	SessionTTL         int    `json:"session_ttl"`
+	UpstreamKbps       int    `json:"upstream_kbps"`
+	DownstreamKbps     int    `json:"downstream_kbps"`
+	IdleTimeout        int    `json:"idle_timeout"`
+	MaxSessionLifetime int    `json:"max_session_lifetime"`
+	MaxDevices         int    `json:"max_devices"`
+	SessionQuotaMB     int    `json:"session_quota_mb"`
+	DailyQuotaMB       int    `json:"daily_quota_mb"`
 }
 
 // =========================
@@ -57,6 +66,49 @@ type RuntimeBypass struct {
 	Domains      []string `json:"domains"`
 }
 
+// Dynamic bypass entry scopes.
+const (
+	ScopeGlobal = "global"
+	ScopeSite   = "site"
+	ScopeAP     = "ap"
+)
+
+// BypassEntry is a bypass entry added through the admin API. Type is
+// "mac", "ip" or "domain".
+type BypassEntry struct {
+	Type    string `json:"type"`
+	Value   string `json:"value"`
+	Scope   string `json:"scope"`
+	Site    string `json:"site,omitempty"`
+	APID    string `json:"ap_id,omitempty"`
+	Expires int64  `json:"expires,omitempty"`
+}
+
+// AppliesTo reports whether e is in effect on the AP apID of site.
+func (e BypassEntry) AppliesTo(site, apID string) bool {
+	switch e.Scope {
+	case ScopeGlobal:
+		return true
+	case ScopeSite:
+		return site != "" && e.Site == site
+	case ScopeAP:
+		return apID != "" && e.APID == apID
+	}
+	return false
+}
+
+// =========================
+// Denylist
+// =========================
+
+// RuntimeDeny is a blocked MAC or OUI ("aa:bb:cc") the AP drops traffic
+// from. Expires is a unix timestamp, 0 = until removed.
+type RuntimeDeny struct {
+	Pattern string `json:"pattern"`
+	Kind    string `json:"kind"`
+	Expires int64  `json:"expires,omitempty"`
+}
+
 // =========================
 // Dataplane
 // =========================
@@ -3,6 +3,7 @@ package policy
 import (
 	"encoding/json"
 	"net/http"
+	"slices"
 
 	"ap-controller-go/internal/config"
 )
@@ -11,7 +12,27 @@ import (
 // Builder
 // =========================
 
+// Dynamic is the part of the runtime policy kept in the store rather
+// than the config file.
+type Dynamic struct {
+	Denylist []RuntimeDeny
+	// Bypass holds the entries of every scope; the ones applying to
+	// Site / APID are merged into the bypass lists
+	Bypass []BypassEntry
+	Site   string
+	APID   string
+	// Rollout is the id of the staged rollout the policy is the
+	// candidate of ("" = the active policy)
+	Rollout string
+}
+
+// BuildRuntimePolicy builds the policy of cfg alone.
 func BuildRuntimePolicy(cfg *config.Config) RuntimePolicy {
+	return Build(cfg, Dynamic{})
+}
+
+// Build builds the runtime policy of cfg and the dynamic entries.
+func Build(cfg *config.Config, dyn Dynamic) RuntimePolicy {
 	rp := RuntimePolicy{
 		Controller: ControllerInfo{
 			ID:   cfg.Controller.ID,
@@ -20,13 +41,9 @@ func BuildRuntimePolicy(cfg *config.Config) RuntimePolicy {
 		},
 		Roles:    map[string]RuntimeRole{},
 		Profiles: map[string]RuntimeProfile{},
-		Bypass: RuntimeBypass{
-			Enabled:      cfg.Bypass.Enabled,
-			EnforceOrder: cfg.Bypass.EnforceOrder,
-			MacWhitelist: cfg.Bypass.MacWhitelist,
-			IPWhitelist:  cfg.Bypass.IPWhitelist,
-			Domains:      cfg.Bypass.Domains,
-		},
+		Bypass:   mergeBypass(cfg.Bypass, dyn),
+		Denylist: dyn.Denylist,
+		Rollout:  dyn.Rollout,
 		Dataplane: RuntimeDataplane{
 			PolicyVersion: cfg.Dataplane.PolicyVersion,
 			PortalIP:      cfg.Dataplane.PortalIP,
@@ -45,22 +62,43 @@ func BuildRuntimePolicy(cfg *config.Config) RuntimePolicy {
 	// Profiles
 	for name, p := range cfg.Profiles {
 		rp.Profiles[name] = RuntimeProfile{
-			VLAN:          p.VLAN,
-			FirewallGroup: p.FirewallGroup,
-			SessionTTL:    p.SessionTTL,
+			VLAN:               p.VLAN,
+			FirewallGroup:      p.FirewallGroup,
+			SessionTTL:         p.SessionTTL,
+			UpstreamKbps:       p.UpstreamKbps,
+			DownstreamKbps:     p.DownstreamKbps,
+			IdleTimeout:        p.IdleTimeout,
+			MaxSessionLifetime: p.MaxSessionLifetime,
+			MaxDevices:         p.MaxDevices,
+			SessionQuotaMB:     p.SessionQuotaMB,
+			DailyQuotaMB:       p.DailyQuotaMB,
 		}
 	}
 
-	// Version (filled later)
+	if rp.Denylist == nil {
+		rp.Denylist = []RuntimeDeny{}
+	}
+
+	// Version (filled later). The checksum covers the static bypass and
+	// the dynamic entries of every scope, so all APs share it; empty
+	// dynamic parts keep the checksum of policies built before them. A
+	// candidate's rollout id sets it apart from the active policy even
+	// when only the controller-side role rules differ.
 	rp.Version = BuildControllerVersion(
 		struct {
-			Roles    any
-			Profiles any
-			Bypass   any
+			Roles         any
+			Profiles      any
+			Bypass        any
+			Denylist      []RuntimeDeny `json:",omitempty"`
+			DynamicBypass []BypassEntry `json:",omitempty"`
+			Rollout       string        `json:",omitempty"`
 		}{
 			rp.Roles,
 			rp.Profiles,
-			rp.Bypass,
+			staticBypass(cfg.Bypass),
+			dyn.Denylist,
+			dyn.Bypass,
+			dyn.Rollout,
 		},
 		cfg.Controller.Version,
 	)
@@ -68,18 +106,69 @@ func BuildRuntimePolicy(cfg *config.Config) RuntimePolicy {
 	return rp
 }
 
+func staticBypass(b config.Bypass) RuntimeBypass {
+	return RuntimeBypass{
+		Enabled:      b.Enabled,
+		EnforceOrder: b.EnforceOrder,
+		MacWhitelist: b.MacWhitelist,
+		IPWhitelist:  b.IPWhitelist,
+		Domains:      b.Domains,
+	}
+}
+
+// mergeBypass appends the dynamic entries applying to dyn.Site /
+// dyn.APID to the static lists, skipping duplicates.
+func mergeBypass(b config.Bypass, dyn Dynamic) RuntimeBypass {
+	rb := staticBypass(b)
+	if len(dyn.Bypass) == 0 {
+		return rb
+	}
+	lists := map[string]*[]string{
+		"mac":    &rb.MacWhitelist,
+		"ip":     &rb.IPWhitelist,
+		"domain": &rb.Domains,
+	}
+	for _, l := range lists {
+		// never append to the config's backing arrays
+		*l = slices.Clone(*l)
+	}
+	for _, e := range dyn.Bypass {
+		l := lists[e.Type]
+		if l == nil || !e.AppliesTo(dyn.Site, dyn.APID) || slices.Contains(*l, e.Value) {
+			continue
+		}
+		*l = append(*l, e.Value)
+	}
+	return rb
+}
+
 // =========================
 // HTTP Handler
 // =========================
 
 func RuntimeHandler(cfg *config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		policy := BuildRuntimePolicy(cfg)
+		WriteRuntime(w, BuildRuntimePolicy(cfg), nil)
+	}
+}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("X-Policy-Version", policy.Version.Version)
-		w.Header().Set("X-Policy-Checksum", policy.Version.Checksum)
+// WriteRuntime writes policy as the runtime response, signed by signer
+// unless it is nil.
+func WriteRuntime(w http.ResponseWriter, policy RuntimePolicy, signer *Signer) {
+	body, err := json.Marshal(policy)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	body = append(body, '\n')
 
-		_ = json.NewEncoder(w).Encode(policy)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Policy-Version", policy.Version.Version)
+	w.Header().Set("X-Policy-Checksum", policy.Version.Checksum)
+	if signer != nil {
+		w.Header().Set(HeaderSignature, signer.Sign(body))
+		w.Header().Set(HeaderKeyID, signer.KeyID())
 	}
+
+	_, _ = w.Write(body)
 }
@@ -0,0 +1,201 @@
+package policy
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// =========================
+// Signed runtime documents
+// =========================
+//
+// The controller signs the runtime policy response body, byte for byte,
+// with Ed25519 and sends the signature in X-Policy-Signature (base64url,
+// no padding) and the key in X-Policy-Key-ID. The public keys are served
+// as a KeySet by GET /api/v1/policy/keys.
+
+// Response headers of a signed runtime policy.
+const (
+	HeaderSignature = "X-Policy-Signature"
+	HeaderKeyID     = "X-Policy-Key-ID"
+)
+
+// SignatureAlg is the only algorithm used for runtime documents.
+const SignatureAlg = "Ed25519"
+
+var (
+	ErrUnknownKey   = errors.New("policy: unknown signing key")
+	ErrBadSignature = errors.New("policy: bad signature")
+	ErrDowngrade    = errors.New("policy: older than the current policy")
+)
+
+// PublicKey is a published verification key. Key is the raw 32 byte
+// Ed25519 public key (base64 in JSON).
+type PublicKey struct {
+	KID string `json:"kid"`
+	Alg string `json:"alg"`
+	Key []byte `json:"key"`
+}
+
+// KeySet is the body of GET /api/v1/policy/keys.
+type KeySet struct {
+	Keys []PublicKey `json:"keys"`
+}
+
+// Find returns the key kid, or nil.
+func (ks KeySet) Find(kid string) *PublicKey {
+	for i := range ks.Keys {
+		if ks.Keys[i].KID == kid {
+			return &ks.Keys[i]
+		}
+	}
+	return nil
+}
+
+// KeyID derives a key id from a public key: "ed25519-" and the first 8
+// bytes of its SHA-256, hex.
+func KeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return "ed25519-" + hex.EncodeToString(sum[:8])
+}
+
+// ParsePrivateKey reads a PKCS#8 PEM Ed25519 private key.
+func ParsePrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block")
+	}
+	k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	priv, ok := k.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%T is not an Ed25519 key", k)
+	}
+	return priv, nil
+}
+
+// ParsePublicKey reads a PKIX PEM Ed25519 public key.
+func ParsePublicKey(data []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block")
+	}
+	k, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := k.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%T is not an Ed25519 key", k)
+	}
+	return pub, nil
+}
+
+// EncodePublicKey returns pub as PKIX PEM.
+func EncodePublicKey(pub ed25519.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+// Signer signs runtime documents.
+type Signer struct {
+	kid string
+	key ed25519.PrivateKey
+}
+
+// NewSigner returns a signer for key, named kid ("" = KeyID).
+func NewSigner(key ed25519.PrivateKey, kid string) *Signer {
+	if kid == "" {
+		kid = KeyID(key.Public().(ed25519.PublicKey))
+	}
+	return &Signer{kid: kid, key: key}
+}
+
+// KeyID is the id signatures are sent with.
+func (s *Signer) KeyID() string { return s.kid }
+
+// PublicKey is the verification key of s.
+func (s *Signer) PublicKey() PublicKey {
+	return PublicKey{KID: s.kid, Alg: SignatureAlg, Key: s.key.Public().(ed25519.PublicKey)}
+}
+
+// Sign returns the signature of body.
+func (s *Signer) Sign(body []byte) string {
+	return base64.RawURLEncoding.EncodeToString(ed25519.Sign(s.key, body))
+}
+
+// Verify checks sig over body with the key kid of ks and returns the
+// policy it carries.
+func Verify(ks KeySet, body []byte, kid, sig string) (*RuntimePolicy, error) {
+	k := ks.Find(kid)
+	if k == nil || k.Alg != SignatureAlg || len(k.Key) != ed25519.PublicKeySize {
+		return nil, ErrUnknownKey
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimSpace(sig))
+	if err != nil || !ed25519.Verify(ed25519.PublicKey(k.Key), body, raw) {
+		return nil, ErrBadSignature
+	}
+	var rp RuntimePolicy
+	if err := json.Unmarshal(body, &rp); err != nil {
+		return nil, fmt.Errorf("policy: decode: %w", err)
+	}
+	return &rp, nil
+}
+
+// CheckForward rejects cur if it is older than prev, the last accepted
+// policy: a lower version, or the same version generated earlier with
+// other contents. Fetching the same policy again is fine.
+func CheckForward(prev, cur ControllerVersion) error {
+	switch c := CompareVersions(cur.Version, prev.Version); {
+	case c < 0:
+		return fmt.Errorf("%w: version %q < %q", ErrDowngrade, cur.Version, prev.Version)
+	case c == 0 && cur.Checksum != prev.Checksum && cur.Generated < prev.Generated:
+		return fmt.Errorf("%w: generated %d < %d", ErrDowngrade, cur.Generated, prev.Generated)
+	}
+	return nil
+}
+
+// CompareVersions compares dotted versions ("1.10.2", "v2") part by
+// part, numerically where both parts are numbers. It returns -1, 0 or 1.
+func CompareVersions(a, b string) int {
+	pa := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	pb := strings.Split(strings.TrimPrefix(b, "v"), ".")
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		x, y := "0", "0"
+		if i < len(pa) && pa[i] != "" {
+			x = pa[i]
+		}
+		if i < len(pb) && pb[i] != "" {
+			y = pb[i]
+		}
+		nx, ex := strconv.ParseUint(x, 10, 64)
+		ny, ey := strconv.ParseUint(y, 10, 64)
+		switch {
+		case ex == nil && ey == nil && nx != ny:
+			if nx < ny {
+				return -1
+			}
+			return 1
+		case (ex != nil || ey != nil) && x != y:
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
@@ -48,7 +48,7 @@ func match(pattern any, value string) bool {
 }
 
 func matchWhen(when map[string]any, ctx map[string]string) bool {
-	for _, f := range []string{"ssid", "auth", "ap_id", "radio_id", "mac", "ip"} {
+	for _, f := range config.WhenKeys {
 		if _, ok := when[f]; ok {
 			if !match(when[f], ctx[f]) {
 				return false
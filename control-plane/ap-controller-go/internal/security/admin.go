@@ -0,0 +1,166 @@
+package security
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Admin roles, each including the permissions of the ones before it.
+const (
+	AdminReadonly = "readonly" // read sessions, APs, policy
+	AdminOperator = "operator" // + change sessions
+	AdminAdmin    = "admin"    // + API keys, AP / certificate management
+)
+
+var adminRank = map[string]int{AdminReadonly: 1, AdminOperator: 2, AdminAdmin: 3}
+
+// ValidAdminRole reports whether role is a built-in admin role.
+func ValidAdminRole(role string) bool {
+	return adminRank[role] > 0
+}
+
+// AdminRoleAllows reports whether role has the permissions of need.
+func AdminRoleAllows(role, need string) bool {
+	return adminRank[role] > 0 && adminRank[role] >= adminRank[need]
+}
+
+// AdminIdentity is the authenticated caller of an admin endpoint.
+type AdminIdentity struct {
+	Subject string `json:"subject"`
+	Role    string `json:"role"`
+	Method  string `json:"method"` // token | api_key | jwt
+	KeyID   string `json:"key_id,omitempty"`
+	// Tenant the identity is limited to; "" = every tenant
+	Tenant string `json:"tenant,omitempty"`
+}
+
+const ctxKeyAdmin ctxKey = "admin_identity"
+
+// WithAdminIdentity stores id in ctx.
+func WithAdminIdentity(ctx context.Context, id AdminIdentity) context.Context {
+	return context.WithValue(ctx, ctxKeyAdmin, id)
+}
+
+// AdminIdentityFrom returns the admin identity set by WithAdminIdentity.
+func AdminIdentityFrom(ctx context.Context) (AdminIdentity, bool) {
+	id, ok := ctx.Value(ctxKeyAdmin).(AdminIdentity)
+	return id, ok
+}
+
+// BearerToken returns the token of an "Authorization: Bearer" header.
+func BearerToken(h string) (string, bool) {
+	t, ok := strings.CutPrefix(h, "Bearer ")
+	return t, ok && t != ""
+}
+
+// EqualToken compares two secrets in constant time. An empty want never
+// matches.
+func EqualToken(got, want string) bool {
+	return want != "" && subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
+
+// -------------------------------------------------------------------
+// API keys: "apk_<id>.<secret>"; only sha256(secret) is stored
+// -------------------------------------------------------------------
+
+const adminKeyPrefix = "apk_"
+
+// NewAdminKey generates an API key. token is shown to the operator once.
+func NewAdminKey() (id, secret, token string, err error) {
+	b := make([]byte, 8+32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", "", err
+	}
+	id = hex.EncodeToString(b[:8])
+	secret = base64.RawURLEncoding.EncodeToString(b[8:])
+	return id, secret, adminKeyPrefix + id + "." + secret, nil
+}
+
+// ParseAdminKey splits an API key token into its id and secret.
+func ParseAdminKey(token string) (id, secret string, ok bool) {
+	rest, ok := strings.CutPrefix(token, adminKeyPrefix)
+	if !ok {
+		return "", "", false
+	}
+	id, secret, ok = strings.Cut(rest, ".")
+	return id, secret, ok && id != "" && secret != ""
+}
+
+// HashAdminSecret is the stored form of an API key secret. The secret is
+// 256 random bits, so a plain digest is enough.
+func HashAdminSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// -------------------------------------------------------------------
+// Admin JWTs (HS256, aud "ap-controller-admin", claims sub + role and
+// an optional tenant)
+// -------------------------------------------------------------------
+
+const (
+	adminJWTIssuer   = "ap-controller"
+	adminJWTAudience = "ap-controller-admin"
+)
+
+// ErrBadAdminJWT is returned for any admin JWT that does not verify.
+var ErrBadAdminJWT = errors.New("invalid admin jwt")
+
+type adminClaims struct {
+	Role   string `json:"role"`
+	Tenant string `json:"tenant,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// IssueAdminJWT signs an admin JWT for subject with role in every tenant.
+func IssueAdminJWT(secret []byte, subject, role string, ttl time.Duration) (string, error) {
+	return IssueTenantAdminJWT(secret, "", subject, role, ttl)
+}
+
+// IssueTenantAdminJWT signs an admin JWT limited to tenant ("" = all).
+func IssueTenantAdminJWT(secret []byte, tenant, subject, role string, ttl time.Duration) (string, error) {
+	if !ValidAdminRole(role) || subject == "" {
+		return "", ErrBadAdminJWT
+	}
+	now := time.Now()
+	claims := adminClaims{
+		Role:   role,
+		Tenant: tenant,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    adminJWTIssuer,
+			Audience:  jwt.ClaimStrings{adminJWTAudience},
+			Subject:   subject,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+}
+
+// VerifyAdminJWT checks signature, issuer, audience and expiry of an
+// admin JWT and returns its identity.
+func VerifyAdminJWT(secret []byte, token string) (AdminIdentity, error) {
+	if len(secret) == 0 {
+		return AdminIdentity{}, ErrBadAdminJWT
+	}
+	var c adminClaims
+	_, err := jwt.ParseWithClaims(token, &c, func(*jwt.Token) (any, error) { return secret, nil },
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(adminJWTIssuer),
+		jwt.WithAudience(adminJWTAudience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil || c.Subject == "" || !ValidAdminRole(c.Role) {
+		return AdminIdentity{}, ErrBadAdminJWT
+	}
+	return AdminIdentity{Subject: c.Subject, Role: c.Role, Method: "jwt", Tenant: c.Tenant}, nil
+}
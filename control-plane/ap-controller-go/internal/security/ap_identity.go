@@ -0,0 +1,82 @@
+package security
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+	"strings"
+)
+
+// APIdentity is the AP a verified mTLS client certificate belongs to.
+type APIdentity struct {
+	APID    string `json:"ap_id"`
+	Site    string `json:"site,omitempty"`
+	Tenant  string `json:"tenant,omitempty"`
+	Subject string `json:"subject"`
+	Serial  string `json:"serial"`
+}
+
+// CtxKeyAPIdentity is the context key used to store the APIdentity
+// of a request authenticated by client certificate.
+const CtxKeyAPIdentity ctxKey = "ap_identity"
+
+// APIdentityURIScheme is the SAN URI scheme carrying the AP identity:
+//
+//	ap://<site>/<ap_id>[?tenant=<tenant>]
+//
+// Without such a SAN the identity falls back to the subject:
+// CN = ap_id, first OU = site.
+const APIdentityURIScheme = "ap"
+
+// APIdentityFromCert maps a client certificate to an AP identity.
+// ok is false if the certificate names no AP.
+func APIdentityFromCert(c *x509.Certificate) (APIdentity, bool) {
+	id := APIdentity{
+		Subject: c.Subject.String(),
+		Serial:  c.SerialNumber.Text(16),
+	}
+
+	for _, u := range c.URIs {
+		if u.Scheme != APIdentityURIScheme {
+			continue
+		}
+		apID := strings.Trim(u.Path, "/")
+		if apID == "" || strings.Contains(apID, "/") {
+			continue
+		}
+		id.APID, id.Site = apID, u.Host
+		id.Tenant = u.Query().Get("tenant")
+		return id, true
+	}
+
+	id.APID = c.Subject.CommonName
+	if len(c.Subject.OrganizationalUnit) > 0 {
+		id.Site = c.Subject.OrganizationalUnit[0]
+	}
+	return id, id.APID != ""
+}
+
+// APIdentityFrom returns the AP identity stored by APIdentityMiddleware.
+func APIdentityFrom(ctx context.Context) (APIdentity, bool) {
+	id, ok := ctx.Value(CtxKeyAPIdentity).(APIdentity)
+	return id, ok
+}
+
+// APIdentityMiddleware stores the AP identity of a verified client
+// certificate in the request context. Plain HTTP requests and TLS
+// requests without a verified chain pass through unchanged.
+func APIdentityMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		id, ok := APIdentityFromCert(r.TLS.VerifiedChains[0][0])
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx := context.WithValue(r.Context(), CtxKeyAPIdentity, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
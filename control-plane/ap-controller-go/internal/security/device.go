@@ -0,0 +1,37 @@
+package security
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net"
+)
+
+// -------------------------------------------------------------------
+// Known-device tokens
+// -------------------------------------------------------------------
+
+// NewDeviceToken returns a token the portal keeps in a cookie to link a
+// device across MAC changes: 256 random bits, URL safe.
+func NewDeviceToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashDeviceToken is the stored form of a device token.
+func HashDeviceToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// RandomizedMAC reports whether mac is locally administered, as the
+// private / random addresses of current phones and laptops are. Such a
+// MAC alone does not identify a device.
+func RandomizedMAC(mac string) bool {
+	hw, err := net.ParseMAC(mac)
+	return err == nil && len(hw) > 0 && hw[0]&0x02 != 0
+}
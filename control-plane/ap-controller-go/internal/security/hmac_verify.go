@@ -11,10 +11,11 @@ import (
 var (
 	ErrNotInitialized = errors.New("portal hmac not initialized")
 	ErrInvalidSign    = errors.New("invalid hmac signature")
+	ErrUnknownKID     = errors.New("unknown hmac key id")
 )
 
 func VerifyPortalSignature(req *http.Request, body []byte) error {
-	ks := PortalHMACProvider()
+	ks := keySetFor(req.Context())
 	if ks == nil {
 		return ErrNotInitialized
 	}
@@ -40,7 +41,7 @@ func VerifyPortalSignature(req *http.Request, body []byte) error {
 
 	key, ok := ks.Keys[kid]
 	if !ok || key == nil {
-		return ErrInvalidSign
+		return ErrUnknownKID
 	}
 
 	// --------------------------------------------------
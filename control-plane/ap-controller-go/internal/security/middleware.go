@@ -3,9 +3,12 @@ package security
 import (
 	"bytes"
 	"context"
+	"errors"
 	"io"
 	"net/http"
 	"time"
+
+	"ap-controller-go/internal/metrics"
 )
 
 const (
@@ -34,30 +37,11 @@ func PortalAuthMiddleware(st Store) func(http.Handler) http.Handler {
 				return
 			}
 
-			body := readBodyAndRestore(r)
-
-			// 1. HMAC verify
-			if err := VerifyPortalSignature(r, body); err != nil {
-				http.Error(w, "unauthorized", http.StatusUnauthorized)
-				return
-			}
-
-			// 2. timestamp window
-			if err := ValidateTimestamp(
-				r.Header.Get("X-Portal-Timestamp"),
-				time.Now(),
-			); err != nil {
-				http.Error(w, err.Error(), http.StatusUnauthorized)
-				return
-			}
-
-			// 3. nonce replay protection
-			if err := ValidateNonce(
-				context.Background(),
-				st,
-				r.Header.Get("X-Portal-Nonce"),
-			); err != nil {
-				http.Error(w, err.Error(), http.StatusUnauthorized)
+			// --------------------------------------------------
+			// 1-3. HMAC / timestamp / nonce
+			// --------------------------------------------------
+			if status, err := verifySignedRequest(r, st); err != nil {
+				http.Error(w, err.Error(), status)
 				return
 			}
 
@@ -80,6 +64,78 @@ func PortalAuthMiddleware(st Store) func(http.Handler) http.Handler {
 	}
 }
 
+// APAuthMiddleware authenticates AP / data-plane callers.
+// It performs the same HMAC, timestamp and nonce checks as
+// PortalAuthMiddleware, but AP requests carry many clients,
+// so no X-Client-MAC is required.
+//
+// An AP that presented a verified client certificate (see
+// APIdentityMiddleware) is authenticated by it and skips the HMAC checks.
+func APAuthMiddleware(st Store) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, viaCert := APIdentityFrom(r.Context())
+			if !SkipAuthForTest && !viaCert {
+				if status, err := verifySignedRequest(r, st); err != nil {
+					http.Error(w, err.Error(), status)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// PortalSigned reports whether r carries a valid portal signature, for
+// routes such as /portal/login that also serve unsigned callers. A missing
+// signature is not a failure. The nonce is used up, so call it once.
+func PortalSigned(r *http.Request, st Store) bool {
+	if SkipAuthForTest {
+		return true
+	}
+	if r.Header.Get("X-Portal-Signature") == "" {
+		return false
+	}
+	_, err := verifySignedRequest(r, st)
+	return err == nil
+}
+
+// verifySignedRequest runs the shared HMAC / timestamp / nonce checks.
+func verifySignedRequest(r *http.Request, st Store) (int, error) {
+	body := readBodyAndRestore(r)
+
+	// 1. HMAC verify
+	if err := VerifyPortalSignature(r, body); err != nil {
+		if errors.Is(err, ErrUnknownKID) {
+			metrics.HMACFailure("unknown_kid")
+		} else {
+			metrics.HMACFailure("bad_signature")
+		}
+		return http.StatusUnauthorized, errors.New("unauthorized")
+	}
+
+	// 2. timestamp window
+	if err := ValidateTimestamp(
+		r.Header.Get("X-Portal-Timestamp"),
+		time.Now(),
+	); err != nil {
+		metrics.HMACFailure("skew")
+		return http.StatusUnauthorized, err
+	}
+
+	// 3. nonce replay protection
+	if err := ValidateNonce(
+		context.Background(),
+		st,
+		r.Header.Get("X-Portal-Nonce"),
+	); err != nil {
+		metrics.HMACFailure("replay")
+		return http.StatusUnauthorized, err
+	}
+
+	return 0, nil
+}
+
 // readBodyAndRestore reads body for HMAC and restores it for handlers
 func readBodyAndRestore(r *http.Request) []byte {
 	if r.Body == nil {
@@ -1,6 +1,7 @@
 package security
 
 import (
+	"context"
 	"encoding/base64"
 	"errors"
 	"fmt"
@@ -31,6 +32,39 @@ func PortalHMAC() *KeySet {
 	return portalHMAC
 }
 
+// NewKeySet builds a keyset from base64 secrets by kid, e.g. a tenant's
+// hmac_keys.
+func NewKeySet(currentKID string, secrets map[string]string) (*KeySet, error) {
+	ks := &KeySet{CurrentKID: currentKID, Keys: make(map[string][]byte, len(secrets))}
+	for kid, s := range secrets {
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+		if err != nil || len(key) == 0 {
+			return nil, fmt.Errorf("hmac key %s: not base64", kid)
+		}
+		ks.Keys[kid] = key
+	}
+	if _, ok := ks.Keys[ks.CurrentKID]; !ok {
+		return nil, fmt.Errorf("current kid %s not found", ks.CurrentKID)
+	}
+	return ks, nil
+}
+
+const ctxKeyKeySet ctxKey = "hmac_keyset"
+
+// WithKeySet makes requests with ctx verify against ks instead of the
+// process-wide PortalHMACProvider (tenants).
+func WithKeySet(ctx context.Context, ks *KeySet) context.Context {
+	return context.WithValue(ctx, ctxKeyKeySet, ks)
+}
+
+// keySetFor returns the keyset requests with ctx are verified with.
+func keySetFor(ctx context.Context) *KeySet {
+	if ks, ok := ctx.Value(ctxKeyKeySet).(*KeySet); ok {
+		return ks
+	}
+	return PortalHMACProvider()
+}
+
 func LoadPortalHMACKeySet() (*KeySet, error) {
 	ks := &KeySet{
 		Keys: make(map[string][]byte),
@@ -0,0 +1,42 @@
+package security
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strconv"
+)
+
+// -------------------------------------------------------------------
+// Sponsor approval links
+// -------------------------------------------------------------------
+
+// NewSponsorRequestID returns the id of a sponsored access request. The
+// portal polls the request by id, so it is long enough not to be guessed.
+func NewSponsorRequestID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "sr_" + hex.EncodeToString(b), nil
+}
+
+// SignSponsorLink signs the decision action ("approve" / "deny") on
+// request id, valid until exp (unix seconds).
+func SignSponsorLink(secret []byte, id, action string, exp int64) string {
+	m := hmac.New(sha256.New, secret)
+	m.Write([]byte(id + "\n" + action + "\n" + strconv.FormatInt(exp, 10)))
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
+
+// VerifySponsorLink checks a link signed by SignSponsorLink and that it
+// has not expired at now.
+func VerifySponsorLink(secret []byte, id, action string, exp int64, sig string, now int64) bool {
+	if len(secret) == 0 || now > exp {
+		return false
+	}
+	want := SignSponsorLink(secret, id, action, exp)
+	return hmac.Equal([]byte(want), []byte(sig))
+}
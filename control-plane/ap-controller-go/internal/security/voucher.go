@@ -0,0 +1,94 @@
+package security
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"math/big"
+	"strings"
+)
+
+// -------------------------------------------------------------------
+// Guest voucher codes
+// -------------------------------------------------------------------
+
+// voucherAlphabet is Crockford's base32: no I, L, O or U, so codes read
+// off paper are unambiguous. On input I / L are read as 1 and O as 0.
+const voucherAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// VoucherCodeLen is the length of a code without dashes: 11 random
+// symbols (55 bits) and a check symbol.
+const VoucherCodeLen = 12
+
+// NewVoucherCode returns a random code in its stored form (no dashes).
+func NewVoucherCode() (string, error) {
+	max := big.NewInt(int64(len(voucherAlphabet)))
+	b := make([]byte, VoucherCodeLen-1)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = voucherAlphabet[n.Int64()]
+	}
+	return string(b) + string(voucherCheck(string(b))), nil
+}
+
+// NewVoucherID returns a voucher or batch id: prefix and 12 hex digits.
+// Ids are not secret; the code is.
+func NewVoucherID(prefix string) (string, error) {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return prefix + hex.EncodeToString(b), nil
+}
+
+// NormalizeVoucherCode turns a typed code into its stored form. It
+// ignores case, dashes and spaces and reports false if the code has the
+// wrong length, unknown symbols or a bad check symbol, so typos never
+// reach redis.
+func NormalizeVoucherCode(in string) (string, bool) {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(in) {
+		switch r {
+		case '-', ' ':
+			continue
+		case 'O':
+			r = '0'
+		case 'I', 'L':
+			r = '1'
+		}
+		if !strings.ContainsRune(voucherAlphabet, r) {
+			return "", false
+		}
+		b.WriteRune(r)
+	}
+	code := b.String()
+	if len(code) != VoucherCodeLen || voucherCheck(code[:VoucherCodeLen-1]) != code[VoucherCodeLen-1] {
+		return "", false
+	}
+	return code, true
+}
+
+// FormatVoucherCode groups a stored code for printing: XXXX-XXXX-XXXX.
+func FormatVoucherCode(code string) string {
+	var parts []string
+	for len(code) > 4 {
+		parts = append(parts, code[:4])
+		code = code[4:]
+	}
+	return strings.Join(append(parts, code), "-")
+}
+
+// voucherCheck is the Luhn mod 32 check symbol of s. It catches every
+// single wrong symbol and most swaps of neighbours.
+func voucherCheck(s string) byte {
+	n := len(voucherAlphabet)
+	factor, sum := 2, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		addend := factor * strings.IndexByte(voucherAlphabet, s[i])
+		factor = 3 - factor
+		sum += addend/n + addend%n
+	}
+	return voucherAlphabet[(n-sum%n)%n]
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// CertReloader serves the controller certificate and client CA pool from
+// disk and picks up rotated files without a restart.
+//
+// Files are re-checked (by modification time) at most once per interval,
+// on the next handshake. Reload forces a re-read, e.g. on SIGHUP.
+// A failed reload keeps serving the previous certificate.
+type CertReloader struct {
+	certFile, keyFile, caFile string
+	interval                  time.Duration
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	pool    *x509.CertPool
+	modTime time.Time
+	checked time.Time
+}
+
+// NewCertReloader loads the initial certificate (and client CA bundle,
+// if caFile is set). Unlike later reloads, a failure here is fatal.
+func NewCertReloader(certFile, keyFile, caFile string, interval time.Duration) (*CertReloader, error) {
+	c := &CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		caFile:   caFile,
+		interval: interval,
+	}
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Reload re-reads the certificate, key and client CA bundle.
+func (c *CertReloader) Reload() error {
+	mod, err := c.latestModTime()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("load tls key pair: %w", err)
+	}
+
+	var pool *x509.CertPool
+	if c.caFile != "" {
+		pem, err := os.ReadFile(c.caFile)
+		if err != nil {
+			return fmt.Errorf("read client ca: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return errors.New("client ca: no certificates found in " + c.caFile)
+		}
+	}
+
+	c.mu.Lock()
+	c.cert, c.pool, c.modTime = &cert, pool, mod
+	c.checked = time.Now()
+	c.mu.Unlock()
+	return nil
+}
+
+// Certificate returns the current server certificate.
+func (c *CertReloader) Certificate() *tls.Certificate {
+	c.maybeReload()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert
+}
+
+// ClientCAs returns the current client CA pool (nil without client_ca_file).
+func (c *CertReloader) ClientCAs() *x509.CertPool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.pool
+}
+
+// maybeReload reloads if the interval elapsed and any file changed.
+func (c *CertReloader) maybeReload() {
+	if c.interval <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	if time.Since(c.checked) < c.interval {
+		c.mu.Unlock()
+		return
+	}
+	c.checked = time.Now()
+	last := c.modTime
+	c.mu.Unlock()
+
+	mod, err := c.latestModTime()
+	if err != nil {
+		log.Printf("tls: stat certificate files failed: %v", err)
+		return
+	}
+	if !mod.After(last) {
+		return
+	}
+	if err := c.Reload(); err != nil {
+		log.Printf("tls: reload failed, keeping previous certificate: %v", err)
+		return
+	}
+	log.Printf("tls: certificate reloaded")
+}
+
+func (c *CertReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, f := range []string{c.certFile, c.keyFile, c.caFile} {
+		if f == "" {
+			continue
+		}
+		fi, err := os.Stat(f)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
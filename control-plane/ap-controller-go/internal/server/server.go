@@ -0,0 +1,154 @@
+// Package server runs the controller HTTP(S) listener: optional TLS with
+// hot certificate reload and mutual TLS for APs, server timeouts, and a
+// graceful drain on shutdown.
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"ap-controller-go/internal/config"
+)
+
+// Options configures New.
+type Options struct {
+	Addr     string
+	Handler  http.Handler
+	TLS      config.TLS
+	Timeouts config.Timeouts
+
+	// VerifyClient, if set, runs after chain verification for every
+	// client certificate (e.g. a revocation check). An error aborts the
+	// handshake.
+	VerifyClient func(cert *x509.Certificate) error
+}
+
+// Server wraps an http.Server.
+type Server struct {
+	http     *http.Server
+	certs    *CertReloader
+	shutdown time.Duration
+}
+
+func seconds(n int) time.Duration {
+	return time.Duration(n) * time.Second
+}
+
+// New builds the server. With TLS enabled the certificate files are
+// loaded immediately so a bad path fails at startup.
+func New(opts Options) (*Server, error) {
+	t := opts.Timeouts
+	s := &Server{
+		http: &http.Server{
+			Addr:              opts.Addr,
+			Handler:           opts.Handler,
+			ReadHeaderTimeout: seconds(t.ReadHeader),
+			ReadTimeout:       seconds(t.Read),
+			WriteTimeout:      seconds(t.Write),
+			IdleTimeout:       seconds(t.Idle),
+		},
+		shutdown: seconds(t.Shutdown),
+	}
+	if !opts.TLS.Enabled {
+		return s, nil
+	}
+
+	caFile := ""
+	if opts.TLS.ClientAuth == config.ClientAuthRequest || opts.TLS.ClientAuth == config.ClientAuthRequire {
+		caFile = opts.TLS.ClientCAFile
+	}
+	certs, err := NewCertReloader(opts.TLS.CertFile, opts.TLS.KeyFile, caFile, seconds(opts.TLS.ReloadInterval))
+	if err != nil {
+		return nil, err
+	}
+	s.certs = certs
+	s.http.TLSConfig = tlsConfig(certs, opts.TLS.ClientAuth, opts.VerifyClient)
+	return s, nil
+}
+
+// tlsConfig resolves the certificate and client CA pool per handshake,
+// so reloaded files apply to new connections only.
+func tlsConfig(certs *CertReloader, clientAuth string, verify func(*x509.Certificate) error) *tls.Config {
+	base := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return certs.Certificate(), nil
+		},
+	}
+	switch clientAuth {
+	case config.ClientAuthRequest:
+		base.ClientAuth = tls.VerifyClientCertIfGiven
+	case config.ClientAuthRequire:
+		base.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	if verify != nil {
+		base.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.VerifiedChains) == 0 {
+				return nil
+			}
+			return verify(cs.VerifiedChains[0][0])
+		}
+	}
+
+	cfg := base.Clone()
+	cfg.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		c := base.Clone()
+		c.ClientCAs = certs.ClientCAs()
+		return c, nil
+	}
+	return cfg
+}
+
+// TLSEnabled reports whether the server terminates TLS itself.
+func (s *Server) TLSEnabled() bool {
+	return s.certs != nil
+}
+
+// Reload re-reads the TLS certificate files (no-op without TLS).
+func (s *Server) Reload() error {
+	if s.certs == nil {
+		return nil
+	}
+	return s.certs.Reload()
+}
+
+// Run listens on the configured address and serves until ctx is done.
+func (s *Server) Run(ctx context.Context) error {
+	ln, err := net.Listen("tcp", s.http.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, ln)
+}
+
+// Serve serves on ln until ctx is done, then stops accepting connections
+// and waits up to the shutdown timeout for in-flight requests.
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	errc := make(chan error, 1)
+	go func() {
+		if s.certs != nil {
+			errc <- s.http.ServeTLS(ln, "", "")
+		} else {
+			errc <- s.http.Serve(ln)
+		}
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	sctx, cancel := context.WithTimeout(context.Background(), s.shutdown)
+	defer cancel()
+	err := s.http.Shutdown(sctx)
+	if serr := <-errc; !errors.Is(serr, http.ErrServerClosed) && err == nil {
+		err = serr
+	}
+	return err
+}
@@ -0,0 +1,147 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Admin API keys.
+//
+// key: <prefix>admin:key:<id>  (STRING json AdminKey, no TTL)
+// key: <prefix>admin:keys      (ZSET member=id, score=created ts)
+// key: <prefix>admin:keys:used (HASH id -> last use ts)
+//
+// Revoked keys are kept, so the list shows who could do what and when.
+
+// AdminKey is a stored API key. Hash is sha256 of the secret.
+type AdminKey struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Role      string `json:"role"`
+	Hash      string `json:"hash"`
+	CreatedBy string `json:"created_by"`
+	Created   int64  `json:"created"`
+	Expires   int64  `json:"expires,omitempty"`
+	Revoked   int64  `json:"revoked,omitempty"`
+	RevokedBy string `json:"revoked_by,omitempty"`
+
+	// LastUsed is filled by GetAdminKey / ListAdminKeys
+	LastUsed int64 `json:"-"`
+}
+
+func (s *Store) adminKeyKey(id string) string {
+	return s.RawKey("admin", "key", id)
+}
+
+// CreateAdminKey stores a new key. It returns false if the id is taken.
+func (s *Store) CreateAdminKey(ctx context.Context, k AdminKey) (bool, error) {
+	b, err := json.Marshal(k)
+	if err != nil {
+		return false, err
+	}
+	ok, err := s.rdb.SetNX(ctx, s.adminKeyKey(k.ID), b, 0).Result()
+	if err != nil || !ok {
+		return false, err
+	}
+	return true, s.rdb.ZAdd(ctx, s.RawKey("admin", "keys"), redis.Z{Score: float64(k.Created), Member: k.ID}).Err()
+}
+
+// GetAdminKey returns the key with id, or nil.
+func (s *Store) GetAdminKey(ctx context.Context, id string) (*AdminKey, error) {
+	pipe := s.rdb.Pipeline()
+	get := pipe.Get(ctx, s.adminKeyKey(id))
+	used := pipe.HGet(ctx, s.RawKey("admin", "keys", "used"), id)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+	raw, err := get.Bytes()
+	if err != nil {
+		return nil, nil
+	}
+	var k AdminKey
+	if err := json.Unmarshal(raw, &k); err != nil {
+		return nil, err
+	}
+	k.LastUsed, _ = used.Int64()
+	return &k, nil
+}
+
+// ListAdminKeys returns all keys, oldest first.
+func (s *Store) ListAdminKeys(ctx context.Context) ([]AdminKey, error) {
+	ids, err := s.rdb.ZRange(ctx, s.RawKey("admin", "keys"), 0, -1).Result()
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = s.adminKeyKey(id)
+	}
+	vals, err := s.rdb.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	used, err := s.rdb.HGetAll(ctx, s.RawKey("admin", "keys", "used")).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]AdminKey, 0, len(vals))
+	for _, v := range vals {
+		raw, ok := v.(string)
+		if !ok {
+			continue
+		}
+		var k AdminKey
+		if json.Unmarshal([]byte(raw), &k) != nil {
+			continue
+		}
+		k.LastUsed, _ = strconv.ParseInt(used[k.ID], 10, 64)
+		out = append(out, k)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Created < out[j].Created })
+	return out, nil
+}
+
+// RevokeAdminKey marks a key revoked. It returns nil if there is no such
+// key; an already revoked key is returned unchanged.
+func (s *Store) RevokeAdminKey(ctx context.Context, id, by string, now int64) (*AdminKey, error) {
+	var out *AdminKey
+	k := s.adminKeyKey(id)
+	err := s.rdb.Watch(ctx, func(tx *redis.Tx) error {
+		raw, err := tx.Get(ctx, k).Bytes()
+		if err == redis.Nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var key AdminKey
+		if err := json.Unmarshal(raw, &key); err != nil {
+			return err
+		}
+		out = &key
+		if key.Revoked != 0 {
+			return nil
+		}
+		key.Revoked, key.RevokedBy = now, by
+		b, err := json.Marshal(key)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(p redis.Pipeliner) error {
+			p.Set(ctx, k, b, 0)
+			return nil
+		})
+		return err
+	}, k)
+	return out, err
+}
+
+// TouchAdminKey records a use of the key.
+func (s *Store) TouchAdminKey(ctx context.Context, id string, now int64) error {
+	return s.rdb.HSet(ctx, s.RawKey("admin", "keys", "used"), id, now).Err()
+}
@@ -0,0 +1,206 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// AP inventory.
+//
+// key: <prefix>ap:<ap_id>  (STRING json APRecord, no TTL)
+// key: <prefix>aps         (ZSET member=ap_id, score=last heartbeat ts)
+// key: <prefix>aps:offline (SET ap_id, the records marked offline)
+//
+// Records are kept while an AP is offline; only an admin delete removes
+// them.
+
+// APRecord is what the controller knows about one AP.
+type APRecord struct {
+	APID           string `json:"ap_id"`
+	Site           string `json:"site,omitempty"`
+	Hostname       string `json:"hostname,omitempty"`
+	Firmware       string `json:"firmware,omitempty"`
+	LanIF          string `json:"lan_if,omitempty"`
+	Uptime         int64  `json:"uptime,omitempty"`
+	PolicyChecksum string `json:"policy_checksum,omitempty"`
+	Addr           string `json:"addr,omitempty"`
+
+	FirstSeen int64 `json:"first_seen"`
+	LastSeen  int64 `json:"last_seen"`
+
+	// Offline is set by the offline monitor and cleared by the next
+	// heartbeat; it makes the transition (and its alert) happen once.
+	Offline      bool  `json:"offline,omitempty"`
+	OfflineSince int64 `json:"offline_since,omitempty"`
+
+	// Policy acknowledgement. PolicyChecksum is the policy the AP runs;
+	// a failed apply leaves it unchanged and records FailedChecksum.
+	PolicyVersion  string `json:"policy_version,omitempty"`
+	PolicyStatus   string `json:"policy_status,omitempty"` // applied | failed
+	PolicyError    string `json:"policy_error,omitempty"`
+	PolicyAckAt    int64  `json:"policy_ack_at,omitempty"`
+	FailedChecksum string `json:"failed_checksum,omitempty"`
+	StaleAlerted   string `json:"stale_alerted,omitempty"` // checksum the stale alert fired for
+}
+
+// ErrSkipUpdate may be returned by an UpdateAP callback to leave the
+// record unchanged.
+var ErrSkipUpdate = errors.New("skip update")
+
+func (s *Store) apKey(apID string) string {
+	return s.RawKey("ap", apID)
+}
+
+func (s *Store) apIndexKey() string {
+	return s.RawKey("aps")
+}
+
+func (s *Store) apOfflineKey() string {
+	return s.RawKey("aps", "offline")
+}
+
+// staleAPs returns the members of KEYS[1] scored at or below ARGV[1]
+// that are not in KEYS[2].
+var staleAPs = redis.NewScript(`
+local ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+local out = {}
+for _, id in ipairs(ids) do
+  if redis.call('SISMEMBER', KEYS[2], id) == 0 then
+    out[#out+1] = id
+  end
+end
+return out
+`)
+
+// UpdateAP atomically applies fn to the record of apID. fn receives a
+// zero record (with APID set) and exists=false for unknown APs.
+// It returns (nil, nil) if fn returned ErrSkipUpdate.
+func (s *Store) UpdateAP(ctx context.Context, apID string, fn func(rec *APRecord, exists bool) error) (*APRecord, error) {
+	k := s.apKey(apID)
+	var out *APRecord
+
+	txf := func(tx *redis.Tx) error {
+		out = nil
+		rec := APRecord{APID: apID}
+		exists := true
+		val, err := tx.Get(ctx, k).Result()
+		switch {
+		case err == redis.Nil:
+			exists = false
+		case err != nil:
+			return err
+		default:
+			if err := json.Unmarshal([]byte(val), &rec); err != nil {
+				return err
+			}
+		}
+
+		if err := fn(&rec, exists); err != nil {
+			return err
+		}
+		b, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, k, b, 0)
+			pipe.ZAdd(ctx, s.apIndexKey(), redis.Z{Score: float64(rec.LastSeen), Member: apID})
+			if rec.Offline {
+				pipe.SAdd(ctx, s.apOfflineKey(), apID)
+			} else {
+				pipe.SRem(ctx, s.apOfflineKey(), apID)
+			}
+			return nil
+		})
+		if err == nil {
+			out = &rec
+		}
+		return err
+	}
+
+	for i := 0; i < 3; i++ {
+		err := s.watch(ctx, txf, k)
+		if err == redis.TxFailedErr {
+			continue
+		}
+		if errors.Is(err, ErrSkipUpdate) {
+			return nil, nil
+		}
+		return out, err
+	}
+	return nil, ErrConflict
+}
+
+// GetAP returns the record of apID or nil if unknown.
+func (s *Store) GetAP(ctx context.Context, apID string) (*APRecord, error) {
+	b, err := s.rdb.Get(ctx, s.apKey(apID)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rec APRecord
+	if err := json.Unmarshal(b, &rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// ListAPs returns all registered APs ordered by ap_id.
+func (s *Store) ListAPs(ctx context.Context) ([]APRecord, error) {
+	ids, err := s.rdb.ZRange(ctx, s.apIndexKey(), 0, -1).Result()
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = s.apKey(id)
+	}
+	vals, err := s.rdb.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]APRecord, 0, len(vals))
+	for _, v := range vals {
+		str, ok := v.(string)
+		if !ok {
+			continue
+		}
+		var rec APRecord
+		if json.Unmarshal([]byte(str), &rec) == nil {
+			out = append(out, rec)
+		}
+	}
+	sortAPs(out)
+	return out, nil
+}
+
+// StaleAPs returns the ap_ids whose last heartbeat is at or before ts
+// and that are not marked offline yet.
+func (s *Store) StaleAPs(ctx context.Context, ts int64) ([]string, error) {
+	keys := []string{s.apIndexKey(), s.apOfflineKey()}
+	return staleAPs.Run(ctx, s.rdb, keys, strconv.FormatInt(ts, 10)).StringSlice()
+}
+
+// DeleteAP removes apID from the inventory.
+func (s *Store) DeleteAP(ctx context.Context, apID string) (bool, error) {
+	pipe := s.rdb.TxPipeline()
+	del := pipe.Del(ctx, s.apKey(apID))
+	pipe.ZRem(ctx, s.apIndexKey(), apID)
+	pipe.SRem(ctx, s.apOfflineKey(), apID)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, err
+	}
+	return del.Val() > 0, nil
+}
+
+func sortAPs(aps []APRecord) {
+	sort.Slice(aps, func(i, j int) bool { return aps[i].APID < aps[j].APID })
+}
@@ -0,0 +1,153 @@
+package store
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Bulk session access. Each call is a single pipelined round trip; callers
+// split large inputs into pages (controller.batch.pipeline_size).
+
+// SessionStatus is one result of GetSessions. Session is nil when the MAC
+// has no (decodable) session.
+type SessionStatus struct {
+	MAC     string
+	Session *SessionV2
+	TTL     int
+}
+
+// GetSessions looks up many sessions with one MGET plus one TTL per key,
+// all in a single pipeline. Results are in the order of macs. Records of
+// an older schema are upgraded in one more pipeline.
+func (s *Store) GetSessions(ctx context.Context, macs []string) ([]SessionStatus, error) {
+	out := make([]SessionStatus, len(macs))
+	if len(macs) == 0 {
+		return out, nil
+	}
+
+	keys := make([]string, len(macs))
+	for i, m := range macs {
+		keys[i] = s.key(m)
+		out[i].MAC = m
+	}
+
+	pipe := s.rdb.Pipeline()
+	mget := pipe.MGet(ctx, keys...)
+	ttls := make([]*redis.DurationCmd, len(keys))
+	for i, k := range keys {
+		ttls[i] = pipe.TTL(ctx, k)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	var ups []sessionUpgrade
+	for i, v := range mget.Val() {
+		raw, ok := v.(string)
+		if !ok {
+			continue
+		}
+		sess, upgraded, err := s.decodeSession(macs[i], []byte(raw))
+		if err != nil {
+			continue
+		}
+		if upgraded {
+			ups = append(ups, sessionUpgrade{key: keys[i], old: raw, sess: sess})
+		}
+		out[i].Session = &sess
+		if ttl := int(ttls[i].Val() / time.Second); ttl > 0 {
+			out[i].TTL = ttl
+		}
+	}
+	if _, err := s.writeUpgrades(ctx, ups); err != nil {
+		log.Printf("store: upgrade %d sessions failed: %v", len(ups), err)
+	}
+	return out, nil
+}
+
+// SessionWrite is one session stored by SetSessions.
+type SessionWrite struct {
+	Session SessionV2
+	TTL     int
+}
+
+// SetSessions stores many sessions in one pipeline, like SetSession, and
+// returns the sessions they replaced (SET GET; nil where there was none),
+// in the order of writes.
+func (s *Store) SetSessions(ctx context.Context, writes []SessionWrite) ([]*SessionV2, error) {
+	out := make([]*SessionV2, len(writes))
+	if len(writes) == 0 {
+		return out, nil
+	}
+	now := time.Now().Unix()
+	pipe := s.rdb.Pipeline()
+	cmds := make([]*redis.StatusCmd, len(writes))
+	for i, w := range writes {
+		sess := w.Session
+		if sess.TS.Created == 0 {
+			sess.TS.Created = now
+		}
+		sess.TS.Updated = now
+
+		b, err := encodeSession(sess)
+		if err != nil {
+			return nil, err
+		}
+		cmds[i] = pipe.SetArgs(ctx, s.key(sess.MAC), string(b), redis.SetArgs{
+			TTL: time.Duration(w.TTL) * time.Second,
+			Get: true,
+		})
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	for i, c := range cmds {
+		raw, err := c.Result()
+		if err != nil {
+			continue
+		}
+		mac := writes[i].Session.MAC
+		old, _, err := s.decodeSession(mac, []byte(raw))
+		if err != nil {
+			old = SessionV2{MAC: mac}
+		}
+		out[i] = &old
+	}
+	return out, nil
+}
+
+// DeleteSessions removes many sessions in one pipeline and returns the
+// ones that existed (GETDEL), in the order of macs.
+func (s *Store) DeleteSessions(ctx context.Context, macs []string) ([]*SessionV2, error) {
+	out := make([]*SessionV2, len(macs))
+	if len(macs) == 0 {
+		return out, nil
+	}
+
+	pipe := s.rdb.Pipeline()
+	cmds := make([]*redis.StringCmd, len(macs))
+	for i, m := range macs {
+		cmds[i] = pipe.GetDel(ctx, s.key(m))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	for i, c := range cmds {
+		raw, err := c.Result()
+		if err != nil {
+			continue
+		}
+		sess, _, err := s.decodeSession(macs[i], []byte(raw))
+		if err != nil {
+			// the key is gone either way
+			sess = SessionV2{MAC: macs[i]}
+		}
+		out[i] = &sess
+	}
+	return out, nil
+}
@@ -0,0 +1,154 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"sort"
+	"strings"
+
+	"ap-controller-go/internal/config"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Dynamic bypass entries, added through the admin API next to the static
+// bypass block of the config.
+//
+// key: <prefix>bypass:entries  (HASH id -> json BypassEntry, no TTL)
+//
+// The id is derived from type, scope and value, so adding an entry again
+// replaces it. Expired entries stop applying at once and are dropped
+// from the hash the next time the list is read.
+
+// Bypass entry types.
+const (
+	BypassMAC    = "mac"
+	BypassIP     = "ip"
+	BypassDomain = "domain"
+)
+
+// BypassEntry is one dynamic bypass entry. Scope is one of
+// policy.ScopeGlobal / ScopeSite / ScopeAP; Site is set for the site
+// scope, APID for the ap scope.
+type BypassEntry struct {
+	ID        string `json:"id"`
+	Type      string `json:"type"`
+	Value     string `json:"value"`
+	Scope     string `json:"scope"`
+	Site      string `json:"site,omitempty"`
+	APID      string `json:"ap_id,omitempty"`
+	Reason    string `json:"reason"`
+	CreatedBy string `json:"created_by"`
+	Created   int64  `json:"created"`
+	Expires   int64  `json:"expires,omitempty"`
+}
+
+// Active reports whether e still applies at now.
+func (e BypassEntry) Active(now int64) bool {
+	return e.Expires == 0 || now < e.Expires
+}
+
+// BypassEntryID is the id of the entry for value of typ in a scope.
+func BypassEntryID(typ, scope, site, apID, value string) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{typ, scope, site, apID, value}, "\x00")))
+	return "byp_" + hex.EncodeToString(sum[:8])
+}
+
+// ParseBypassValue normalizes a MAC, an IP or CIDR, or a domain.
+func ParseBypassValue(typ, v string) (string, bool) {
+	v = strings.ToLower(strings.TrimSpace(v))
+	switch typ {
+	case BypassMAC:
+		hw, err := net.ParseMAC(v)
+		if err != nil || len(hw) != 6 {
+			return "", false
+		}
+		return hw.String(), true
+	case BypassIP:
+		if ip := net.ParseIP(v); ip != nil {
+			return ip.String(), true
+		}
+		if _, n, err := net.ParseCIDR(v); err == nil {
+			return n.String(), true
+		}
+		return "", false
+	case BypassDomain:
+		v = strings.TrimSuffix(v, ".")
+		if !config.ValidHostname(v) {
+			return "", false
+		}
+		return v, true
+	}
+	return "", false
+}
+
+func (s *Store) bypassKey() string { return s.RawKey("bypass", "entries") }
+
+// AddBypass stores e, replacing an entry with the same id.
+func (s *Store) AddBypass(ctx context.Context, e BypassEntry) error {
+	raw, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return s.rdb.HSet(ctx, s.bypassKey(), e.ID, raw).Err()
+}
+
+// RemoveBypass deletes the entry id. It returns the removed entry, or
+// nil if there was none.
+func (s *Store) RemoveBypass(ctx context.Context, id string) (*BypassEntry, error) {
+	raw, err := s.rdb.HGet(ctx, s.bypassKey(), id).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var e BypassEntry
+	if err := json.Unmarshal(raw, &e); err != nil {
+		return nil, err
+	}
+	n, err := s.rdb.HDel(ctx, s.bypassKey(), id).Result()
+	if err != nil || n == 0 {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// ListBypass returns the entries active at now, ordered by type, value
+// and id.
+func (s *Store) ListBypass(ctx context.Context, now int64) ([]BypassEntry, error) {
+	all, err := s.rdb.HGetAll(ctx, s.bypassKey()).Result()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]BypassEntry, 0, len(all))
+	var expired []string
+	for id, raw := range all {
+		var e BypassEntry
+		if json.Unmarshal([]byte(raw), &e) != nil {
+			continue
+		}
+		if !e.Active(now) {
+			expired = append(expired, id)
+			continue
+		}
+		out = append(out, e)
+	}
+	if len(expired) > 0 {
+		_ = s.rdb.HDel(ctx, s.bypassKey(), expired...).Err()
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		if a.Value != b.Value {
+			return a.Value < b.Value
+		}
+		return a.ID < b.ID
+	})
+	return out, nil
+}
@@ -0,0 +1,131 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Authorized-client change log.
+//
+// key: <prefix>clients:log  (STREAM, field "change" = json, trimmed to ~maxLen)
+//
+// Every session add / refresh / removal is appended so data-planes can
+// apply deltas to their ipsets. Sessions that simply expire are not
+// logged: the ipset entry times out with the same TTL.
+
+// Client change ops.
+const (
+	ClientAdd     = "add"
+	ClientRefresh = "refresh"
+	ClientRemove  = "remove"
+)
+
+// ClientChange is one change-log entry. TTL is 0 for removals; APID is
+// the AP of the session ("" = not bound to an AP).
+type ClientChange struct {
+	Op    string `json:"op"`
+	MAC   string `json:"mac"`
+	APID  string `json:"ap_id,omitempty"`
+	Group string `json:"group,omitempty"`
+	TTL   int    `json:"ttl,omitempty"`
+	TS    int64  `json:"ts"`
+}
+
+// ClientLogEntry is a change with its stream id (the cursor).
+type ClientLogEntry struct {
+	ID string
+	ClientChange
+}
+
+func (s *Store) clientLogKey() string {
+	return s.RawKey("clients", "log")
+}
+
+// AppendClientChanges logs changes in one pipeline.
+func (s *Store) AppendClientChanges(ctx context.Context, changes []ClientChange, maxLen int64) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	pipe := s.rdb.Pipeline()
+	for _, c := range changes {
+		b, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: s.clientLogKey(),
+			MaxLen: maxLen,
+			Approx: true,
+			Values: []any{"change", string(b)},
+		})
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// ClientChangesAfter returns up to count changes with an id greater
+// than after.
+func (s *Store) ClientChangesAfter(ctx context.Context, after string, count int64) ([]ClientLogEntry, error) {
+	msgs, err := s.rdb.XRangeN(ctx, s.clientLogKey(), "("+after, "+", count).Result()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]ClientLogEntry, 0, len(msgs))
+	for _, m := range msgs {
+		v, _ := m.Values["change"].(string)
+		e := ClientLogEntry{ID: m.ID}
+		if json.Unmarshal([]byte(v), &e.ClientChange) == nil {
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+
+// ClientLogBounds returns the first and last ids in the log ("" if empty).
+func (s *Store) ClientLogBounds(ctx context.Context) (first, last string, err error) {
+	f, err := s.rdb.XRangeN(ctx, s.clientLogKey(), "-", "+", 1).Result()
+	if err != nil || len(f) == 0 {
+		return "", "", err
+	}
+	l, err := s.rdb.XRevRangeN(ctx, s.clientLogKey(), "+", "-", 1).Result()
+	if err != nil || len(l) == 0 {
+		return "", "", err
+	}
+	return f[0].ID, l[0].ID, nil
+}
+
+// ScanSessions calls fn with every stored session and its TTL, a page
+// (SCAN + pipelined MGET / TTL) at a time.
+func (s *Store) ScanSessions(ctx context.Context, fn func([]SessionStatus)) error {
+	iter := s.rdb.Scan(ctx, 0, s.prefix+macPattern, 500).Iterator()
+
+	batch := make([]string, 0, 500)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		res, err := s.GetSessions(ctx, batch)
+		if err != nil {
+			return err
+		}
+		fn(res)
+		batch = batch[:0]
+		return nil
+	}
+
+	for iter.Next(ctx) {
+		batch = append(batch, strings.TrimPrefix(iter.Val(), s.prefix))
+		if len(batch) == cap(batch) {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
@@ -0,0 +1,214 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"ap-controller-go/internal/config"
+)
+
+// Session records are versioned by their "schema" field:
+//
+//	1  plain role string, as written by the Python controller
+//	2  SessionV2 JSON (current)
+//
+// decodeSession reads every known schema into SessionV2; records of an
+// older schema are upgraded when read (GetSessionFull, GetSessions) or
+// rewritten (UpdateSession), and by the background migration.
+//
+// During a rolling upgrade a newer controller may write schemas this one
+// does not know. They are decoded best-effort: known fields are read,
+// unknown top-level fields are kept and written back as they were, and
+// the record keeps its schema, so this controller never downgrades it.
+
+// SessionSchema is the schema written by this controller.
+const SessionSchema = 2
+
+// sessionDecoders decode the known schemas older than or equal to
+// SessionSchema.
+var sessionDecoders = map[int]func(s *Store, mac string, raw []byte) (SessionV2, error){
+	1: (*Store).decodeSessionV1,
+	2: (*Store).decodeSessionV2,
+}
+
+// decodeSession decodes the record raw of mac. upgraded reports that it
+// was of an older schema and should be written back.
+func (s *Store) decodeSession(mac string, raw []byte) (sess SessionV2, upgraded bool, err error) {
+	schema := 1
+	if trimmed := bytes.TrimSpace(raw); len(trimmed) > 0 && trimmed[0] == '{' {
+		var head struct {
+			Schema int `json:"schema"`
+		}
+		if err := json.Unmarshal(raw, &head); err != nil {
+			return SessionV2{}, false, err
+		}
+		schema = head.Schema
+		if schema == 0 {
+			schema = SessionSchema
+		}
+	}
+
+	if schema > SessionSchema {
+		sess, err = s.decodeSessionNewer(raw)
+		return sess, false, err
+	}
+	dec, ok := sessionDecoders[schema]
+	if !ok {
+		return SessionV2{}, false, fmt.Errorf("unknown session schema %d", schema)
+	}
+	sess, err = dec(s, mac, raw)
+	if err != nil {
+		return SessionV2{}, false, err
+	}
+	if sess.MAC == "" {
+		sess.MAC = mac
+	}
+	upgraded = schema < SessionSchema
+	sess.Schema = SessionSchema
+	return sess, upgraded, nil
+}
+
+// decodeSessionV1 upgrades a legacy role string. Profile and attributes
+// come from the role's current profile; the rest was never recorded.
+func (s *Store) decodeSessionV1(mac string, raw []byte) (SessionV2, error) {
+	role := strings.TrimSpace(string(raw))
+	if role == "" {
+		return SessionV2{}, fmt.Errorf("empty legacy session")
+	}
+	sess := SessionV2{MAC: mac, Role: role}
+	sess.Auth.Source = "legacy"
+	if s.cfg != nil {
+		if rd, ok := s.cfg.Roles[role]; ok {
+			sess.Profile = rd.Profile
+			sess.ApplyProfile(s.cfg.Profiles[rd.Profile])
+		}
+	}
+	now := time.Now().Unix()
+	sess.TS.Created, sess.TS.Updated = now, now
+	return sess, nil
+}
+
+func (s *Store) decodeSessionV2(_ string, raw []byte) (SessionV2, error) {
+	var sess SessionV2
+	err := json.Unmarshal(raw, &sess)
+	return sess, err
+}
+
+// decodeSessionNewer reads a record of a newer schema, keeping the fields
+// SessionV2 does not know.
+func (s *Store) decodeSessionNewer(raw []byte) (SessionV2, error) {
+	var sess SessionV2
+	if err := json.Unmarshal(raw, &sess); err != nil {
+		return SessionV2{}, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return SessionV2{}, err
+	}
+	for _, k := range sessionFields {
+		delete(fields, k)
+	}
+	if len(fields) > 0 {
+		sess.extra = fields
+	}
+	return sess, nil
+}
+
+// sessionFields are the top-level JSON fields of SessionV2.
+var sessionFields = []string{"schema", "mac", "role", "profile", "policy_version", "rule", "ap", "attrs", "auth", "usage", "ts"}
+
+// encodeSession encodes sess for storage: at SessionSchema unless it was
+// read from a newer schema, whose unknown fields are written back.
+func encodeSession(sess SessionV2) ([]byte, error) {
+	if sess.Schema < SessionSchema {
+		sess.Schema = SessionSchema
+	}
+	b, err := json.Marshal(sess)
+	if err != nil || len(sess.extra) == 0 {
+		return b, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, err
+	}
+	for k, v := range sess.extra {
+		if _, ok := fields[k]; !ok {
+			fields[k] = v
+		}
+	}
+	return json.Marshal(fields)
+}
+
+// ApplyProfile copies the enforcement attributes of p into sess.
+func (sess *SessionV2) ApplyProfile(p config.Profile) {
+	sess.Attrs.VLAN = p.VLAN
+	sess.Attrs.FirewallGroup = p.FirewallGroup
+	sess.Attrs.UpstreamKbps = p.UpstreamKbps
+	sess.Attrs.DownstreamKbps = p.DownstreamKbps
+	sess.Attrs.IdleTimeout = p.IdleTimeout
+	sess.Attrs.MaxLifetime = p.MaxSessionLifetime
+	sess.Attrs.MaxDevices = p.MaxDevices
+}
+
+// -------------------------------------------------------------------
+// Upgrades
+// -------------------------------------------------------------------
+
+// upgradeSession replaces KEYS[1] with ARGV[2] (keeping its TTL) only if
+// it still holds ARGV[1], so an upgrade never overwrites a newer write.
+var upgradeSession = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+  redis.call('SET', KEYS[1], ARGV[2], 'KEEPTTL')
+  return 1
+end
+return 0
+`)
+
+// sessionUpgrade is a decoded record of an older schema to write back.
+type sessionUpgrade struct {
+	key  string
+	old  string
+	sess SessionV2
+}
+
+// writeUpgrades writes upgraded records back in one pipeline and returns
+// how many were replaced. Records changed since they were read are left
+// to their writer.
+func (s *Store) writeUpgrades(ctx context.Context, ups []sessionUpgrade) (int, error) {
+	if len(ups) == 0 {
+		return 0, nil
+	}
+	encoded := make([][]byte, len(ups))
+	for i, u := range ups {
+		b, err := encodeSession(u.sess)
+		if err != nil {
+			return 0, err
+		}
+		encoded[i] = b
+	}
+	var cmds []*redis.Cmd
+	err := s.fencedPipelined(ctx, func(pipe redis.Pipeliner) error {
+		cmds = cmds[:0]
+		for i, u := range ups {
+			// EVAL, not EVALSHA: a pipeline cannot load a missing script
+			cmds = append(cmds, upgradeSession.Eval(ctx, pipe, []string{u.key}, u.old, string(encoded[i])))
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return 0, err
+	}
+	n := 0
+	for _, c := range cmds {
+		if v, _ := c.Int(); v == 1 {
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,147 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Client denylist. An entry blocks one MAC, or every MAC of a vendor
+// prefix (OUI, the first three octets).
+//
+// key: <prefix>deny:entries  (HASH pattern -> json DenyEntry, no TTL)
+//
+// Expired entries stop matching at once and are dropped from the hash
+// the next time the list is read.
+
+// Deny entry kinds.
+const (
+	DenyMAC = "mac"
+	DenyOUI = "oui"
+)
+
+// DenyEntry is a denylisted MAC or OUI. Pattern is "aa:bb:cc:dd:ee:ff"
+// or "aa:bb:cc".
+type DenyEntry struct {
+	Pattern   string `json:"pattern"`
+	Kind      string `json:"kind"`
+	Reason    string `json:"reason,omitempty"`
+	CreatedBy string `json:"created_by"`
+	Created   int64  `json:"created"`
+	Expires   int64  `json:"expires,omitempty"`
+}
+
+// Active reports whether e still applies at now.
+func (e DenyEntry) Active(now int64) bool {
+	return e.Expires == 0 || now < e.Expires
+}
+
+// ParseDenyPattern normalizes a MAC ("AA-BB-CC-DD-EE-FF") or OUI
+// ("aa:bb:cc", "aa:bb:cc:*") pattern and returns it with its kind.
+func ParseDenyPattern(p string) (string, string, bool) {
+	p = strings.ToLower(strings.TrimSpace(p))
+	if hw, err := net.ParseMAC(p); err == nil && len(hw) == 6 {
+		return hw.String(), DenyMAC, true
+	}
+	p = strings.TrimSuffix(strings.TrimSuffix(p, "*"), ":")
+	p = strings.ReplaceAll(p, "-", ":")
+	// ParseMAC wants at least six octets; pad the prefix to check it
+	if hw, err := net.ParseMAC(p + ":00:00:00"); err == nil && len(hw) == 6 {
+		return hw[:3].String(), DenyOUI, true
+	}
+	return "", "", false
+}
+
+// ouiOf returns the OUI pattern of a normalized MAC.
+func ouiOf(mac string) string {
+	if len(mac) < 8 {
+		return ""
+	}
+	return mac[:8]
+}
+
+func (s *Store) denyKey() string { return s.RawKey("deny", "entries") }
+
+// AddDeny stores e, replacing an entry with the same pattern.
+func (s *Store) AddDeny(ctx context.Context, e DenyEntry) error {
+	raw, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return s.rdb.HSet(ctx, s.denyKey(), e.Pattern, raw).Err()
+}
+
+// RemoveDeny deletes the entry of pattern. It returns false if there was
+// none.
+func (s *Store) RemoveDeny(ctx context.Context, pattern string) (bool, error) {
+	n, err := s.rdb.HDel(ctx, s.denyKey(), pattern).Result()
+	return n > 0, err
+}
+
+// ListDenylist returns the entries active at now, ordered by pattern.
+func (s *Store) ListDenylist(ctx context.Context, now int64) ([]DenyEntry, error) {
+	all, err := s.rdb.HGetAll(ctx, s.denyKey()).Result()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]DenyEntry, 0, len(all))
+	var expired []string
+	for pattern, raw := range all {
+		var e DenyEntry
+		if json.Unmarshal([]byte(raw), &e) != nil {
+			continue
+		}
+		if !e.Active(now) {
+			expired = append(expired, pattern)
+			continue
+		}
+		out = append(out, e)
+	}
+	if len(expired) > 0 {
+		_ = s.rdb.HDel(ctx, s.denyKey(), expired...).Err()
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Pattern < out[j].Pattern })
+	return out, nil
+}
+
+// DenyMatch returns the active entry blocking mac (its own entry before
+// its OUI's), or nil.
+func (s *Store) DenyMatch(ctx context.Context, mac string, now int64) (*DenyEntry, error) {
+	out, err := s.DenyMatches(ctx, []string{mac}, now)
+	if err != nil {
+		return nil, err
+	}
+	return out[0], nil
+}
+
+// DenyMatches is DenyMatch for many MACs in one HMGET: out[i] is the
+// entry of macs[i], or nil.
+func (s *Store) DenyMatches(ctx context.Context, macs []string, now int64) ([]*DenyEntry, error) {
+	out := make([]*DenyEntry, len(macs))
+	if len(macs) == 0 {
+		return out, nil
+	}
+	fields := make([]string, 0, 2*len(macs))
+	for _, m := range macs {
+		fields = append(fields, m, ouiOf(m))
+	}
+	vals, err := s.rdb.HMGet(ctx, s.denyKey(), fields...).Result()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	for i, v := range vals {
+		raw, ok := v.(string)
+		if !ok || out[i/2] != nil {
+			continue
+		}
+		var e DenyEntry
+		if json.Unmarshal([]byte(raw), &e) == nil && e.Active(now) {
+			out[i/2] = &e
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,132 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Per-identity device index.
+//
+// key: <prefix>identity:<identity>:devices  (ZSET, member=mac, score=login ts)
+//
+// The index is only a hint: members whose session key has expired are
+// pruned lazily by ActiveDevices, so no sweeper is needed.
+
+func (s *Store) devicesKey(identity string) string {
+	return s.RawKey("identity", identity, "devices")
+}
+
+// deviceClaimGrace is how long (seconds) a claimed device counts as
+// active before its session key is written.
+const deviceClaimGrace = 60
+
+// claimDevice prunes the index KEYS[1] of members without a session
+// and, unless mac ARGV[1] is beyond the ARGV[3] oldest active devices
+// (0 = no limit), adds it at time ARGV[2]. The index TTL only grows to
+// ARGV[4]. ARGV[6..] are the members the caller listed and KEYS[2..]
+// their session keys, in the same order; a member added since is within
+// the grace ARGV[5] anyway. It returns 1 if mac holds a slot.
+var claimDevice = redis.NewScript(`
+local now = tonumber(ARGV[2])
+local max = tonumber(ARGV[3])
+local ttl = tonumber(ARGV[4])
+local grace = tonumber(ARGV[5])
+local sessions = {}
+for i = 6, #ARGV do
+  sessions[ARGV[i]] = KEYS[i - 4]
+end
+local macs = redis.call('ZRANGE', KEYS[1], 0, -1, 'WITHSCORES')
+local active, rank = 0, -1
+for i = 1, #macs, 2 do
+  local m = macs[i]
+  local k = sessions[m]
+  if tonumber(macs[i + 1]) > now - grace or (k and redis.call('EXISTS', k) == 1) then
+    if m == ARGV[1] then
+      rank = active
+    end
+    active = active + 1
+  else
+    redis.call('ZREM', KEYS[1], m)
+  end
+end
+if max > 0 and ((rank >= 0 and rank >= max) or (rank < 0 and active >= max)) then
+  return 0
+end
+redis.call('ZADD', KEYS[1], 'NX', now, ARGV[1])
+if ttl > 0 and redis.call('TTL', KEYS[1]) < ttl then
+  redis.call('EXPIRE', KEYS[1], ttl)
+end
+return 1
+`)
+
+// ClaimDevice records mac as an active device of identity unless
+// identity already has max (0 = no limit) older active devices. The
+// check and the add are one step, so concurrent logins cannot exceed
+// the limit. An existing entry keeps its original login time.
+//
+// The members are listed first so the script declares every session key
+// it reads.
+func (s *Store) ClaimDevice(ctx context.Context, identity, mac string, max, ttlSec int) (bool, error) {
+	k := s.devicesKey(identity)
+	members, err := s.rdb.ZRange(ctx, k, 0, -1).Result()
+	if err != nil {
+		return false, err
+	}
+	keys := make([]string, 0, len(members)+1)
+	args := make([]any, 0, len(members)+5)
+	keys = append(keys, k)
+	args = append(args, mac, time.Now().Unix(), max, ttlSec, deviceClaimGrace)
+	for _, m := range members {
+		keys = append(keys, s.key(m))
+		args = append(args, m)
+	}
+	ok, err := claimDevice.Run(ctx, s.rdb, keys, args...).Int()
+	return ok == 1, err
+}
+
+// AddDevice records mac as an active device of identity. The index
+// lives as long as the longest session added to it.
+func (s *Store) AddDevice(ctx context.Context, identity, mac string, ttlSec int) error {
+	_, err := s.ClaimDevice(ctx, identity, mac, 0, ttlSec)
+	return err
+}
+
+// RemoveDevice drops mac from the identity index.
+func (s *Store) RemoveDevice(ctx context.Context, identity, mac string) error {
+	return s.rdb.ZRem(ctx, s.devicesKey(identity), mac).Err()
+}
+
+// ActiveDevices returns the MACs of identity that still have a session,
+// oldest login first. Stale members are removed from the index.
+func (s *Store) ActiveDevices(ctx context.Context, identity string) ([]string, error) {
+	k := s.devicesKey(identity)
+	macs, err := s.rdb.ZRange(ctx, k, 0, -1).Result()
+	if err != nil || len(macs) == 0 {
+		return nil, err
+	}
+
+	pipe := s.rdb.Pipeline()
+	exists := make([]*redis.IntCmd, len(macs))
+	for i, m := range macs {
+		exists[i] = pipe.Exists(ctx, s.key(m))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	active := make([]string, 0, len(macs))
+	var stale []any
+	for i, m := range macs {
+		if exists[i].Val() > 0 {
+			active = append(active, m)
+		} else {
+			stale = append(stale, m)
+		}
+	}
+	if len(stale) > 0 {
+		_ = s.rdb.ZRem(ctx, k, stale...).Err()
+	}
+	return active, nil
+}
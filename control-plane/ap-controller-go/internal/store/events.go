@@ -0,0 +1,105 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// AP event stream.
+//
+// key: <prefix>events  (STREAM, field "event" = json, trimmed to ~maxLen)
+//
+// Entry IDs double as SSE event ids, so a reconnecting AP can resume
+// from the last id it saw.
+
+// StreamEntry is one raw entry of the event stream.
+type StreamEntry struct {
+	ID   string
+	Data []byte
+}
+
+func (s *Store) eventsKey() string {
+	return s.RawKey("events")
+}
+
+func toEntries(msgs []redis.XMessage) []StreamEntry {
+	out := make([]StreamEntry, 0, len(msgs))
+	for _, m := range msgs {
+		v, _ := m.Values["event"].(string)
+		out = append(out, StreamEntry{ID: m.ID, Data: []byte(v)})
+	}
+	return out
+}
+
+// AppendEvent adds data to the stream and returns its id.
+func (s *Store) AppendEvent(ctx context.Context, data []byte, maxLen int64) (string, error) {
+	return s.rdb.XAdd(ctx, &redis.XAddArgs{
+		Stream: s.eventsKey(),
+		MaxLen: maxLen,
+		Approx: true,
+		Values: []any{"event", string(data)},
+	}).Result()
+}
+
+// AppendEvents adds several entries in one pipeline and returns their ids.
+func (s *Store) AppendEvents(ctx context.Context, data [][]byte, maxLen int64) ([]string, error) {
+	pipe := s.rdb.Pipeline()
+	cmds := make([]*redis.StringCmd, len(data))
+	for i, d := range data {
+		cmds[i] = pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: s.eventsKey(),
+			MaxLen: maxLen,
+			Approx: true,
+			Values: []any{"event", string(d)},
+		})
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(cmds))
+	for i, c := range cmds {
+		ids[i] = c.Val()
+	}
+	return ids, nil
+}
+
+// EventsAfter returns up to count entries with an id greater than after.
+func (s *Store) EventsAfter(ctx context.Context, after string, count int64) ([]StreamEntry, error) {
+	msgs, err := s.rdb.XRangeN(ctx, s.eventsKey(), "("+after, "+", count).Result()
+	if err != nil {
+		return nil, err
+	}
+	return toEntries(msgs), nil
+}
+
+// ReadEvents blocks up to block for entries after the given id.
+// It returns nil, nil on timeout.
+func (s *Store) ReadEvents(ctx context.Context, after string, count int64, block time.Duration) ([]StreamEntry, error) {
+	res, err := s.rdb.XRead(ctx, &redis.XReadArgs{
+		Streams: []string{s.eventsKey(), after},
+		Count:   count,
+		Block:   block,
+	}).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil || len(res) == 0 {
+		return nil, err
+	}
+	return toEntries(res[0].Messages), nil
+}
+
+// EventBounds returns the first and last ids in the stream ("" if empty).
+func (s *Store) EventBounds(ctx context.Context) (first, last string, err error) {
+	f, err := s.rdb.XRangeN(ctx, s.eventsKey(), "-", "+", 1).Result()
+	if err != nil || len(f) == 0 {
+		return "", "", err
+	}
+	l, err := s.rdb.XRevRangeN(ctx, s.eventsKey(), "+", "-", 1).Result()
+	if err != nil || len(l) == 0 {
+		return "", "", err
+	}
+	return f[0].ID, l[0].ID, nil
+}
@@ -0,0 +1,141 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Known devices for MAC authentication bypass (MAB). A portal login with
+// a profile that sets remember_device records the device; until the
+// record expires the device is re-authorized without the portal.
+//
+// key: <prefix>known:<mac>             (STRING json KnownDevice, ttl remember_device)
+// key: <prefix>known:token:<sha256>    (STRING mac, same ttl)
+//
+// MAB does not extend a record: only an interactive login does.
+
+// KnownDevice is what the controller remembers about a device.
+type KnownDevice struct {
+	MAC      string `json:"mac"`
+	Identity string `json:"identity,omitempty"`
+	Role     string `json:"role"`
+	SSID     string `json:"ssid,omitempty"`
+	// Auth is the time of the last interactive login, Until when the
+	// record expires
+	Auth  int64 `json:"auth"`
+	Until int64 `json:"until"`
+	// LastMAB is the time of the last re-authorization, MABs how many
+	// there were since Auth
+	LastMAB int64 `json:"last_mab,omitempty"`
+	MABs    int   `json:"mabs,omitempty"`
+	// TokenHash links the device token of the portal cookie
+	TokenHash string `json:"token_hash,omitempty"`
+}
+
+func (s *Store) knownKey(mac string) string { return s.RawKey("known", mac) }
+
+func (s *Store) knownTokenKey(hash string) string { return s.RawKey("known", "token", hash) }
+
+// RememberDevice stores d until d.Until, replacing an older record of
+// the device and its token.
+func (s *Store) RememberDevice(ctx context.Context, d KnownDevice) error {
+	old, err := s.GetKnownDevice(ctx, d.MAC)
+	if err != nil {
+		return err
+	}
+	raw, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	until := time.Unix(d.Until, 0)
+	pipe := s.rdb.TxPipeline()
+	if old != nil && old.TokenHash != "" && old.TokenHash != d.TokenHash {
+		pipe.Del(ctx, s.knownTokenKey(old.TokenHash))
+	}
+	pipe.Set(ctx, s.knownKey(d.MAC), raw, 0)
+	pipe.ExpireAt(ctx, s.knownKey(d.MAC), until)
+	if d.TokenHash != "" {
+		pipe.Set(ctx, s.knownTokenKey(d.TokenHash), d.MAC, 0)
+		pipe.ExpireAt(ctx, s.knownTokenKey(d.TokenHash), until)
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// GetKnownDevice returns the record of mac, or nil.
+func (s *Store) GetKnownDevice(ctx context.Context, mac string) (*KnownDevice, error) {
+	raw, err := s.rdb.Get(ctx, s.knownKey(mac)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var d KnownDevice
+	if err := json.Unmarshal(raw, &d); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// KnownDeviceByToken returns the record linked to a device token hash,
+// or nil.
+func (s *Store) KnownDeviceByToken(ctx context.Context, hash string) (*KnownDevice, error) {
+	mac, err := s.rdb.Get(ctx, s.knownTokenKey(hash)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	d, err := s.GetKnownDevice(ctx, mac)
+	if err != nil || d == nil || d.TokenHash != hash {
+		return nil, err
+	}
+	return d, nil
+}
+
+// RecordMAB notes a re-authorization of d at now. If the device came
+// back with another MAC (linked by its token), the record moves to mac.
+// The expiry stays the same.
+func (s *Store) RecordMAB(ctx context.Context, d KnownDevice, mac string, now int64) (*KnownDevice, error) {
+	moved := d.MAC != mac
+	oldMAC := d.MAC
+	d.MAC = mac
+	d.LastMAB = now
+	d.MABs++
+	raw, err := json.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+	until := time.Unix(d.Until, 0)
+	pipe := s.rdb.TxPipeline()
+	if moved {
+		pipe.Del(ctx, s.knownKey(oldMAC))
+		if d.TokenHash != "" {
+			pipe.Set(ctx, s.knownTokenKey(d.TokenHash), mac, 0)
+			pipe.ExpireAt(ctx, s.knownTokenKey(d.TokenHash), until)
+		}
+	}
+	pipe.Set(ctx, s.knownKey(mac), raw, 0)
+	pipe.ExpireAt(ctx, s.knownKey(mac), until)
+	_, err = pipe.Exec(ctx)
+	return &d, err
+}
+
+// ForgetDevice removes the record of mac and its token. It returns the
+// removed record, or nil if there was none.
+func (s *Store) ForgetDevice(ctx context.Context, mac string) (*KnownDevice, error) {
+	d, err := s.GetKnownDevice(ctx, mac)
+	if err != nil || d == nil {
+		return nil, err
+	}
+	keys := []string{s.knownKey(mac)}
+	if d.TokenHash != "" {
+		keys = append(keys, s.knownTokenKey(d.TokenHash))
+	}
+	return d, s.rdb.Del(ctx, keys...).Err()
+}
@@ -0,0 +1,149 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Leader leases. One replica holds the lease of a name at a time; every
+// new holder gets a fencing token one higher than the last, so work done
+// under an older token can be told apart and rejected.
+//
+// key: <prefix>leader:<name>        (STRING "<holder> <token>", PX = lease ttl)
+// key: <prefix>leader:<name>:fence  (counter, last token handed out)
+//
+// A job context made by WithFence carries the lease it runs under. The
+// writes of singleton jobs (UpdateAP, SetRollout, DecideSponsorRequest,
+// the session migration) then check it in their own transaction: redis
+// refuses them once another token holds the lease, even mid-job.
+
+// ErrFenced is returned by a fenced write whose lease is gone.
+var ErrFenced = errors.New("fenced: lease lost")
+
+type fenceCtxKey struct{}
+
+type fence struct {
+	key   string
+	value string
+}
+
+// acquireLease renews the lease if ARGV[1] holds it, takes it if it is
+// free and otherwise leaves it. It returns the holder, token and ms left.
+var acquireLease = redis.NewScript(`
+local cur = redis.call('GET', KEYS[1])
+if cur then
+  local holder, token = string.match(cur, '^(.*) (%d+)$')
+  if holder == ARGV[1] then
+    redis.call('PEXPIRE', KEYS[1], ARGV[2])
+    return {holder, tonumber(token), tonumber(ARGV[2])}
+  end
+  return {holder, tonumber(token), redis.call('PTTL', KEYS[1])}
+end
+local token = redis.call('INCR', KEYS[2])
+redis.call('SET', KEYS[1], ARGV[1] .. ' ' .. token, 'PX', ARGV[2])
+return {ARGV[1], token, tonumber(ARGV[2])}
+`)
+
+// releaseLease drops the lease only if it is still ARGV[1] with token.
+var releaseLease = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+  return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// Lease is the current holder of a leader lease.
+type Lease struct {
+	Holder string
+	Token  int64
+	TTL    time.Duration
+}
+
+func (s *Store) leaseKey(name string) string {
+	return s.RawKey("leader", name)
+}
+
+// AcquireLease takes or renews the lease name for holder and returns the
+// lease as it is now, which names another holder if it is taken.
+func (s *Store) AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (Lease, error) {
+	k := s.leaseKey(name)
+	res, err := acquireLease.Run(ctx, s.rdb, []string{k, k + ":fence"}, holder, ttl.Milliseconds()).Slice()
+	if err != nil {
+		return Lease{}, err
+	}
+	l := Lease{}
+	if len(res) == 3 {
+		l.Holder, _ = res[0].(string)
+		l.Token, _ = res[1].(int64)
+		ms, _ := res[2].(int64)
+		l.TTL = time.Duration(ms) * time.Millisecond
+	}
+	return l, nil
+}
+
+// ReleaseLease gives up the lease if holder still holds it with token.
+func (s *Store) ReleaseLease(ctx context.Context, name, holder string, token int64) error {
+	return releaseLease.Run(ctx, s.rdb, []string{s.leaseKey(name)}, holder+" "+strconv.FormatInt(token, 10)).Err()
+}
+
+// WithFence returns ctx with the fence of lease name held by holder with
+// token, for the writes of a singleton job.
+func (s *Store) WithFence(ctx context.Context, name, holder string, token int64) context.Context {
+	return context.WithValue(ctx, fenceCtxKey{}, fence{key: s.leaseKey(name), value: holder + " " + strconv.FormatInt(token, 10)})
+}
+
+// watch runs txf with keys watched. Under a fence it watches the lease
+// too and fails with ErrFenced unless the lease is still the fence's, so
+// the EXEC of txf only goes through while it is.
+func (s *Store) watch(ctx context.Context, txf func(*redis.Tx) error, keys ...string) error {
+	f, ok := ctx.Value(fenceCtxKey{}).(fence)
+	if !ok {
+		return s.rdb.Watch(ctx, txf, keys...)
+	}
+	return s.rdb.Watch(ctx, func(tx *redis.Tx) error {
+		cur, err := tx.Get(ctx, f.key).Result()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		if cur != f.value {
+			return ErrFenced
+		}
+		return txf(tx)
+	}, append(keys, f.key)...)
+}
+
+// fencedPipelined runs fn in a pipeline, or under a fence in a MULTI
+// that only executes while the lease is still the fence's. Scripts must
+// be queued with Eval: a pipeline cannot load a missing one.
+func (s *Store) fencedPipelined(ctx context.Context, fn func(redis.Pipeliner) error) error {
+	if _, ok := ctx.Value(fenceCtxKey{}).(fence); !ok {
+		_, err := s.rdb.Pipelined(ctx, fn)
+		return err
+	}
+	for i := 0; i < 3; i++ {
+		err := s.watch(ctx, func(tx *redis.Tx) error {
+			_, err := tx.TxPipelined(ctx, fn)
+			return err
+		})
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+	return ErrConflict
+}
+
+// CheckLease reports whether holder still holds the lease with token.
+func (s *Store) CheckLease(ctx context.Context, name, holder string, token int64) (bool, error) {
+	cur, err := s.rdb.Get(ctx, s.leaseKey(name)).Result()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return cur == holder+" "+strconv.FormatInt(token, 10), nil
+}
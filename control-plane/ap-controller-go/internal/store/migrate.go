@@ -0,0 +1,111 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Background session migration. A pass SCANs every session key and
+// upgrades records of an older schema; its progress is saved after each
+// step, so a pass survives restarts and leader changes.
+//
+// key: <prefix>migration:sessions  (STRING, JSON MigrationProgress)
+
+// MigrationProgress is the state of the current (or last) pass.
+type MigrationProgress struct {
+	// Schema the pass upgrades to (SessionSchema of the controller that
+	// started it)
+	Schema int    `json:"schema"`
+	Cursor uint64 `json:"cursor"`
+	// Scanned counts session keys seen, Upgraded those rewritten, Newer
+	// those of a newer schema (left alone) and Failed undecodable ones
+	Scanned  int   `json:"scanned"`
+	Upgraded int   `json:"upgraded"`
+	Newer    int   `json:"newer"`
+	Failed   int   `json:"failed"`
+	Started  int64 `json:"started"`
+	Updated  int64 `json:"updated,omitempty"`
+	Finished int64 `json:"finished,omitempty"`
+}
+
+// Done reports whether the pass has scanned every key.
+func (p *MigrationProgress) Done() bool { return p.Finished != 0 }
+
+func (s *Store) migrationKey() string { return s.RawKey("migration", "sessions") }
+
+// GetMigration returns the saved progress (nil before the first pass).
+func (s *Store) GetMigration(ctx context.Context) (*MigrationProgress, error) {
+	b, err := s.rdb.Get(ctx, s.migrationKey()).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var p MigrationProgress
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// SaveMigration stores p.
+func (s *Store) SaveMigration(ctx context.Context, p MigrationProgress) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return s.fencedPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, s.migrationKey(), b, 0)
+		return nil
+	})
+}
+
+// MigrateSessions runs one step of the pass p: it scans about count keys
+// from p.Cursor on, upgrades the older ones and advances p. p.Done() is
+// true once the scan wrapped around.
+func (s *Store) MigrateSessions(ctx context.Context, p *MigrationProgress, count int64) error {
+	keys, next, err := s.rdb.Scan(ctx, p.Cursor, s.prefix+macPattern, count).Result()
+	if err != nil {
+		return err
+	}
+	if len(keys) > 0 {
+		vals, err := s.rdb.MGet(ctx, keys...).Result()
+		if err != nil {
+			return err
+		}
+		var ups []sessionUpgrade
+		for i, v := range vals {
+			raw, ok := v.(string)
+			if !ok {
+				continue // expired since the SCAN
+			}
+			p.Scanned++
+			sess, upgraded, err := s.decodeSession(strings.TrimPrefix(keys[i], s.prefix), []byte(raw))
+			switch {
+			case err != nil:
+				p.Failed++
+			case upgraded:
+				ups = append(ups, sessionUpgrade{key: keys[i], old: raw, sess: sess})
+			case sess.Schema > SessionSchema:
+				p.Newer++
+			}
+		}
+		n, err := s.writeUpgrades(ctx, ups)
+		if err != nil {
+			return err
+		}
+		p.Upgraded += n
+	}
+
+	p.Cursor = next
+	p.Updated = time.Now().Unix()
+	if next == 0 {
+		p.Finished = p.Updated
+	}
+	return nil
+}
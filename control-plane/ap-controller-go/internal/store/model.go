@@ -1,6 +1,8 @@
 package store
 
 import (
+	"encoding/json"
+
 	"ap-controller-go/internal/config"
 
 	"github.com/redis/go-redis/v9"
@@ -33,17 +35,63 @@ type SessionV2 struct {
 	} `json:"ap"`
 
 	Attrs struct {
-		VLAN          int    `json:"vlan,omitempty"`
-		FirewallGroup string `json:"firewall_group,omitempty"`
+		VLAN           int    `json:"vlan,omitempty"`
+		FirewallGroup  string `json:"firewall_group,omitempty"`
+		UpstreamKbps   int    `json:"upstream_kbps,omitempty"`
+		DownstreamKbps int    `json:"downstream_kbps,omitempty"`
+		IdleTimeout    int    `json:"idle_timeout,omitempty"`
+		MaxLifetime    int    `json:"max_session_lifetime,omitempty"`
+		MaxDevices     int    `json:"max_devices,omitempty"`
 	} `json:"attrs"`
 
 	Auth struct {
-		Method string `json:"method,omitempty"`
-		Source string `json:"source,omitempty"`
+		Method   string `json:"method,omitempty"`
+		Source   string `json:"source,omitempty"`
+		Identity string `json:"identity,omitempty"`
+		// Voucher is the id of the voucher redeemed (method "voucher")
+		Voucher string `json:"voucher,omitempty"`
+		// Sponsor is the id of the approved sponsor request (method "sponsor")
+		Sponsor string `json:"sponsor,omitempty"`
 	} `json:"auth"`
 
+	Usage Usage `json:"usage"`
+
 	TS struct {
 		Created int64 `json:"created"`
 		Updated int64 `json:"updated"`
+		// Expires is the absolute end of the session (0 = no hard limit)
+		Expires int64 `json:"expires,omitempty"`
 	} `json:"ts"`
+
+	// extra holds the fields of a newer schema this controller does not
+	// know, written back unchanged (see codec.go)
+	extra map[string]json.RawMessage
+}
+
+// Counters are traffic counters for one client.
+type Counters struct {
+	BytesIn    int64 `json:"bytes_in"`
+	BytesOut   int64 `json:"bytes_out"`
+	PacketsIn  int64 `json:"packets_in"`
+	PacketsOut int64 `json:"packets_out"`
+}
+
+// Bytes is the combined upstream + downstream volume.
+func (c Counters) Bytes() int64 { return c.BytesIn + c.BytesOut }
+
+// Usage is the accounting state of a session.
+//
+// Total accumulates deltas over the whole session. Last holds the most
+// recent cumulative counters reported by Last.APID and is the baseline
+// for the next delta.
+type Usage struct {
+	Total Counters `json:"total"`
+	Last  struct {
+		APID string   `json:"ap_id,omitempty"`
+		Raw  Counters `json:"raw"`
+	} `json:"last"`
+	Updated int64 `json:"updated,omitempty"` // last accounting record
+	Active  int64 `json:"active,omitempty"`  // last record that showed traffic
+	// QuotaExceeded is set once a quota action was applied
+	QuotaExceeded string `json:"quota_exceeded,omitempty"`
 }
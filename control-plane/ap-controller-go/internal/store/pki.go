@@ -0,0 +1,214 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// AP enrollment CA state.
+//
+// key: <prefix>pki:token:<sha256(token)>  (STRING json EnrollToken, TTL = token lifetime)
+// key: <prefix>pki:cert:<serial>          (STRING json CertRecord, expires with the cert)
+// key: <prefix>pki:revoked                (HASH serial -> json CertRecord)
+// key: <prefix>pki:ap:<ap_id>             (SET serials issued to the AP, expires with the newest cert)
+//
+// Tokens are stored hashed so a redis dump does not leak usable tokens.
+// Revoked entries are kept until the certificate itself expires.
+
+// EnrollToken is a one-time enrollment grant created by an admin.
+type EnrollToken struct {
+	APID      string `json:"ap_id"`
+	Site      string `json:"site,omitempty"`
+	CreatedBy string `json:"created_by,omitempty"`
+	Created   int64  `json:"created"`
+	Expires   int64  `json:"expires"`
+}
+
+// CertRecord describes an issued AP certificate.
+type CertRecord struct {
+	Serial    string `json:"serial"`
+	APID      string `json:"ap_id"`
+	Site      string `json:"site,omitempty"`
+	Method    string `json:"method"` // enroll | renew
+	Issued    int64  `json:"issued"`
+	NotAfter  int64  `json:"not_after"`
+	Revoked   int64  `json:"revoked,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+	RevokedBy string `json:"revoked_by,omitempty"`
+}
+
+func (s *Store) enrollTokenKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return s.RawKey("pki", "token", hex.EncodeToString(sum[:]))
+}
+
+func (s *Store) certKey(serial string) string {
+	return s.RawKey("pki", "cert", serial)
+}
+
+func (s *Store) revokedKey() string {
+	return s.RawKey("pki", "revoked")
+}
+
+func (s *Store) apCertsKey(apID string) string {
+	return s.RawKey("pki", "ap", apID)
+}
+
+// CreateEnrollToken stores t under token until t.Expires.
+func (s *Store) CreateEnrollToken(ctx context.Context, token string, t EnrollToken) error {
+	b, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	ttl := time.Until(time.Unix(t.Expires, 0))
+	return s.rdb.Set(ctx, s.enrollTokenKey(token), b, ttl).Err()
+}
+
+// ConsumeEnrollToken atomically fetches and deletes token, so each token
+// enrolls at most one AP. It returns nil, nil for unknown or used tokens.
+func (s *Store) ConsumeEnrollToken(ctx context.Context, token string) (*EnrollToken, error) {
+	b, err := s.rdb.GetDel(ctx, s.enrollTokenKey(token)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var t EnrollToken
+	if err := json.Unmarshal(b, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// RecordCert remembers an issued certificate until it expires.
+func (s *Store) RecordCert(ctx context.Context, rec CertRecord) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	ttl := time.Until(time.Unix(rec.NotAfter, 0))
+	if ttl <= 0 {
+		return nil
+	}
+	pipe := s.rdb.TxPipeline()
+	pipe.Set(ctx, s.certKey(rec.Serial), b, ttl)
+	if rec.APID != "" {
+		k := s.apCertsKey(rec.APID)
+		pipe.SAdd(ctx, k, rec.Serial)
+		pipe.ExpireGT(ctx, k, ttl)
+		pipe.ExpireNX(ctx, k, ttl)
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// APCerts returns the unexpired, unrevoked certificates issued to apID.
+func (s *Store) APCerts(ctx context.Context, apID string) ([]CertRecord, error) {
+	serials, err := s.rdb.SMembers(ctx, s.apCertsKey(apID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	var out []CertRecord
+	var gone []any
+	for _, serial := range serials {
+		rec, err := s.Cert(ctx, serial)
+		if err != nil {
+			return nil, err
+		}
+		if rec == nil {
+			gone = append(gone, serial)
+			continue
+		}
+		out = append(out, *rec)
+	}
+	if len(gone) > 0 {
+		_ = s.rdb.SRem(ctx, s.apCertsKey(apID), gone...).Err()
+	}
+	return out, nil
+}
+
+// Cert returns the record of an issued certificate, or nil if unknown
+// (never issued here, or already expired).
+func (s *Store) Cert(ctx context.Context, serial string) (*CertRecord, error) {
+	b, err := s.rdb.Get(ctx, s.certKey(serial)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rec CertRecord
+	if err := json.Unmarshal(b, &rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// RevokeCert adds rec to the revocation list.
+func (s *Store) RevokeCert(ctx context.Context, rec CertRecord) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	pipe := s.rdb.TxPipeline()
+	pipe.HSet(ctx, s.revokedKey(), rec.Serial, b)
+	pipe.Del(ctx, s.certKey(rec.Serial))
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// SupersedeCert revokes rec (the certificate an AP renewed) unless it
+// is revoked already. It returns false in that case, so a certificate
+// can be renewed once only.
+func (s *Store) SupersedeCert(ctx context.Context, rec CertRecord) (bool, error) {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return false, err
+	}
+	pipe := s.rdb.TxPipeline()
+	set := pipe.HSetNX(ctx, s.revokedKey(), rec.Serial, b)
+	pipe.Del(ctx, s.certKey(rec.Serial))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, err
+	}
+	return set.Val(), nil
+}
+
+// IsRevoked reports whether serial is on the revocation list.
+func (s *Store) IsRevoked(ctx context.Context, serial string) (bool, error) {
+	return s.rdb.HExists(ctx, s.revokedKey(), serial).Result()
+}
+
+// RevokedCerts returns the revocation list. Entries for certificates that
+// have expired anyway are dropped.
+func (s *Store) RevokedCerts(ctx context.Context) ([]CertRecord, error) {
+	m, err := s.rdb.HGetAll(ctx, s.revokedKey()).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	out := make([]CertRecord, 0, len(m))
+	var expired []string
+	for serial, v := range m {
+		var rec CertRecord
+		if err := json.Unmarshal([]byte(v), &rec); err != nil {
+			continue
+		}
+		if rec.NotAfter > 0 && rec.NotAfter < now {
+			expired = append(expired, serial)
+			continue
+		}
+		out = append(out, rec)
+	}
+	if len(expired) > 0 {
+		_ = s.rdb.HDel(ctx, s.revokedKey(), expired...).Err()
+	}
+	return out, nil
+}
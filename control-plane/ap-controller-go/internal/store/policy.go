@@ -0,0 +1,62 @@
+package store
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Policy publication times.
+//
+// key: <prefix>policy:published  (HASH checksum -> unix ts first published)
+//
+// The first replica to publish a checksum fixes its publication time, so
+// convergence deadlines agree across replicas and restarts.
+
+// RecordPolicyPublished returns when checksum was first published,
+// recording now if it is new.
+func (s *Store) RecordPolicyPublished(ctx context.Context, checksum string, now int64) (int64, error) {
+	k := s.RawKey("policy", "published")
+	if err := s.rdb.HSetNX(ctx, k, checksum, now).Err(); err != nil {
+		return 0, err
+	}
+	v, err := s.rdb.HGet(ctx, k, checksum).Result()
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(v, 10, 64)
+}
+
+// PolicyPublished returns when checksum was first published; ok is
+// false if it has not been published yet.
+func (s *Store) PolicyPublished(ctx context.Context, checksum string) (ts int64, ok bool, err error) {
+	v, err := s.rdb.HGet(ctx, s.RawKey("policy", "published"), checksum).Result()
+	if err == redis.Nil {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	ts, err = strconv.ParseInt(v, 10, 64)
+	return ts, err == nil, err
+}
+
+// policyStateTTL bounds the state of config generations no replica runs
+// any more.
+const policyStateTTL = 7 * 24 * time.Hour
+
+// SwapPolicyState stores value under name for the config generation gen
+// and returns the previous value ("" if none). Used to detect policy
+// changes once across the replicas of a generation; replicas of another
+// generation (during a rolling deploy) keep their own state.
+//
+// key: <prefix>policy:state:<name>:<gen>  (STRING, TTL 7d)
+func (s *Store) SwapPolicyState(ctx context.Context, name, gen, value string) (string, error) {
+	old, err := s.rdb.SetArgs(ctx, s.RawKey("policy", "state", name, gen), value, redis.SetArgs{Get: true, TTL: policyStateTTL}).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return old, err
+}
@@ -0,0 +1,174 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Login rate limiting. All state lives in redis and every update is one
+// script call, so the limits hold across controller replicas.
+//
+// key: <prefix>rl:bucket:<scope>:<id>  (HASH tokens, ts in ms)
+// key: <prefix>rl:fail:<scope>:<id>    (counter, expires after the failure window)
+// key: <prefix>rl:lock:<scope>:<id>    (lockout level, expires with the lockout)
+// key: <prefix>rl:level:<scope>:<id>   (lockouts so far; decays after max seconds of quiet)
+
+// takeTokens refills every bucket of KEYS for the time elapsed since its
+// last call (ARGV: now, then rate, burst, window per key) and takes one
+// token from each, or from none if one is empty. It returns {-1, 0}, or
+// the 0-based index of the first empty bucket and the ms until it has a
+// token.
+var takeTokens = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local state = {}
+for i, key in ipairs(KEYS) do
+  local rate = tonumber(ARGV[3*i-1])
+  local burst = tonumber(ARGV[3*i])
+  local window = tonumber(ARGV[3*i+1])
+
+  local b = redis.call('HMGET', key, 'tokens', 'ts')
+  local tokens = tonumber(b[1])
+  local ts = tonumber(b[2])
+  if tokens == nil or ts == nil then
+    tokens, ts = burst, now
+  end
+  -- replica clocks may disagree slightly: never refill backwards
+  if now > ts then
+    tokens = math.min(burst, tokens + (now - ts) * rate / window)
+    ts = now
+  end
+  if tokens < 1 then
+    return {i - 1, math.ceil((1 - tokens) * window / rate)}
+  end
+  state[i] = {tokens - 1, ts, math.ceil(burst * window / rate) + 1000}
+end
+
+for i, key in ipairs(KEYS) do
+  redis.call('HSET', key, 'tokens', tostring(state[i][1]), 'ts', tostring(state[i][2]))
+  redis.call('PEXPIRE', key, state[i][3])
+end
+return {-1, 0}
+`)
+
+// recordFailure counts a failure and, at the threshold, starts a lockout
+// of base * 2^(level-1) seconds (capped at max). It returns the lockout
+// in seconds, or 0.
+var recordFailure = redis.NewScript(`
+local threshold = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local base = tonumber(ARGV[3])
+local max = tonumber(ARGV[4])
+
+local n = redis.call('INCR', KEYS[1])
+if n == 1 then
+  redis.call('EXPIRE', KEYS[1], window)
+end
+if n < threshold then
+  return 0
+end
+
+redis.call('DEL', KEYS[1])
+local level = redis.call('INCR', KEYS[3])
+local d = math.floor(math.min(base * 2 ^ (level - 1), max))
+redis.call('SET', KEYS[2], level, 'EX', d)
+redis.call('EXPIRE', KEYS[3], d + max)
+return d
+`)
+
+// RateSubject is one limited key, e.g. {"mac", "aa:bb:cc:dd:ee:ff"}.
+type RateSubject struct {
+	Scope string
+	ID    string
+}
+
+func (s *Store) rateKey(kind string, sub RateSubject) string {
+	return s.RawKey("rl", kind, sub.Scope, sub.ID)
+}
+
+// RateBucket is the token bucket of a subject: it allows Rate requests
+// per Window with bursts up to Burst.
+type RateBucket struct {
+	Subject RateSubject
+	Rate    int
+	Burst   int
+	Window  time.Duration
+}
+
+// TakeToken takes a token from the bucket of sub. It returns 0 when the
+// request may proceed, else how long until it may be retried.
+func (s *Store) TakeToken(ctx context.Context, sub RateSubject, rate, burst int, window time.Duration) (time.Duration, error) {
+	_, wait, err := s.TakeTokens(ctx, []RateBucket{{Subject: sub, Rate: rate, Burst: burst, Window: window}})
+	return wait, err
+}
+
+// TakeTokens takes a token from every bucket in one script call, so a
+// request refused by one bucket costs nothing in the others. It returns
+// -1 when the request may proceed, else the index of the first empty
+// bucket and how long until it may be retried.
+func (s *Store) TakeTokens(ctx context.Context, buckets []RateBucket) (int, time.Duration, error) {
+	if len(buckets) == 0 {
+		return -1, 0, nil
+	}
+	keys := make([]string, len(buckets))
+	args := make([]any, 0, 1+3*len(buckets))
+	args = append(args, time.Now().UnixMilli())
+	for i, b := range buckets {
+		keys[i] = s.rateKey("bucket", b.Subject)
+		args = append(args, b.Rate, b.Burst, b.Window.Milliseconds())
+	}
+	res, err := takeTokens.Run(ctx, s.rdb, keys, args...).Int64Slice()
+	if err != nil {
+		return -1, 0, err
+	}
+	if len(res) != 2 {
+		return -1, 0, fmt.Errorf("take tokens: unexpected reply %v", res)
+	}
+	return int(res[0]), time.Duration(res[1]) * time.Millisecond, nil
+}
+
+// LockedFor returns the remaining lockout of each subject (0 = none), in
+// one pipeline.
+func (s *Store) LockedFor(ctx context.Context, subs []RateSubject) ([]time.Duration, error) {
+	pipe := s.rdb.Pipeline()
+	cmds := make([]*redis.DurationCmd, len(subs))
+	for i, sub := range subs {
+		cmds[i] = pipe.PTTL(ctx, s.rateKey("lock", sub))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	out := make([]time.Duration, len(subs))
+	for i, c := range cmds {
+		// -2 (missing) and -1 (no ttl) come back as negative durations
+		if d := c.Val(); d > 0 {
+			out[i] = d
+		}
+	}
+	return out, nil
+}
+
+// RecordFailure counts a rejected attempt of sub. After failures of them
+// within window, sub is locked out for base, doubling with every further
+// lockout up to max; the new lockout is returned (0 = none).
+func (s *Store) RecordFailure(ctx context.Context, sub RateSubject, failures int, window, base, max time.Duration) (time.Duration, error) {
+	sec, err := recordFailure.Run(ctx, s.rdb,
+		[]string{s.rateKey("fail", sub), s.rateKey("lock", sub), s.rateKey("level", sub)},
+		failures, int64(window.Seconds()), int64(base.Seconds()), int64(max.Seconds())).Int64()
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(sec) * time.Second, nil
+}
+
+// ClearFailures resets the failure counters of subs. The lockout level
+// is kept: it only decays with time.
+func (s *Store) ClearFailures(ctx context.Context, subs []RateSubject) error {
+	keys := make([]string, len(subs))
+	for i, sub := range subs {
+		keys[i] = s.rateKey("fail", sub)
+	}
+	return s.rdb.Del(ctx, keys...).Err()
+}
@@ -0,0 +1,255 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strconv"
+	"time"
+
+	"ap-controller-go/internal/config"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Staged policy rollouts.
+//
+// key: <prefix>policy:rollout              (STRING json Rollout, no TTL)
+// key: <prefix>policy:promoted             (STRING json PromotedPolicy, no TTL)
+// key: <prefix>policy:rollout:<id>:logins  (HASH counter -> n, TTL rolloutLoginsTTL)
+//
+// One rollout at a time: the record stays after it was promoted or
+// aborted until the next candidate replaces it. The login counters are
+// copied into the record when the canary stage ends.
+
+// Rollout stages.
+const (
+	RolloutCanary   = "canary"
+	RolloutPromoted = "promoted"
+	RolloutAborted  = "aborted"
+)
+
+// Canary selects the APs a candidate policy is served to: listed APs,
+// every AP of a listed site, and Percent of the other APs, picked by a
+// hash of the rollout id and the ap_id.
+type Canary struct {
+	APIDs   []string `json:"ap_ids,omitempty"`
+	Sites   []string `json:"sites,omitempty"`
+	Percent int      `json:"percent,omitempty"`
+}
+
+// Empty reports whether c selects no AP at all.
+func (c Canary) Empty() bool {
+	return len(c.APIDs) == 0 && len(c.Sites) == 0 && c.Percent <= 0
+}
+
+// Selects reports whether the AP apID of site is a canary of the
+// rollout seed.
+func (c Canary) Selects(seed, site, apID string) bool {
+	if apID != "" && slices.Contains(c.APIDs, apID) {
+		return true
+	}
+	if site != "" && slices.Contains(c.Sites, site) {
+		return true
+	}
+	if c.Percent <= 0 || apID == "" {
+		return false
+	}
+	sum := sha256.Sum256([]byte(seed + "\x00" + apID))
+	return int(binary.BigEndian.Uint32(sum[:4])%100) < c.Percent
+}
+
+// RolloutLogins counts the logins (and failed logins) on canary APs and
+// on the other ("baseline") APs during the canary stage.
+type RolloutLogins struct {
+	Canary         int64 `json:"canary"`
+	CanaryErrors   int64 `json:"canary_errors"`
+	Baseline       int64 `json:"baseline"`
+	BaselineErrors int64 `json:"baseline_errors"`
+}
+
+// Rollout is a candidate policy and its stage. RoleRules / Bypass nil
+// keep the active section; an empty RoleRules list removes every rule.
+type Rollout struct {
+	ID        string            `json:"id"`
+	Stage     string            `json:"stage"`
+	RoleRules []config.RoleRule `json:"role_rules"`
+	Bypass    *config.Bypass    `json:"bypass,omitempty"`
+	Canary    Canary            `json:"canary"`
+	Note      string            `json:"note,omitempty"`
+	CreatedBy string            `json:"created_by"`
+	Created   int64             `json:"created"`
+	Updated   int64             `json:"updated"`
+	// Alerted is when the unhealthy canary alert fired (0 = not yet)
+	Alerted int64 `json:"alerted,omitempty"`
+	// set when the canary stage ends
+	EndedBy string         `json:"ended_by,omitempty"`
+	Ended   int64          `json:"ended,omitempty"`
+	Reason  string         `json:"reason,omitempty"`
+	Logins  *RolloutLogins `json:"logins,omitempty"`
+}
+
+// PromotedPolicy is the policy of the last promoted rollout: complete
+// role_rules and bypass sections replacing those of the config file.
+// Base is the checksum of the file's sections it was promoted over;
+// once the file changes, the file wins.
+type PromotedPolicy struct {
+	Rollout    string            `json:"rollout"`
+	Base       string            `json:"base"`
+	RoleRules  []config.RoleRule `json:"role_rules"`
+	Bypass     config.Bypass     `json:"bypass"`
+	PromotedBy string            `json:"promoted_by"`
+	Promoted   int64             `json:"promoted"`
+}
+
+// rolloutLoginsTTL only drops counters a late login recreated after the
+// canary stage ended; every login refreshes it.
+const rolloutLoginsTTL = 30 * 24 * time.Hour
+
+// ErrRolloutChanged is returned by SetRollout when the current rollout
+// is not the one expected.
+var ErrRolloutChanged = errors.New("rollout changed")
+
+func (s *Store) rolloutKey() string  { return s.RawKey("policy", "rollout") }
+func (s *Store) promotedKey() string { return s.RawKey("policy", "promoted") }
+
+func (s *Store) rolloutLoginsKey(id string) string {
+	return s.RawKey("policy", "rollout", id, "logins")
+}
+
+// PolicyRollout returns the promoted policy and the rollout, each nil if
+// there is none.
+func (s *Store) PolicyRollout(ctx context.Context) (*PromotedPolicy, *Rollout, error) {
+	vals, err := s.rdb.MGet(ctx, s.promotedKey(), s.rolloutKey()).Result()
+	if err != nil {
+		return nil, nil, err
+	}
+	var p *PromotedPolicy
+	var r *Rollout
+	if str, ok := vals[0].(string); ok {
+		p = new(PromotedPolicy)
+		if err := json.Unmarshal([]byte(str), p); err != nil {
+			return nil, nil, err
+		}
+	}
+	if str, ok := vals[1].(string); ok {
+		r = new(Rollout)
+		if err := json.Unmarshal([]byte(str), r); err != nil {
+			return nil, nil, err
+		}
+	}
+	return p, r, nil
+}
+
+// SetRollout stores next if the current rollout is the one with id
+// expectID in its canary stage, or, with expectID "", if no rollout is
+// in its canary stage. When next ends the canary stage, its login
+// counters are copied into it and dropped; when it is promoted, promoted
+// becomes the promoted policy. It returns the stored rollout, or
+// ErrRolloutChanged.
+func (s *Store) SetRollout(ctx context.Context, expectID string, next Rollout, promoted *PromotedPolicy) (*Rollout, error) {
+	k := s.rolloutKey()
+	logins := s.rolloutLoginsKey(next.ID)
+
+	txf := func(tx *redis.Tx) error {
+		var cur *Rollout
+		raw, err := tx.Get(ctx, k).Bytes()
+		switch {
+		case err == redis.Nil:
+		case err != nil:
+			return err
+		default:
+			cur = new(Rollout)
+			if err := json.Unmarshal(raw, cur); err != nil {
+				return err
+			}
+		}
+		running := cur != nil && cur.Stage == RolloutCanary
+		if expectID == "" && running || expectID != "" && (!running || cur.ID != expectID) {
+			return ErrRolloutChanged
+		}
+
+		if next.Stage != RolloutCanary {
+			counts, err := tx.HGetAll(ctx, logins).Result()
+			if err != nil {
+				return err
+			}
+			next.Logins = parseRolloutLogins(counts)
+		}
+		b, err := json.Marshal(next)
+		if err != nil {
+			return err
+		}
+		var pb []byte
+		if next.Stage == RolloutPromoted && promoted != nil {
+			if pb, err = json.Marshal(promoted); err != nil {
+				return err
+			}
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, k, b, 0)
+			if next.Stage != RolloutCanary {
+				pipe.Del(ctx, logins)
+			}
+			if pb != nil {
+				pipe.Set(ctx, s.promotedKey(), pb, 0)
+			}
+			return nil
+		})
+		return err
+	}
+
+	for i := 0; i < 3; i++ {
+		err := s.watch(ctx, txf, k, logins)
+		if err == redis.TxFailedErr {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &next, nil
+	}
+	return nil, ErrConflict
+}
+
+// CountRolloutLogin counts a login on a canary or baseline AP during the
+// canary stage of rollout id.
+func (s *Store) CountRolloutLogin(ctx context.Context, id string, canary, failed bool) error {
+	field := "baseline"
+	if canary {
+		field = "canary"
+	}
+	pipe := s.rdb.TxPipeline()
+	pipe.HIncrBy(ctx, s.rolloutLoginsKey(id), field, 1)
+	if failed {
+		pipe.HIncrBy(ctx, s.rolloutLoginsKey(id), field+"_errors", 1)
+	}
+	pipe.Expire(ctx, s.rolloutLoginsKey(id), rolloutLoginsTTL)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// RolloutLoginCounts returns the login counters of rollout id so far.
+func (s *Store) RolloutLoginCounts(ctx context.Context, id string) (RolloutLogins, error) {
+	counts, err := s.rdb.HGetAll(ctx, s.rolloutLoginsKey(id)).Result()
+	if err != nil {
+		return RolloutLogins{}, err
+	}
+	return *parseRolloutLogins(counts), nil
+}
+
+func parseRolloutLogins(m map[string]string) *RolloutLogins {
+	n := func(k string) int64 {
+		v, _ := strconv.ParseInt(m[k], 10, 64)
+		return v
+	}
+	return &RolloutLogins{
+		Canary:         n("canary"),
+		CanaryErrors:   n("canary_errors"),
+		Baseline:       n("baseline"),
+		BaselineErrors: n("baseline_errors"),
+	}
+}
@@ -0,0 +1,190 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Sponsored guest access requests. A request waits for its sponsor until
+// it expires; the decision is one script call, so two clicks (or a click
+// and the expiry job) cannot both win.
+//
+// key: <prefix>sponsor:<id>       (HASH SponsorRequest, kept sponsorKeep after it expires)
+// key: <prefix>sponsor:pending    (ZSET member=id, score=expires ts)
+// key: <prefix>sponsor:mac:<mac>  (STRING id of the device's latest request, ttl request_ttl)
+
+// SponsorRequest is a visitor's request for access, naming the employee
+// (Sponsor) who approves it.
+type SponsorRequest struct {
+	ID       string `redis:"id" json:"id"`
+	MAC      string `redis:"mac" json:"mac"`
+	IP       string `redis:"ip" json:"ip,omitempty"`
+	APID     string `redis:"ap_id" json:"ap_id,omitempty"`
+	SSID     string `redis:"ssid" json:"ssid,omitempty"`
+	Guest    string `redis:"guest" json:"guest"`
+	Note     string `redis:"note" json:"note,omitempty"`
+	Sponsor  string `redis:"sponsor" json:"sponsor"`
+	Role     string `redis:"role" json:"role"`
+	Duration int    `redis:"duration" json:"duration"`
+	Status   string `redis:"status" json:"status"`
+	Created  int64  `redis:"created" json:"created"`
+	Expires  int64  `redis:"expires" json:"expires"`
+	Decided  int64  `redis:"decided" json:"decided,omitempty"`
+}
+
+// Sponsor request states.
+const (
+	SponsorPending  = "pending"
+	SponsorApproved = "approved"
+	SponsorDenied   = "denied"
+	SponsorExpired  = "expired"
+)
+
+// sponsorKeep is how long a request stays readable after it expired, so
+// a waiting portal and the admin see how it ended.
+const sponsorKeep = 24 * time.Hour
+
+func (s *Store) sponsorKey(id string) string { return s.RawKey("sponsor", id) }
+
+// decideSponsor moves request KEYS[1] from pending to ARGV[1] at ARGV[2].
+// A pending request past its expiry becomes expired instead. It returns
+// {status, changed}.
+var decideSponsor = redis.NewScript(`
+local v = redis.call('HMGET', KEYS[1], 'status', 'expires')
+if not v[1] then
+  redis.call('ZREM', KEYS[2], ARGV[3])
+  return {'', 0}
+end
+if v[1] ~= 'pending' then
+  return {v[1], 0}
+end
+local now = tonumber(ARGV[2])
+local status = ARGV[1]
+if now > tonumber(v[2]) then
+  status = 'expired'
+elseif status == 'expired' then
+  return {'pending', 0}
+end
+redis.call('HSET', KEYS[1], 'status', status, 'decided', now)
+redis.call('ZREM', KEYS[2], ARGV[3])
+return {status, 1}
+`)
+
+// reopenSponsor undoes the approval of KEYS[1] made at ARGV[1]: the
+// request is pending again, in the pending set KEYS[2] as ARGV[2].
+var reopenSponsor = redis.NewScript(`
+local v = redis.call('HMGET', KEYS[1], 'status', 'decided', 'expires')
+if v[1] ~= 'approved' or v[2] ~= ARGV[1] then
+  return 0
+end
+redis.call('HSET', KEYS[1], 'status', 'pending', 'decided', 0)
+redis.call('ZADD', KEYS[2], v[3], ARGV[2])
+return 1
+`)
+
+// CreateSponsorRequest stores a new pending request.
+func (s *Store) CreateSponsorRequest(ctx context.Context, r SponsorRequest) error {
+	r.Status = SponsorPending
+	ttl := time.Until(time.Unix(r.Expires, 0))
+	if ttl <= 0 {
+		return errors.New("sponsor request already expired")
+	}
+	pipe := s.rdb.TxPipeline()
+	pipe.HSet(ctx, s.sponsorKey(r.ID), &r)
+	pipe.ExpireAt(ctx, s.sponsorKey(r.ID), time.Unix(r.Expires, 0).Add(sponsorKeep))
+	pipe.ZAdd(ctx, s.RawKey("sponsor", "pending"), redis.Z{Score: float64(r.Expires), Member: r.ID})
+	pipe.Set(ctx, s.RawKey("sponsor", "mac", r.MAC), r.ID, ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// DeleteSponsorRequest removes a request, e.g. when its sponsor could not
+// be notified.
+func (s *Store) DeleteSponsorRequest(ctx context.Context, r SponsorRequest) error {
+	pipe := s.rdb.TxPipeline()
+	pipe.Del(ctx, s.sponsorKey(r.ID))
+	pipe.ZRem(ctx, s.RawKey("sponsor", "pending"), r.ID)
+	pipe.Del(ctx, s.RawKey("sponsor", "mac", r.MAC))
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// GetSponsorRequest returns the request with id, or nil.
+func (s *Store) GetSponsorRequest(ctx context.Context, id string) (*SponsorRequest, error) {
+	h := s.rdb.HGetAll(ctx, s.sponsorKey(id))
+	if err := h.Err(); err != nil {
+		return nil, err
+	}
+	if len(h.Val()) == 0 {
+		return nil, nil
+	}
+	var r SponsorRequest
+	if err := h.Scan(&r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// PendingSponsorRequest returns the request of mac still waiting for its
+// sponsor at now, or nil.
+func (s *Store) PendingSponsorRequest(ctx context.Context, mac string, now int64) (*SponsorRequest, error) {
+	id, err := s.rdb.Get(ctx, s.RawKey("sponsor", "mac", mac)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	r, err := s.GetSponsorRequest(ctx, id)
+	if err != nil || r == nil || r.Status != SponsorPending || now > r.Expires {
+		return nil, err
+	}
+	return r, nil
+}
+
+// DecideSponsorRequest moves request id from pending to status at now. It
+// returns the request's status afterwards ("" if it does not exist) and
+// whether this call changed it. A request past its expiry becomes
+// SponsorExpired whatever status was asked for.
+func (s *Store) DecideSponsorRequest(ctx context.Context, id, status string, now int64) (string, bool, error) {
+	var cmd *redis.Cmd
+	err := s.fencedPipelined(ctx, func(pipe redis.Pipeliner) error {
+		cmd = decideSponsor.Eval(ctx, pipe, []string{s.sponsorKey(id), s.RawKey("sponsor", "pending")}, status, now, id)
+		return nil
+	})
+	if err != nil {
+		return "", false, err
+	}
+	res, err := cmd.Slice()
+	if err != nil {
+		return "", false, err
+	}
+	if len(res) != 2 {
+		return "", false, errors.New("decide sponsor request: bad reply")
+	}
+	st, _ := res[0].(string)
+	changed, _ := res[1].(int64)
+	return st, changed == 1, nil
+}
+
+// ReopenSponsorRequest makes request id pending again if it is still the
+// approval decided at decided, e.g. when the device could not be
+// authorized. It reports whether it did.
+func (s *Store) ReopenSponsorRequest(ctx context.Context, id string, decided int64) (bool, error) {
+	n, err := reopenSponsor.Run(ctx, s.rdb, []string{s.sponsorKey(id), s.RawKey("sponsor", "pending")},
+		decided, id).Int()
+	return n == 1, err
+}
+
+// DueSponsorRequests returns the ids of pending requests that expired by
+// now.
+func (s *Store) DueSponsorRequests(ctx context.Context, now int64) ([]string, error) {
+	return s.rdb.ZRangeByScore(ctx, s.RawKey("sponsor", "pending"), &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(now-1, 10),
+	}).Result()
+}
@@ -0,0 +1,79 @@
+package store
+
+import (
+	"context"
+	"strings"
+)
+
+// macPattern matches session keys only (<prefix>aa:bb:cc:dd:ee:ff),
+// not the identity / usage / nonce keys sharing the prefix.
+const macPattern = "??:??:??:??:??:??"
+
+// CountByRole scans all sessions and counts them per role.
+// It is O(sessions) and meant for metrics scrapes, not request paths.
+func (s *Store) CountByRole(ctx context.Context) (map[string]int, error) {
+	out := map[string]int{}
+	err := s.scanSessions(ctx, func(sess SessionV2) {
+		out[sess.Role]++
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CountByAP scans all sessions and counts them per ap_id.
+// Sessions without an AP are not counted.
+func (s *Store) CountByAP(ctx context.Context) (map[string]int, error) {
+	out := map[string]int{}
+	err := s.scanSessions(ctx, func(sess SessionV2) {
+		if sess.AP.APID != "" {
+			out[sess.AP.APID]++
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// scanSessions calls fn for every stored session (SCAN + batched MGET),
+// whatever its schema. It only reads; the migration upgrades.
+func (s *Store) scanSessions(ctx context.Context, fn func(SessionV2)) error {
+	iter := s.rdb.Scan(ctx, 0, s.prefix+macPattern, 500).Iterator()
+
+	batch := make([]string, 0, 500)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		vals, err := s.rdb.MGet(ctx, batch...).Result()
+		if err != nil {
+			return err
+		}
+		for i, v := range vals {
+			str, ok := v.(string)
+			if !ok {
+				continue // expired between SCAN and MGET
+			}
+			if sess, _, err := s.decodeSession(strings.TrimPrefix(batch[i], s.prefix), []byte(str)); err == nil {
+				fn(sess)
+			}
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	for iter.Next(ctx) {
+		batch = append(batch, iter.Val())
+		if len(batch) == cap(batch) {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
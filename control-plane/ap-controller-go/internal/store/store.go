@@ -2,7 +2,7 @@ package store
 
 import (
 	"context"
-	"encoding/json"
+	"log"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -10,21 +10,20 @@ import (
 
 func (s *Store) SetSession(ctx context.Context, sess SessionV2, ttlSec int) error {
 	now := time.Now().Unix()
-	if sess.Schema == 0 {
-		sess.Schema = 2
-	}
 	if sess.TS.Created == 0 {
 		sess.TS.Created = now
 	}
 	sess.TS.Updated = now
 
-	b, err := json.Marshal(sess)
+	b, err := encodeSession(sess)
 	if err != nil {
 		return err
 	}
 	return s.rdb.Set(ctx, s.key(sess.MAC), string(b), time.Duration(ttlSec)*time.Second).Err()
 }
 
+// GetSessionFull returns the session of mac and its TTL in seconds. A
+// record of an older schema is upgraded in redis on the way.
 func (s *Store) GetSessionFull(ctx context.Context, mac string) (*SessionV2, int, error) {
 	k := s.key(mac)
 	val, err := s.rdb.Get(ctx, k).Result()
@@ -34,10 +33,15 @@ func (s *Store) GetSessionFull(ctx context.Context, mac string) (*SessionV2, int
 	if err != nil {
 		return nil, 0, err
 	}
-	var sess SessionV2
-	if err := json.Unmarshal([]byte(val), &sess); err != nil {
+	sess, upgraded, err := s.decodeSession(mac, []byte(val))
+	if err != nil {
 		return nil, 0, err
 	}
+	if upgraded {
+		if _, err := s.writeUpgrades(ctx, []sessionUpgrade{{key: k, old: val, sess: sess}}); err != nil {
+			log.Printf("store: upgrade session %s failed: %v", mac, err)
+		}
+	}
 	ttl, err := s.rdb.TTL(ctx, k).Result()
 	if err != nil {
 		return &sess, 0, nil
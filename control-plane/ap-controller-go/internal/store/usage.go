@@ -0,0 +1,137 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Daily usage per identity.
+//
+// key: <prefix>usage:daily:<yyyymmdd>:<identity>  (HASH of Counters fields)
+const dailyUsageRetention = 8 * 24 * time.Hour
+
+// ErrConflict is returned when a session kept changing during UpdateSession.
+var ErrConflict = errors.New("session update conflict")
+
+// Accumulate derives the delta between raw and the previous report and
+// adds it to Total. Counters are cumulative per AP; a report from another
+// AP (roaming) or a counter that went backwards (AP reboot) starts a new
+// baseline, so the whole raw value counts as delta.
+func (u *Usage) Accumulate(apID string, raw Counters, now int64) Counters {
+	delta := raw
+	if u.Last.APID == apID && raw.BytesIn >= u.Last.Raw.BytesIn && raw.BytesOut >= u.Last.Raw.BytesOut &&
+		raw.PacketsIn >= u.Last.Raw.PacketsIn && raw.PacketsOut >= u.Last.Raw.PacketsOut {
+		delta = Counters{
+			BytesIn:    raw.BytesIn - u.Last.Raw.BytesIn,
+			BytesOut:   raw.BytesOut - u.Last.Raw.BytesOut,
+			PacketsIn:  raw.PacketsIn - u.Last.Raw.PacketsIn,
+			PacketsOut: raw.PacketsOut - u.Last.Raw.PacketsOut,
+		}
+	}
+
+	u.Total.BytesIn += delta.BytesIn
+	u.Total.BytesOut += delta.BytesOut
+	u.Total.PacketsIn += delta.PacketsIn
+	u.Total.PacketsOut += delta.PacketsOut
+
+	u.Last.APID = apID
+	u.Last.Raw = raw
+	u.Updated = now
+	if u.Active == 0 || delta.PacketsIn+delta.PacketsOut > 0 || delta.Bytes() > 0 {
+		u.Active = now
+	}
+	return delta
+}
+
+// UpdateSession atomically applies fn to the stored session while keeping
+// its TTL. It returns (nil, nil) when the session does not exist.
+func (s *Store) UpdateSession(ctx context.Context, mac string, fn func(*SessionV2) error) (*SessionV2, error) {
+	k := s.key(mac)
+	var out *SessionV2
+
+	txf := func(tx *redis.Tx) error {
+		out = nil
+		val, err := tx.Get(ctx, k).Result()
+		if err == redis.Nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		sess, _, err := s.decodeSession(mac, []byte(val))
+		if err != nil {
+			return err
+		}
+		if err := fn(&sess); err != nil {
+			return err
+		}
+		sess.TS.Updated = time.Now().Unix()
+		b, err := encodeSession(sess)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.SetArgs(ctx, k, string(b), redis.SetArgs{KeepTTL: true})
+			return nil
+		})
+		if err == nil {
+			out = &sess
+		}
+		return err
+	}
+
+	for i := 0; i < 3; i++ {
+		err := s.rdb.Watch(ctx, txf, k)
+		if err == redis.TxFailedErr {
+			continue
+		}
+		return out, err
+	}
+	return nil, ErrConflict
+}
+
+func (s *Store) dailyUsageKey(identity string, day time.Time) string {
+	return s.RawKey("usage", "daily", day.Format("20060102"), identity)
+}
+
+// AddDailyUsage adds delta to the identity's usage for day and returns
+// the new daily total.
+func (s *Store) AddDailyUsage(ctx context.Context, identity string, day time.Time, delta Counters) (Counters, error) {
+	k := s.dailyUsageKey(identity, day)
+	pipe := s.rdb.TxPipeline()
+	in := pipe.HIncrBy(ctx, k, "bytes_in", delta.BytesIn)
+	out := pipe.HIncrBy(ctx, k, "bytes_out", delta.BytesOut)
+	pin := pipe.HIncrBy(ctx, k, "packets_in", delta.PacketsIn)
+	pout := pipe.HIncrBy(ctx, k, "packets_out", delta.PacketsOut)
+	pipe.Expire(ctx, k, dailyUsageRetention)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return Counters{}, err
+	}
+	return Counters{
+		BytesIn:    in.Val(),
+		BytesOut:   out.Val(),
+		PacketsIn:  pin.Val(),
+		PacketsOut: pout.Val(),
+	}, nil
+}
+
+// DailyUsage returns the identity's usage for day.
+func (s *Store) DailyUsage(ctx context.Context, identity string, day time.Time) (Counters, error) {
+	var c Counters
+	vals, err := s.rdb.HMGet(ctx, s.dailyUsageKey(identity, day),
+		"bytes_in", "bytes_out", "packets_in", "packets_out").Result()
+	if err != nil {
+		return c, err
+	}
+	dst := []*int64{&c.BytesIn, &c.BytesOut, &c.PacketsIn, &c.PacketsOut}
+	for i, v := range vals {
+		if str, ok := v.(string); ok {
+			*dst[i], _ = strconv.ParseInt(str, 10, 64)
+		}
+	}
+	return c, nil
+}
@@ -23,6 +23,16 @@ func New(cfg *config.Config, password string) *Store {
 	}
 }
 
+// WithConfig returns a store for cfg (a tenant, see config.ForTenant)
+// on the same redis connection pool and hooks.
+func (s *Store) WithConfig(cfg *config.Config) *Store {
+	return &Store{
+		cfg:    cfg,
+		rdb:    s.rdb,
+		prefix: cfg.Redis.Prefix,
+	}
+}
+
 func (s *Store) key(mac string) string { return s.prefix + mac }
 
 func (s *Store) Ping(ctx context.Context) error {
@@ -32,3 +42,8 @@ func (s *Store) Ping(ctx context.Context) error {
 func (s *Store) RawKey(parts ...string) string {
 	return s.prefix + strings.Join(parts, ":")
 }
+
+// AddHook instruments the redis client (metrics, tracing, ...).
+func (s *Store) AddHook(h redis.Hook) {
+	s.rdb.AddHook(h)
+}
@@ -0,0 +1,348 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Guest vouchers. A batch is created at once and fixes the terms of its
+// vouchers; each voucher keeps its own redemption state, so redeeming is
+// one script call on one voucher.
+//
+// key: <prefix>voucher:batch:<batch>      (STRING json VoucherBatch)
+// key: <prefix>voucher:batch:<batch>:ids  (LIST voucher ids, print order)
+// key: <prefix>voucher:batches            (ZSET member=batch, score=created ts)
+// key: <prefix>voucher:<id>               (HASH Voucher: terms + state)
+// key: <prefix>voucher:<id>:macs          (SET devices that redeemed it)
+// key: <prefix>voucher:code:<code>        (STRING voucher id)
+//
+// Nothing expires: revoked and used vouchers stay for the usage report.
+
+// VoucherBatch is a set of vouchers created together with the same terms.
+type VoucherBatch struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Role of the sessions created from its vouchers
+	Role string `json:"role"`
+	// ValidFrom / ValidUntil bound redemption (unix seconds, 0 = open)
+	ValidFrom  int64 `json:"valid_from,omitempty"`
+	ValidUntil int64 `json:"valid_until,omitempty"`
+	// Duration (seconds) a voucher grants from its first redemption
+	// (0 = the profile's limits only)
+	Duration int `json:"duration,omitempty"`
+	// Devices is how many devices may redeem one voucher (0 = unlimited)
+	Devices int `json:"devices,omitempty"`
+	// QuotaMB is the data quota of one voucher over all its devices
+	// (0 = unlimited)
+	QuotaMB   int    `json:"quota_mb,omitempty"`
+	Count     int    `json:"count"`
+	CreatedBy string `json:"created_by"`
+	Created   int64  `json:"created"`
+}
+
+// Voucher is the stored state of one voucher. The batch terms are copied
+// in, so redemption needs no other key.
+type Voucher struct {
+	ID         string `redis:"id"`
+	Batch      string `redis:"batch"`
+	Code       string `redis:"code"`
+	Role       string `redis:"role"`
+	ValidFrom  int64  `redis:"valid_from"`
+	ValidUntil int64  `redis:"valid_until"`
+	Duration   int    `redis:"duration"`
+	Devices    int    `redis:"devices"`
+	QuotaMB    int    `redis:"quota_mb"`
+	Created    int64  `redis:"created"`
+	FirstUsed  int64  `redis:"first_used"`
+	Revoked    int64  `redis:"revoked"`
+	RevokedBy  string `redis:"revoked_by"`
+	BytesIn    int64  `redis:"bytes_in"`
+	BytesOut   int64  `redis:"bytes_out"`
+
+	// MACs is filled by GetVoucher / BatchVouchers
+	MACs []string `redis:"-"`
+}
+
+// Bytes is the data volume used by all devices of the voucher.
+func (v *Voucher) Bytes() int64 { return v.BytesIn + v.BytesOut }
+
+// Voucher redemption results.
+const (
+	VoucherOK           = "ok"
+	VoucherNotFound     = "not_found"
+	VoucherRevoked      = "revoked"
+	VoucherNotYetValid  = "not_yet_valid"
+	VoucherExpired      = "expired"
+	VoucherUsedUp       = "used_up"
+	VoucherQuotaReached = "quota_exceeded"
+)
+
+// ErrVoucherCodeTaken is returned by CreateVoucherBatch when a generated
+// code already exists; the caller retries with new codes.
+var ErrVoucherCodeTaken = errors.New("voucher code taken")
+
+func (s *Store) voucherKey(id string) string { return s.RawKey("voucher", id) }
+
+func (s *Store) voucherBatchKey(id string) string { return s.RawKey("voucher", "batch", id) }
+
+// redeemVoucher checks the voucher terms for device ARGV[1] at time
+// ARGV[2] and takes one use if the device is new. It returns
+// {result, first_used}.
+var redeemVoucher = redis.NewScript(`
+local now = tonumber(ARGV[2])
+local v = redis.call('HMGET', KEYS[1], 'id', 'revoked', 'valid_from', 'valid_until',
+  'duration', 'devices', 'first_used', 'quota_mb', 'bytes_in', 'bytes_out')
+if not v[1] then
+  return {'not_found', 0}
+end
+local n = function(x) return tonumber(x) or 0 end
+local first = n(v[7])
+if n(v[2]) > 0 then
+  return {'revoked', first}
+end
+if redis.call('SISMEMBER', KEYS[2], ARGV[1]) == 0 then
+  if now < n(v[3]) then
+    return {'not_yet_valid', first}
+  end
+  if n(v[4]) > 0 and now > n(v[4]) then
+    return {'expired', first}
+  end
+  if n(v[6]) > 0 and redis.call('SCARD', KEYS[2]) >= n(v[6]) then
+    return {'used_up', first}
+  end
+end
+if first > 0 and n(v[5]) > 0 and now >= first + n(v[5]) then
+  return {'expired', first}
+end
+if n(v[8]) > 0 and n(v[9]) + n(v[10]) >= n(v[8]) * 1048576 then
+  return {'quota_exceeded', first}
+end
+redis.call('SADD', KEYS[2], ARGV[1])
+if first == 0 then
+  first = now
+  redis.call('HSET', KEYS[1], 'first_used', first)
+end
+return {'ok', first}
+`)
+
+// CreateVoucherBatch stores batch b with vouchers ids / codes (same
+// order). All or nothing: if any code exists, nothing is written and
+// ErrVoucherCodeTaken is returned. The codes are watched, so a code taken
+// between the check and the write fails the transaction too.
+func (s *Store) CreateVoucherBatch(ctx context.Context, b VoucherBatch, ids, codes []string) error {
+	codeKeys := make([]string, len(codes))
+	for i, c := range codes {
+		codeKeys[i] = s.RawKey("voucher", "code", c)
+	}
+	raw, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+
+	txf := func(tx *redis.Tx) error {
+		n, err := tx.Exists(ctx, codeKeys...).Result()
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			return ErrVoucherCodeTaken
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, s.voucherBatchKey(b.ID), raw, 0)
+			pipe.ZAdd(ctx, s.RawKey("voucher", "batches"), redis.Z{Score: float64(b.Created), Member: b.ID})
+			for i, id := range ids {
+				pipe.HSet(ctx, s.voucherKey(id), map[string]any{
+					"id":          id,
+					"batch":       b.ID,
+					"code":        codes[i],
+					"role":        b.Role,
+					"valid_from":  b.ValidFrom,
+					"valid_until": b.ValidUntil,
+					"duration":    b.Duration,
+					"devices":     b.Devices,
+					"quota_mb":    b.QuotaMB,
+					"created":     b.Created,
+				})
+				pipe.Set(ctx, codeKeys[i], id, 0)
+				pipe.RPush(ctx, s.voucherBatchKey(b.ID)+":ids", id)
+			}
+			return nil
+		})
+		return err
+	}
+
+	for i := 0; i < 3; i++ {
+		err := s.rdb.Watch(ctx, txf, codeKeys...)
+		if err == redis.TxFailedErr {
+			continue
+		}
+		return err
+	}
+	return ErrConflict
+}
+
+// GetVoucherBatch returns the batch with id, or nil.
+func (s *Store) GetVoucherBatch(ctx context.Context, id string) (*VoucherBatch, error) {
+	raw, err := s.rdb.Get(ctx, s.voucherBatchKey(id)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var b VoucherBatch
+	if err := json.Unmarshal(raw, &b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// ListVoucherBatches returns all batches, oldest first.
+func (s *Store) ListVoucherBatches(ctx context.Context) ([]VoucherBatch, error) {
+	ids, err := s.rdb.ZRange(ctx, s.RawKey("voucher", "batches"), 0, -1).Result()
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = s.voucherBatchKey(id)
+	}
+	vals, err := s.rdb.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]VoucherBatch, 0, len(vals))
+	for _, v := range vals {
+		raw, ok := v.(string)
+		if !ok {
+			continue
+		}
+		var b VoucherBatch
+		if json.Unmarshal([]byte(raw), &b) == nil {
+			out = append(out, b)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Created < out[j].Created })
+	return out, nil
+}
+
+// BatchVouchers returns the vouchers of batch id in print order.
+func (s *Store) BatchVouchers(ctx context.Context, id string) ([]Voucher, error) {
+	ids, err := s.rdb.LRange(ctx, s.voucherBatchKey(id)+":ids", 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	return s.getVouchers(ctx, ids)
+}
+
+// GetVoucher returns the voucher with id, or nil.
+func (s *Store) GetVoucher(ctx context.Context, id string) (*Voucher, error) {
+	vs, err := s.getVouchers(ctx, []string{id})
+	if err != nil || len(vs) == 0 {
+		return nil, err
+	}
+	return &vs[0], nil
+}
+
+// VoucherByCode returns the voucher with code, or nil.
+func (s *Store) VoucherByCode(ctx context.Context, code string) (*Voucher, error) {
+	id, err := s.rdb.Get(ctx, s.RawKey("voucher", "code", code)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.GetVoucher(ctx, id)
+}
+
+func (s *Store) getVouchers(ctx context.Context, ids []string) ([]Voucher, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	pipe := s.rdb.Pipeline()
+	hs := make([]*redis.MapStringStringCmd, len(ids))
+	macs := make([]*redis.StringSliceCmd, len(ids))
+	for i, id := range ids {
+		hs[i] = pipe.HGetAll(ctx, s.voucherKey(id))
+		macs[i] = pipe.SMembers(ctx, s.voucherKey(id)+":macs")
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	out := make([]Voucher, 0, len(ids))
+	for i := range ids {
+		if len(hs[i].Val()) == 0 {
+			continue
+		}
+		var v Voucher
+		if err := hs[i].Scan(&v); err != nil {
+			return nil, err
+		}
+		v.MACs = macs[i].Val()
+		sort.Strings(v.MACs)
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+// RedeemVoucher lets mac use voucher id at now: a device that redeemed it
+// before logs in again without taking a use. It returns one of the
+// Voucher* results and the time of the first redemption.
+func (s *Store) RedeemVoucher(ctx context.Context, id, mac string, now int64) (string, int64, error) {
+	k := s.voucherKey(id)
+	res, err := redeemVoucher.Run(ctx, s.rdb, []string{k, k + ":macs"}, mac, now).Slice()
+	if err != nil {
+		return "", 0, err
+	}
+	if len(res) != 2 {
+		return "", 0, errors.New("redeem voucher: bad reply")
+	}
+	result, _ := res[0].(string)
+	first, _ := res[1].(int64)
+	return result, first, nil
+}
+
+// RevokeVouchers marks the given vouchers revoked and returns those that
+// were not revoked before.
+func (s *Store) RevokeVouchers(ctx context.Context, ids []string, by string, now int64) ([]Voucher, error) {
+	vs, err := s.getVouchers(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	var out []Voucher
+	pipe := s.rdb.TxPipeline()
+	for _, v := range vs {
+		if v.Revoked != 0 {
+			continue
+		}
+		pipe.HSet(ctx, s.voucherKey(v.ID), "revoked", now, "revoked_by", by)
+		v.Revoked, v.RevokedBy = now, by
+		out = append(out, v)
+	}
+	if len(out) == 0 {
+		return nil, nil
+	}
+	_, err = pipe.Exec(ctx)
+	return out, err
+}
+
+// AddVoucherUsage adds delta to the usage of voucher id and returns the
+// new total in bytes and the voucher's quota in MiB.
+func (s *Store) AddVoucherUsage(ctx context.Context, id string, delta Counters) (int64, int, error) {
+	k := s.voucherKey(id)
+	pipe := s.rdb.TxPipeline()
+	in := pipe.HIncrBy(ctx, k, "bytes_in", delta.BytesIn)
+	out := pipe.HIncrBy(ctx, k, "bytes_out", delta.BytesOut)
+	quota := pipe.HGet(ctx, k, "quota_mb")
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return 0, 0, err
+	}
+	q, _ := quota.Int()
+	return in.Val() + out.Val(), q, nil
+}
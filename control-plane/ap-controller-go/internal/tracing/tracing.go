@@ -0,0 +1,30 @@
+// Package tracing adds the controller's chi middleware to the shared
+// tracing module (control-plane/tracing), which portal-server and
+// access_device use as well.
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/trace"
+
+	shared "tracing"
+)
+
+// Init installs the global tracer provider; see the shared module for
+// the OTEL_* environment it reads. The returned shutdown flushes
+// pending spans.
+func Init(ctx context.Context, service string) (func(context.Context) error, error) {
+	return shared.Init(ctx, service)
+}
+
+// Tracer returns the controller tracer.
+func Tracer() trace.Tracer { return shared.Tracer() }
+
+// Start begins a span on the controller tracer.
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return shared.Start(ctx, name, opts...)
+}
+
+// TraceID returns the current trace id or "" (for log / audit correlation).
+func TraceID(ctx context.Context) string { return shared.TraceID(ctx) }
@@ -0,0 +1,250 @@
+package transport
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"ap-controller-go/internal/config"
+	"ap-controller-go/internal/security"
+)
+
+// Topics, below controller.mqtt.topic_prefix:
+//
+//	<prefix>/all/<kind>             every AP          (controller -> AP)
+//	<prefix>/site/<site>/<kind>     APs of one site   (controller -> AP)
+//	<prefix>/ap/<ap_id>/<kind>      one AP            (controller -> AP)
+//	<prefix>/ap/<ap_id>/status      AP status         (AP -> controller)
+//	<prefix>/ap/<ap_id>/ack         policy ack        (AP -> controller)
+
+const (
+	handlerTimeout = 10 * time.Second
+	connectTimeout = 10 * time.Second
+)
+
+// ErrNotConnected is returned by Send while the broker is unreachable.
+var ErrNotConnected = errors.New("mqtt: not connected")
+
+// MQTTOptions configures NewMQTT.
+type MQTTOptions struct {
+	Config   config.MQTT
+	Password string
+	// Nonces records message nonces; a replayed message is dropped. The
+	// nonce is shared by all replicas, so with several controllers on the
+	// same broker each AP message is handled exactly once.
+	Nonces security.Store
+	// Handler receives verified AP status / ack messages (may be nil).
+	Handler Handler
+}
+
+// MQTT is a Transport over an MQTT 3.1.1 broker. It connects in the
+// background and keeps reconnecting; subscriptions are restored on every
+// connect.
+type MQTT struct {
+	client   mqtt.Client
+	clientID string
+	prefix   string
+	qos      byte
+	nonces   security.Store
+	handler  Handler
+}
+
+// NewMQTT creates the client and starts connecting. It does not wait for
+// the broker: Send fails until the connection is up.
+func NewMQTT(opts MQTTOptions) (*MQTT, error) {
+	c := opts.Config
+	m := &MQTT{
+		prefix:  strings.TrimSuffix(c.TopicPrefix, "/"),
+		qos:     byte(c.QoS),
+		nonces:  opts.Nonces,
+		handler: opts.Handler,
+	}
+
+	clientID := c.ClientID
+	if clientID == "" {
+		clientID = "ap-controller"
+	}
+	// replicas share the configured id; the broker would disconnect one
+	// whenever another connects with the same id
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+	clientID += "-" + hex.EncodeToString(suffix)
+	m.clientID = clientID
+
+	co := mqtt.NewClientOptions().
+		AddBroker(c.Broker).
+		SetClientID(clientID).
+		SetUsername(c.Username).
+		SetPassword(opts.Password).
+		SetCleanSession(true).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(5 * time.Second).
+		SetMaxReconnectInterval(time.Minute).
+		SetConnectTimeout(connectTimeout).
+		SetKeepAlive(30 * time.Second).
+		// handlers hit redis; don't stall the client's read loop
+		SetOrderMatters(false).
+		SetOnConnectHandler(m.onConnect).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			log.Printf("mqtt: connection lost: %v", err)
+		})
+
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read mqtt ca_file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("mqtt ca_file %s: no certificates", c.CAFile)
+		}
+		co.SetTLSConfig(&tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12})
+	}
+
+	m.client = mqtt.NewClient(co)
+	m.client.Connect()
+	return m, nil
+}
+
+// ClientID returns the broker client id of m.
+func (m *MQTT) ClientID() string {
+	return m.clientID
+}
+
+// WaitConnected blocks until the client is connected or ctx ends.
+func (m *MQTT) WaitConnected(ctx context.Context) error {
+	t := time.NewTicker(20 * time.Millisecond)
+	defer t.Stop()
+	for !m.client.IsConnectionOpen() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+	return nil
+}
+
+// Topic returns the topic a message of kind for target is published on.
+func (m *MQTT) Topic(to Target, kind string) string {
+	switch {
+	case to.APID != "":
+		return m.prefix + "/ap/" + to.APID + "/" + kind
+	case to.Site != "":
+		return m.prefix + "/site/" + to.Site + "/" + kind
+	default:
+		return m.prefix + "/all/" + kind
+	}
+}
+
+// Send signs and publishes msg, waiting for the broker (QoS 1) or ctx.
+func (m *MQTT) Send(ctx context.Context, msg Message) error {
+	if !validLevel(msg.Target.APID) || !validLevel(msg.Target.Site) {
+		return fmt.Errorf("mqtt: invalid topic level in target %+v", msg.Target)
+	}
+	if !m.client.IsConnectionOpen() {
+		return ErrNotConnected
+	}
+	topic := m.Topic(msg.Target, msg.Kind)
+	payload, err := Seal(msg.Target.APID, topic, msg.Body)
+	if err != nil {
+		return err
+	}
+
+	tok := m.client.Publish(topic, m.qos, msg.Retain, payload)
+	select {
+	case <-tok.Done():
+		return tok.Error()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close disconnects, giving in-flight publishes a moment to finish.
+func (m *MQTT) Close() {
+	m.client.Disconnect(250)
+}
+
+func (m *MQTT) onConnect(c mqtt.Client) {
+	log.Printf("mqtt: connected")
+	if m.handler == nil {
+		return
+	}
+	filters := map[string]byte{
+		m.prefix + "/ap/+/" + KindStatus: m.qos,
+		m.prefix + "/ap/+/" + KindAck:    m.qos,
+	}
+	// don't block the connect callback on the SUBACK
+	go func() {
+		tok := c.SubscribeMultiple(filters, m.receive)
+		if tok.WaitTimeout(connectTimeout) && tok.Error() != nil {
+			log.Printf("mqtt: subscribe failed: %v", tok.Error())
+		}
+	}()
+}
+
+// receive verifies an AP message and hands it to the handler. Invalid
+// messages are dropped: MQTT has no way to answer them.
+func (m *MQTT) receive(_ mqtt.Client, msg mqtt.Message) {
+	topic := msg.Topic()
+	apID, kind, ok := m.parseAPTopic(topic)
+	if !ok {
+		return
+	}
+
+	// signed with the key of the AP named in the topic
+	env, err := Open(apID, topic, msg.Payload())
+	if err != nil {
+		log.Printf("mqtt: dropped %s: %v", topic, err)
+		return
+	}
+	if err := security.ValidateTimestamp(env.TS, time.Now()); err != nil {
+		log.Printf("mqtt: dropped %s: %v", topic, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), handlerTimeout)
+	defer cancel()
+	if err := security.ValidateNonce(ctx, m.nonces, env.Nonce); err != nil {
+		// another replica took it, or a replay
+		return
+	}
+
+	in := Inbound{Kind: kind, APID: apID, Body: env.Payload}
+	if err := m.handler(ctx, in); err != nil {
+		log.Printf("mqtt: %s from %s: %v", kind, apID, err)
+	}
+}
+
+// parseAPTopic splits <prefix>/ap/<ap_id>/<kind>.
+func (m *MQTT) parseAPTopic(topic string) (apID, kind string, ok bool) {
+	rest, found := strings.CutPrefix(topic, m.prefix+"/ap/")
+	if !found {
+		return "", "", false
+	}
+	apID, kind, found = strings.Cut(rest, "/")
+	if !found || apID == "" || strings.Contains(kind, "/") {
+		return "", "", false
+	}
+	return apID, kind, true
+}
+
+// validLevel reports whether s can be used as a single topic level.
+func validLevel(s string) bool {
+	return !strings.ContainsAny(s, "/+#")
+}
+
+var _ Transport = (*MQTT)(nil)
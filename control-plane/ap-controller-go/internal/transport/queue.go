@@ -0,0 +1,86 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrQueueFull is returned by Queue.Enqueue when the buffer is full.
+	ErrQueueFull = errors.New("transport: queue full")
+	// ErrQueueClosed is returned by Queue.Enqueue after Close.
+	ErrQueueClosed = errors.New("transport: queue closed")
+)
+
+// Queue sends messages from a bounded buffer in the background, so that
+// request handlers never wait for the broker. Messages are sent in
+// order; a full queue drops the message (APs still converge through
+// polling).
+type Queue struct {
+	t       Transport
+	timeout time.Duration
+	ch      chan Message
+
+	mu     sync.RWMutex
+	closed bool
+	done   chan struct{}
+}
+
+// NewQueue starts a queue of size messages in front of t. Each send gets
+// timeout.
+func NewQueue(t Transport, size int, timeout time.Duration) *Queue {
+	q := &Queue{
+		t:       t,
+		timeout: timeout,
+		ch:      make(chan Message, size),
+		done:    make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+// Enqueue queues msg without blocking.
+func (q *Queue) Enqueue(msg Message) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return ErrQueueClosed
+	}
+	select {
+	case q.ch <- msg:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Len returns the number of queued messages.
+func (q *Queue) Len() int {
+	return len(q.ch)
+}
+
+// Close stops accepting messages and waits until the queued ones are
+// sent (or failed). It does not close the transport.
+func (q *Queue) Close() {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.ch)
+	}
+	q.mu.Unlock()
+	<-q.done
+}
+
+func (q *Queue) run() {
+	defer close(q.done)
+	for msg := range q.ch {
+		ctx, cancel := context.WithTimeout(context.Background(), q.timeout)
+		if err := q.t.Send(ctx, msg); err != nil {
+			log.Printf("transport: send %s failed: %v", msg.Kind, err)
+		}
+		cancel()
+	}
+}
@@ -0,0 +1,113 @@
+// Package transport carries controller-to-AP messages over channels other
+// than the HTTP API (currently MQTT).
+//
+// Every message is wrapped in an Envelope signed with a key derived from
+// the portal HMAC keyset (see security.SignMessage): the key of the AP
+// for its own topics, the fleet key for site and fleet topics. The
+// signature covers the topic.
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"ap-controller-go/internal/security"
+)
+
+// Message kinds sent to APs; they are the last topic level.
+const (
+	KindPolicy  = "policy"  // runtime policy checksum (retained)
+	KindBypass  = "bypass"  // bypass lists checksum (retained)
+	KindSession = "session" // authorize / revoke commands
+	KindCert    = "cert"    // certificate revocation
+)
+
+// Message kinds received from APs.
+const (
+	KindStatus = "status" // same body as POST /api/v1/ap/heartbeat
+	KindAck    = "ack"    // same body as POST /api/v1/ap/policy/ack
+)
+
+// Target addresses one AP (APID), every AP of a site (Site), or every AP
+// when both are empty.
+type Target struct {
+	APID string
+	Site string
+}
+
+// Message is one controller-to-AP message. Retained messages are kept by
+// the broker and delivered to APs when they (re)subscribe.
+type Message struct {
+	Target Target
+	Kind   string
+	Retain bool
+	Body   any
+}
+
+// Inbound is a verified message from an AP. APID is taken from the topic.
+type Inbound struct {
+	Kind string
+	APID string
+	Body []byte
+}
+
+// Handler processes inbound AP messages.
+type Handler func(ctx context.Context, in Inbound) error
+
+// Transport delivers messages to APs.
+type Transport interface {
+	Send(ctx context.Context, msg Message) error
+	Close()
+}
+
+var ErrBadEnvelope = errors.New("bad message envelope")
+
+// Envelope is the signed wire format. Payload is signed exactly as it
+// appears on the wire.
+type Envelope struct {
+	KID     string          `json:"kid,omitempty"`
+	TS      string          `json:"ts"`
+	Nonce   string          `json:"nonce"`
+	Sig     string          `json:"sig"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// Seal encodes body and signs it for topic with the key of scope (the
+// ap_id of an AP topic, "" otherwise).
+func Seal(scope, topic string, body any) ([]byte, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := security.SignMessage(scope, topic, payload)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(Envelope{
+		KID:     sig.KID,
+		TS:      sig.Timestamp,
+		Nonce:   sig.Nonce,
+		Sig:     sig.Signature,
+		Payload: payload,
+	})
+}
+
+// Open verifies an envelope received on topic with the key of scope and
+// returns it. Freshness (timestamp / nonce) is checked by the caller.
+func Open(scope, topic string, raw []byte) (*Envelope, error) {
+	var env Envelope
+	if err := json.Unmarshal(raw, &env); err != nil || len(env.Payload) == 0 {
+		return nil, ErrBadEnvelope
+	}
+	err := security.VerifyMessage(scope, topic, security.Signature{
+		KID:       env.KID,
+		Timestamp: env.TS,
+		Nonce:     env.Nonce,
+		Signature: env.Sig,
+	}, env.Payload)
+	if err != nil {
+		return nil, err
+	}
+	return &env, nil
+}
@@ -0,0 +1,391 @@
+package config_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"ap-controller-go/internal/config"
+)
+
+const validYAML = `
+roles:
+  guest:
+    profile: guest-profile
+profiles:
+  guest-profile:
+    vlan: 100
+    firewall_group: portal_allow_guest
+    session_ttl: 1800
+role_rules:
+  - name: guest-ssid
+    priority: 100
+    when:
+      ssid: [GuestWiFi, "Guest-*"]
+    assign: guest
+bypass:
+  enforce_order: [mac, ip, domain]
+  mac_whitelist: ["70:4d:7b:64:3b:da"]
+  ip_whitelist: ["192.168.16.1", "10.0.0.0/8"]
+dataplane:
+  portal_ip: 192.168.16.118
+  lan_if: br-lan
+`
+
+func TestParseBytes_OK(t *testing.T) {
+	cfg, err := config.ParseBytes([]byte(validYAML))
+	if err != nil {
+		t.Fatalf("expected ok, got %v", err)
+	}
+	if cfg.Redis.Prefix != "session:" {
+		t.Fatalf("default prefix not applied: %q", cfg.Redis.Prefix)
+	}
+}
+
+func TestParseBytes_DeprecatedAdminToken(t *testing.T) {
+	doc := validYAML + `
+controller:
+  enrollment:
+    admin_token_ref: env:OLD_TOKEN
+`
+	cfg, err := config.ParseBytes([]byte(doc))
+	if err != nil {
+		t.Fatalf("expected ok, got %v", err)
+	}
+	if cfg.Controller.AdminTokenRef != "env:OLD_TOKEN" {
+		t.Fatalf("old key not carried over: %q", cfg.Controller.AdminTokenRef)
+	}
+
+	cfg, err = config.ParseBytes([]byte(doc + "  admin_token_ref: env:NEW_TOKEN\n"))
+	if err != nil {
+		t.Fatalf("expected ok, got %v", err)
+	}
+	if cfg.Controller.AdminTokenRef != "env:NEW_TOKEN" {
+		t.Fatalf("new key should win: %q", cfg.Controller.AdminTokenRef)
+	}
+}
+
+func TestParseBytes_ReportsAllErrors(t *testing.T) {
+	doc := `
+roles:
+  guest:
+    profile: missing
+profiles:
+  p:
+    firewall_group: fw
+    session_ttl: 0
+role_rules:
+  - name: r1
+    when:
+      ssid: x
+    assign: nobody
+  - name: r1
+    when:
+      colour: red
+dataplane:
+  portal_ip: 192.168.16.118
+  lan_if: br-lan
+`
+	_, err := config.ParseBytes([]byte(doc))
+
+	var verr *config.ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("expected ValidationError, got %v", err)
+	}
+
+	want := map[string]int{
+		"roles.guest.profile":       4,
+		"profiles.p.session_ttl":    8,
+		"role_rules[0].assign":      13,
+		"role_rules[1].name":        14,
+		"role_rules[1].when.colour": 16,
+	}
+	got := map[string]int{}
+	for _, fe := range verr.Errors {
+		got[fe.Path] = fe.Line
+	}
+	for path, line := range want {
+		l, ok := got[path]
+		if !ok {
+			t.Errorf("missing error for %s (got %v)", path, verr.Errors)
+			continue
+		}
+		if l != line {
+			t.Errorf("%s: expected line %d, got %d", path, line, l)
+		}
+	}
+	if len(verr.Errors) != len(want) {
+		t.Errorf("expected %d errors, got %d: %v", len(want), len(verr.Errors), verr)
+	}
+}
+
+func TestParseBytes_RequiredDataplane(t *testing.T) {
+	_, err := config.ParseBytes([]byte("roles: {}\n"))
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+	for _, s := range []string{"lan_if must be set", "portal_ip must be set"} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("error %q does not mention %q", err, s)
+		}
+	}
+}
+
+func TestParseBytes_TLS(t *testing.T) {
+	cfg, err := config.ParseBytes([]byte(validYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Controller.TLS.ClientAuth != config.ClientAuthNone || cfg.Controller.Timeouts.Shutdown == 0 {
+		t.Fatalf("defaults not applied: %+v %+v", cfg.Controller.TLS, cfg.Controller.Timeouts)
+	}
+
+	bad := validYAML + `
+controller:
+  tls:
+    enabled: true
+    client_auth: require
+`
+	_, err = config.ParseBytes([]byte(bad))
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	for _, s := range []string{"cert_file and key_file must be set", "client_ca_file must be set"} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("error %q does not mention %q", err, s)
+		}
+	}
+}
+
+func TestParseBytes_MQTT(t *testing.T) {
+	cfg, err := config.ParseBytes([]byte(validYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Controller.MQTT.TopicPrefix != "ap-controller" {
+		t.Fatalf("defaults not applied: %+v", cfg.Controller.MQTT)
+	}
+
+	bad := validYAML + `
+controller:
+  mqtt:
+    enabled: true
+    broker: http://broker:1883
+    topic_prefix: "aps/#"
+    qos: 2
+`
+	_, err = config.ParseBytes([]byte(bad))
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	for _, s := range []string{"unsupported broker scheme", "qos must be 0 or 1", "must not contain wildcards"} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("error %q does not mention %q", err, s)
+		}
+	}
+}
+
+func TestParseBytes_Sponsor(t *testing.T) {
+	cfg, err := config.ParseBytes([]byte(validYAML + `
+controller:
+  sponsor:
+    enabled: true
+    role: guest
+    domains: [corp.example]
+    base_url: https://wifi.corp.example
+    link_secret_ref: env:SPONSOR_LINK_SECRET
+    notifier:
+      type: file
+      file: /tmp/sponsor.jsonl
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sp := cfg.Controller.Sponsor; sp.Duration != 28800 || sp.RequestTTL != 900 {
+		t.Fatalf("defaults not applied: %+v", sp)
+	}
+
+	bad := validYAML + `
+controller:
+  sponsor:
+    enabled: true
+    role: visitor
+    base_url: wifi.corp.example
+    notifier:
+      type: pigeon
+`
+	_, err = config.ParseBytes([]byte(bad))
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	for _, s := range []string{`unknown role "visitor"`, "domains must be set", "base_url must be a URL", "link_secret_ref must be set", `unknown notifier type "pigeon"`} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("error %q does not mention %q", err, s)
+		}
+	}
+}
+
+func TestParseBytes_PolicySigning(t *testing.T) {
+	if _, err := config.ParseBytes([]byte(validYAML + `
+controller:
+  policy_signing:
+    enabled: true
+    key_file: /etc/ap-controller/policy-signing.pem
+    key_id: hq-2024.1
+`)); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := config.ParseBytes([]byte(validYAML + `
+controller:
+  policy_signing:
+    enabled: true
+    key_id: "hq 2024"
+    publish_keys: [""]
+`))
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	for _, s := range []string{"key_file must be set", `invalid key_id "hq 2024"`, "empty key file"} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("error %q does not mention %q", err, s)
+		}
+	}
+}
+
+func TestParseBytes_Canary(t *testing.T) {
+	cfg, err := config.ParseBytes([]byte(validYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := cfg.Controller.Convergence.Canary; c.MinLogins != 20 || c.MaxErrorIncrease != 10 {
+		t.Fatalf("defaults not applied: %+v", c)
+	}
+
+	_, err = config.ParseBytes([]byte(validYAML + `
+controller:
+  convergence:
+    canary:
+      max_error_increase: 101
+      max_failed_acks: -1
+`))
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	for _, s := range []string{"max_error_increase must be 0..100", "max_failed_acks must not be negative"} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("error %q does not mention %q", err, s)
+		}
+	}
+}
+
+func TestParseBytes_RateLimit(t *testing.T) {
+	cfg, err := config.ParseBytes([]byte(validYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rl := cfg.Controller.RateLimit
+	if rl.MAC.Rate != 5 || rl.MAC.Burst != 5 || rl.Client.Window != 60 || rl.Lockout.Failures != 5 || rl.Lockout.Max != 3600 {
+		t.Fatalf("defaults not applied: %+v", rl)
+	}
+
+	bad := validYAML + `
+controller:
+  rate_limit:
+    enabled: true
+    ip: {rate: 10, burst: -1}
+    lockout: {base: 600, max: 60}
+`
+	_, err = config.ParseBytes([]byte(bad))
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	for _, s := range []string{"controller.rate_limit.ip", "must not be below base"} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("error %q does not mention %q", err, s)
+		}
+	}
+}
+
+func TestParseBytes_BypassDomains(t *testing.T) {
+	ok := strings.Replace(validYAML, "  ip_whitelist:", `  domains: ["captive.apple.com", "*.msftncsi.com", "xn--bcher-kva.example."]
+  ip_whitelist:`, 1)
+	if _, err := config.ParseBytes([]byte(ok)); err != nil {
+		t.Fatalf("expected ok, got %v", err)
+	}
+
+	bad := strings.Replace(validYAML, "  ip_whitelist:", `  domains: ["a.example'; reboot; '", "-a.example", "localhost", "Apple.com", "a..example"]
+  ip_whitelist:`, 1)
+	_, err := config.ParseBytes([]byte(bad))
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	for i := 0; i < 5; i++ {
+		if s := fmt.Sprintf("bypass.domains[%d]", i); !strings.Contains(err.Error(), s) {
+			t.Errorf("error %q does not mention %q", err, s)
+		}
+	}
+}
+
+func TestParseBytes_Tenants(t *testing.T) {
+	doc := validYAML + `
+tenants:
+  campus-a:
+    hosts: [portal.campus-a.example]
+    hmac_keys: {a1: "env:CAMPUS_A_HMAC"}
+    jwt_secret_ref: "env:CAMPUS_A_JWT"
+    roles:
+      guest: {profile: a-guest}
+    profiles:
+      a-guest: {vlan: 300, firewall_group: campus_a_guest, session_ttl: 600}
+    dataplane: {portal_ip: 10.30.0.1, lan_if: br-a}
+`
+	cfg, err := config.ParseBytes([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Tenants["campus-a"].CurrentKID != "a1" {
+		t.Fatalf("single key not made current: %+v", cfg.Tenants["campus-a"])
+	}
+	tc := cfg.ForTenant("campus-a")
+	if tc.Redis.Prefix != "session:t:campus-a:" || tc.Profiles["a-guest"].VLAN != 300 || tc.Dataplane.LanIF != "br-a" {
+		t.Fatalf("tenant config: %+v", tc)
+	}
+	if len(tc.RoleRules) != 1 || len(tc.Bypass.MacWhitelist) != 1 || tc.Tenants != nil {
+		t.Fatalf("role_rules / bypass not inherited: %+v", tc)
+	}
+
+	bad := validYAML + `
+tenants:
+  Campus_B:
+    hmac_keys: {b1: "env:B1", b2: "env:B2"}
+    roles:
+      guest: {profile: missing}
+  campus-c:
+    hmac_keys: {b1: "env:C1"}
+    jwt_secret_ref: "env:C_JWT"
+    roles:
+      staff: {profile: c-staff}
+    profiles:
+      c-staff: {firewall_group: fw, session_ttl: 60}
+`
+	_, err = config.ParseBytes([]byte(bad))
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	for _, s := range []string{
+		`invalid tenant id "Campus_B"`,
+		"tenants.Campus_B: current_kid must be set",
+		"tenants.Campus_B: jwt_secret_ref must be set",
+		`tenants.Campus_B.roles.guest.profile: unknown profile "missing"`,
+		`tenants.campus-c.hmac_keys.b1: kid "b1" is already used by tenant "Campus_B"`,
+		`tenants.campus-c.roles: default role "guest" must be defined`,
+		// inherited role_rules must fit the tenant's roles
+		`tenants.campus-c.role_rules[0].assign: unknown role "guest"`,
+	} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("error %q does not mention %q", err, s)
+		}
+	}
+}
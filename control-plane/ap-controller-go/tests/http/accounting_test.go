@@ -0,0 +1,122 @@
+package httpapi_test
+
+import (
+	"context"
+	"testing"
+
+	"ap-controller-go/internal/config"
+)
+
+func acctReq(ap, mac, typ string, in, out int64) map[string]any {
+	return map[string]any{
+		"ap_id": ap,
+		"records": []map[string]any{
+			{"mac": mac, "type": typ, "bytes_in": in, "bytes_out": out, "packets_in": 1, "packets_out": 1},
+		},
+	}
+}
+
+func firstResult(t *testing.T, body map[string]any) map[string]any {
+	t.Helper()
+	res, ok := body["results"].([]any)
+	if !ok || len(res) != 1 {
+		t.Fatalf("unexpected results: %v", body)
+	}
+	return res[0].(map[string]any)
+}
+
+func TestAccounting_AccumulatesUsage(t *testing.T) {
+	e := newTestEnv(t, testConfig())
+	mac := "aa:aa:aa:aa:aa:01"
+	e.do("POST", "/portal/login", "", portalReq(mac, ""))
+
+	e.do("POST", "/api/v1/accounting", "", acctReq("ap-1", mac, "start", 0, 0))
+	e.do("POST", "/api/v1/accounting", "", acctReq("ap-1", mac, "interim", 1000, 500))
+	_, body := e.do("POST", "/api/v1/accounting", "", acctReq("ap-1", mac, "interim", 3000, 700))
+	if r := firstResult(t, body); r["status"] != "ok" {
+		t.Fatalf("unexpected result %v", r)
+	}
+
+	_, st := e.do("GET", "/portal/status/"+mac, mac, nil)
+	usage := st["usage"].(map[string]any)
+	if usage["bytes_in"].(float64) != 3000 || usage["bytes_out"].(float64) != 700 {
+		t.Fatalf("unexpected usage %v", usage)
+	}
+}
+
+func TestAccounting_UnknownSession(t *testing.T) {
+	e := newTestEnv(t, testConfig())
+
+	_, body := e.do("POST", "/api/v1/accounting", "", acctReq("ap-1", "aa:aa:aa:aa:aa:09", "interim", 1, 1))
+	if r := firstResult(t, body); r["status"] != "no_session" {
+		t.Fatalf("unexpected result %v", r)
+	}
+}
+
+func TestAccounting_SessionQuotaTerminates(t *testing.T) {
+	cfg := testConfig()
+	p := cfg.Profiles["guest-profile"]
+	p.SessionQuotaMB = 1
+	cfg.Profiles["guest-profile"] = p
+	e := newTestEnv(t, cfg)
+
+	mac := "aa:aa:aa:aa:aa:01"
+	e.do("POST", "/portal/login", "", portalReq(mac, ""))
+
+	_, body := e.do("POST", "/api/v1/accounting", "", acctReq("ap-1", mac, "interim", 1<<20, 0))
+	if r := firstResult(t, body); r["status"] != "terminated" || r["reason"] != "session_quota" {
+		t.Fatalf("unexpected result %v", r)
+	}
+	if sess, _, _ := e.st.GetSessionFull(context.Background(), mac); sess != nil {
+		t.Fatalf("session should be gone")
+	}
+}
+
+func TestAccounting_DailyQuotaThrottles(t *testing.T) {
+	cfg := testConfig()
+	p := cfg.Profiles["guest-profile"]
+	p.DailyQuotaMB = 1
+	p.QuotaAction = config.QuotaThrottle
+	p.ThrottleRole = "guest-slow"
+	cfg.Profiles["guest-profile"] = p
+	cfg.Roles["guest-slow"] = config.RoleDef{Profile: "slow-profile"}
+	cfg.Profiles["slow-profile"] = config.Profile{FirewallGroup: "portal_allow_guest", SessionTTL: 600, DownstreamKbps: 256}
+	e := newTestEnv(t, cfg)
+
+	mac := "aa:aa:aa:aa:aa:01"
+	e.do("POST", "/portal/login", "", portalReq(mac, "alice"))
+
+	_, body := e.do("POST", "/api/v1/accounting", "", acctReq("ap-1", mac, "interim", 0, 2<<20))
+	if r := firstResult(t, body); r["status"] != "throttled" || r["reason"] != "daily_quota" {
+		t.Fatalf("unexpected result %v", r)
+	}
+
+	sess, ttl, _ := e.st.GetSessionFull(context.Background(), mac)
+	if sess == nil || sess.Role != "guest-slow" || sess.Attrs.DownstreamKbps != 256 {
+		t.Fatalf("session not throttled: %+v", sess)
+	}
+	if ttl > 600 {
+		t.Fatalf("throttled session kept ttl %d", ttl)
+	}
+
+	// a new device of the same identity starts throttled
+	code, body := e.do("POST", "/portal/login", "", portalReq("aa:aa:aa:aa:aa:02", "alice"))
+	if code != 200 || body["session"].(map[string]any)["role"] != "guest-slow" {
+		t.Fatalf("expected throttled login, got %d %v", code, body)
+	}
+}
+
+func TestAccounting_NegativeCounters(t *testing.T) {
+	e := newTestEnv(t, testConfig())
+	mac := "aa:aa:aa:aa:aa:01"
+	e.do("POST", "/portal/login", "", portalReq(mac, ""))
+
+	e.do("POST", "/api/v1/accounting", "", acctReq("ap-1", mac, "interim", 1000, 0))
+	_, body := e.do("POST", "/api/v1/accounting", "", acctReq("ap-1", mac, "interim", -5000, 0))
+	if r := firstResult(t, body); r["status"] != "error" || r["reason"] != "bad_counters" {
+		t.Fatalf("unexpected result %v", r)
+	}
+	if sess, _, _ := e.st.GetSessionFull(context.Background(), mac); sess.Usage.Total.BytesIn != 1000 {
+		t.Fatalf("usage changed: %+v", sess.Usage.Total)
+	}
+}
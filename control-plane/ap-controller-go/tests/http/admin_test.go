@@ -0,0 +1,164 @@
+package httpapi_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	httpapi "ap-controller-go/internal/http"
+	"ap-controller-go/internal/security"
+)
+
+var adminJWTSecret = []byte("test-admin-jwt-secret")
+
+func newAdminEnv(t *testing.T) *testEnv {
+	t.Helper()
+	return newTestEnvWith(t, testConfig(), func(s *httpapi.Server) {
+		s.SetAdminToken(adminToken)
+		s.SetAdminJWTSecret(adminJWTSecret)
+	})
+}
+
+// bearer sends a JSON request with Authorization: Bearer token.
+func (e *testEnv) bearer(method, path, token string, body any) (int, map[string]any) {
+	e.t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		_ = json.NewEncoder(&buf).Encode(body)
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rr := httptest.NewRecorder()
+	e.h.ServeHTTP(rr, req)
+	out := map[string]any{}
+	_ = json.Unmarshal(rr.Body.Bytes(), &out)
+	return rr.Code, out
+}
+
+func createKey(e *testEnv, name, role string) (string, string) {
+	e.t.Helper()
+	code, out := e.bearer("POST", "/api/v1/admin/keys", adminToken, map[string]any{"name": name, "role": role})
+	if code != 200 {
+		e.t.Fatalf("create %s key: %d %v", role, code, out)
+	}
+	return out["key"].(string), out["id"].(string)
+}
+
+func TestAdminAPIKeys(t *testing.T) {
+	e := newAdminEnv(t)
+	if code, _ := e.do("POST", "/portal/login", "", portalReq("aa:00:00:00:00:01", "")); code != 200 {
+		t.Fatalf("login: %d", code)
+	}
+
+	ro, roID := createKey(e, "dashboard", "readonly")
+	op, _ := createKey(e, "helpdesk", "operator")
+	if code, _ := e.bearer("POST", "/api/v1/admin/keys", adminToken, map[string]any{"name": "x", "role": "root"}); code != 422 {
+		t.Fatalf("unknown role: %d", code)
+	}
+
+	code, out := e.bearer("GET", "/api/v1/admin/whoami", ro, nil)
+	if code != 200 || out["subject"] != "dashboard" || out["role"] != "readonly" || out["method"] != "api_key" || out["key_id"] != roID {
+		t.Fatalf("whoami: %d %v", code, out)
+	}
+
+	// readonly reads sessions and policy but changes nothing
+	if code, out := e.bearer("GET", "/api/v1/admin/sessions/AA:00:00:00:00:01", ro, nil); code != 200 || out["role"] != "guest" {
+		t.Fatalf("get session: %d %v", code, out)
+	}
+	if code, _ := e.bearer("GET", "/api/v1/admin/sessions/aa:00:00:00:00:09", ro, nil); code != 404 {
+		t.Fatalf("missing session: %d", code)
+	}
+	if code, out := e.bearer("GET", "/api/v1/admin/policy/runtime", ro, nil); code != 200 || out["controller_version"] == nil {
+		t.Fatalf("runtime policy: %d %v", code, out)
+	}
+	bulk := map[string]any{"role": "guest", "macs": []string{"aa:00:00:00:00:02"}}
+	if code, out := e.bearer("POST", "/api/v1/admin/sessions/bulk_login", ro, bulk); code != 403 || out["required_role"] != "operator" {
+		t.Fatalf("readonly bulk login: %d %v", code, out)
+	}
+
+	// operator changes sessions but does not manage keys
+	if code, _ := e.bearer("POST", "/api/v1/admin/sessions/bulk_login", op, bulk); code != 200 {
+		t.Fatalf("operator bulk login: %d", code)
+	}
+	if code, _ := e.bearer("GET", "/api/v1/admin/keys", op, nil); code != 403 {
+		t.Fatalf("operator lists keys: %d", code)
+	}
+
+	// the list never shows secrets
+	code, out = e.bearer("GET", "/api/v1/admin/keys", adminToken, nil)
+	keys := out["keys"].([]any)
+	if code != 200 || len(keys) != 2 {
+		t.Fatalf("list: %d %v", code, out)
+	}
+	var roView map[string]any
+	for _, k := range keys {
+		// both keys were created in the same second, in either order
+		if k := k.(map[string]any); k["id"] == roID {
+			roView = k
+		}
+	}
+	if roView == nil || roView["status"] != "active" || roView["created_by"] != "admin_token" || roView["last_used"] == nil {
+		t.Fatalf("keys = %v", keys)
+	}
+	if _, ok := roView["hash"]; ok {
+		t.Fatalf("hash listed: %v", roView)
+	}
+
+	// revoked keys stop working at once
+	code, out = e.bearer("DELETE", "/api/v1/admin/keys/"+roID, adminToken, nil)
+	if code != 200 || out["status"] != "revoked" || out["revoked_by"] != "admin_token" {
+		t.Fatalf("revoke: %d %v", code, out)
+	}
+	if code, _ := e.bearer("GET", "/api/v1/admin/whoami", ro, nil); code != 401 {
+		t.Fatalf("revoked key: %d", code)
+	}
+	if code, _ := e.bearer("DELETE", "/api/v1/admin/keys/nope", adminToken, nil); code != 404 {
+		t.Fatalf("revoke unknown: %d", code)
+	}
+
+	// a forged secret for a real id is rejected
+	id, _, _ := security.ParseAdminKey(op)
+	if code, _ := e.bearer("GET", "/api/v1/admin/whoami", "apk_"+id+".forged", nil); code != 401 {
+		t.Fatalf("forged key: %d", code)
+	}
+}
+
+func TestAdminJWT(t *testing.T) {
+	e := newAdminEnv(t)
+
+	tok, err := security.IssueAdminJWT(adminJWTSecret, "alice@ops", "operator", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, out := e.bearer("GET", "/api/v1/admin/whoami", tok, nil)
+	if code != 200 || out["subject"] != "alice@ops" || out["method"] != "jwt" {
+		t.Fatalf("whoami: %d %v", code, out)
+	}
+	if code, _ := e.bearer("DELETE", "/api/v1/admin/aps/ap-01", tok, nil); code != 403 {
+		t.Fatalf("operator deletes AP: %d", code)
+	}
+
+	forged, _ := security.IssueAdminJWT([]byte("other"), "mallory", "admin", time.Minute)
+	expired, _ := security.IssueAdminJWT(adminJWTSecret, "alice@ops", "admin", -time.Minute)
+	for _, bad := range []string{forged, expired, "", "not-a-token"} {
+		if code, _ := e.bearer("GET", "/api/v1/admin/whoami", bad, nil); code != 401 {
+			t.Fatalf("token %q: %d", bad, code)
+		}
+	}
+}
+
+func TestPortalStatusOwnMACOnly(t *testing.T) {
+	e := newTestEnv(t, testConfig())
+	e.do("POST", "/portal/login", "", portalReq("aa:00:00:00:00:01", ""))
+
+	if code, out := e.do("GET", "/portal/status/aa:00:00:00:00:01", "AA:00:00:00:00:01", nil); code != 200 || out["authorized"] != true {
+		t.Fatalf("own status: %d %v", code, out)
+	}
+	if code, _ := e.do("GET", "/portal/status/aa:00:00:00:00:01", "aa:00:00:00:00:02", nil); code != 403 {
+		t.Fatalf("other client's status: %d", code)
+	}
+}
@@ -0,0 +1,207 @@
+package httpapi_test
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"ap-controller-go/internal/events"
+	httpapi "ap-controller-go/internal/http"
+)
+
+func batchStatus(e *testEnv, macs ...string) (int, []map[string]any) {
+	e.t.Helper()
+	entries := make([]map[string]any, len(macs))
+	for i, m := range macs {
+		entries[i] = map[string]any{"mac": m}
+	}
+	code, out := e.do("POST", "/api/v1/ap/batch_status", "", map[string]any{"ap_id": "ap-01", "entries": entries})
+	var results []map[string]any
+	raw, _ := json.Marshal(out["results"])
+	_ = json.Unmarshal(raw, &results)
+	return code, results
+}
+
+func TestBatchStatusPipelined(t *testing.T) {
+	cfg := testConfig()
+	cfg.Controller.Batch.PipelineSize = 2
+	cfg.Controller.Batch.MaxStatus = 5
+	e := newTestEnv(t, cfg)
+
+	for _, m := range []string{"aa:00:00:00:00:01", "aa:00:00:00:00:03", "aa:00:00:00:00:04"} {
+		if code, _ := e.do("POST", "/portal/login", "", portalReq(m, "")); code != 200 {
+			t.Fatalf("login %s: %d", m, code)
+		}
+	}
+
+	code, res := batchStatus(e, "AA:00:00:00:00:01", "aa:00:00:00:00:02", "aa:00:00:00:00:03", "aa:00:00:00:00:04", "aa:00:00:00:00:05")
+	if code != 200 || len(res) != 5 {
+		t.Fatalf("batch: %d %v", code, res)
+	}
+	for i, want := range []bool{true, false, true, true, false} {
+		if res[i]["authorized"] != want {
+			t.Fatalf("results[%d] = %v", i, res[i])
+		}
+	}
+	if res[0]["mac"] != "aa:00:00:00:00:01" || res[0]["role"] != "guest" || res[0]["ttl"].(float64) <= 0 ||
+		res[0]["profile"].(map[string]any)["firewall_group"] != "portal_allow_guest" {
+		t.Fatalf("results[0] = %v", res[0])
+	}
+	if _, ok := res[1]["role"]; ok {
+		t.Fatalf("unauthorized entry has a role: %v", res[1])
+	}
+
+	if code, res := batchStatus(e); code != 200 || len(res) != 0 {
+		t.Fatalf("empty batch: %d %v", code, res)
+	}
+	code, _ = batchStatus(e, "a", "b", "c", "d", "e", "f")
+	if code != 413 {
+		t.Fatalf("oversized batch: %d", code)
+	}
+}
+
+func TestBulkLoginLogout(t *testing.T) {
+	cfg := testConfig()
+	cfg.Controller.Batch.MaxBulk = 4
+	var srv *httpapi.Server
+	e := newTestEnvWith(t, cfg, func(s *httpapi.Server) {
+		s.SetAdminToken(adminToken)
+		srv = s
+	})
+	srv.EnableEvents(events.NewHub(e.st, 1000))
+
+	body := map[string]any{
+		"role": "guest",
+		"ttl":  600,
+		"macs": []string{"AA:00:00:00:00:01", "aa:00:00:00:00:02", "aa:00:00:00:00:01", "not-a-mac"},
+	}
+	if rr, _ := e.send("POST", "/api/v1/admin/sessions/bulk_login", body, false, nil); rr.Code != 401 {
+		t.Fatalf("without admin token: %d", rr.Code)
+	}
+	rr, out := e.send("POST", "/api/v1/admin/sessions/bulk_login", body, true, nil)
+	if rr.Code != 200 || out["authorized"] != float64(2) || out["ttl"] != float64(600) {
+		t.Fatalf("bulk login: %d %v", rr.Code, out)
+	}
+	if inv := out["invalid"].([]any); len(inv) != 1 || inv[0] != "not-a-mac" {
+		t.Fatalf("invalid = %v", out["invalid"])
+	}
+
+	_, res := batchStatus(e, "aa:00:00:00:00:01", "aa:00:00:00:00:02")
+	if res[0]["authorized"] != true || res[1]["authorized"] != true || res[1]["ttl"].(float64) > 600 {
+		t.Fatalf("status after bulk login: %v", res)
+	}
+
+	for _, bad := range []map[string]any{
+		{"role": "nope", "macs": []string{"aa:00:00:00:00:09"}},
+		{"role": "guest", "macs": []string{"bad"}},
+	} {
+		if rr, _ := e.send("POST", "/api/v1/admin/sessions/bulk_login", bad, true, nil); rr.Code != 422 {
+			t.Fatalf("bulk login %v: %d", bad, rr.Code)
+		}
+	}
+	tooMany := map[string]any{"role": "guest", "macs": []string{"a", "b", "c", "d", "e"}}
+	if rr, _ := e.send("POST", "/api/v1/admin/sessions/bulk_login", tooMany, true, nil); rr.Code != 413 {
+		t.Fatalf("oversized bulk login: %d", rr.Code)
+	}
+
+	rr, out = e.send("POST", "/api/v1/admin/sessions/bulk_logout", map[string]any{
+		"macs": []string{"aa:00:00:00:00:01", "aa:00:00:00:00:03"}, "reason": "event_ended",
+	}, true, nil)
+	if rr.Code != 200 || out["not_found"] != float64(1) {
+		t.Fatalf("bulk logout: %d %v", rr.Code, out)
+	}
+	if lo := out["logged_out"].([]any); len(lo) != 1 || lo[0] != "aa:00:00:00:00:01" {
+		t.Fatalf("logged_out = %v", out["logged_out"])
+	}
+	_, res = batchStatus(e, "aa:00:00:00:00:01", "aa:00:00:00:00:02")
+	if res[0]["authorized"] != false || res[1]["authorized"] != true {
+		t.Fatalf("status after bulk logout: %v", res)
+	}
+
+	// two created + one deleted, appended to the event stream
+	entries, err := e.st.EventsAfter(context.Background(), "0-0", 10)
+	if err != nil || len(entries) != 3 {
+		t.Fatalf("events: %v %d", err, len(entries))
+	}
+	var last events.Event
+	_ = json.Unmarshal(entries[2].Data, &last)
+	if last.Type != events.SessionDeleted || last.Data["reason"] != "event_ended" {
+		t.Fatalf("last event = %+v", last)
+	}
+}
+
+func TestBatchStatusStoreError(t *testing.T) {
+	e := newTestEnv(t, testConfig())
+	e.mr.SetError("ERR redis down")
+
+	// an outage is not "every client unauthorized"
+	if code, res := batchStatus(e, "aa:00:00:00:00:01"); code != 500 || res != nil {
+		t.Fatalf("batch on store error: %d %v", code, res)
+	}
+}
+
+func TestBatchStatusScopes(t *testing.T) {
+	e := newTestEnvWith(t, testConfig(), func(s *httpapi.Server) { s.SetAdminToken(adminToken) })
+	loginOnAP(e, "aa:00:00:00:00:01", "ap-01")
+	loginOnAP(e, "aa:00:00:00:00:02", "ap-02")
+	entries := []map[string]any{{"mac": "aa:00:00:00:00:01"}, {"mac": "aa:00:00:00:00:02"}}
+
+	// a portal signer only asks about its own client
+	if code, out := e.do("POST", "/portal/batch_status", "aa:00:00:00:00:01", map[string]any{"entries": entries}); code != 403 || out["error"] != "mac_mismatch" {
+		t.Fatalf("portal, other mac: %d %v", code, out)
+	}
+	code, out := e.do("POST", "/portal/batch_status", "aa:00:00:00:00:01", map[string]any{"entries": entries[:1]})
+	if res := out["results"].([]any); code != 200 || len(res) != 1 || res[0].(map[string]any)["authorized"] != true {
+		t.Fatalf("portal, own mac: %d %v", code, out)
+	}
+
+	// an AP does not see the clients of another AP
+	code, res := batchStatus(e, "aa:00:00:00:00:01", "aa:00:00:00:00:02")
+	if code != 200 || res[0]["authorized"] != true || res[1]["authorized"] != false || res[1]["role"] != nil {
+		t.Fatalf("ap: %d %v", code, res)
+	}
+	if code, out := e.do("POST", "/api/v1/ap/batch_status", "", map[string]any{"entries": entries}); code != 422 || out["error"] != "ap_id_required" {
+		t.Fatalf("ap without ap_id: %d %v", code, out)
+	}
+
+	// readonly admins see every client
+	if rr, _ := e.send("POST", "/api/v1/admin/sessions/batch_status", map[string]any{"entries": entries}, false, nil); rr.Code != 401 {
+		t.Fatalf("admin, anonymous: %d", rr.Code)
+	}
+	rr, out := e.send("POST", "/api/v1/admin/sessions/batch_status", map[string]any{"entries": entries}, true, nil)
+	if res := out["results"].([]any); rr.Code != 200 || res[0].(map[string]any)["authorized"] != true || res[1].(map[string]any)["authorized"] != true {
+		t.Fatalf("admin: %d %v", rr.Code, out)
+	}
+}
+
+func TestBatchBodyLimit(t *testing.T) {
+	cfg := testConfig()
+	cfg.Controller.Batch.MaxStatus = 5
+	e := newTestEnv(t, cfg)
+
+	huge := map[string]any{"entries": []map[string]any{{"mac": strings.Repeat("a", 64<<10)}}}
+	if code, out := e.do("POST", "/api/v1/ap/batch_status", "", huge); code != 413 || out["error"] != "body_too_large" {
+		t.Fatalf("oversized body: %d %v", code, out)
+	}
+}
+
+func TestBulkLoginFreesDeviceSlot(t *testing.T) {
+	e := newTestEnvWith(t, testConfig(), func(s *httpapi.Server) { s.SetAdminToken(adminToken) })
+	ctx := context.Background()
+
+	if code, out := e.do("POST", "/portal/login", "", portalReq("aa:00:00:00:00:01", "alice")); code != 200 {
+		t.Fatalf("login: %d %v", code, out)
+	}
+	if devs, _ := e.st.ActiveDevices(ctx, "alice"); len(devs) != 1 {
+		t.Fatalf("devices before = %v", devs)
+	}
+
+	body := map[string]any{"role": "guest", "macs": []string{"aa:00:00:00:00:01"}}
+	if rr, out := e.send("POST", "/api/v1/admin/sessions/bulk_login", body, true, nil); rr.Code != 200 {
+		t.Fatalf("bulk login: %d %v", rr.Code, out)
+	}
+	if devs, _ := e.st.ActiveDevices(ctx, "alice"); len(devs) != 0 {
+		t.Fatalf("replaced session still holds a device slot: %v", devs)
+	}
+}
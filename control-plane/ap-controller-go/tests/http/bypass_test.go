@@ -0,0 +1,132 @@
+package httpapi_test
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	httpapi "ap-controller-go/internal/http"
+	"ap-controller-go/internal/policy"
+	"ap-controller-go/internal/store"
+)
+
+func newBypassEnv(t *testing.T) *testEnv {
+	t.Helper()
+	cfg := testConfig()
+	cfg.Bypass.Enabled = true
+	cfg.Bypass.MacWhitelist = []string{"70:4d:7b:64:3b:da"}
+	return newTestEnvWith(t, cfg, func(s *httpapi.Server) { s.SetAdminToken(adminToken) })
+}
+
+// runtimeFor fetches the runtime policy as the AP of query sees it.
+func (e *testEnv) runtimeFor(query string) (policy.RuntimePolicy, string) {
+	e.t.Helper()
+	rr, out := e.send("GET", "/api/v1/admin/policy/runtime"+query, nil, true, nil)
+	var rp policy.RuntimePolicy
+	if rr.Code != 200 || json.Unmarshal(rr.Body.Bytes(), &rp) != nil {
+		e.t.Fatalf("runtime%s: %d %v", query, rr.Code, out)
+	}
+	return rp, rp.Version.Checksum
+}
+
+func TestBypassScopes(t *testing.T) {
+	e := newBypassEnv(t)
+	static := policy.BuildRuntimePolicy(e.cfg).Version.Checksum
+
+	e.send("POST", "/api/v1/ap/register", map[string]any{"ap_id": "ap-02", "site": "branch"}, false, nil)
+
+	for _, req := range []map[string]any{
+		{"type": "mac", "value": "AA-BB-CC-00-00-01", "reason": "lobby printer"},
+		{"type": "domain", "value": "Updates.Vendor.Example.", "scope": "site", "site": "hq", "reason": "vendor updates", "ttl": 3600},
+		{"type": "ip", "value": "10.0.0.7", "scope": "ap", "ap_id": "ap-02", "reason": "signage player"},
+	} {
+		if rr, out := e.send("POST", "/api/v1/admin/bypass", req, true, nil); rr.Code != 200 || out["id"] == nil {
+			t.Fatalf("add %v: %d %v", req, rr.Code, out)
+		}
+	}
+
+	hq, sumHQ := e.runtimeFor("?ap_id=ap-01&site=hq")
+	if len(hq.Bypass.MacWhitelist) != 2 || hq.Bypass.MacWhitelist[1] != "aa:bb:cc:00:00:01" ||
+		len(hq.Bypass.Domains) != 1 || hq.Bypass.Domains[0] != "updates.vendor.example" || len(hq.Bypass.IPWhitelist) != 0 {
+		t.Fatalf("hq: %+v", hq.Bypass)
+	}
+	// the site comes from the inventory record
+	branch, sumBranch := e.runtimeFor("?ap_id=ap-02")
+	if len(branch.Bypass.MacWhitelist) != 2 || len(branch.Bypass.Domains) != 0 ||
+		len(branch.Bypass.IPWhitelist) != 1 || branch.Bypass.IPWhitelist[0] != "10.0.0.7" {
+		t.Fatalf("branch: %+v", branch.Bypass)
+	}
+	if sumHQ != sumBranch || sumHQ == static {
+		t.Fatalf("checksums: hq %s branch %s static %s", sumHQ, sumBranch, static)
+	}
+	if len(e.cfg.Bypass.MacWhitelist) != 1 {
+		t.Fatalf("config modified: %v", e.cfg.Bypass.MacWhitelist)
+	}
+
+	rr, out := e.send("GET", "/api/v1/admin/bypass?site=hq", nil, true, nil)
+	entries, _ := out["entries"].([]any)
+	if rr.Code != 200 || len(entries) != 2 {
+		t.Fatalf("list hq: %d %v", rr.Code, out)
+	}
+
+	id := store.BypassEntryID("ip", "ap", "", "ap-02", "10.0.0.7")
+	if rr, _ := e.send("DELETE", "/api/v1/admin/bypass/"+id, nil, true, nil); rr.Code != 200 {
+		t.Fatalf("remove: %d", rr.Code)
+	}
+	if rr, _ := e.send("DELETE", "/api/v1/admin/bypass/"+id, nil, true, nil); rr.Code != 404 {
+		t.Fatalf("remove again: %d", rr.Code)
+	}
+	if branch, _ := e.runtimeFor("?ap_id=ap-02"); len(branch.Bypass.IPWhitelist) != 0 {
+		t.Fatalf("removed entry kept: %+v", branch.Bypass)
+	}
+}
+
+func TestBypassExpiry(t *testing.T) {
+	e := newBypassEnv(t)
+	ctx := context.Background()
+	static := policy.BuildRuntimePolicy(e.cfg).Version.Checksum
+
+	_ = e.st.AddBypass(ctx, store.BypassEntry{
+		ID: "byp_old", Type: "mac", Value: "aa:bb:cc:00:00:02", Scope: "global",
+		Reason: "trade fair", Expires: time.Now().Unix() - 1,
+	})
+	rp, sum := e.runtimeFor("")
+	if len(rp.Bypass.MacWhitelist) != 1 || sum != static {
+		t.Fatalf("expired entry applied: %+v %s", rp.Bypass, sum)
+	}
+	if entries, _ := e.st.ListBypass(ctx, 0); len(entries) != 0 {
+		t.Fatalf("expired entry kept: %+v", entries)
+	}
+}
+
+func TestBypassErrors(t *testing.T) {
+	e := newBypassEnv(t)
+
+	for _, c := range []struct {
+		body map[string]any
+		want string
+	}{
+		{map[string]any{"type": "mac", "value": "nope", "reason": "x"}, "bad_value"},
+		{map[string]any{"type": "url", "value": "https://x.example", "reason": "x"}, "bad_value"},
+		{map[string]any{"type": "domain", "value": "*.example.com", "reason": "x"}, "bad_value"},
+		{map[string]any{"type": "domain", "value": "a.example'; reboot; '", "reason": "x"}, "bad_value"},
+		{map[string]any{"type": "domain", "value": "-a.example.com", "reason": "x"}, "bad_value"},
+		{map[string]any{"type": "domain", "value": strings.Repeat("a", 64) + ".example", "reason": "x"}, "bad_value"},
+		{map[string]any{"type": "ip", "value": "10.0.0.0/8", "scope": "site", "reason": "x"}, "bad_scope"},
+		{map[string]any{"type": "ip", "value": "10.0.0.1", "scope": "global", "ap_id": "ap-01", "reason": "x"}, "bad_scope"},
+		{map[string]any{"type": "ip", "value": "10.0.0.1", "scope": "region", "reason": "x"}, "bad_scope"},
+		{map[string]any{"type": "ip", "value": "10.0.0.1"}, "reason_required"},
+		{map[string]any{"type": "ip", "value": "10.0.0.1", "reason": "x", "ttl": -5}, "bad_ttl"},
+	} {
+		if rr, out := e.send("POST", "/api/v1/admin/bypass", c.body, true, nil); rr.Code != 422 || out["error"] != c.want {
+			t.Fatalf("%v: %d %v", c.body, rr.Code, out)
+		}
+	}
+
+	e.cfg.Bypass.Enabled = false
+	if rr, out := e.send("POST", "/api/v1/admin/bypass", map[string]any{"type": "ip", "value": "10.0.0.1", "reason": "x"}, true, nil); rr.Code != 409 || out["error"] != "bypass_disabled" {
+		t.Fatalf("bypass disabled: %d %v", rr.Code, out)
+	}
+}
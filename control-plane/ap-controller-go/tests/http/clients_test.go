@@ -0,0 +1,113 @@
+package httpapi_test
+
+import (
+	"testing"
+)
+
+func clientChanges(t *testing.T, out map[string]any) []map[string]any {
+	t.Helper()
+	if out["mode"] != "delta" {
+		t.Fatalf("mode = %v", out)
+	}
+	var res []map[string]any
+	for _, c := range out["changes"].([]any) {
+		res = append(res, c.(map[string]any))
+	}
+	return res
+}
+
+func TestClientFeed(t *testing.T) {
+	cfg := testConfig()
+	cfg.Controller.ClientFeed.PageSize = 2
+	e := newTestEnv(t, cfg)
+
+	// empty log: full snapshot with the "0-0" cursor
+	code, out := e.do("GET", "/api/v1/ap/clients?ap_id=ap-01", "", nil)
+	if code != 200 || out["mode"] != "full" || out["cursor"] != "0-0" || len(out["groups"].(map[string]any)) != 0 {
+		t.Fatalf("empty snapshot: %d %v", code, out)
+	}
+
+	for _, m := range []string{"aa:00:00:00:00:01", "aa:00:00:00:00:02"} {
+		if code, _ := e.do("POST", "/portal/login", "", portalReq(m, "")); code != 200 {
+			t.Fatalf("login %s: %d", m, code)
+		}
+	}
+
+	// the empty log's cursor can't be told from a trimmed one
+	_, out = e.do("GET", "/api/v1/ap/clients?ap_id=ap-01&since=0-0", "", nil)
+	guests := out["groups"].(map[string]any)["portal_allow_guest"].([]any)
+	if out["mode"] != "full" || len(guests) != 2 || guests[0].(map[string]any)["ttl"].(float64) <= 0 {
+		t.Fatalf("snapshot: %v", out)
+	}
+	cursor := out["cursor"].(string)
+
+	e.do("POST", "/portal/heartbeat", "aa:00:00:00:00:01", portalReq("aa:00:00:00:00:01", ""))
+	e.do("POST", "/portal/logout", "aa:00:00:00:00:02", portalReq("aa:00:00:00:00:02", ""))
+	e.do("POST", "/portal/login", "", portalReq("aa:00:00:00:00:03", ""))
+
+	// deltas page through the log
+	_, out = e.do("GET", "/api/v1/ap/clients?ap_id=ap-01&since="+cursor, "", nil)
+	ch := clientChanges(t, out)
+	if len(ch) != 2 || out["more"] != true ||
+		ch[0]["op"] != "refresh" || ch[0]["ttl"].(float64) <= 0 || ch[0]["group"] != "portal_allow_guest" ||
+		ch[1]["op"] != "remove" || ch[1]["mac"] != "aa:00:00:00:00:02" {
+		t.Fatalf("delta: %v", out)
+	}
+	_, out = e.do("GET", "/api/v1/ap/clients?ap_id=ap-01&since="+out["cursor"].(string), "", nil)
+	ch = clientChanges(t, out)
+	if len(ch) != 1 || ch[0]["op"] != "add" || ch[0]["mac"] != "aa:00:00:00:00:03" || out["more"] != false {
+		t.Fatalf("second page: %v", out)
+	}
+	cursor = out["cursor"].(string)
+	_, out = e.do("GET", "/api/v1/ap/clients?ap_id=ap-01&since="+cursor, "", nil)
+	if ch := clientChanges(t, out); len(ch) != 0 || out["cursor"] != cursor {
+		t.Fatalf("caught up: %v", out)
+	}
+
+	// cursors before the log (trimmed) or after it (reset) get a snapshot
+	for _, c := range []string{"1-0", "99999999999999-0"} {
+		_, out = e.do("GET", "/api/v1/ap/clients?ap_id=ap-01&since="+c, "", nil)
+		guests = out["groups"].(map[string]any)["portal_allow_guest"].([]any)
+		if out["mode"] != "full" || out["cursor"] != cursor || len(guests) != 2 {
+			t.Fatalf("since=%s: %v", c, out)
+		}
+	}
+
+	if code, _ := e.do("GET", "/api/v1/ap/clients?ap_id=ap-01&since=latest", "", nil); code != 400 {
+		t.Fatalf("bad cursor: %d", code)
+	}
+}
+
+func TestClientFeedPerAP(t *testing.T) {
+	e := newTestEnv(t, testConfig())
+
+	if code, out := e.do("GET", "/api/v1/ap/clients", "", nil); code != 422 {
+		t.Fatalf("no ap_id: %d %v", code, out)
+	}
+
+	loginOnAP(e, "aa:00:00:00:00:01", "ap-01")
+	loginOnAP(e, "aa:00:00:00:00:02", "ap-02")
+
+	// the snapshot holds the clients of the caller only
+	_, out := e.do("GET", "/api/v1/ap/clients?ap_id=ap-01&since=0-0", "", nil)
+	guests := out["groups"].(map[string]any)["portal_allow_guest"].([]any)
+	if out["mode"] != "full" || len(guests) != 1 || guests[0].(map[string]any)["mac"] != "aa:00:00:00:00:01" {
+		t.Fatalf("snapshot: %v", out)
+	}
+	cursor := out["cursor"].(string)
+
+	// adds of other APs are skipped but still move the cursor; removals
+	// go to every AP
+	loginOnAP(e, "aa:00:00:00:00:03", "ap-02")
+	e.do("POST", "/portal/logout", "aa:00:00:00:00:02", portalReq("aa:00:00:00:00:02", ""))
+	_, out = e.do("GET", "/api/v1/ap/clients?ap_id=ap-01&since="+cursor, "", nil)
+	ch := clientChanges(t, out)
+	if len(ch) != 1 || ch[0]["op"] != "remove" || ch[0]["mac"] != "aa:00:00:00:00:02" || out["cursor"] == cursor {
+		t.Fatalf("delta: %v", out)
+	}
+	_, out = e.do("GET", "/api/v1/ap/clients?ap_id=ap-02&since="+cursor, "", nil)
+	ch = clientChanges(t, out)
+	if len(ch) != 2 || ch[0]["op"] != "add" || ch[0]["ap_id"] != "ap-02" || ch[1]["op"] != "remove" {
+		t.Fatalf("delta ap-02: %v", out)
+	}
+}
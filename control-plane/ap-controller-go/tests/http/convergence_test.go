@@ -0,0 +1,83 @@
+package httpapi_test
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"ap-controller-go/internal/policy"
+)
+
+func TestPolicyConvergence(t *testing.T) {
+	e, srv := newInventoryEnv(t)
+	e.cfg.Controller.Convergence.StaleAfter = 60
+	ctx := context.Background()
+	current := policy.BuildRuntimePolicy(e.cfg).Version.Checksum
+
+	for _, ap := range []string{"ap-01", "ap-02", "ap-03"} {
+		e.send("POST", "/api/v1/ap/register", apBody(ap), false, nil) // policy_checksum "abc"
+	}
+
+	// the deadline runs from publication, not from the first query
+	if got, _ := srv.CheckStalePolicies(ctx, time.Now().Add(time.Hour)); len(got) != 0 {
+		t.Fatalf("alerted before publication: %v", got)
+	}
+	if err := srv.PublishPolicyState(ctx); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+
+	ack := func(ap, status, errMsg string) (int, map[string]any) {
+		rr, out := e.send("POST", "/api/v1/ap/policy/ack", map[string]any{
+			"ap_id": ap, "checksum": current, "version": "0.1.0", "status": status, "error": errMsg,
+		}, false, nil)
+		return rr.Code, out
+	}
+
+	if code, out := ack("ap-01", "applied", ""); code != 200 || out["state"] != "current" {
+		t.Fatalf("ack applied: %d %v", code, out)
+	}
+	if code, out := ack("ap-02", "failed", "fw4 reload failed"); code != 200 || out["state"] != "failed" {
+		t.Fatalf("ack failed: %d %v", code, out)
+	}
+	if code, _ := ack("ap-01", "done", ""); code != 422 {
+		t.Fatalf("bad status: %d", code)
+	}
+	if code, _ := ack("ap-99", "applied", ""); code != 404 {
+		t.Fatalf("unregistered ack: %d", code)
+	}
+
+	rr, out := e.send("GET", "/api/v1/admin/policy/convergence", nil, true, nil)
+	if rr.Code != 200 {
+		t.Fatalf("convergence: %d", rr.Code)
+	}
+	summary := out["summary"].(map[string]any)
+	if summary["current"] != float64(1) || summary["lagging"] != float64(1) || summary["failed"] != float64(1) {
+		t.Fatalf("summary = %v", summary)
+	}
+	failed := out["aps"].(map[string]any)["failed"].([]any)[0].(map[string]any)
+	if failed["ap_id"] != "ap-02" || failed["error"] != "fw4 reload failed" {
+		t.Fatalf("failed = %v", failed)
+	}
+	if n := len(out["versions"].([]any)); n != 2 {
+		t.Fatalf("versions = %v", out["versions"])
+	}
+	if out["overdue"] != false {
+		t.Fatalf("overdue right after publish: %v", out)
+	}
+
+	// nothing fires before the deadline
+	if got, _ := srv.CheckStalePolicies(ctx, time.Now()); len(got) != 0 {
+		t.Fatalf("alerted before deadline: %v", got)
+	}
+
+	later := time.Now().Add(61 * time.Second)
+	got, err := srv.CheckStalePolicies(ctx, later)
+	sort.Strings(got)
+	if err != nil || len(got) != 2 || got[0] != "ap-02" || got[1] != "ap-03" {
+		t.Fatalf("stale alerts = %v, %v", got, err)
+	}
+	if got, _ := srv.CheckStalePolicies(ctx, later); len(got) != 0 {
+		t.Fatalf("stale alert repeated: %v", got)
+	}
+}
@@ -0,0 +1,142 @@
+package httpapi_test
+
+import (
+	"context"
+	"testing"
+
+	httpapi "ap-controller-go/internal/http"
+	"ap-controller-go/internal/policy"
+)
+
+func newDenyEnv(t *testing.T) *testEnv {
+	t.Helper()
+	cfg := testConfig()
+	p := cfg.Profiles["guest-profile"]
+	p.RememberDevice = 3600
+	cfg.Profiles["guest-profile"] = p
+	return newTestEnvWith(t, cfg, func(s *httpapi.Server) { s.SetAdminToken(adminToken) })
+}
+
+func TestDenylistMAC(t *testing.T) {
+	e := newDenyEnv(t)
+	ctx := context.Background()
+	mac := "00:11:22:00:0d:01"
+
+	if code, _ := e.do("POST", "/portal/login", "", portalReq(mac, "")); code != 200 {
+		t.Fatalf("login: %d", code)
+	}
+	rr, out := e.send("POST", "/api/v1/admin/denylist", map[string]any{"pattern": "00-11-22-00-0D-01", "reason": "port scanning", "ttl": 3600}, true, nil)
+	if rr.Code != 200 || out["pattern"] != mac || out["kind"] != "mac" || out["expires"] == nil {
+		t.Fatalf("add: %d %v", rr.Code, out)
+	}
+	if sess, _, _ := e.st.GetSessionFull(ctx, mac); sess != nil {
+		t.Fatalf("session kept: %+v", sess)
+	}
+
+	if code, out := e.do("POST", "/portal/login", "", portalReq(mac, "")); code != 403 || out["error"] != "denylisted" {
+		t.Fatalf("login denied: %d %v", code, out)
+	}
+	if code, out := e.do("POST", "/api/v1/ap/mab", "", apMAB(mac, "")); code != 403 || out["error"] != "denylisted" {
+		t.Fatalf("mab denied: %d %v", code, out)
+	}
+
+	rr, out = e.send("GET", "/api/v1/admin/denylist", nil, true, nil)
+	if entries, _ := out["entries"].([]any); rr.Code != 200 || len(entries) != 1 {
+		t.Fatalf("list: %d %v", rr.Code, out)
+	}
+
+	if rr, _ := e.send("DELETE", "/api/v1/admin/denylist/"+mac, nil, true, nil); rr.Code != 200 {
+		t.Fatalf("remove: %d", rr.Code)
+	}
+	if rr, _ := e.send("DELETE", "/api/v1/admin/denylist/"+mac, nil, true, nil); rr.Code != 404 {
+		t.Fatalf("remove again: %d", rr.Code)
+	}
+	if code, _ := e.do("POST", "/portal/login", "", portalReq(mac, "")); code != 200 {
+		t.Fatalf("login after remove: %d", code)
+	}
+}
+
+func TestDenylistOUIHeartbeat(t *testing.T) {
+	e := newDenyEnv(t)
+	ctx := context.Background()
+	mac := "00:11:33:00:0d:02"
+
+	e.do("POST", "/portal/login", "", portalReq(mac, ""))
+	if rr, out := e.send("POST", "/api/v1/admin/denylist", map[string]any{"pattern": "00:11:33:*", "reason": "rogue vendor"}, true, nil); rr.Code != 200 || out["kind"] != "oui" {
+		t.Fatalf("add: %d %v", rr.Code, out)
+	}
+	// an OUI entry ends sessions on their next heartbeat
+	if sess, _, _ := e.st.GetSessionFull(ctx, mac); sess == nil {
+		t.Fatal("session ended early")
+	}
+	code, out := e.do("POST", "/portal/heartbeat", mac, portalReq(mac, ""))
+	if code != 200 || out["authorized"] != false || out["error"] != "denylisted" {
+		t.Fatalf("heartbeat: %d %v", code, out)
+	}
+	if sess, _, _ := e.st.GetSessionFull(ctx, mac); sess != nil {
+		t.Fatalf("session kept: %+v", sess)
+	}
+
+	for _, c := range []struct {
+		body map[string]any
+		want string
+	}{
+		{map[string]any{"pattern": "00:11", "reason": "x"}, "bad_pattern"},
+		{map[string]any{"pattern": "00:11:44", "reason": " "}, "reason_required"},
+		{map[string]any{"pattern": "00:11:44", "reason": "x", "ttl": -1}, "bad_ttl"},
+	} {
+		if rr, out := e.send("POST", "/api/v1/admin/denylist", c.body, true, nil); rr.Code != 422 || out["error"] != c.want {
+			t.Fatalf("%v: %d %v", c.body, rr.Code, out)
+		}
+	}
+}
+
+func TestDenylistRuntimeFeed(t *testing.T) {
+	e := newDenyEnv(t)
+	static := policy.BuildRuntimePolicy(e.cfg).Version.Checksum
+
+	rr, out := e.send("GET", "/api/v1/admin/policy/runtime", nil, true, nil)
+	if rr.Code != 200 || rr.Header().Get("X-Policy-Checksum") != static {
+		t.Fatalf("empty denylist changed the checksum: %d %v", rr.Code, out)
+	}
+
+	e.send("POST", "/api/v1/admin/denylist", map[string]any{"pattern": "00:11:55", "reason": "rogue vendor"}, true, nil)
+	rr, out = e.send("GET", "/api/v1/admin/policy/runtime", nil, true, nil)
+	list, _ := out["denylist"].([]any)
+	if rr.Code != 200 || len(list) != 1 || rr.Header().Get("X-Policy-Checksum") == static {
+		t.Fatalf("runtime: %d %v", rr.Code, out)
+	}
+	if d := list[0].(map[string]any); d["pattern"] != "00:11:55" || d["kind"] != "oui" {
+		t.Fatalf("entry: %v", d)
+	}
+}
+
+func TestDenylistOtherPaths(t *testing.T) {
+	e := newDenyEnv(t)
+	ctx := context.Background()
+	mac := "00:11:22:00:0d:02"
+	e.send("POST", "/api/v1/admin/denylist", map[string]any{"pattern": mac, "reason": "stolen"}, true, nil)
+
+	// a non-canonical spelling can't slip past the denylist
+	if code, out := e.do("POST", "/portal/login", "", portalReq("00-11-22-00-0D-02", "")); code != 422 || out["error"] != "mac_required" {
+		t.Fatalf("dashed login: %d %v", code, out)
+	}
+	if code, out := e.do("POST", "/portal/heartbeat", "00-11-22-00-0D-02", portalReq("00-11-22-00-0D-02", "")); code != 422 {
+		t.Fatalf("dashed heartbeat: %d %v", code, out)
+	}
+
+	// bulk logins leave denylisted devices out
+	rr, out := e.send("POST", "/api/v1/admin/sessions/bulk_login", map[string]any{
+		"role": "guest", "macs": []string{mac, "00:11:22:00:0d:03"},
+	}, true, nil)
+	if denied, _ := out["denied"].([]any); rr.Code != 200 || out["authorized"] != float64(1) || len(denied) != 1 || denied[0] != mac {
+		t.Fatalf("bulk login: %d %v", rr.Code, out)
+	}
+	if sess, _, _ := e.st.GetSessionFull(ctx, mac); sess != nil {
+		t.Fatalf("denylisted device authorized: %+v", sess)
+	}
+	rr, out = e.send("POST", "/api/v1/admin/sessions/bulk_login", map[string]any{"role": "guest", "macs": []string{mac}}, true, nil)
+	if rr.Code != 403 || out["error"] != "denylisted" {
+		t.Fatalf("bulk login of denied only: %d %v", rr.Code, out)
+	}
+}
@@ -0,0 +1,290 @@
+package httpapi_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	httpapi "ap-controller-go/internal/http"
+	"ap-controller-go/internal/pki"
+)
+
+const adminToken = "test-admin-token"
+
+func writeTestCA(t *testing.T) *pki.CA {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test enrollment ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kder, _ := x509.MarshalPKCS8PrivateKey(key)
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key")
+	_ = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	_ = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: kder}), 0o600)
+
+	ca, err := pki.LoadCA(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca
+}
+
+func newEnrollEnv(t *testing.T) (*testEnv, *pki.CA) {
+	cfg := testConfig()
+	cfg.Controller.Enrollment.CertTTL = 3600
+	cfg.Controller.Enrollment.TokenTTL = 600
+	cfg.Controller.Enrollment.RenewBefore = 3600
+	ca := writeTestCA(t)
+	e := newTestEnvWith(t, cfg, func(s *httpapi.Server) {
+		s.SetAdminToken(adminToken)
+		s.EnableEnrollment(ca)
+	})
+	return e, ca
+}
+
+func newCSR(t *testing.T, cn string) string {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: cn},
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))
+}
+
+// send issues a request with optional admin auth and mTLS peer cert.
+func (e *testEnv) send(method, path string, body any, admin bool, peer *x509.Certificate) (*httptest.ResponseRecorder, map[string]any) {
+	e.t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		_ = json.NewEncoder(&buf).Encode(body)
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	if admin {
+		req.Header.Set("Authorization", "Bearer "+adminToken)
+	}
+	if peer != nil {
+		req.TLS = &tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{peer},
+			VerifiedChains:   [][]*x509.Certificate{{peer}},
+		}
+	}
+	rr := httptest.NewRecorder()
+	e.h.ServeHTTP(rr, req)
+	out := map[string]any{}
+	_ = json.Unmarshal(rr.Body.Bytes(), &out)
+	return rr, out
+}
+
+func parseIssued(t *testing.T, out map[string]any) *x509.Certificate {
+	t.Helper()
+	s, _ := out["certificate"].(string)
+	block, _ := pem.Decode([]byte(s))
+	if block == nil {
+		t.Fatalf("no certificate in %v", out)
+	}
+	c, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func (e *testEnv) enrollToken(apID, site string) string {
+	e.t.Helper()
+	rr, out := e.send("POST", "/api/v1/enroll/tokens", map[string]any{"ap_id": apID, "site": site}, true, nil)
+	if rr.Code != 200 {
+		e.t.Fatalf("create token: %d %v", rr.Code, out)
+	}
+	return out["token"].(string)
+}
+
+func TestEnrollIssuesBoundCertificateOnce(t *testing.T) {
+	e, ca := newEnrollEnv(t)
+
+	if rr, _ := e.send("POST", "/api/v1/enroll/tokens", map[string]any{"ap_id": "ap-01"}, false, nil); rr.Code != 401 {
+		t.Fatalf("token without admin auth: %d", rr.Code)
+	}
+
+	token := e.enrollToken("ap-01", "hq")
+
+	// a broken CSR must not consume the token
+	if rr, out := e.send("POST", "/api/v1/enroll", map[string]any{"token": token, "csr": "junk"}, false, nil); rr.Code != 422 {
+		t.Fatalf("bad csr: %d %v", rr.Code, out)
+	}
+
+	// the AP asks for a different CN; the controller ignores it
+	rr, out := e.send("POST", "/api/v1/enroll", map[string]any{"token": token, "csr": newCSR(t, "evil")}, false, nil)
+	if rr.Code != 200 {
+		t.Fatalf("enroll: %d %v", rr.Code, out)
+	}
+	cert := parseIssued(t, out)
+
+	if cert.Subject.CommonName != "ap-01" || len(cert.URIs) != 1 || cert.URIs[0].String() != "ap://hq/ap-01" {
+		t.Fatalf("subject=%v uris=%v", cert.Subject, cert.URIs)
+	}
+	if err := cert.CheckSignatureFrom(ca.Certificate()); err != nil {
+		t.Fatalf("not signed by ca: %v", err)
+	}
+	if life := time.Until(cert.NotAfter); life > time.Hour || life < 50*time.Minute {
+		t.Fatalf("lifetime = %v", life)
+	}
+
+	if rr, _ := e.send("POST", "/api/v1/enroll", map[string]any{"token": token, "csr": newCSR(t, "x")}, false, nil); rr.Code != 403 {
+		t.Fatalf("token reuse: %d", rr.Code)
+	}
+}
+
+func TestEnrollRenewWithCurrentCert(t *testing.T) {
+	e, _ := newEnrollEnv(t)
+
+	_, out := e.send("POST", "/api/v1/enroll", map[string]any{"token": e.enrollToken("ap-02", "lab"), "csr": newCSR(t, "")}, false, nil)
+	old := parseIssued(t, out)
+
+	if rr, _ := e.send("POST", "/api/v1/enroll/renew", map[string]any{"csr": newCSR(t, "")}, false, nil); rr.Code != 401 {
+		t.Fatalf("renew without cert: %d", rr.Code)
+	}
+
+	rr, out := e.send("POST", "/api/v1/enroll/renew", map[string]any{"csr": newCSR(t, "")}, false, old)
+	if rr.Code != 200 {
+		t.Fatalf("renew: %d %v", rr.Code, out)
+	}
+	renewed := parseIssued(t, out)
+	if renewed.SerialNumber.Cmp(old.SerialNumber) == 0 || renewed.URIs[0].String() != "ap://lab/ap-02" {
+		t.Fatalf("renewed serial=%v uris=%v", renewed.SerialNumber, renewed.URIs)
+	}
+
+	// the renewed certificate is superseded and cannot renew again
+	if err := pki.RevocationChecker(e.st)(old); !errors.Is(err, pki.ErrRevoked) {
+		t.Fatalf("old cert: err = %v", err)
+	}
+	if rr, out := e.send("POST", "/api/v1/enroll/renew", map[string]any{"csr": newCSR(t, "")}, false, old); rr.Code != 403 || out["error"] != "cert_revoked" {
+		t.Fatalf("second renew: %d %v", rr.Code, out)
+	}
+	if err := pki.RevocationChecker(e.st)(renewed); err != nil {
+		t.Fatalf("renewed cert: %v", err)
+	}
+}
+
+func TestEnrollRenewTooEarly(t *testing.T) {
+	e, _ := newEnrollEnv(t)
+	e.cfg.Controller.Enrollment.RenewBefore = 600
+
+	_, out := e.send("POST", "/api/v1/enroll", map[string]any{"token": e.enrollToken("ap-02", "lab"), "csr": newCSR(t, "")}, false, nil)
+	cert := parseIssued(t, out)
+
+	rr, out := e.send("POST", "/api/v1/enroll/renew", map[string]any{"csr": newCSR(t, "")}, false, cert)
+	if rr.Code != 409 || out["error"] != "too_early" || out["renew_after"].(float64) != float64(cert.NotAfter.Unix()-600) {
+		t.Fatalf("early renew: %d %v", rr.Code, out)
+	}
+	if err := pki.RevocationChecker(e.st)(cert); err != nil {
+		t.Fatalf("cert revoked by a refused renewal: %v", err)
+	}
+}
+
+func TestRevokeByAPID(t *testing.T) {
+	e, _ := newEnrollEnv(t)
+
+	var certs []*x509.Certificate
+	for i := 0; i < 2; i++ {
+		_, out := e.send("POST", "/api/v1/enroll", map[string]any{"token": e.enrollToken("ap-04", "hq"), "csr": newCSR(t, "")}, false, nil)
+		certs = append(certs, parseIssued(t, out))
+	}
+	_, out := e.send("POST", "/api/v1/enroll", map[string]any{"token": e.enrollToken("ap-05", "hq"), "csr": newCSR(t, "")}, false, nil)
+	other := parseIssued(t, out)
+
+	if rr, out := e.send("POST", "/api/v1/pki/revoke", map[string]any{"ap_id": "ap-04", "serial": "1"}, true, nil); rr.Code != 422 {
+		t.Fatalf("serial and ap_id: %d %v", rr.Code, out)
+	}
+	rr, out := e.send("POST", "/api/v1/pki/revoke", map[string]any{"ap_id": "ap-04", "reason": "stolen"}, true, nil)
+	if revoked, _ := out["revoked"].([]any); rr.Code != 200 || len(revoked) != 2 {
+		t.Fatalf("revoke ap: %d %v", rr.Code, out)
+	}
+	check := pki.RevocationChecker(e.st)
+	for _, c := range certs {
+		if err := check(c); !errors.Is(err, pki.ErrRevoked) {
+			t.Fatalf("cert of ap-04: err = %v", err)
+		}
+	}
+	if err := check(other); err != nil {
+		t.Fatalf("cert of ap-05: %v", err)
+	}
+	if _, out := e.send("POST", "/api/v1/pki/revoke", map[string]any{"ap_id": "ap-04"}, true, nil); len(out["revoked"].([]any)) != 0 {
+		t.Fatalf("revoked twice: %v", out)
+	}
+}
+
+func TestRevokeDeniesHandshakeAndListsInCRL(t *testing.T) {
+	e, ca := newEnrollEnv(t)
+
+	_, out := e.send("POST", "/api/v1/enroll", map[string]any{"token": e.enrollToken("ap-03", "hq"), "csr": newCSR(t, "")}, false, nil)
+	cert := parseIssued(t, out)
+	serial := pki.SerialString(cert.SerialNumber)
+
+	check := pki.RevocationChecker(e.st)
+	if err := check(cert); err != nil {
+		t.Fatalf("fresh cert rejected: %v", err)
+	}
+
+	if rr, _ := e.send("POST", "/api/v1/pki/revoke", map[string]any{"serial": serial}, false, nil); rr.Code != 401 {
+		t.Fatalf("revoke without admin auth: %d", rr.Code)
+	}
+	rr, out := e.send("POST", "/api/v1/pki/revoke", map[string]any{"serial": serial, "reason": "stolen"}, true, nil)
+	if rr.Code != 200 || out["ap_id"] != "ap-03" {
+		t.Fatalf("revoke: %d %v", rr.Code, out)
+	}
+
+	if err := check(cert); !errors.Is(err, pki.ErrRevoked) {
+		t.Fatalf("revoked cert: err = %v", err)
+	}
+
+	rr, _ = e.send("GET", "/api/v1/pki/crl", nil, false, nil)
+	if rr.Code != 200 || rr.Header().Get("Content-Type") != "application/pkix-crl" {
+		t.Fatalf("crl: %d %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	crl, err := x509.ParseRevocationList(rr.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := crl.CheckSignatureFrom(ca.Certificate()); err != nil {
+		t.Fatalf("crl signature: %v", err)
+	}
+	if len(crl.RevokedCertificateEntries) != 1 || crl.RevokedCertificateEntries[0].SerialNumber.Cmp(cert.SerialNumber) != 0 {
+		t.Fatalf("crl entries = %v", crl.RevokedCertificateEntries)
+	}
+}
+
+func TestEnrollDisabledByDefault(t *testing.T) {
+	e := newTestEnv(t, testConfig())
+	if rr, _ := e.send("POST", "/api/v1/enroll", map[string]any{}, false, nil); rr.Code == http.StatusOK {
+		t.Fatalf("enroll with CA disabled: %d", rr.Code)
+	}
+}
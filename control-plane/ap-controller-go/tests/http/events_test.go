@@ -0,0 +1,244 @@
+package httpapi_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"ap-controller-go/internal/audit"
+	"ap-controller-go/internal/events"
+	httpapi "ap-controller-go/internal/http"
+	"ap-controller-go/internal/security"
+)
+
+func newEventsEnv(t *testing.T) (*testEnv, *httpapi.Server, *events.Hub, *httptest.Server) {
+	cfg := testConfig()
+	cfg.Controller.Events.Enabled = true
+	cfg.Controller.Events.MaxLen = 1000
+	cfg.Controller.Events.Keepalive = 1
+
+	var srv *httpapi.Server
+	var hub *events.Hub
+	e := newTestEnvWith(t, cfg, func(s *httpapi.Server) {
+		srv = s
+	})
+	hub = events.NewHub(e.st, 1000)
+	srv.EnableEvents(hub)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go hub.Run(ctx)
+
+	ts := httptest.NewServer(e.h)
+	t.Cleanup(func() {
+		cancel()
+		ts.CloseClientConnections()
+		ts.Close()
+	})
+	return e, srv, hub, ts
+}
+
+type sseFrame struct {
+	ID    string
+	Event string
+	Data  map[string]any
+}
+
+// openSSE connects and returns a channel of parsed frames.
+func openSSE(t *testing.T, url, lastID string) <-chan sseFrame {
+	t.Helper()
+	req, _ := http.NewRequest("GET", url, nil)
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("sse: %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	out := make(chan sseFrame, 64)
+	go func() {
+		defer close(out)
+		sc := bufio.NewScanner(resp.Body)
+		var f sseFrame
+		for sc.Scan() {
+			line := sc.Text()
+			switch {
+			case line == "":
+				if f.Event != "" {
+					out <- f
+				}
+				f = sseFrame{}
+			case strings.HasPrefix(line, "id: "):
+				f.ID = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				f.Event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				_ = json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &f.Data)
+			}
+		}
+	}()
+	return out
+}
+
+func nextFrame(t *testing.T, c <-chan sseFrame) sseFrame {
+	t.Helper()
+	select {
+	case f, ok := <-c:
+		if !ok {
+			t.Fatal("stream closed")
+		}
+		return f
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for event")
+	}
+	return sseFrame{}
+}
+
+func loginOnAP(e *testEnv, mac, apID string) {
+	body := portalReq(mac, "")
+	body["access"] = map[string]any{"ap_id": apID}
+	if code, out := e.do("POST", "/portal/login", "", body); code != 200 {
+		e.t.Fatalf("login: %d %v", code, out)
+	}
+}
+
+func TestEventsStreamSessionChangesForAP(t *testing.T) {
+	e, _, _, ts := newEventsEnv(t)
+
+	stream := openSSE(t, ts.URL+"/api/v1/ap/events?ap_id=ap-01", "")
+	time.Sleep(50 * time.Millisecond) // let the subscription register
+
+	loginOnAP(e, "aa:bb:cc:00:00:02", "ap-02") // other AP: not delivered
+	loginOnAP(e, "aa:bb:cc:00:00:01", "ap-01")
+
+	f := nextFrame(t, stream)
+	data := f.Data["data"].(map[string]any)
+	if f.Event != events.SessionCreated || f.ID == "" || data["mac"] != "aa:bb:cc:00:00:01" || data["firewall_group"] != "portal_allow_guest" {
+		t.Fatalf("frame = %+v", f)
+	}
+
+	e.do("POST", "/portal/heartbeat", "aa:bb:cc:00:00:01", portalReq("aa:bb:cc:00:00:01", ""))
+	if f := nextFrame(t, stream); f.Event != events.SessionRefreshed {
+		t.Fatalf("frame = %+v", f)
+	}
+	// a second heartbeat within half the ttl is not announced again
+	e.do("POST", "/portal/heartbeat", "aa:bb:cc:00:00:01", portalReq("aa:bb:cc:00:00:01", ""))
+
+	e.do("POST", "/portal/logout", "aa:bb:cc:00:00:01", portalReq("aa:bb:cc:00:00:01", ""))
+	f = nextFrame(t, stream)
+	if f.Event != events.SessionDeleted || f.Data["data"].(map[string]any)["reason"] != "logout" {
+		t.Fatalf("frame = %+v", f)
+	}
+}
+
+func TestEventsSiteFromRegistration(t *testing.T) {
+	e, _, hub, ts := newEventsEnv(t)
+	ctx := context.Background()
+	e.send("POST", "/api/v1/ap/register", apBody("ap-01"), false, nil) // site hq
+
+	// the query cannot widen the stream to another site
+	stream := openSSE(t, ts.URL+"/api/v1/ap/events?ap_id=ap-01&site=branch", "")
+	time.Sleep(50 * time.Millisecond)
+
+	_, _ = hub.Publish(ctx, events.Event{Type: events.PolicyChanged, Site: "branch", Data: map[string]any{"n": 1}})
+	_, _ = hub.Publish(ctx, events.Event{Type: events.PolicyChanged, Site: "hq", Data: map[string]any{"n": 2}})
+	if f := nextFrame(t, stream); f.Data["data"].(map[string]any)["n"] != float64(2) {
+		t.Fatalf("frame = %+v", f)
+	}
+}
+
+func TestEventsResumeFromLastEventID(t *testing.T) {
+	_, _, hub, ts := newEventsEnv(t)
+	ctx := context.Background()
+
+	var ids []string
+	for i := 0; i < 3; i++ {
+		id, err := hub.Publish(ctx, events.Event{Type: events.PolicyChanged, Data: map[string]any{"n": i}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	stream := openSSE(t, ts.URL+"/api/v1/ap/events?ap_id=ap-01", ids[0])
+	for _, want := range ids[1:] {
+		if f := nextFrame(t, stream); f.ID != want {
+			t.Fatalf("replayed id = %s, want %s", f.ID, want)
+		}
+	}
+
+	// live events continue after the replay, without duplicates
+	id, _ := hub.Publish(ctx, events.Event{Type: events.BypassChanged})
+	if f := nextFrame(t, stream); f.ID != id || f.Event != events.BypassChanged {
+		t.Fatalf("live frame = %+v", f)
+	}
+
+	// an id older than the stream start asks for a full resync
+	stream = openSSE(t, ts.URL+"/api/v1/ap/events?ap_id=ap-01", "1-0")
+	if f := nextFrame(t, stream); f.Event != "resync" {
+		t.Fatalf("frame = %+v", f)
+	}
+}
+
+func TestPublishPolicyStateOnlyOnChange(t *testing.T) {
+	e, srv, _, _ := newEventsEnv(t)
+	ctx := context.Background()
+
+	if err := srv.PublishPolicyState(ctx); err != nil {
+		t.Fatal(err)
+	}
+	_ = srv.PublishPolicyState(ctx)
+
+	entries, _ := e.st.EventsAfter(ctx, "0-0", 100)
+	if len(entries) != 2 { // policy.changed + bypass.changed, once
+		t.Fatalf("events = %d", len(entries))
+	}
+
+	e.cfg.Bypass.MacWhitelist = []string{"70:4d:7b:64:3b:da"}
+	_ = srv.PublishPolicyState(ctx)
+	entries, _ = e.st.EventsAfter(ctx, entries[1].ID, 100)
+	if len(entries) != 2 {
+		t.Fatalf("events after bypass change = %d", len(entries))
+	}
+
+	// bypass.changed is a refetch notice, not the (unsigned) lists
+	var ev events.Event
+	_ = json.Unmarshal(entries[1].Data, &ev)
+	if ev.Type != events.BypassChanged || ev.Data["checksum"] == "" || ev.Data["bypass"] != nil {
+		t.Fatalf("bypass.changed = %+v", ev)
+	}
+}
+
+func TestPublishPolicyStateRollingDeploy(t *testing.T) {
+	e, srv, _, _ := newEventsEnv(t)
+	ctx := context.Background()
+
+	// a replica on the next config shares the store
+	next := *e.cfg
+	next.Bypass.MacWhitelist = []string{"70:4d:7b:64:3b:da"}
+	other := httpapi.New(&next, e.st, audit.New(false, ""), "1", security.NewJWTIssuer([]byte("test"), time.Minute))
+	other.EnableEvents(events.NewHub(e.st, 1000))
+
+	_ = srv.PublishPolicyState(ctx)
+	_ = other.PublishPolicyState(ctx)
+	entries, _ := e.st.EventsAfter(ctx, "0-0", 100)
+	if len(entries) != 4 { // each config announces once
+		t.Fatalf("events = %d", len(entries))
+	}
+	for i := 0; i < 3; i++ {
+		_ = srv.PublishPolicyState(ctx)
+		_ = other.PublishPolicyState(ctx)
+	}
+	if entries, _ = e.st.EventsAfter(ctx, entries[3].ID, 100); len(entries) != 0 {
+		t.Fatalf("replicas flap: %d events", len(entries))
+	}
+}
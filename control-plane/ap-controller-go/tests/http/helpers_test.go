@@ -0,0 +1,107 @@
+package httpapi_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"ap-controller-go/internal/audit"
+	"ap-controller-go/internal/config"
+	httpapi "ap-controller-go/internal/http"
+	"ap-controller-go/internal/security"
+	"ap-controller-go/internal/store"
+)
+
+// testEnv is a controller wired to an in-memory redis.
+type testEnv struct {
+	t   *testing.T
+	mr  *miniredis.Miniredis
+	cfg *config.Config
+	st  *store.Store
+	h   http.Handler
+}
+
+func testConfig() *config.Config {
+	return &config.Config{
+		Redis: config.Redis{Prefix: "session:"},
+		Roles: map[string]config.RoleDef{
+			"guest": {Profile: "guest-profile"},
+		},
+		Profiles: map[string]config.Profile{
+			"guest-profile": {
+				VLAN:          100,
+				FirewallGroup: "portal_allow_guest",
+				SessionTTL:    1800,
+			},
+		},
+		Dataplane: config.Dataplane{
+			PolicyVersion: 1,
+			PortalIP:      "192.168.16.118",
+			LanIF:         "br-lan",
+		},
+	}
+}
+
+func newTestEnv(t *testing.T, cfg *config.Config) *testEnv {
+	t.Helper()
+	return newTestEnvWith(t, cfg, nil)
+}
+
+// newTestEnvWith is newTestEnv with a hook to enable optional features
+// on the server before the router is built.
+func newTestEnvWith(t *testing.T, cfg *config.Config, setup func(*httpapi.Server)) *testEnv {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	port, _ := strconv.Atoi(mr.Port())
+	cfg.Redis.Host = mr.Host()
+	cfg.Redis.Port = port
+
+	st := store.New(cfg, "")
+	jwt := security.NewJWTIssuer([]byte("test"), time.Minute)
+	srv := httpapi.New(cfg, st, audit.New(false, ""), "1", jwt)
+	if setup != nil {
+		setup(srv)
+	}
+
+	security.SkipAuthForTest = true
+	t.Cleanup(func() { security.SkipAuthForTest = false })
+
+	return &testEnv{t: t, mr: mr, cfg: cfg, st: st, h: srv.Router()}
+}
+
+// do sends a JSON request; mac is injected as the authenticated client.
+func (e *testEnv) do(method, path, mac string, body any) (int, map[string]any) {
+	e.t.Helper()
+
+	var buf bytes.Buffer
+	if body != nil {
+		_ = json.NewEncoder(&buf).Encode(body)
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	if mac != "" {
+		req.Header.Set("X-Client-MAC", mac)
+	}
+	rr := httptest.NewRecorder()
+	e.h.ServeHTTP(rr, req)
+
+	out := map[string]any{}
+	_ = json.Unmarshal(rr.Body.Bytes(), &out)
+	return rr.Code, out
+}
+
+// portalReq builds a PortalContextReq-shaped body.
+func portalReq(mac, identity string) map[string]any {
+	return map[string]any{
+		"client":   map[string]any{"mac": mac},
+		"user":     map[string]any{"identity": identity},
+		"wireless": map[string]any{"ssid": "GuestWiFi"},
+		"meta":     map[string]any{"source": "test"},
+	}
+}
@@ -0,0 +1,169 @@
+package httpapi_test
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	httpapi "ap-controller-go/internal/http"
+	"ap-controller-go/internal/leader"
+	"ap-controller-go/internal/store"
+)
+
+func newInventoryEnv(t *testing.T) (*testEnv, *httpapi.Server) {
+	cfg := testConfig()
+	cfg.Controller.Inventory.HeartbeatInterval = 30
+	cfg.Controller.Inventory.OfflineAfter = 90
+	var srv *httpapi.Server
+	e := newTestEnvWith(t, cfg, func(s *httpapi.Server) {
+		s.SetAdminToken(adminToken)
+		srv = s
+	})
+	return e, srv
+}
+
+func apBody(apID string) map[string]any {
+	return map[string]any{
+		"ap_id":           apID,
+		"site":            "hq",
+		"hostname":        "immortalwrt-" + apID,
+		"firmware":        "23.05.2",
+		"lan_if":          "br-lan",
+		"uptime":          120,
+		"policy_checksum": "abc",
+	}
+}
+
+func TestAPRegisterAndHeartbeat(t *testing.T) {
+	e, _ := newInventoryEnv(t)
+
+	if rr, _ := e.send("POST", "/api/v1/ap/heartbeat", apBody("ap-01"), false, nil); rr.Code != 404 {
+		t.Fatalf("heartbeat before register: %d", rr.Code)
+	}
+
+	rr, out := e.send("POST", "/api/v1/ap/register", apBody("ap-01"), false, nil)
+	if rr.Code != 200 || out["registered"] != true || out["heartbeat_interval"] != float64(30) {
+		t.Fatalf("register: %d %v", rr.Code, out)
+	}
+
+	rr, out = e.send("POST", "/api/v1/ap/heartbeat", map[string]any{"ap_id": "ap-01", "uptime": 150, "policy_checksum": "def"}, false, nil)
+	if rr.Code != 200 || out["registered"] != false {
+		t.Fatalf("heartbeat: %d %v", rr.Code, out)
+	}
+
+	rec, _ := e.st.GetAP(context.Background(), "ap-01")
+	if rec.Uptime != 150 || rec.PolicyChecksum != "def" || rec.Hostname != "immortalwrt-ap-01" || rec.LanIF != "br-lan" {
+		t.Fatalf("record = %+v", rec)
+	}
+
+	if rr, _ := e.send("POST", "/api/v1/ap/register", map[string]any{}, false, nil); rr.Code != 422 {
+		t.Fatalf("register without ap_id: %d", rr.Code)
+	}
+}
+
+func TestAPRegisterCertMustMatch(t *testing.T) {
+	e, _ := newInventoryEnv(t)
+
+	peer := &x509.Certificate{
+		SerialNumber: big.NewInt(7),
+		Subject:      pkix.Name{CommonName: "ap-01", OrganizationalUnit: []string{"lab"}},
+	}
+	if rr, _ := e.send("POST", "/api/v1/ap/register", apBody("ap-02"), false, peer); rr.Code != 403 {
+		t.Fatalf("foreign ap_id: %d", rr.Code)
+	}
+
+	rr, _ := e.send("POST", "/api/v1/ap/register", map[string]any{"hostname": "x"}, false, peer)
+	if rr.Code != 200 {
+		t.Fatalf("register via cert: %d", rr.Code)
+	}
+	rec, _ := e.st.GetAP(context.Background(), "ap-01")
+	if rec == nil || rec.Site != "lab" {
+		t.Fatalf("record = %+v", rec)
+	}
+}
+
+func TestAdminListAPsWithStatusAndClients(t *testing.T) {
+	e, srv := newInventoryEnv(t)
+	ctx := context.Background()
+
+	e.send("POST", "/api/v1/ap/register", apBody("ap-01"), false, nil)
+	e.send("POST", "/api/v1/ap/register", apBody("ap-02"), false, nil)
+
+	for _, mac := range []string{"aa:bb:cc:00:00:01", "aa:bb:cc:00:00:02"} {
+		var sess store.SessionV2
+		sess.MAC = mac
+		sess.AP.APID = "ap-01"
+		_ = e.st.SetSession(ctx, sess, 600)
+	}
+
+	if rr, _ := e.send("GET", "/api/v1/admin/aps", nil, false, nil); rr.Code != 401 {
+		t.Fatalf("list without admin auth: %d", rr.Code)
+	}
+
+	rr, out := e.send("GET", "/api/v1/admin/aps", nil, true, nil)
+	if rr.Code != 200 || out["total"] != float64(2) {
+		t.Fatalf("list: %d %v", rr.Code, out)
+	}
+	first := out["aps"].([]any)[0].(map[string]any)
+	if first["ap_id"] != "ap-01" || first["status"] != "online" || first["clients"] != float64(2) {
+		t.Fatalf("ap-01 = %v", first)
+	}
+
+	// ap-02 keeps reporting, ap-01 goes silent
+	later := time.Now().Add(100 * time.Second)
+	rec, _ := e.st.GetAP(ctx, "ap-02")
+	rec.LastSeen = later.Unix()
+	_, _ = e.st.UpdateAP(ctx, "ap-02", func(r *store.APRecord, _ bool) error { *r = *rec; return nil })
+
+	went, err := srv.CheckOfflineAPs(ctx, later)
+	if err != nil || len(went) != 1 || went[0] != "ap-01" {
+		t.Fatalf("offline = %v, %v", went, err)
+	}
+	if went, _ := srv.CheckOfflineAPs(ctx, later); len(went) != 0 {
+		t.Fatalf("offline transition reported twice: %v", went)
+	}
+	if stale, _ := e.st.StaleAPs(ctx, later.Unix()-90); len(stale) != 0 {
+		t.Fatalf("offline APs still scanned: %v", stale)
+	}
+
+	_, out = e.send("GET", "/api/v1/admin/aps?status=offline", nil, true, nil)
+	if out["total"] != float64(1) {
+		t.Fatalf("offline list = %v", out)
+	}
+
+	// a heartbeat brings it back
+	e.send("POST", "/api/v1/ap/heartbeat", map[string]any{"ap_id": "ap-01"}, false, nil)
+	_, out = e.send("GET", "/api/v1/admin/aps/ap-01", nil, true, nil)
+	if out["status"] != "online" || out["offline"] != nil {
+		t.Fatalf("ap-01 after heartbeat = %v", out)
+	}
+
+	if rr, _ := e.send("DELETE", "/api/v1/admin/aps/ap-02", nil, true, nil); rr.Code != 200 {
+		t.Fatalf("delete: %d", rr.Code)
+	}
+	if rr, _ := e.send("GET", "/api/v1/admin/aps/ap-02", nil, true, nil); rr.Code != 404 {
+		t.Fatalf("get deleted: %d", rr.Code)
+	}
+}
+
+func TestHealthzLeader(t *testing.T) {
+	var srv *httpapi.Server
+	e := newTestEnvWith(t, testConfig(), func(s *httpapi.Server) { srv = s })
+
+	_, out := e.send("GET", "/healthz", nil, false, nil)
+	if ld, _ := out["leader"].(map[string]any); ld["enabled"] != false || ld["is_leader"] != true {
+		t.Fatalf("without election: %v", out)
+	}
+
+	el := leader.New(e.st, "jobs", "ctrl-a", time.Minute, time.Second)
+	srv.SetLeader(el)
+	_ = el.Campaign(context.Background())
+	_, out = e.send("GET", "/healthz", nil, false, nil)
+	ld, _ := out["leader"].(map[string]any)
+	if ld["enabled"] != true || ld["is_leader"] != true || ld["holder"] != "ctrl-a" || ld["token"] != float64(1) {
+		t.Fatalf("leader: %v", out)
+	}
+}
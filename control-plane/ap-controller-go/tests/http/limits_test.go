@@ -0,0 +1,154 @@
+package httpapi_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"ap-controller-go/internal/security"
+	"ap-controller-go/internal/store"
+)
+
+func TestLogin_DeviceLimit(t *testing.T) {
+	cfg := testConfig()
+	p := cfg.Profiles["guest-profile"]
+	p.MaxDevices = 1
+	cfg.Profiles["guest-profile"] = p
+	e := newTestEnv(t, cfg)
+
+	code, _ := e.do("POST", "/portal/login", "", portalReq("aa:aa:aa:aa:aa:01", "alice"))
+	if code != 200 {
+		t.Fatalf("first login: expected 200, got %d", code)
+	}
+
+	code, body := e.do("POST", "/portal/login", "", portalReq("aa:aa:aa:aa:aa:02", "alice"))
+	if code != 403 || body["error"] != "device_limit_exceeded" {
+		t.Fatalf("second device: expected 403 device_limit_exceeded, got %d %v", code, body)
+	}
+
+	// re-login on the same device is always allowed
+	if code, _ := e.do("POST", "/portal/login", "", portalReq("aa:aa:aa:aa:aa:01", "alice")); code != 200 {
+		t.Fatalf("re-login: expected 200, got %d", code)
+	}
+
+	// another identity is unaffected
+	if code, _ := e.do("POST", "/portal/login", "", portalReq("aa:aa:aa:aa:aa:03", "bob")); code != 200 {
+		t.Fatalf("other identity: expected 200, got %d", code)
+	}
+
+	// freeing the slot admits the second device
+	e.do("POST", "/portal/logout", "aa:aa:aa:aa:aa:01", portalReq("aa:aa:aa:aa:aa:01", "alice"))
+	if code, _ := e.do("POST", "/portal/login", "", portalReq("aa:aa:aa:aa:aa:02", "alice")); code != 200 {
+		t.Fatalf("after logout: expected 200, got %d", code)
+	}
+}
+
+func TestLogin_DeviceLimitTrustsSignedIdentity(t *testing.T) {
+	cfg := testConfig()
+	p := cfg.Profiles["guest-profile"]
+	p.MaxDevices = 1
+	cfg.Profiles["guest-profile"] = p
+	e := newTestEnv(t, cfg)
+	security.SkipAuthForTest = false
+	orig := security.PortalHMACProvider
+	security.PortalHMACProvider = func() *security.KeySet { return defaultKeys }
+	t.Cleanup(func() { security.PortalHMACProvider = orig })
+	ctx := context.Background()
+
+	login := func(mac string, signed bool) (int, map[string]any) {
+		body := portalReq(mac, "alice")
+		hdr := map[string]string{}
+		if signed {
+			hdr = signBody(hdr, "POST", "/portal/login", "v1", defaultKeys.Keys["v1"], body)
+		}
+		return e.tenantReq("POST", "/portal/login", hdr, body)
+	}
+
+	if code, _ := login("aa:aa:aa:aa:aa:01", true); code != 200 {
+		t.Fatalf("signed login: %d", code)
+	}
+	if sess, _, _ := e.st.GetSessionFull(ctx, "aa:aa:aa:aa:aa:01"); sess.Auth.Identity != "alice" {
+		t.Fatalf("signed identity: %q", sess.Auth.Identity)
+	}
+	if code, out := login("aa:aa:aa:aa:aa:02", true); code != 403 || out["error"] != "device_limit_exceeded" {
+		t.Fatalf("signed second device: %d %v", code, out)
+	}
+
+	// an unsigned body only asserts the identity: the login is anonymous,
+	// neither taking alice's slot nor counted against it
+	if code, _ := login("aa:aa:aa:aa:aa:03", false); code != 200 {
+		t.Fatalf("unsigned login: %d", code)
+	}
+	if sess, _, _ := e.st.GetSessionFull(ctx, "aa:aa:aa:aa:aa:03"); sess.Auth.Identity != "" {
+		t.Fatalf("unsigned identity: %q", sess.Auth.Identity)
+	}
+	if devices, _ := e.st.ActiveDevices(ctx, "alice"); len(devices) != 1 || devices[0] != "aa:aa:aa:aa:aa:01" {
+		t.Fatalf("alice devices: %v", devices)
+	}
+}
+
+func TestLogin_LifetimeCapsTTL(t *testing.T) {
+	cfg := testConfig()
+	p := cfg.Profiles["guest-profile"]
+	p.MaxSessionLifetime = 60
+	p.UpstreamKbps = 1024
+	cfg.Profiles["guest-profile"] = p
+	e := newTestEnv(t, cfg)
+
+	code, body := e.do("POST", "/portal/login", "", portalReq("aa:aa:aa:aa:aa:01", ""))
+	if code != 200 {
+		t.Fatalf("login: expected 200, got %d", code)
+	}
+	sess := body["session"].(map[string]any)
+	if ttl := sess["ttl"].(float64); ttl > 60 {
+		t.Fatalf("ttl %v not capped by lifetime", ttl)
+	}
+	prof := sess["profile"].(map[string]any)
+	if prof["upstream_kbps"].(float64) != 1024 {
+		t.Fatalf("profile attrs not returned: %v", prof)
+	}
+}
+
+func TestHeartbeat_LifetimeExceeded(t *testing.T) {
+	cfg := testConfig()
+	p := cfg.Profiles["guest-profile"]
+	p.MaxSessionLifetime = 60
+	cfg.Profiles["guest-profile"] = p
+	e := newTestEnv(t, cfg)
+
+	mac := "aa:aa:aa:aa:aa:01"
+	sess := store.SessionV2{MAC: mac, Role: "guest", Profile: "guest-profile"}
+	sess.TS.Created = time.Now().Add(-2 * time.Minute).Unix()
+	sess.TS.Expires = time.Now().Add(-time.Minute).Unix()
+	if err := e.st.SetSession(context.Background(), sess, 600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, body := e.do("POST", "/portal/heartbeat", mac, portalReq(mac, ""))
+	if body["authorized"] != false || body["error"] != "session_lifetime_exceeded" {
+		t.Fatalf("expected lifetime termination, got %v", body)
+	}
+	if got, _, _ := e.st.GetSessionFull(context.Background(), mac); got != nil {
+		t.Fatalf("session should have been deleted")
+	}
+}
+
+func TestHeartbeat_RefreshWithinLifetime(t *testing.T) {
+	cfg := testConfig()
+	p := cfg.Profiles["guest-profile"]
+	p.MaxSessionLifetime = 3600
+	cfg.Profiles["guest-profile"] = p
+	e := newTestEnv(t, cfg)
+
+	mac := "aa:aa:aa:aa:aa:01"
+	if code, _ := e.do("POST", "/portal/login", "", portalReq(mac, "")); code != 200 {
+		t.Fatalf("login failed: %d", code)
+	}
+	_, body := e.do("POST", "/portal/heartbeat", mac, portalReq(mac, ""))
+	if body["authorized"] != true {
+		t.Fatalf("expected authorized heartbeat, got %v", body)
+	}
+	if body["expires_at"].(float64) == 0 {
+		t.Fatalf("expires_at missing: %v", body)
+	}
+}
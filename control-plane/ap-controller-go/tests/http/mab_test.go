@@ -0,0 +1,121 @@
+package httpapi_test
+
+import (
+	"context"
+	"testing"
+
+	httpapi "ap-controller-go/internal/http"
+)
+
+func newMABEnv(t *testing.T) *testEnv {
+	t.Helper()
+	cfg := testConfig()
+	p := cfg.Profiles["guest-profile"]
+	p.RememberDevice = 3600
+	cfg.Profiles["guest-profile"] = p
+	return newTestEnvWith(t, cfg, func(s *httpapi.Server) { s.SetAdminToken(adminToken) })
+}
+
+// loginToken logs mac in through the portal and returns its device token.
+func (e *testEnv) loginToken(mac, identity string) string {
+	e.t.Helper()
+	code, out := e.do("POST", "/portal/login", "", portalReq(mac, identity))
+	tok, _ := out["device_token"].(string)
+	if code != 200 || tok == "" {
+		e.t.Fatalf("login %s: %d %v", mac, code, out)
+	}
+	_, _ = e.st.Delete(context.Background(), mac)
+	return tok
+}
+
+func apMAB(mac, token string) map[string]any {
+	return map[string]any{"mac": mac, "ap_id": "ap-01", "ssid": "GuestWiFi", "device_token": token}
+}
+
+func TestMABKnownDevice(t *testing.T) {
+	e := newMABEnv(t)
+	ctx := context.Background()
+	mac := "00:11:22:00:0c:01" // globally administered
+
+	e.loginToken(mac, "alice@corp.example")
+
+	code, out := e.do("POST", "/api/v1/ap/mab", "", apMAB(mac, ""))
+	if code != 200 || out["authorized"] != true || vlanOf(out) != float64(100) {
+		t.Fatalf("mab: %d %v", code, out)
+	}
+	sess, _, _ := e.st.GetSessionFull(ctx, mac)
+	if sess.Auth.Method != "mab" || sess.Auth.Source != "ap" || sess.Auth.Identity != "alice@corp.example" || sess.AP.APID != "ap-01" {
+		t.Fatalf("session: %+v", sess.Auth)
+	}
+	if d, _ := e.st.GetKnownDevice(ctx, mac); d == nil || d.MABs != 1 || d.Role != "guest" {
+		t.Fatalf("known device: %+v", d)
+	}
+
+	if code, out := e.do("POST", "/api/v1/ap/mab", "", apMAB("00:11:22:00:0c:99", "")); code != 403 || out["error"] != "not_known" {
+		t.Fatalf("unknown device: %d %v", code, out)
+	}
+
+	// the profile no longer remembers devices
+	p := e.cfg.Profiles["guest-profile"]
+	p.RememberDevice = 0
+	e.cfg.Profiles["guest-profile"] = p
+	if code, out := e.do("POST", "/api/v1/ap/mab", "", apMAB(mac, "")); code != 403 || out["error"] != "not_remembered" {
+		t.Fatalf("remembering off: %d %v", code, out)
+	}
+}
+
+func TestMABRandomizedMAC(t *testing.T) {
+	e := newMABEnv(t)
+	ctx := context.Background()
+	mac := "da:00:00:00:0c:01" // locally administered
+
+	token := e.loginToken(mac, "")
+
+	if code, out := e.do("POST", "/api/v1/ap/mab", "", apMAB(mac, "")); code != 403 || out["error"] != "token_required" {
+		t.Fatalf("without token: %d %v", code, out)
+	}
+	if code, out := e.do("POST", "/api/v1/ap/mab", "", apMAB(mac, "forged")); code != 403 || out["error"] != "not_known" {
+		t.Fatalf("wrong token: %d %v", code, out)
+	}
+	if code, _ := e.do("POST", "/api/v1/ap/mab", "", apMAB(mac, token)); code != 200 {
+		t.Fatalf("with token: %d", code)
+	}
+
+	// the device rotated its MAC; the portal cookie links it
+	rotated := "de:00:00:00:0c:02"
+	req := portalReq(rotated, "")
+	req["user"] = map[string]any{"device_token": token}
+	code, out := e.do("POST", "/portal/mab", rotated, req)
+	if code != 200 || out["authorized"] != true || out["token"] == nil {
+		t.Fatalf("portal mab: %d %v", code, out)
+	}
+	if sess, _, _ := e.st.GetSessionFull(ctx, rotated); sess == nil || sess.Auth.Method != "mab" || sess.Auth.Source != "portal" {
+		t.Fatalf("session: %+v", sess)
+	}
+	if d, _ := e.st.GetKnownDevice(ctx, mac); d != nil {
+		t.Fatalf("old MAC still known: %+v", d)
+	}
+	if d, _ := e.st.GetKnownDevice(ctx, rotated); d == nil || d.MABs != 2 {
+		t.Fatalf("record not moved: %+v", d)
+	}
+}
+
+func TestForgetKnownDevice(t *testing.T) {
+	e := newMABEnv(t)
+	mac := "00:11:22:00:0c:03"
+	e.loginToken(mac, "bob@corp.example")
+
+	rr, out := e.send("GET", "/api/v1/admin/known-devices/"+mac, nil, true, nil)
+	if rr.Code != 200 || out["identity"] != "bob@corp.example" || out["token_hash"] != nil {
+		t.Fatalf("get: %d %v", rr.Code, out)
+	}
+	if rr, _ := e.send("DELETE", "/api/v1/admin/known-devices/"+mac, nil, true, nil); rr.Code != 200 {
+		t.Fatalf("forget: %d", rr.Code)
+	}
+	if code, out := e.do("POST", "/api/v1/ap/mab", "", apMAB(mac, "")); code != 403 || out["error"] != "not_known" {
+		t.Fatalf("mab after forget: %d %v", code, out)
+	}
+	if rr, _ := e.send("DELETE", "/api/v1/admin/known-devices/"+mac, nil, true, nil); rr.Code != 404 {
+		t.Fatalf("forget again: %d", rr.Code)
+	}
+}
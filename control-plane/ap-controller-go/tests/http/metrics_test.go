@@ -0,0 +1,120 @@
+package httpapi_test
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ap-controller-go/internal/config"
+	httpapi "ap-controller-go/internal/http"
+	"ap-controller-go/internal/metrics"
+)
+
+func newMetricsEnv(t *testing.T, cfg *config.Config) *testEnv {
+	return newTestEnvWith(t, cfg, func(s *httpapi.Server) { s.SetAdminToken(adminToken) })
+}
+
+func scrape(t *testing.T, e *testEnv) string {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+	e.h.ServeHTTP(rr, req)
+	if rr.Code != 200 {
+		t.Fatalf("scrape: %d", rr.Code)
+	}
+	b, _ := io.ReadAll(rr.Body)
+	return string(b)
+}
+
+func TestMetrics_RequiresAdmin(t *testing.T) {
+	e := newMetricsEnv(t, testConfig())
+	rr := httptest.NewRecorder()
+	e.h.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	if rr.Code != 401 {
+		t.Fatalf("anonymous scrape: %d", rr.Code)
+	}
+}
+
+func TestMetrics_RouteLabelsUsePatterns(t *testing.T) {
+	e := newMetricsEnv(t, testConfig())
+
+	for _, mac := range []string{"aa:aa:aa:aa:aa:01", "aa:aa:aa:aa:aa:02"} {
+		e.do("GET", "/portal/status/"+mac, mac, nil)
+	}
+
+	out := scrape(t, e)
+	if !strings.Contains(out, `route="/portal/status/{mac}"`) {
+		t.Fatalf("route pattern label missing:\n%s", out)
+	}
+	if strings.Contains(out, "aa:aa:aa:aa:aa:01") {
+		t.Fatalf("per-MAC url leaked into labels")
+	}
+}
+
+func TestMetrics_LoginOutcomeAndSessions(t *testing.T) {
+	cfg := testConfig()
+	cfg.RoleRules = []config.RoleRule{{Name: "guest-wifi", When: map[string]any{"ssid": []any{"GuestWiFi"}}, Assign: "guest"}}
+	e := newMetricsEnv(t, cfg)
+	metrics.SetSessionCounter("default", e.st.CountByRole)
+	t.Cleanup(func() { metrics.SetSessionCounter("default", nil) })
+
+	e.do("POST", "/portal/login", "", portalReq("aa:aa:aa:aa:aa:01", ""))
+	other := portalReq("aa:aa:aa:aa:aa:02", "")
+	other["wireless"] = map[string]any{"ssid": "attacker-ssid-1234"}
+	e.do("POST", "/portal/login", "", other)
+
+	out := scrape(t, e)
+	for _, want := range []string{
+		`ap_controller_portal_logins_total{result="ok",role="guest",rule="guest-wifi",ssid="GuestWiFi"}`,
+		`ap_controller_portal_logins_total{result="ok",role="guest",rule="",ssid="other"}`,
+		`ap_controller_sessions_active{role="guest",tenant="default"} 2`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %s", want)
+		}
+	}
+	if strings.Contains(out, "attacker-ssid") {
+		t.Fatal("unknown ssid leaked into labels")
+	}
+}
+
+func TestMetrics_PolicyInfoFollowsActiveChecksum(t *testing.T) {
+	e := newBypassEnv(t)
+
+	// admin changes publish the policy state at once
+	if rr, out := e.send("POST", "/api/v1/admin/bypass", map[string]any{"type": "mac", "value": "aa:bb:cc:00:00:01", "reason": "printer"}, true, nil); rr.Code != 200 {
+		t.Fatalf("add: %d %v", rr.Code, out)
+	}
+	_, sum := e.runtimeFor("?ap_id=ap-01")
+	out := scrape(t, e)
+	if !strings.Contains(out, `checksum="`+sum+`"`) {
+		t.Fatalf("policy_info does not show %s:\n%s", sum, out)
+	}
+}
+
+func TestMetrics_SessionsPerTenant(t *testing.T) {
+	e := newTenantEnv(t)
+	tenant := e.st.WithConfig(e.cfg.ForTenant("campus-a"))
+	metrics.SetSessionCounter("default", e.st.CountByRole)
+	metrics.SetSessionCounter("campus-a", tenant.CountByRole)
+	t.Cleanup(func() {
+		metrics.SetSessionCounter("default", nil)
+		metrics.SetSessionCounter("campus-a", nil)
+	})
+
+	e.tenantReq("POST", "/portal/login", map[string]string{"X-Tenant": "campus-a"}, portalReq("aa:aa:aa:aa:aa:01", ""))
+	e.tenantReq("POST", "/portal/login", map[string]string{"X-Tenant": "campus-a"}, portalReq("aa:aa:aa:aa:aa:02", ""))
+	e.tenantReq("POST", "/portal/login", nil, portalReq("aa:aa:aa:aa:aa:03", ""))
+
+	out := scrape(t, e)
+	for _, want := range []string{
+		`ap_controller_sessions_active{role="guest",tenant="campus-a"} 2`,
+		`ap_controller_sessions_active{role="guest",tenant="default"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %s", want)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package httpapi_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	httpapi "ap-controller-go/internal/http"
+	"ap-controller-go/internal/store"
+)
+
+func TestSessionMigration(t *testing.T) {
+	cfg := testConfig()
+	cfg.Controller.Migration.Enabled = true
+	cfg.Controller.Migration.BatchSize = 5
+	cfg.Controller.Migration.Steps = 1
+	var srv *httpapi.Server
+	e := newTestEnvWith(t, cfg, func(s *httpapi.Server) {
+		s.SetAdminToken(adminToken)
+		srv = s
+	})
+	ctx := context.Background()
+
+	// sessions left behind by the Python controller
+	for i := 0; i < 12; i++ {
+		mac := fmt.Sprintf("aa:00:00:00:01:%02x", i)
+		e.mr.Set("session:"+mac, "guest")
+		e.mr.SetTTL("session:"+mac, time.Hour)
+	}
+
+	// they keep working before the migration reaches them
+	if code, out := e.do("GET", "/portal/status/aa:00:00:00:01:00", "aa:00:00:00:01:00", nil); code != 200 || out["authorized"] != true || vlanOf(out) != float64(100) {
+		t.Fatalf("legacy status: %d %v", code, out)
+	}
+
+	var p *store.MigrationProgress
+	for runs := 0; p == nil || !p.Done(); runs++ {
+		if runs > 20 {
+			t.Fatal("migration does not finish")
+		}
+		var err error
+		if p, err = srv.MigrateSessions(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the status read above already upgraded one
+	if p.Scanned != 12 || p.Upgraded != 11 {
+		t.Fatalf("progress: %+v", p)
+	}
+
+	rr, out := e.send("GET", "/api/v1/admin/migrations/sessions", nil, true, nil)
+	prog, _ := out["progress"].(map[string]any)
+	if rr.Code != 200 || out["schema"] != float64(store.SessionSchema) || prog["finished"] == nil {
+		t.Fatalf("progress endpoint: %d %v", rr.Code, out)
+	}
+
+	// a finished pass is not repeated until restarted
+	if p, _ := srv.MigrateSessions(ctx); p.Scanned != 12 {
+		t.Fatalf("pass repeated: %+v", p)
+	}
+	if rr, _ := e.send("POST", "/api/v1/admin/migrations/sessions", nil, true, nil); rr.Code != 202 {
+		t.Fatalf("restart: %d", rr.Code)
+	}
+	if p, _ := srv.MigrateSessions(ctx); p.Done() || p.Scanned == 0 || p.Upgraded != 0 {
+		t.Fatalf("restarted pass: %+v", p)
+	}
+}
@@ -0,0 +1,118 @@
+package httpapi_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"ap-controller-go/internal/audit"
+	"ap-controller-go/internal/config"
+	httpapi "ap-controller-go/internal/http"
+	"ap-controller-go/internal/security"
+)
+
+func rateLimitConfig() *config.Config {
+	cfg := testConfig()
+	cfg.Controller.RateLimit = config.RateLimit{
+		Enabled: true,
+		MAC:     config.Bucket{Rate: 2, Burst: 2, Window: 60},
+		IP:      config.Bucket{Rate: 3, Burst: 3, Window: 60},
+		Client:  config.Bucket{Rate: 100, Burst: 100, Window: 60},
+		Lockout: config.Lockout{Failures: 2, Window: 60, Base: 30, Max: 300},
+	}
+	return cfg
+}
+
+func loginFrom(mac, ip, identity string) map[string]any {
+	body := portalReq(mac, identity)
+	body["client"] = map[string]any{"mac": mac, "ip": ip}
+	return body
+}
+
+func TestLoginRateLimit(t *testing.T) {
+	e := newTestEnv(t, rateLimitConfig())
+
+	// a second replica on the same redis shares the buckets
+	replica := httpapi.New(e.cfg, e.st, audit.New(false, ""), "1", security.NewJWTIssuer([]byte("test"), time.Minute)).Router()
+
+	if code, _ := e.do("POST", "/portal/login", "", loginFrom("aa:00:00:00:00:01", "10.0.0.1", "")); code != 200 {
+		t.Fatalf("first login: %d", code)
+	}
+	raw, _ := json.Marshal(loginFrom("aa:00:00:00:00:01", "10.0.0.1", ""))
+	rr := httptest.NewRecorder()
+	replica.ServeHTTP(rr, httptest.NewRequest("POST", "/portal/login", bytes.NewReader(raw)))
+	if rr.Code != 200 {
+		t.Fatalf("second login on the other replica: %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	e.h.ServeHTTP(rr, httptest.NewRequest("POST", "/portal/login", bytes.NewReader(raw)))
+	var out map[string]any
+	_ = json.Unmarshal(rr.Body.Bytes(), &out)
+	if rr.Code != 429 || out["error"] != "rate_limited" || rr.Header().Get("Retry-After") != "30" {
+		t.Fatalf("third login: %d %v Retry-After=%q", rr.Code, out, rr.Header().Get("Retry-After"))
+	}
+
+	// another MAC from the same IP uses up the IP bucket
+	if code, _ := e.do("POST", "/portal/login", "", loginFrom("aa:00:00:00:00:02", "10.0.0.1", "")); code != 200 {
+		t.Fatalf("other mac: %d", code)
+	}
+	if code, out := e.do("POST", "/portal/login", "", loginFrom("aa:00:00:00:00:03", "10.0.0.1", "")); code != 429 || out["error"] != "rate_limited" {
+		t.Fatalf("ip limit: %d %v", code, out)
+	}
+	// the refused login took no token from the MAC bucket
+	for _, ip := range []string{"10.0.0.2", "10.0.0.3"} {
+		if code, _ := e.do("POST", "/portal/login", "", loginFrom("aa:00:00:00:00:03", ip, "")); code != 200 {
+			t.Fatalf("other ip %s: %d", ip, code)
+		}
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	cfg := rateLimitConfig()
+	cfg.Controller.RateLimit.MAC.Rate = -1
+	cfg.Controller.RateLimit.IP.Rate = -1
+	p := cfg.Profiles["guest-profile"]
+	p.MaxDevices = 1
+	cfg.Profiles["guest-profile"] = p
+	e := newTestEnv(t, cfg)
+
+	if code, _ := e.do("POST", "/portal/login", "", loginFrom("aa:00:00:00:00:01", "10.0.0.1", "alice")); code != 200 {
+		t.Fatalf("first device: %d", code)
+	}
+	// policy refusals are no failures
+	for i := 0; i < 3; i++ {
+		if code, _ := e.do("POST", "/portal/login", "", loginFrom("aa:00:00:00:00:02", "10.0.0.2", "alice")); code != 403 {
+			t.Fatalf("over the device limit: %d", code)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		body := loginFrom("aa:00:00:00:00:02", "10.0.0.2", "")
+		body["user"] = map[string]any{"voucher": "NOPE-NOPE"}
+		if code, out := e.do("POST", "/portal/login", "", body); code != 403 || out["error"] != "voucher_invalid" {
+			t.Fatalf("bad voucher: %d %v", code, out)
+		}
+	}
+
+	// both the MAC and the IP are locked out now, even for a valid login
+	for _, body := range []map[string]any{
+		loginFrom("aa:00:00:00:00:02", "10.0.0.9", "bob"),
+		loginFrom("aa:00:00:00:00:09", "10.0.0.2", "bob"),
+	} {
+		code, out := e.do("POST", "/portal/login", "", body)
+		if code != 429 || out["error"] != "locked_out" || out["retry_after"].(float64) != 30 {
+			t.Fatalf("locked out: %d %v", code, out)
+		}
+	}
+	if code, _ := e.do("POST", "/portal/login", "", loginFrom("aa:00:00:00:00:09", "10.0.0.9", "bob")); code != 200 {
+		t.Fatalf("unrelated login: %d", code)
+	}
+
+	// lockouts expire
+	e.mr.FastForward(31 * time.Second)
+	if code, _ := e.do("POST", "/portal/login", "", loginFrom("aa:00:00:00:00:02", "10.0.0.2", "carol")); code != 200 {
+		t.Fatalf("after lockout: %d", code)
+	}
+}
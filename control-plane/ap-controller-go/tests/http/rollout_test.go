@@ -0,0 +1,270 @@
+package httpapi_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"ap-controller-go/internal/config"
+	httpapi "ap-controller-go/internal/http"
+)
+
+func newRolloutEnv(t *testing.T) (*testEnv, *httpapi.Server) {
+	t.Helper()
+	cfg := testConfig()
+	cfg.Roles["staff"] = config.RoleDef{Profile: "staff-profile"}
+	cfg.Profiles["staff-profile"] = config.Profile{VLAN: 200, FirewallGroup: "portal_allow_staff", SessionTTL: 3600}
+	cfg.Bypass = config.Bypass{Enabled: true, MacWhitelist: []string{"70:4d:7b:64:3b:da"}}
+	cfg.Controller.Convergence.StaleAfter = 900
+	cfg.Controller.Convergence.Canary = config.Canary{MinLogins: 20, MaxErrorIncrease: 10}
+	var srv *httpapi.Server
+	e := newTestEnvWith(t, cfg, func(s *httpapi.Server) {
+		s.SetAdminToken(adminToken)
+		srv = s
+	})
+	for ap, site := range map[string]string{"ap-01": "hq", "ap-02": "branch"} {
+		body := apBody(ap)
+		body["site"] = site
+		e.send("POST", "/api/v1/ap/register", body, false, nil)
+	}
+	return e, srv
+}
+
+// candidateReq puts GuestWiFi users into the staff role and adds a
+// bypass domain.
+func candidateReq(canary map[string]any) map[string]any {
+	return map[string]any{
+		"role_rules": []map[string]any{
+			{"name": "guest-wifi-staff", "priority": 10, "when": map[string]any{"ssid": "GuestWiFi"}, "assign": "staff"},
+		},
+		"bypass": map[string]any{
+			"enabled":       true,
+			"mac_whitelist": []string{"70:4d:7b:64:3b:da"},
+			"domains":       []string{"updates.vendor.example"},
+		},
+		"canary": canary,
+		"note":   "staff on the guest SSID",
+	}
+}
+
+// loginRole logs mac in on apID and returns the role it got.
+func (e *testEnv) loginRole(mac, apID string) string {
+	e.t.Helper()
+	req := portalReq(mac, "")
+	req["access"] = map[string]any{"ap_id": apID}
+	code, out := e.do("POST", "/portal/login", "", req)
+	sess, _ := out["session"].(map[string]any)
+	if code != 200 || sess == nil {
+		e.t.Fatalf("login %s on %s: %d %v", mac, apID, code, out)
+	}
+	role, _ := sess["role"].(string)
+	return role
+}
+
+func TestRolloutCanaryAndPromote(t *testing.T) {
+	e, _ := newRolloutEnv(t)
+
+	rr, out := e.send("POST", "/api/v1/admin/policy/rollout", candidateReq(map[string]any{"ap_ids": []string{"ap-01", " ap-01"}}), true, nil)
+	if rr.Code != 200 {
+		t.Fatalf("create: %d %v", rr.Code, out)
+	}
+	ro, _ := out["rollout"].(map[string]any)
+	cand, _ := out["candidate"].(map[string]any)
+	id, _ := ro["id"].(string)
+	if ro["stage"] != "canary" || cand == nil || id == "" {
+		t.Fatalf("create: %v", out)
+	}
+	if c, _ := ro["canary"].(map[string]any); len(c["ap_ids"].([]any)) != 1 {
+		t.Fatalf("canary not normalized: %v", c)
+	}
+	if rr, _ := e.send("POST", "/api/v1/admin/policy/rollout", candidateReq(map[string]any{"percent": 10}), true, nil); rr.Code != 409 {
+		t.Fatalf("second rollout: %d", rr.Code)
+	}
+
+	canary, sumCanary := e.runtimeFor("?ap_id=ap-01")
+	other, sumOther := e.runtimeFor("?ap_id=ap-02")
+	if canary.Rollout != id || sumCanary != cand["checksum"] || !slices.Contains(canary.Bypass.Domains, "updates.vendor.example") {
+		t.Fatalf("canary policy: %s %s %+v", canary.Rollout, sumCanary, canary.Bypass)
+	}
+	if other.Rollout != "" || sumOther == sumCanary || len(other.Bypass.Domains) != 0 {
+		t.Fatalf("baseline policy: %s %+v", other.Rollout, other.Bypass)
+	}
+
+	if role := e.loginRole("aa:00:00:00:01:01", "ap-01"); role != "staff" {
+		t.Fatalf("canary login: %s", role)
+	}
+	if role := e.loginRole("aa:00:00:00:01:02", "ap-02"); role != "guest" {
+		t.Fatalf("baseline login: %s", role)
+	}
+
+	rr, out = e.send("POST", "/api/v1/ap/policy/ack", map[string]any{
+		"ap_id": "ap-01", "checksum": sumCanary, "version": canary.Version.Version, "status": "applied",
+	}, false, nil)
+	if rr.Code != 200 || out["state"] != "current" || out["current_checksum"] != sumCanary {
+		t.Fatalf("canary ack: %d %v", rr.Code, out)
+	}
+
+	rr, out = e.send("GET", "/api/v1/admin/policy/rollout", nil, true, nil)
+	h, _ := out["health"].(map[string]any)
+	logins, _ := h["logins"].(map[string]any)
+	if rr.Code != 200 || h["aps"] != 1.0 || h["applied"] != 1.0 || h["healthy"] != true ||
+		logins["canary"] != 1.0 || logins["baseline"] != 1.0 {
+		t.Fatalf("status: %d %v", rr.Code, out)
+	}
+
+	rr, out = e.send("GET", "/api/v1/admin/policy/convergence", nil, true, nil)
+	aps, _ := out["aps"].(map[string]any)
+	current, _ := aps["current"].([]any)
+	if rr.Code != 200 || out["candidate"] == nil || len(current) != 1 || current[0].(map[string]any)["canary"] != true {
+		t.Fatalf("convergence: %d %v", rr.Code, out)
+	}
+
+	rr, out = e.send("POST", "/api/v1/admin/policy/rollout/promote", map[string]any{"reason": "canary clean"}, true, nil)
+	ro, _ = out["rollout"].(map[string]any)
+	if rr.Code != 200 || ro["stage"] != "promoted" || ro["reason"] != "canary clean" || ro["logins"] == nil {
+		t.Fatalf("promote: %d %v", rr.Code, out)
+	}
+	if rr, _ := e.send("POST", "/api/v1/admin/policy/rollout/abort", nil, true, nil); rr.Code != 409 {
+		t.Fatalf("abort after promote: %d", rr.Code)
+	}
+
+	// the promoted policy is every AP's, without the rollout marker
+	after, _ := e.runtimeFor("?ap_id=ap-02")
+	if after.Rollout != "" || !slices.Contains(after.Bypass.Domains, "updates.vendor.example") {
+		t.Fatalf("promoted policy: %+v", after)
+	}
+	if role := e.loginRole("aa:00:00:00:01:03", "ap-02"); role != "staff" {
+		t.Fatalf("login after promote: %s", role)
+	}
+	if len(e.cfg.RoleRules) != 0 {
+		t.Fatalf("config modified: %v", e.cfg.RoleRules)
+	}
+
+	// a changed config file wins over the promoted policy
+	e.cfg.Bypass.Domains = []string{"other.example"}
+	if after, _ := e.runtimeFor("?ap_id=ap-02"); !slices.Equal(after.Bypass.Domains, []string{"other.example"}) {
+		t.Fatalf("config change: %+v", after.Bypass)
+	}
+}
+
+func TestRolloutUnhealthyCanary(t *testing.T) {
+	e, srv := newRolloutEnv(t)
+	ctx := context.Background()
+
+	rr, out := e.send("POST", "/api/v1/admin/policy/rollout", candidateReq(map[string]any{"sites": []string{"hq"}}), true, nil)
+	if rr.Code != 200 {
+		t.Fatalf("create: %d %v", rr.Code, out)
+	}
+	sum := out["candidate"].(map[string]any)["checksum"]
+
+	if problems, err := srv.CheckCanary(ctx, time.Now()); err != nil || problems != nil {
+		t.Fatalf("healthy canary: %v %v", problems, err)
+	}
+
+	e.send("POST", "/api/v1/ap/policy/ack", map[string]any{
+		"ap_id": "ap-01", "checksum": sum, "status": "failed", "error": "fw4 reload failed",
+	}, false, nil)
+
+	rr, out = e.send("POST", "/api/v1/admin/policy/rollout/promote", nil, true, nil)
+	if rr.Code != 409 || out["error"] != "canary_unhealthy" {
+		t.Fatalf("promote unhealthy: %d %v", rr.Code, out)
+	}
+
+	problems, err := srv.CheckCanary(ctx, time.Now())
+	if err != nil || !slices.Equal(problems, []string{"failed_acks"}) {
+		t.Fatalf("check: %v %v", problems, err)
+	}
+	_, out = e.send("GET", "/api/v1/admin/policy/rollout", nil, true, nil)
+	ro := out["rollout"].(map[string]any)
+	if ro["stage"] != "canary" || ro["alerted"] == nil {
+		t.Fatalf("alert: %v", ro)
+	}
+
+	e.cfg.Controller.Convergence.Canary.AutoAbort = true
+	if _, err := srv.CheckCanary(ctx, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	_, out = e.send("GET", "/api/v1/admin/policy/rollout", nil, true, nil)
+	ro = out["rollout"].(map[string]any)
+	if ro["stage"] != "aborted" || ro["ended_by"] != "auto_abort" || out["health"] != nil {
+		t.Fatalf("auto abort: %v", out)
+	}
+	if rp, _ := e.runtimeFor("?ap_id=ap-01"); rp.Rollout != "" || len(rp.Bypass.Domains) != 0 {
+		t.Fatalf("canary kept the candidate: %+v", rp)
+	}
+	if role := e.loginRole("aa:00:00:00:02:01", "ap-01"); role != "guest" {
+		t.Fatalf("login after abort: %s", role)
+	}
+}
+
+func TestRolloutWidenAndAbort(t *testing.T) {
+	e, _ := newRolloutEnv(t)
+
+	e.send("POST", "/api/v1/admin/policy/rollout", candidateReq(map[string]any{"ap_ids": []string{"ap-01"}}), true, nil)
+	if rp, _ := e.runtimeFor("?ap_id=ap-02"); rp.Rollout != "" {
+		t.Fatal("ap-02 is not a canary yet")
+	}
+	rr, out := e.send("POST", "/api/v1/admin/policy/rollout/canary", map[string]any{"ap_ids": []string{"ap-01"}, "sites": []string{"branch"}}, true, nil)
+	if rr.Code != 200 {
+		t.Fatalf("widen: %d %v", rr.Code, out)
+	}
+	// the site comes from the inventory record
+	if rp, _ := e.runtimeFor("?ap_id=ap-02"); rp.Rollout == "" {
+		t.Fatal("ap-02 should be a canary")
+	}
+	if rr, out := e.send("POST", "/api/v1/admin/policy/rollout/canary", map[string]any{"percent": 101}, true, nil); rr.Code != 422 || out["error"] != "bad_canary" {
+		t.Fatalf("bad canary: %d %v", rr.Code, out)
+	}
+
+	rr, out = e.send("POST", "/api/v1/admin/policy/rollout/abort", map[string]any{"reason": "wrong VLAN"}, true, nil)
+	if ro, _ := out["rollout"].(map[string]any); rr.Code != 200 || ro["stage"] != "aborted" || ro["ended_by"] == "" {
+		t.Fatalf("abort: %d %v", rr.Code, out)
+	}
+	if rp, _ := e.runtimeFor("?ap_id=ap-02"); rp.Rollout != "" {
+		t.Fatal("candidate served after abort")
+	}
+	if rr, _ := e.send("POST", "/api/v1/admin/policy/rollout/canary", map[string]any{"percent": 50}, true, nil); rr.Code != 409 {
+		t.Fatalf("widen after abort: %d", rr.Code)
+	}
+}
+
+func TestRolloutValidation(t *testing.T) {
+	e, _ := newRolloutEnv(t)
+
+	if rr, _ := e.send("GET", "/api/v1/admin/policy/rollout", nil, true, nil); rr.Code != 404 {
+		t.Fatalf("no rollout: %d", rr.Code)
+	}
+
+	badRule := candidateReq(map[string]any{"percent": 10})
+	badRule["role_rules"] = []map[string]any{{"name": "r", "assign": "vip", "when": map[string]any{"vlan": "7"}}}
+	noChange := map[string]any{
+		"bypass": map[string]any{"enabled": true, "mac_whitelist": []string{"70:4d:7b:64:3b:da"}},
+		"canary": map[string]any{"percent": 10},
+	}
+	for _, c := range []struct {
+		body any
+		want string
+	}{
+		{map[string]any{"canary": map[string]any{"percent": 10}}, "empty_candidate"},
+		{candidateReq(map[string]any{}), "bad_canary"},
+		{candidateReq(map[string]any{"ap_ids": []string{" "}}), "bad_canary"},
+		{candidateReq(map[string]any{"percent": -1}), "bad_canary"},
+		{badRule, "invalid_policy"},
+		{noChange, "no_change"},
+	} {
+		rr, out := e.send("POST", "/api/v1/admin/policy/rollout", c.body, true, nil)
+		if rr.Code != 422 || out["error"] != c.want {
+			t.Errorf("%v: %d %v", c.body, rr.Code, out)
+		}
+		if c.want == "invalid_policy" {
+			if details, _ := out["details"].([]any); len(details) != 2 {
+				t.Errorf("details: %v", out["details"])
+			}
+		}
+	}
+
+	if rr, _ := e.send("POST", "/api/v1/admin/policy/rollout/promote", nil, true, nil); rr.Code != 409 {
+		t.Fatalf("promote without rollout: %d", rr.Code)
+	}
+}
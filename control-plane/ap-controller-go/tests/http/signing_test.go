@@ -0,0 +1,54 @@
+package httpapi_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	httpapi "ap-controller-go/internal/http"
+	"ap-controller-go/internal/policy"
+)
+
+func TestSignedRuntimePolicy(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	signer := policy.NewSigner(priv, "policy-2024")
+	next := policy.NewSigner(priv, "policy-next").PublicKey()
+	e := newTestEnvWith(t, testConfig(), func(s *httpapi.Server) {
+		s.SetAdminToken(adminToken)
+		s.EnablePolicySigning(signer, next)
+	})
+
+	rr, out := e.send("GET", "/api/v1/policy/keys", nil, false, nil)
+	var ks policy.KeySet
+	if rr.Code != 200 || json.Unmarshal(rr.Body.Bytes(), &ks) != nil || len(ks.Keys) != 2 || ks.Keys[0].KID != "policy-2024" {
+		t.Fatalf("keys: %d %v", rr.Code, out)
+	}
+
+	rr, _ = e.send("GET", "/api/v1/admin/policy/runtime", nil, true, nil)
+	kid, sig := rr.Header().Get(policy.HeaderKeyID), rr.Header().Get(policy.HeaderSignature)
+	if kid != "policy-2024" || sig == "" {
+		t.Fatalf("headers: %v", rr.Header())
+	}
+	rp, err := policy.Verify(ks, rr.Body.Bytes(), kid, sig)
+	if err != nil || rp.Version.Checksum != rr.Header().Get("X-Policy-Checksum") {
+		t.Fatalf("verify: %+v %v", rp, err)
+	}
+
+	body := rr.Body.Bytes()
+	body[len(body)-2] = ' ' // "...}\n" -> "... \n"
+	if _, err := policy.Verify(ks, body, kid, sig); !errors.Is(err, policy.ErrBadSignature) {
+		t.Fatalf("tampered: %v", err)
+	}
+}
+
+func TestRuntimePolicyUnsigned(t *testing.T) {
+	e := newTestEnvWith(t, testConfig(), func(s *httpapi.Server) { s.SetAdminToken(adminToken) })
+	if rr, _ := e.send("GET", "/api/v1/policy/keys", nil, false, nil); rr.Code != 404 {
+		t.Fatalf("keys without signing: %d", rr.Code)
+	}
+	if rr, _ := e.send("GET", "/api/v1/admin/policy/runtime", nil, true, nil); rr.Code != 200 || rr.Header().Get(policy.HeaderSignature) != "" {
+		t.Fatalf("runtime: %d %v", rr.Code, rr.Header())
+	}
+}
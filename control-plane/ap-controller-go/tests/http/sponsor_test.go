@@ -0,0 +1,277 @@
+package httpapi_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	httpapi "ap-controller-go/internal/http"
+	"ap-controller-go/internal/notify"
+	"ap-controller-go/internal/store"
+)
+
+// outbox records notifications instead of sending them.
+type outbox struct {
+	mu   sync.Mutex
+	msgs []notify.Message
+	fail bool
+}
+
+func (o *outbox) Notify(_ context.Context, msg notify.Message) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.fail {
+		return errors.New("relay down")
+	}
+	o.msgs = append(o.msgs, msg)
+	return nil
+}
+
+func (o *outbox) last(t *testing.T) notify.Message {
+	t.Helper()
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.msgs) == 0 {
+		t.Fatal("no notification sent")
+	}
+	return o.msgs[len(o.msgs)-1]
+}
+
+func newSponsorEnv(t *testing.T) (*testEnv, *outbox, *httpapi.Server) {
+	t.Helper()
+	cfg := testConfig()
+	cfg.Controller.Sponsor.Enabled = true
+	cfg.Controller.Sponsor.Role = "guest"
+	cfg.Controller.Sponsor.Duration = 7200
+	cfg.Controller.Sponsor.RequestTTL = 600
+	cfg.Controller.Sponsor.Domains = []string{"corp.example"}
+	cfg.Controller.Sponsor.BaseURL = "https://wifi.corp.example/"
+	box := &outbox{}
+	var srv *httpapi.Server
+	e := newTestEnvWith(t, cfg, func(s *httpapi.Server) {
+		s.EnableSponsor(box, []byte("link-secret"))
+		srv = s
+	})
+	return e, box, srv
+}
+
+func sponsorReq(mac, sponsor string) map[string]any {
+	req := portalReq(mac, "")
+	req["guest"] = "Alice Example"
+	req["sponsor"] = sponsor
+	req["note"] = "meeting in room 4.12"
+	return req
+}
+
+// click opens a sponsor link (GET) or submits its form (POST) and
+// returns the status and page.
+func (e *testEnv) click(method, link string) (int, string) {
+	e.t.Helper()
+	u, err := url.Parse(link)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	e.h.ServeHTTP(rr, httptest.NewRequest(method, u.RequestURI(), nil))
+	return rr.Code, rr.Body.String()
+}
+
+func TestSponsorApproval(t *testing.T) {
+	e, box, _ := newSponsorEnv(t)
+	ctx := context.Background()
+	mac := "aa:00:00:00:0b:01"
+
+	code, out := e.do("POST", "/portal/sponsor", "", sponsorReq(mac, "Bob <Bob@Corp.Example>"))
+	if code != 202 || out["status"] != "pending" || out["authorized"] != false {
+		t.Fatalf("request: %d %v", code, out)
+	}
+	id := out["id"].(string)
+
+	msg := box.last(t)
+	approve, deny := msg.Data["approve_url"].(string), msg.Data["deny_url"].(string)
+	if msg.To != "bob@corp.example" || !strings.HasPrefix(approve, "https://wifi.corp.example/portal/sponsor/"+id+"/approve?") ||
+		!strings.Contains(msg.Text, "Alice Example") || !strings.Contains(msg.Text, approve) {
+		t.Fatalf("message: %+v", msg)
+	}
+
+	// asking again while pending neither creates nor notifies
+	code, out = e.do("POST", "/portal/sponsor", "", sponsorReq(mac, "bob@corp.example"))
+	if code != 202 || out["id"] != id || len(box.msgs) != 1 {
+		t.Fatalf("repeat: %d %v (%d messages)", code, out, len(box.msgs))
+	}
+
+	// opening the link only shows the confirmation
+	code, page := e.click("GET", approve)
+	if code != 200 || !strings.Contains(page, "Approve access") || !strings.Contains(page, `method="post"`) {
+		t.Fatalf("confirm page: %d %s", code, page)
+	}
+	if code, out := e.do("GET", "/portal/sponsor/"+id, "", nil); code != 200 || out["status"] != "pending" {
+		t.Fatalf("poll pending: %d %v", code, out)
+	}
+
+	// tampered links are refused
+	if code, _ := e.click("POST", strings.Replace(approve, "/approve?", "/deny?", 1)); code != 403 {
+		t.Fatalf("action swapped: %d", code)
+	}
+
+	code, page = e.click("POST", approve)
+	if code != 200 || !strings.Contains(page, "Access approved") {
+		t.Fatalf("approve: %d %s", code, page)
+	}
+	sess, ttl, _ := e.st.GetSessionFull(ctx, mac)
+	if sess == nil || sess.Role != "guest" || sess.Auth.Method != "sponsor" || sess.Auth.Sponsor != id || ttl < 7100 || ttl > 7200 {
+		t.Fatalf("session: %+v ttl %d", sess, ttl)
+	}
+
+	code, out = e.do("GET", "/portal/sponsor/"+id, "", nil)
+	if code != 200 || out["status"] != "approved" || out["authorized"] != true || vlanOf(out) != float64(100) {
+		t.Fatalf("poll approved: %d %v", code, out)
+	}
+
+	// the decision is final
+	if code, page := e.click("POST", deny); code != 409 || !strings.Contains(page, "Access approved") {
+		t.Fatalf("deny after approve: %d %s", code, page)
+	}
+}
+
+// failSetHook fails the SET of the session of mac.
+type failSetHook struct{ mac string }
+
+func (failSetHook) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (h failSetHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if args := cmd.Args(); cmd.Name() == "set" && len(args) > 1 && strings.HasSuffix(fmt.Sprint(args[1]), h.mac) {
+			cmd.SetErr(errors.New("set failed"))
+			return cmd.Err()
+		}
+		return next(ctx, cmd)
+	}
+}
+
+func (failSetHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func TestSponsorApprovalRollsBack(t *testing.T) {
+	e, box, _ := newSponsorEnv(t)
+	mac := "aa:00:00:00:0b:05"
+
+	code, out := e.do("POST", "/portal/sponsor", "", sponsorReq(mac, "bob@corp.example"))
+	if code != 202 {
+		t.Fatalf("request: %d %v", code, out)
+	}
+	id := out["id"].(string)
+	approve := box.last(t).Data["approve_url"].(string)
+
+	// the device cannot be authorized: the request stays pending
+	e.st.AddHook(failSetHook{mac: mac})
+	if code, _ := e.click("POST", approve); code != 500 {
+		t.Fatalf("approve with store error: %d", code)
+	}
+	if code, out := e.do("GET", "/portal/sponsor/"+id, "", nil); code != 200 || out["status"] != "pending" {
+		t.Fatalf("after failed approval: %d %v", code, out)
+	}
+}
+
+func TestSponsorApprovalDenylisted(t *testing.T) {
+	e, box, _ := newSponsorEnv(t)
+	ctx := context.Background()
+	mac := "aa:00:00:00:0b:06"
+
+	code, out := e.do("POST", "/portal/sponsor", "", sponsorReq(mac, "bob@corp.example"))
+	if code != 202 {
+		t.Fatalf("request: %d %v", code, out)
+	}
+	id := out["id"].(string)
+
+	// the device is blocked while its sponsor makes up their mind
+	if err := e.st.AddDeny(ctx, store.DenyEntry{Pattern: mac, Kind: store.DenyMAC}); err != nil {
+		t.Fatal(err)
+	}
+	if code, page := e.click("POST", box.last(t).Data["approve_url"].(string)); code != 403 || !strings.Contains(page, "blocked") {
+		t.Fatalf("approve denylisted: %d %s", code, page)
+	}
+	if sess, _, _ := e.st.GetSessionFull(ctx, mac); sess != nil {
+		t.Fatalf("denylisted device authorized: %+v", sess)
+	}
+	if code, out := e.do("GET", "/portal/sponsor/"+id, "", nil); code != 200 || out["status"] != "denied" {
+		t.Fatalf("after approval: %d %v", code, out)
+	}
+}
+
+func TestSponsorDenyAndExpiry(t *testing.T) {
+	e, box, srv := newSponsorEnv(t)
+	ctx := context.Background()
+
+	_, out := e.do("POST", "/portal/sponsor", "", sponsorReq("aa:00:00:00:0b:02", "bob@corp.example"))
+	denied := out["id"].(string)
+	if code, _ := e.click("POST", box.last(t).Data["deny_url"].(string)); code != 200 {
+		t.Fatalf("deny: %d", code)
+	}
+	if code, out := e.do("GET", "/portal/sponsor/"+denied, "", nil); out["status"] != "denied" || out["authorized"] != false {
+		t.Fatalf("poll denied: %d %v", code, out)
+	}
+	if sess, _, _ := e.st.GetSessionFull(ctx, "aa:00:00:00:0b:02"); sess != nil {
+		t.Fatalf("denied device has a session: %+v", sess)
+	}
+
+	_, out = e.do("POST", "/portal/sponsor", "", sponsorReq("aa:00:00:00:0b:03", "bob@corp.example"))
+	waiting := out["id"].(string)
+	if n, err := srv.ExpireSponsorRequests(ctx, time.Now()); err != nil || n != 0 {
+		t.Fatalf("expire early: %d %v", n, err)
+	}
+	if n, err := srv.ExpireSponsorRequests(ctx, time.Now().Add(11*time.Minute)); err != nil || n != 1 {
+		t.Fatalf("expire: %d %v", n, err)
+	}
+	if code, out := e.do("GET", "/portal/sponsor/"+waiting, "", nil); out["status"] != "expired" {
+		t.Fatalf("poll expired: %d %v", code, out)
+	}
+	if code, page := e.click("POST", box.last(t).Data["approve_url"].(string)); code != 409 || !strings.Contains(page, "expired") {
+		t.Fatalf("approve expired: %d %s", code, page)
+	}
+}
+
+func TestSponsorRequestErrors(t *testing.T) {
+	e, box, _ := newSponsorEnv(t)
+
+	for _, c := range []struct {
+		body map[string]any
+		want string
+	}{
+		{sponsorReq("not-a-mac", "bob@corp.example"), "mac_required"},
+		{sponsorReq("aa:00:00:00:0b:04", "bob@elsewhere.example"), "sponsor_not_allowed"},
+		{sponsorReq("aa:00:00:00:0b:04", "corp.example"), "sponsor_not_allowed"},
+		{func() map[string]any {
+			r := sponsorReq("aa:00:00:00:0b:04", "bob@corp.example")
+			r["guest"] = " "
+			return r
+		}(), "guest_required"},
+	} {
+		if code, out := e.do("POST", "/portal/sponsor", "", c.body); code != 422 || out["error"] != c.want {
+			t.Fatalf("%v: %d %v", c.body, code, out)
+		}
+	}
+
+	// a request nobody could be told about is dropped
+	box.fail = true
+	if code, out := e.do("POST", "/portal/sponsor", "", sponsorReq("aa:00:00:00:0b:05", "bob@corp.example")); code != 502 || out["error"] != "notify_failed" {
+		t.Fatalf("notify failed: %d %v", code, out)
+	}
+	box.fail = false
+	if code, out := e.do("POST", "/portal/sponsor", "", sponsorReq("aa:00:00:00:0b:05", "bob@corp.example")); code != 202 || len(box.msgs) != 1 {
+		t.Fatalf("retry: %d %v", code, out)
+	}
+
+	if code, _ := e.do("GET", "/portal/sponsor/sr_unknown", "", nil); code != 404 {
+		t.Fatalf("unknown id: %d", code)
+	}
+}
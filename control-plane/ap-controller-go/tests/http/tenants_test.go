@@ -0,0 +1,231 @@
+package httpapi_test
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"ap-controller-go/internal/audit"
+	"ap-controller-go/internal/config"
+	httpapi "ap-controller-go/internal/http"
+	"ap-controller-go/internal/security"
+)
+
+var (
+	defaultKeys = &security.KeySet{CurrentKID: "v1", Keys: map[string][]byte{"v1": []byte("default-key")}}
+	campusKeys  = &security.KeySet{CurrentKID: "a1", Keys: map[string][]byte{"a1": []byte("campus-a-key")}}
+)
+
+// newTenantEnv serves the default tenant and "campus-a" (own profile,
+// HMAC key and portal host) through a TenantRouter.
+func newTenantEnv(t *testing.T) *testEnv {
+	t.Helper()
+	cfg := testConfig()
+	cfg.Tenants = map[string]config.Tenant{"campus-a": {
+		Hosts:      []string{"portal.campus-a.example"},
+		CurrentKID: "a1",
+		Roles:      map[string]config.RoleDef{"guest": {Profile: "a-guest"}},
+		Profiles: map[string]config.Profile{
+			"a-guest": {VLAN: 300, FirewallGroup: "campus_a_guest", SessionTTL: 600},
+		},
+	}}
+
+	var def *httpapi.Server
+	e := newTestEnvWith(t, cfg, func(s *httpapi.Server) {
+		s.SetAdminToken(adminToken)
+		s.SetAdminJWTSecret(adminJWTSecret)
+		def = s
+	})
+
+	tcfg := cfg.ForTenant("campus-a")
+	tenant := httpapi.New(tcfg, e.st.WithConfig(tcfg), audit.New(false, ""), "1",
+		security.NewJWTIssuer([]byte("campus-a-jwt"), time.Minute))
+	tenant.SetTenant("campus-a", campusKeys)
+	tenant.SetAdminToken(adminToken)
+	tenant.SetAdminJWTSecret(adminJWTSecret)
+
+	rt := httpapi.NewTenantRouter(def, defaultKeys)
+	if err := rt.Add(tenant, cfg.Tenants["campus-a"].Hosts); err != nil {
+		t.Fatal(err)
+	}
+	e.h = rt
+	return e
+}
+
+// tenantReq sends a JSON request with the given headers ("Host" sets the
+// request host).
+func (e *testEnv) tenantReq(method, path string, hdr map[string]string, body any) (int, map[string]any) {
+	e.t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		_ = json.NewEncoder(&buf).Encode(body)
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	for k, v := range hdr {
+		if k == "Host" {
+			req.Host = v
+			continue
+		}
+		req.Header.Set(k, v)
+	}
+	rr := httptest.NewRecorder()
+	e.h.ServeHTTP(rr, req)
+	out := map[string]any{}
+	_ = json.Unmarshal(rr.Body.Bytes(), &out)
+	return rr.Code, out
+}
+
+// vlanOf returns the profile VLAN of a login or session response.
+func vlanOf(out map[string]any) any {
+	if sess, ok := out["session"].(map[string]any); ok {
+		out = sess
+	}
+	p, _ := out["profile"].(map[string]any)
+	return p["vlan"]
+}
+
+func TestTenantRoutingAndKeyspace(t *testing.T) {
+	e := newTenantEnv(t)
+	const mac = "aa:00:00:00:00:01"
+
+	// the same MAC logs in on both tenants without the sessions meeting
+	if code, out := e.tenantReq("POST", "/portal/login", nil, portalReq(mac, "")); code != 200 || vlanOf(out) != float64(100) {
+		t.Fatalf("default login: %d %v", code, out)
+	}
+	if code, out := e.tenantReq("POST", "/portal/login", map[string]string{"Host": "portal.campus-a.example:443"}, portalReq(mac, "")); code != 200 || vlanOf(out) != float64(300) {
+		t.Fatalf("campus-a login by host: %d %v", code, out)
+	}
+	if !e.mr.Exists("session:"+mac) || !e.mr.Exists("session:t:campus-a:"+mac) {
+		t.Fatalf("keys: %v", e.mr.Keys())
+	}
+	if code, _ := e.tenantReq("POST", "/portal/logout", map[string]string{"X-Tenant": "campus-a", "X-Client-MAC": mac}, portalReq(mac, "")); code != 200 {
+		t.Fatalf("campus-a logout: %d", code)
+	}
+	if !e.mr.Exists("session:"+mac) || e.mr.Exists("session:t:campus-a:"+mac) {
+		t.Fatalf("logout crossed tenants: %v", e.mr.Keys())
+	}
+
+	// a signed request goes to the tenant of its kid
+	e.tenantReq("POST", "/portal/login", map[string]string{"X-Tenant": "campus-a"}, portalReq("aa:00:00:00:00:02", ""))
+	status := map[string]string{"X-Portal-Kid": "a1", "X-Client-MAC": "aa:00:00:00:00:02"}
+	if code, out := e.tenantReq("GET", "/portal/status/aa:00:00:00:00:02", status, nil); code != 200 || out["authorized"] != true {
+		t.Fatalf("status via kid: %d %v", code, out)
+	}
+	status["X-Portal-Kid"] = "v1"
+	if code, out := e.tenantReq("GET", "/portal/status/aa:00:00:00:00:02", status, nil); code != 200 || out["authorized"] != false {
+		t.Fatalf("default tenant sees campus-a session: %d %v", code, out)
+	}
+
+	// X-Tenant may confirm the kid's tenant, not override it
+	status["X-Portal-Kid"], status["X-Tenant"] = "a1", "default"
+	if code, out := e.tenantReq("GET", "/portal/status/aa:00:00:00:00:02", status, nil); code != 403 || out["error"] != "tenant_mismatch" {
+		t.Fatalf("mismatch: %d %v", code, out)
+	}
+	if code, _ := e.tenantReq("POST", "/portal/login", map[string]string{"X-Tenant": "nope"}, portalReq(mac, "")); code != 404 {
+		t.Fatalf("unknown tenant: %d", code)
+	}
+}
+
+func TestTenantAdminScoping(t *testing.T) {
+	e := newTenantEnv(t)
+	inA := func(token string) map[string]string {
+		return map[string]string{"Authorization": "Bearer " + token, "X-Tenant": "campus-a"}
+	}
+	inDefault := func(token string) map[string]string {
+		return map[string]string{"Authorization": "Bearer " + token}
+	}
+
+	// API keys belong to the tenant they were created in
+	code, out := e.tenantReq("POST", "/api/v1/admin/keys", inA(adminToken), map[string]any{"name": "campus-a-ops", "role": "operator"})
+	if code != 200 {
+		t.Fatalf("create key: %d %v", code, out)
+	}
+	key := out["key"].(string)
+	if code, out := e.tenantReq("GET", "/api/v1/admin/whoami", inA(key), nil); code != 200 || out["tenant"] != "campus-a" {
+		t.Fatalf("whoami: %d %v", code, out)
+	}
+	if code, _ := e.tenantReq("GET", "/api/v1/admin/whoami", inDefault(key), nil); code != 401 {
+		t.Fatalf("campus-a key on default tenant: %d", code)
+	}
+	if code, out := e.tenantReq("GET", "/api/v1/admin/keys", inDefault(adminToken), nil); code != 200 || len(out["keys"].([]any)) != 0 {
+		t.Fatalf("default key list: %d %v", code, out)
+	}
+
+	// tenant JWTs only work in their tenant, others everywhere
+	scoped, _ := security.IssueTenantAdminJWT(adminJWTSecret, "campus-a", "bob@campus-a", "readonly", time.Minute)
+	if code, _ := e.tenantReq("GET", "/api/v1/admin/whoami", inA(scoped), nil); code != 200 {
+		t.Fatalf("scoped jwt in its tenant: %d", code)
+	}
+	if code, out := e.tenantReq("GET", "/api/v1/admin/whoami", inDefault(scoped), nil); code != 403 || out["error"] != "wrong_tenant" {
+		t.Fatalf("scoped jwt elsewhere: %d %v", code, out)
+	}
+	global, _ := security.IssueAdminJWT(adminJWTSecret, "alice@ops", "readonly", time.Minute)
+	for _, hdr := range []map[string]string{inA(global), inDefault(global)} {
+		if code, _ := e.tenantReq("GET", "/api/v1/admin/whoami", hdr, nil); code != 200 {
+			t.Fatalf("global jwt: %d", code)
+		}
+	}
+
+	// admin reads see the tenant's sessions only
+	e.tenantReq("POST", "/portal/login", map[string]string{"X-Tenant": "campus-a"}, portalReq("aa:00:00:00:00:05", ""))
+	if code, out := e.tenantReq("GET", "/api/v1/admin/sessions/aa:00:00:00:00:05", inA(key), nil); code != 200 || vlanOf(out) != float64(300) {
+		t.Fatalf("tenant session: %d %v", code, out)
+	}
+	if code, _ := e.tenantReq("GET", "/api/v1/admin/sessions/aa:00:00:00:00:05", inDefault(global), nil); code != 404 {
+		t.Fatalf("default tenant session: %d", code)
+	}
+}
+
+// sign adds portal HMAC headers for key / kid.
+func sign(hdr map[string]string, method, path, kid string, key []byte) map[string]string {
+	return signBody(hdr, method, path, kid, key, nil)
+}
+
+// signBody signs a request whose body is tenantReq's encoding of body.
+func signBody(hdr map[string]string, method, path, kid string, key []byte, body any) map[string]string {
+	var buf bytes.Buffer
+	if body != nil {
+		_ = json.NewEncoder(&buf).Encode(body)
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := strconv.FormatInt(time.Now().UnixNano(), 36)
+	req := httptest.NewRequest(method, path, nil)
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(ts + "\n" + nonce + "\n" + security.CanonicalString(req, buf.Bytes())))
+	hdr["X-Portal-Kid"] = kid
+	hdr["X-Portal-Timestamp"] = ts
+	hdr["X-Portal-Nonce"] = nonce
+	hdr["X-Portal-Signature"] = base64.StdEncoding.EncodeToString(m.Sum(nil))
+	return hdr
+}
+
+func TestTenantHMACKeys(t *testing.T) {
+	e := newTenantEnv(t)
+	security.SkipAuthForTest = false
+	orig := security.PortalHMACProvider
+	security.PortalHMACProvider = func() *security.KeySet { return defaultKeys }
+	t.Cleanup(func() { security.PortalHMACProvider = orig })
+
+	const path = "/portal/status/aa:00:00:00:00:01"
+	client := func() map[string]string { return map[string]string{"X-Client-MAC": "aa:00:00:00:00:01"} }
+
+	if code, _ := e.tenantReq("GET", path, sign(client(), "GET", path, "a1", campusKeys.Keys["a1"]), nil); code != 200 {
+		t.Fatalf("tenant key: %d", code)
+	}
+	if code, _ := e.tenantReq("GET", path, sign(client(), "GET", path, "v1", defaultKeys.Keys["v1"]), nil); code != 200 {
+		t.Fatalf("default key: %d", code)
+	}
+	// the default key cannot sign for the tenant, nor the other way round
+	if code, _ := e.tenantReq("GET", path, sign(client(), "GET", path, "a1", defaultKeys.Keys["v1"]), nil); code != 401 {
+		t.Fatalf("default key as a1: %d", code)
+	}
+	if code, _ := e.tenantReq("GET", path, sign(client(), "GET", path, "v1", campusKeys.Keys["a1"]), nil); code != 401 {
+		t.Fatalf("tenant key as v1: %d", code)
+	}
+}
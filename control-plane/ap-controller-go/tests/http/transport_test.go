@@ -0,0 +1,221 @@
+package httpapi_test
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"ap-controller-go/internal/config"
+	httpapi "ap-controller-go/internal/http"
+	"ap-controller-go/internal/policy"
+	"ap-controller-go/internal/security"
+	"ap-controller-go/internal/store"
+	"ap-controller-go/internal/transport"
+	"ap-controller-go/tests/mqtttest"
+)
+
+// newMQTTEnv wires the server to an embedded broker (events disabled, so
+// MQTT is the only push channel).
+func newMQTTEnv(t *testing.T) (*testEnv, *httpapi.Server, *mqtttest.Broker) {
+	t.Helper()
+
+	orig := security.PortalHMACProvider
+	security.PortalHMACProvider = func() *security.KeySet {
+		return &security.KeySet{CurrentKID: "k1", Keys: map[string][]byte{"k1": []byte("test-secret")}}
+	}
+	t.Cleanup(func() { security.PortalHMACProvider = orig })
+
+	b, err := mqtttest.NewBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(b.Close)
+
+	var srv *httpapi.Server
+	e := newTestEnvWith(t, testConfig(), func(s *httpapi.Server) {
+		s.SetAdminToken(adminToken)
+		srv = s
+	})
+
+	mq, err := transport.NewMQTT(transport.MQTTOptions{
+		Config:  config.MQTT{Broker: b.URL(), ClientID: "apc-test", TopicPrefix: "apc", QoS: 1},
+		Nonces:  e.st,
+		Handler: srv.HandleAPMessage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(mq.Close)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := mq.WaitConnected(ctx); err != nil {
+		t.Fatal(err)
+	}
+	srv.EnableTransport(mq)
+	time.Sleep(100 * time.Millisecond) // let the controller subscribe
+	return e, srv, b
+}
+
+// topicScope returns the signing scope of topic: its ap_id, or "".
+func topicScope(topic string) string {
+	if rest, ok := strings.CutPrefix(topic, "apc/ap/"); ok {
+		apID, _, _ := strings.Cut(rest, "/")
+		return apID
+	}
+	return ""
+}
+
+// nextPublished returns the payload of the next broker message on topic.
+func nextPublished(t *testing.T, b *mqtttest.Broker, topic string) map[string]any {
+	t.Helper()
+	deadline := time.After(2 * time.Second)
+	for {
+		select {
+		case m := <-b.Published:
+			if m.Topic != topic {
+				continue
+			}
+			env, err := transport.Open(topicScope(m.Topic), m.Topic, m.Payload)
+			if err != nil {
+				t.Fatalf("%s: %v", topic, err)
+			}
+			out := map[string]any{}
+			_ = json.Unmarshal(env.Payload, &out)
+			return out
+		case <-deadline:
+			t.Fatalf("nothing published on %s", topic)
+		}
+	}
+}
+
+func TestMQTTSessionCommands(t *testing.T) {
+	e, _, b := newMQTTEnv(t)
+
+	loginOnAP(e, "aa:bb:cc:00:00:01", "ap-01")
+	cmd := nextPublished(t, b, "apc/ap/ap-01/session")
+	data := cmd["data"].(map[string]any)
+	if cmd["cmd"] != "authorize" || cmd["type"] != "session.created" || data["mac"] != "aa:bb:cc:00:00:01" || data["firewall_group"] != "portal_allow_guest" {
+		t.Fatalf("authorize = %v", cmd)
+	}
+
+	e.do("POST", "/portal/logout", "aa:bb:cc:00:00:01", portalReq("aa:bb:cc:00:00:01", ""))
+	cmd = nextPublished(t, b, "apc/ap/ap-01/session")
+	if cmd["cmd"] != "revoke" || cmd["data"].(map[string]any)["reason"] != "logout" {
+		t.Fatalf("revoke = %v", cmd)
+	}
+}
+
+func TestMQTTRetainedPolicy(t *testing.T) {
+	e, srv, b := newMQTTEnv(t)
+	ctx := context.Background()
+
+	if err := srv.PublishPolicyState(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// only checksums: APs refetch the signed runtime policy
+	notice := retainedNotice(t, b, "apc/all/policy")
+	if notice["checksum"] != policy.BuildRuntimePolicy(e.cfg).Version.Checksum || notice["bypass"] != nil || notice["dataplane"] != nil {
+		t.Fatalf("retained policy = %v", notice)
+	}
+	if notice = retainedNotice(t, b, "apc/all/bypass"); notice["checksum"] == nil || len(notice) != 1 {
+		t.Fatalf("retained bypass = %v", notice)
+	}
+
+	// unchanged policy is not re-sent
+	for len(b.Published) > 0 {
+		<-b.Published
+	}
+	_ = srv.PublishPolicyState(ctx)
+	select {
+	case m := <-b.Published:
+		t.Fatalf("re-sent %s", m.Topic)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func retainedNotice(t *testing.T, b *mqtttest.Broker, topic string) map[string]any {
+	t.Helper()
+	m, ok := b.Retained(topic)
+	if !ok {
+		t.Fatalf("%s not retained", topic)
+	}
+	env, err := transport.Open("", m.Topic, m.Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var notice map[string]any
+	_ = json.Unmarshal(env.Payload, &notice)
+	return notice
+}
+
+func TestMQTTRetainedPolicyCanary(t *testing.T) {
+	e, srv, b := newMQTTEnv(t)
+	e.cfg.Roles["staff"] = config.RoleDef{Profile: "staff-profile"}
+	e.cfg.Profiles["staff-profile"] = config.Profile{VLAN: 200, FirewallGroup: "portal_allow_staff", SessionTTL: 3600}
+	e.cfg.Controller.Convergence.StaleAfter = 900
+	_ = srv.PublishPolicyState(context.Background())
+	active := retainedNotice(t, b, "apc/all/policy")["checksum"]
+
+	// a rollout changes the notice but not the active checksum, so
+	// canaries are not pointed back at the active policy
+	rr, out := e.send("POST", "/api/v1/admin/policy/rollout", candidateReq(map[string]any{"ap_ids": []string{"ap-01"}}), true, nil)
+	if rr.Code != 200 {
+		t.Fatalf("rollout: %d %v", rr.Code, out)
+	}
+	notice := retainedNotice(t, b, "apc/all/policy")
+	if notice["checksum"] != active || notice["candidate_checksum"] == nil || notice["candidate_checksum"] == active ||
+		notice["rollout"] != out["rollout"].(map[string]any)["id"] {
+		t.Fatalf("retained policy = %v", notice)
+	}
+}
+
+func TestMQTTStatusAndAck(t *testing.T) {
+	e, _, b := newMQTTEnv(t)
+	ctx := context.Background()
+
+	ap := mqtt.NewClient(mqtt.NewClientOptions().AddBroker(b.URL()).SetClientID("ap-01"))
+	if tok := ap.Connect(); !tok.WaitTimeout(5*time.Second) || tok.Error() != nil {
+		t.Fatalf("connect: %v", tok.Error())
+	}
+	t.Cleanup(func() { ap.Disconnect(50) })
+	send := func(topic string, body any) {
+		t.Helper()
+		raw, _ := transport.Seal("ap-01", topic, body)
+		if tok := ap.Publish(topic, 1, false, raw); !tok.WaitTimeout(2*time.Second) || tok.Error() != nil {
+			t.Fatalf("publish: %v", tok.Error())
+		}
+	}
+	waitAP := func(ok func(*store.APRecord) bool) *store.APRecord {
+		t.Helper()
+		for i := 0; i < 100; i++ {
+			if rec, _ := e.st.GetAP(ctx, "ap-01"); rec != nil && ok(rec) {
+				return rec
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		t.Fatal("ap record not updated")
+		return nil
+	}
+
+	// status registers an unknown AP
+	send("apc/ap/ap-01/status", map[string]any{"site": "hq", "firmware": "1.2.3", "uptime": 60})
+	rec := waitAP(func(r *store.APRecord) bool { return r.Firmware == "1.2.3" })
+	if rec.Site != "hq" || rec.Uptime != 60 {
+		t.Fatalf("record = %+v", rec)
+	}
+
+	current := policy.BuildRuntimePolicy(e.cfg).Version.Checksum
+	send("apc/ap/ap-01/ack", map[string]any{"checksum": current, "version": "0.1.0", "status": "applied"})
+	waitAP(func(r *store.APRecord) bool { return r.PolicyChecksum == current && r.PolicyStatus == "applied" })
+
+	// a body speaking for another AP is ignored
+	send("apc/ap/ap-01/ack", map[string]any{"ap_id": "ap-02", "checksum": "x", "status": "failed"})
+	time.Sleep(100 * time.Millisecond)
+	if rec, _ := e.st.GetAP(ctx, "ap-01"); rec.PolicyStatus != "applied" {
+		t.Fatalf("mismatched ack applied: %+v", rec)
+	}
+}
@@ -0,0 +1,175 @@
+package httpapi_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	httpapi "ap-controller-go/internal/http"
+)
+
+func newVoucherEnv(t *testing.T) *testEnv {
+	t.Helper()
+	cfg := testConfig()
+	cfg.Controller.Vouchers.MaxBatch = 100
+	return newTestEnvWith(t, cfg, func(s *httpapi.Server) { s.SetAdminToken(adminToken) })
+}
+
+func voucherLogin(mac, code string) map[string]any {
+	req := portalReq(mac, "")
+	req["user"] = map[string]any{"voucher": code}
+	return req
+}
+
+// createVouchers creates a batch and returns its id and codes.
+func (e *testEnv) createVouchers(body map[string]any) (string, []string) {
+	e.t.Helper()
+	rr, out := e.send("POST", "/api/v1/admin/vouchers", body, true, nil)
+	if rr.Code != 200 {
+		e.t.Fatalf("create batch: %d %v", rr.Code, out)
+	}
+	var codes []string
+	for _, v := range out["vouchers"].([]any) {
+		codes = append(codes, v.(map[string]any)["code"].(string))
+	}
+	return out["batch"].(map[string]any)["id"].(string), codes
+}
+
+func TestVoucherRedemption(t *testing.T) {
+	e := newVoucherEnv(t)
+	ctx := context.Background()
+	batch, codes := e.createVouchers(map[string]any{"name": "lobby", "role": "guest", "count": 3, "duration": 3600, "devices": 2})
+	if len(codes) != 3 {
+		t.Fatalf("codes: %v", codes)
+	}
+
+	code, out := e.do("POST", "/portal/login", "", voucherLogin("aa:00:00:00:0a:01", strings.ToLower(codes[0])))
+	if code != 200 || out["authorized"] != true || vlanOf(out) != float64(100) {
+		t.Fatalf("redeem: %d %v", code, out)
+	}
+	sess, ttl, _ := e.st.GetSessionFull(ctx, "aa:00:00:00:0a:01")
+	if sess.Auth.Method != "voucher" || !strings.HasPrefix(sess.Auth.Voucher, "v_") || sess.Auth.Identity != "voucher:"+sess.Auth.Voucher || sess.TS.Expires == 0 || ttl > 3600 {
+		t.Fatalf("session: %+v ttl %d", sess.Auth, ttl)
+	}
+
+	// a second device takes the last use; the first may log in again
+	if code, _ := e.do("POST", "/portal/login", "", voucherLogin("aa:00:00:00:0a:02", codes[0])); code != 200 {
+		t.Fatalf("second device: %d", code)
+	}
+	if code, _ := e.do("POST", "/portal/login", "", voucherLogin("aa:00:00:00:0a:01", codes[0])); code != 200 {
+		t.Fatalf("same device again: %d", code)
+	}
+	if code, out := e.do("POST", "/portal/login", "", voucherLogin("aa:00:00:00:0a:03", codes[0])); code != 403 || out["error"] != "voucher_used_up" {
+		t.Fatalf("third device: %d %v", code, out)
+	}
+
+	// typos fail the checksum, unknown codes are not found
+	typo := []byte(codes[1])
+	typo[0] ^= 1
+	for _, c := range []string{string(typo), "0000-0000-0000"} {
+		if code, out := e.do("POST", "/portal/login", "", voucherLogin("aa:00:00:00:0a:04", c)); code != 403 || out["error"] != "voucher_invalid" {
+			t.Fatalf("%s: %d %v", c, code, out)
+		}
+	}
+
+	// report
+	rr, out := e.send("GET", "/api/v1/admin/vouchers/"+batch, nil, true, nil)
+	rep := out["report"].(map[string]any)
+	if rr.Code != 200 || rep["devices"] != float64(2) || rep["status"].(map[string]any)["active"] != float64(1) || rep["status"].(map[string]any)["unused"] != float64(2) {
+		t.Fatalf("batch: %d %v", rr.Code, out)
+	}
+	if strings.Contains(rr.Body.String(), codes[1]) {
+		t.Fatal("batch view shows codes")
+	}
+	if _, out := e.send("GET", "/api/v1/admin/vouchers", nil, true, nil); len(out["batches"].([]any)) != 1 {
+		t.Fatalf("list: %v", out)
+	}
+
+	// revoking the batch ends its sessions
+	rr, out = e.send("DELETE", "/api/v1/admin/vouchers/"+batch, nil, true, nil)
+	if rr.Code != 200 || out["revoked"] != float64(3) || out["sessions_ended"] != float64(2) {
+		t.Fatalf("revoke: %d %v", rr.Code, out)
+	}
+	if sess, _, _ := e.st.GetSessionFull(ctx, "aa:00:00:00:0a:02"); sess != nil {
+		t.Fatal("session survived revocation")
+	}
+	if code, out := e.do("POST", "/portal/login", "", voucherLogin("aa:00:00:00:0a:05", codes[2])); code != 403 || out["error"] != "voucher_revoked" {
+		t.Fatalf("revoked voucher: %d %v", code, out)
+	}
+}
+
+func TestVoucherTermsAndQuota(t *testing.T) {
+	e := newVoucherEnv(t)
+
+	for _, body := range []map[string]any{
+		{"role": "guest", "count": 0},
+		{"role": "nope", "count": 1},
+		{"role": "guest", "count": 1, "valid_until": 1},
+		{"role": "guest", "count": 1, "devices": -1},
+	} {
+		if rr, _ := e.send("POST", "/api/v1/admin/vouchers", body, true, nil); rr.Code != 422 {
+			t.Fatalf("%v: %d", body, rr.Code)
+		}
+	}
+
+	_, codes := e.createVouchers(map[string]any{"role": "guest", "count": 1, "quota_mb": 1})
+	const mac = "aa:00:00:00:0b:01"
+	e.do("POST", "/portal/login", "", voucherLogin(mac, codes[0]))
+	_, body := e.do("POST", "/api/v1/accounting", "", acctReq("ap-1", mac, "interim", 1<<20, 0))
+	if r := firstResult(t, body); r["status"] != "terminated" || r["reason"] != "voucher_quota" {
+		t.Fatalf("quota: %v", r)
+	}
+	if code, out := e.do("POST", "/portal/login", "", voucherLogin(mac, codes[0])); code != 403 || out["error"] != "voucher_quota_exceeded" {
+		t.Fatalf("after quota: %d %v", code, out)
+	}
+
+	_, codes = e.createVouchers(map[string]any{"role": "guest", "count": 1, "valid_from": 4102444800})
+	if code, out := e.do("POST", "/portal/login", "", voucherLogin(mac, codes[0])); code != 403 || out["error"] != "voucher_not_yet_valid" {
+		t.Fatalf("not yet valid: %d %v", code, out)
+	}
+}
+
+func TestVoucherRejectFreesDeviceSlot(t *testing.T) {
+	cfg := testConfig()
+	cfg.Controller.Vouchers.MaxBatch = 100
+	p := cfg.Profiles["guest-profile"]
+	p.MaxDevices = 1
+	cfg.Profiles["guest-profile"] = p
+	e := newTestEnvWith(t, cfg, func(s *httpapi.Server) { s.SetAdminToken(adminToken) })
+	_, codes := e.createVouchers(map[string]any{"role": "guest", "count": 1, "devices": 1})
+	const first, second = "aa:00:00:00:0c:01", "aa:00:00:00:0c:02"
+
+	if code, _ := e.do("POST", "/portal/login", "", voucherLogin(first, codes[0])); code != 200 {
+		t.Fatalf("redeem: %d", code)
+	}
+	e.do("POST", "/portal/logout", first, portalReq(first, ""))
+
+	// the free slot is claimed before the voucher refuses the device
+	if code, out := e.do("POST", "/portal/login", "", voucherLogin(second, codes[0])); code != 403 || out["error"] != "voucher_used_up" {
+		t.Fatalf("other device: %d %v", code, out)
+	}
+	if code, out := e.do("POST", "/portal/login", "", voucherLogin(first, codes[0])); code != 200 {
+		t.Fatalf("login at max_devices after a refused voucher: %d %v", code, out)
+	}
+}
+
+func TestVoucherExport(t *testing.T) {
+	e := newVoucherEnv(t)
+	batch, codes := e.createVouchers(map[string]any{"name": "front desk", "role": "guest", "count": 2, "duration": 86400})
+
+	rr, _ := e.send("GET", "/api/v1/admin/vouchers/"+batch+"/export", nil, true, nil)
+	lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+	if rr.Code != 200 || !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/csv") || len(lines) != 3 ||
+		!strings.HasPrefix(lines[0], "id,code,") || !strings.Contains(lines[1], codes[0]) {
+		t.Fatalf("csv: %d %q", rr.Code, rr.Body.String())
+	}
+
+	rr, _ = e.send("GET", "/api/v1/admin/vouchers/"+batch+"/export?format=html", nil, true, nil)
+	html := rr.Body.String()
+	if rr.Code != 200 || !strings.Contains(html, codes[1]) || !strings.Contains(html, "front desk") || !strings.Contains(html, "24h0m0s") {
+		t.Fatalf("html: %d %s", rr.Code, html)
+	}
+	if rr, _ := e.send("GET", "/api/v1/admin/vouchers/nope/export", nil, true, nil); rr.Code != 404 {
+		t.Fatalf("unknown batch: %d", rr.Code)
+	}
+}
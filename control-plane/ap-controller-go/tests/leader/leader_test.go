@@ -0,0 +1,161 @@
+package leader_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"ap-controller-go/internal/config"
+	"ap-controller-go/internal/leader"
+	"ap-controller-go/internal/store"
+)
+
+func newStore(t *testing.T) (*store.Store, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	port, _ := strconv.Atoi(mr.Port())
+	cfg := &config.Config{Redis: config.Redis{Host: mr.Host(), Port: port, Prefix: "session:"}}
+	return store.New(cfg, ""), mr
+}
+
+func TestElectionAndTakeover(t *testing.T) {
+	st, mr := newStore(t)
+	ctx := context.Background()
+
+	var changes []string
+	a := leader.New(st, "jobs", "a", time.Minute, time.Second)
+	a.OnChange = func(ch leader.Change) { changes = append(changes, "a:"+ch.Reason) }
+	b := leader.New(st, "jobs", "b", time.Minute, time.Second)
+	b.OnChange = func(ch leader.Change) { changes = append(changes, "b:"+ch.Reason) }
+
+	for _, e := range []*leader.Elector{a, b, a, b} {
+		if err := e.Campaign(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !a.IsLeader() || b.IsLeader() || a.Token() != 1 {
+		t.Fatalf("a=%+v b=%+v", a.Status(), b.Status())
+	}
+	if st := b.Status(); st.Holder != "a" || st.Token != 1 || !st.Enabled {
+		t.Fatalf("b status: %+v", st)
+	}
+
+	// a stops renewing; b takes over once the lease expires
+	mr.FastForward(2 * time.Minute)
+	_ = b.Campaign(ctx)
+	if !b.IsLeader() || b.Token() != 2 {
+		t.Fatalf("b: %+v", b.Status())
+	}
+	// until its next campaign a still believes it leads, but the fence
+	// check in redis fails
+	if err := a.Fence(ctx, a.Token()); err != leader.ErrNotLeader {
+		t.Fatalf("fence: %v", err)
+	}
+	_ = a.Campaign(ctx)
+	if a.IsLeader() {
+		t.Fatal("a still leader")
+	}
+
+	want := []string{"a:elected", "b:elected", "a:taken_over"}
+	if len(changes) != len(want) {
+		t.Fatalf("changes: %v", changes)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Fatalf("changes: %v", changes)
+		}
+	}
+}
+
+func TestSchedulerRunsSingletonsOnLeaderOnly(t *testing.T) {
+	st, mr := newStore(t)
+	ctx := context.Background()
+
+	tokens := map[string]int64{}
+	jobs := func(replica string) []leader.Job {
+		return []leader.Job{
+			{Name: "offline", Every: time.Second, Singleton: true, Run: func(ctx context.Context) error {
+				tokens[replica] = leader.TokenFrom(ctx)
+				return nil
+			}},
+			{Name: "publish", Every: time.Second, Run: func(context.Context) error { return nil }},
+		}
+	}
+	a := leader.New(st, "jobs", "a", time.Minute, time.Second)
+	b := leader.New(st, "jobs", "b", time.Minute, time.Second)
+	sa, sb := leader.NewScheduler(a), leader.NewScheduler(b)
+	sa.Add(jobs("a")...)
+	sb.Add(jobs("b")...)
+
+	_ = a.Campaign(ctx)
+	_ = b.Campaign(ctx)
+	if ran := sa.RunAll(ctx); len(ran) != 2 || tokens["a"] != 1 {
+		t.Fatalf("leader ran %v, token %d", ran, tokens["a"])
+	}
+	if ran := sb.RunAll(ctx); len(ran) != 1 || ran[0] != "publish" {
+		t.Fatalf("follower ran %v", ran)
+	}
+
+	// a deposed leader that has not noticed yet is fenced off
+	mr.FastForward(2 * time.Minute)
+	_ = b.Campaign(ctx)
+	delete(tokens, "a")
+	if ran := sa.RunAll(ctx); len(ran) != 1 || tokens["a"] != 0 {
+		t.Fatalf("deposed leader ran %v", ran)
+	}
+	if ran := sb.RunAll(ctx); len(ran) != 2 || tokens["b"] != 2 {
+		t.Fatalf("new leader ran %v, token %d", ran, tokens["b"])
+	}
+
+	// without election every job runs
+	sc := leader.NewScheduler(nil)
+	sc.Add(jobs("c")...)
+	if ran := sc.RunAll(ctx); len(ran) != 2 || tokens["c"] != 0 {
+		t.Fatalf("without election ran %v", ran)
+	}
+}
+
+func TestSchedulerFencesJobWrites(t *testing.T) {
+	st, mr := newStore(t)
+	ctx := context.Background()
+
+	a := leader.New(st, "jobs", "a", time.Minute, time.Second)
+	b := leader.New(st, "jobs", "b", time.Minute, time.Second)
+	var errs []error
+	sched := leader.NewScheduler(a)
+	sched.Add(leader.Job{Name: "offline", Every: time.Second, Singleton: true, Run: func(ctx context.Context) error {
+		_, err := st.UpdateAP(ctx, "ap-01", func(rec *store.APRecord, _ bool) error {
+			rec.LastSeen = 1
+			return nil
+		})
+		errs = append(errs, err)
+
+		// the lease moves on while the job runs: its next write is refused
+		mr.FastForward(2 * time.Minute)
+		_ = b.Campaign(ctx)
+		_, err = st.UpdateAP(ctx, "ap-01", func(rec *store.APRecord, _ bool) error {
+			rec.Offline = true
+			return nil
+		})
+		errs = append(errs, err)
+		return nil
+	}})
+
+	_ = a.Campaign(ctx)
+	sched.RunAll(ctx)
+	if len(errs) != 2 || errs[0] != nil || !errors.Is(errs[1], store.ErrFenced) {
+		t.Fatalf("writes: %v", errs)
+	}
+	if rec, _ := st.GetAP(ctx, "ap-01"); rec == nil || rec.Offline {
+		t.Fatalf("fenced write applied: %+v", rec)
+	}
+
+	// outside a job nothing is fenced
+	if _, err := st.UpdateAP(ctx, "ap-01", func(*store.APRecord, bool) error { return nil }); err != nil {
+		t.Fatalf("unfenced write: %v", err)
+	}
+}
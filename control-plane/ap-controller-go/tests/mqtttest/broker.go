@@ -0,0 +1,483 @@
+// Package mqtttest provides a minimal in-process MQTT 3.1.1 broker for
+// tests, in the spirit of net/http/httptest.
+//
+// It supports CONNECT, SUBSCRIBE / UNSUBSCRIBE with + and # wildcards,
+// PUBLISH at QoS 0 and 1 (QoS 2 is accepted from clients and delivered
+// at QoS 1), retained messages and PINGREQ. There is no persistence, no
+// authentication and no redelivery.
+package mqtttest
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Packet types.
+const (
+	pConnect     = 1
+	pConnack     = 2
+	pPublish     = 3
+	pPuback      = 4
+	pPubrec      = 5
+	pPubrel      = 6
+	pPubcomp     = 7
+	pSubscribe   = 8
+	pSuback      = 9
+	pUnsubscribe = 10
+	pUnsuback    = 11
+	pPingreq     = 12
+	pPingresp    = 13
+	pDisconnect  = 14
+)
+
+// Message is a message seen by the broker.
+type Message struct {
+	Topic   string
+	Payload []byte
+	QoS     byte
+	Retain  bool
+}
+
+// Broker is a running test broker.
+type Broker struct {
+	ln net.Listener
+
+	mu       sync.Mutex
+	conns    map[*conn]struct{}
+	retained map[string]Message
+	closed   bool
+
+	// Published receives a copy of every PUBLISH from a client; the
+	// oldest messages are dropped when nobody reads it.
+	Published chan Message
+
+	wg sync.WaitGroup
+}
+
+// NewBroker starts a broker on a random loopback port.
+func NewBroker() (*Broker, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	b := &Broker{
+		ln:        ln,
+		conns:     map[*conn]struct{}{},
+		retained:  map[string]Message{},
+		Published: make(chan Message, 256),
+	}
+	b.wg.Add(1)
+	go b.accept()
+	return b, nil
+}
+
+// URL is the broker address for MQTT clients (tcp://127.0.0.1:port).
+func (b *Broker) URL() string {
+	return "tcp://" + b.ln.Addr().String()
+}
+
+// Retained returns the retained message on topic, if any.
+func (b *Broker) Retained(topic string) (Message, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	m, ok := b.retained[topic]
+	return m, ok
+}
+
+// Close stops the broker and drops every client.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	b.closed = true
+	for c := range b.conns {
+		c.nc.Close()
+	}
+	b.mu.Unlock()
+	b.ln.Close()
+	b.wg.Wait()
+}
+
+func (b *Broker) accept() {
+	defer b.wg.Done()
+	for {
+		nc, err := b.ln.Accept()
+		if err != nil {
+			return
+		}
+		c := &conn{b: b, nc: nc, r: bufio.NewReader(nc), subs: map[string]byte{}}
+		b.mu.Lock()
+		if b.closed {
+			b.mu.Unlock()
+			nc.Close()
+			return
+		}
+		b.conns[c] = struct{}{}
+		b.mu.Unlock()
+
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			c.serve()
+			b.mu.Lock()
+			delete(b.conns, c)
+			b.mu.Unlock()
+			nc.Close()
+		}()
+	}
+}
+
+// route delivers m to every matching subscription.
+func (b *Broker) route(m Message) {
+	b.mu.Lock()
+	if m.Retain {
+		if len(m.Payload) == 0 {
+			delete(b.retained, m.Topic)
+		} else {
+			b.retained[m.Topic] = m
+		}
+	}
+	type target struct {
+		c   *conn
+		qos byte
+	}
+	var targets []target
+	for c := range b.conns {
+		if qos, ok := c.match(m.Topic); ok {
+			targets = append(targets, target{c, qos})
+		}
+	}
+	b.mu.Unlock()
+
+	select {
+	case b.Published <- m:
+	default:
+		select {
+		case <-b.Published:
+		default:
+		}
+		select {
+		case b.Published <- m:
+		default:
+		}
+	}
+
+	// forwarded messages don't carry the retain flag (MQTT-3.3.1-9)
+	for _, t := range targets {
+		_ = t.c.publish(m.Topic, m.Payload, min(m.QoS, t.qos), false)
+	}
+}
+
+// -------------------------------------------------------------------
+// Connections
+// -------------------------------------------------------------------
+
+type conn struct {
+	b  *Broker
+	nc net.Conn
+	r  *bufio.Reader
+
+	wmu    sync.Mutex
+	nextID uint16
+
+	smu  sync.Mutex
+	subs map[string]byte // filter -> granted qos
+}
+
+func (c *conn) match(topic string) (byte, bool) {
+	c.smu.Lock()
+	defer c.smu.Unlock()
+	var best byte
+	found := false
+	for f, qos := range c.subs {
+		if Match(f, topic) {
+			found = true
+			best = max(best, qos)
+		}
+	}
+	return best, found
+}
+
+func (c *conn) serve() {
+	typ, _, body, err := readPacket(c.r)
+	if err != nil || typ != pConnect {
+		return
+	}
+	if err := c.connect(body); err != nil {
+		return
+	}
+
+	for {
+		typ, flags, body, err := readPacket(c.r)
+		if err != nil {
+			return
+		}
+		switch typ {
+		case pPublish:
+			if err := c.onPublish(flags, body); err != nil {
+				return
+			}
+		case pPubrel:
+			if len(body) < 2 {
+				return
+			}
+			_ = c.write(pPubcomp<<4, body[:2])
+		case pSubscribe:
+			if err := c.onSubscribe(body); err != nil {
+				return
+			}
+		case pUnsubscribe:
+			if err := c.onUnsubscribe(body); err != nil {
+				return
+			}
+		case pPingreq:
+			_ = c.write(pPingresp<<4, nil)
+		case pPuback, pPubrec, pPubcomp:
+			// no redelivery, nothing to track
+		case pDisconnect:
+			return
+		default:
+			return
+		}
+	}
+}
+
+func (c *conn) connect(body []byte) error {
+	p := parser{b: body}
+	proto := p.str()
+	level := p.byte()
+	if p.err != nil || (proto != "MQTT" && proto != "MQIsdp") {
+		return errors.New("bad connect")
+	}
+	if level != 3 && level != 4 {
+		// unacceptable protocol version
+		_ = c.write(pConnack<<4, []byte{0, 1})
+		return errors.New("unsupported protocol level")
+	}
+	return c.write(pConnack<<4, []byte{0, 0})
+}
+
+func (c *conn) onPublish(flags byte, body []byte) error {
+	qos := (flags >> 1) & 3
+	retain := flags&1 == 1
+	p := parser{b: body}
+	topic := p.str()
+	var id []byte
+	if qos > 0 {
+		id = p.bytes(2)
+	}
+	if p.err != nil || topic == "" || strings.ContainsAny(topic, "+#") {
+		return errors.New("bad publish")
+	}
+	payload := append([]byte(nil), p.rest()...)
+
+	switch qos {
+	case 1:
+		if err := c.write(pPuback<<4, id); err != nil {
+			return err
+		}
+	case 2:
+		if err := c.write(pPubrec<<4, id); err != nil {
+			return err
+		}
+	}
+	c.b.route(Message{Topic: topic, Payload: payload, QoS: min(qos, 1), Retain: retain})
+	return nil
+}
+
+func (c *conn) onSubscribe(body []byte) error {
+	p := parser{b: body}
+	id := p.bytes(2)
+	var granted []byte
+	var filters []string
+	for p.err == nil && len(p.b) > 0 {
+		f := p.str()
+		qos := min(p.byte(), 1)
+		if p.err != nil {
+			break
+		}
+		c.smu.Lock()
+		c.subs[f] = qos
+		c.smu.Unlock()
+		granted = append(granted, qos)
+		filters = append(filters, f)
+	}
+	if p.err != nil || len(granted) == 0 {
+		return errors.New("bad subscribe")
+	}
+	if err := c.write(pSuback<<4, append(id, granted...)); err != nil {
+		return err
+	}
+
+	// retained messages go out after the SUBACK
+	c.b.mu.Lock()
+	var ret []Message
+	for _, m := range c.b.retained {
+		for _, f := range filters {
+			if Match(f, m.Topic) {
+				ret = append(ret, m)
+				break
+			}
+		}
+	}
+	c.b.mu.Unlock()
+	for _, m := range ret {
+		qos, _ := c.match(m.Topic)
+		if err := c.publish(m.Topic, m.Payload, min(m.QoS, qos), true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *conn) onUnsubscribe(body []byte) error {
+	p := parser{b: body}
+	id := p.bytes(2)
+	for p.err == nil && len(p.b) > 0 {
+		f := p.str()
+		c.smu.Lock()
+		delete(c.subs, f)
+		c.smu.Unlock()
+	}
+	if p.err != nil {
+		return p.err
+	}
+	return c.write(pUnsuback<<4, id)
+}
+
+func (c *conn) publish(topic string, payload []byte, qos byte, retain bool) error {
+	var hdr byte = pPublish<<4 | qos<<1
+	if retain {
+		hdr |= 1
+	}
+	body := appendStr(nil, topic)
+	if qos > 0 {
+		c.wmu.Lock()
+		c.nextID++
+		if c.nextID == 0 {
+			c.nextID = 1
+		}
+		id := c.nextID
+		c.wmu.Unlock()
+		body = binary.BigEndian.AppendUint16(body, id)
+	}
+	return c.write(hdr, append(body, payload...))
+}
+
+func (c *conn) write(hdr byte, body []byte) error {
+	buf := []byte{hdr}
+	n := len(body)
+	for {
+		d := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			d |= 0x80
+		}
+		buf = append(buf, d)
+		if n == 0 {
+			break
+		}
+	}
+	buf = append(buf, body...)
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, err := c.nc.Write(buf)
+	return err
+}
+
+// -------------------------------------------------------------------
+// Wire helpers
+// -------------------------------------------------------------------
+
+func readPacket(r *bufio.Reader) (typ, flags byte, body []byte, err error) {
+	h, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	n, mul := 0, 1
+	for i := 0; ; i++ {
+		d, err := r.ReadByte()
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		n += int(d&0x7f) * mul
+		if d&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			return 0, 0, nil, errors.New("bad remaining length")
+		}
+		mul *= 128
+	}
+	body = make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, 0, nil, err
+	}
+	return h >> 4, h & 0x0f, body, nil
+}
+
+type parser struct {
+	b   []byte
+	err error
+}
+
+func (p *parser) bytes(n int) []byte {
+	if p.err != nil {
+		return nil
+	}
+	if len(p.b) < n {
+		p.err = fmt.Errorf("short packet")
+		return nil
+	}
+	out := append([]byte(nil), p.b[:n]...)
+	p.b = p.b[n:]
+	return out
+}
+
+func (p *parser) byte() byte {
+	b := p.bytes(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (p *parser) str() string {
+	l := p.bytes(2)
+	if l == nil {
+		return ""
+	}
+	return string(p.bytes(int(binary.BigEndian.Uint16(l))))
+}
+
+func (p *parser) rest() []byte {
+	return p.b
+}
+
+func appendStr(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// Match reports whether topic matches the subscription filter.
+func Match(filter, topic string) bool {
+	fs := strings.Split(filter, "/")
+	ts := strings.Split(topic, "/")
+	for i, f := range fs {
+		if f == "#" {
+			return true
+		}
+		if i >= len(ts) {
+			return false
+		}
+		if f != "+" && f != ts[i] {
+			return false
+		}
+	}
+	return len(fs) == len(ts)
+}
@@ -0,0 +1,218 @@
+package notify_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ap-controller-go/internal/config"
+	"ap-controller-go/internal/notify"
+	"ap-controller-go/internal/tracing"
+)
+
+var msg = notify.Message{
+	Kind:    "sponsor.request",
+	To:      "bob@corp.example",
+	Subject: "Wi-Fi access request from Alice",
+	Text:    "Approve: https://wifi.corp.example/x",
+	Data:    map[string]any{"id": "sr_1"},
+}
+
+func TestFileNotifier(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.jsonl")
+	n, err := notify.New(config.Notifier{Type: config.NotifierFile, File: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := n.Notify(context.Background(), msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var lines []notify.Message
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var m notify.Message
+		if err := json.Unmarshal(sc.Bytes(), &m); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, m)
+	}
+	if len(lines) != 2 || lines[1].To != msg.To || lines[1].Data["id"] != "sr_1" {
+		t.Fatalf("lines: %+v", lines)
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	secret := []byte("hook-secret")
+	var got notify.Message
+	var sig string
+	fail := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		sig = r.Header.Get("X-Signature")
+		if sig != "sha256="+notify.Sign(secret, body) {
+			w.WriteHeader(401)
+			return
+		}
+		_ = json.Unmarshal(body, &got)
+		if fail {
+			w.WriteHeader(503)
+		}
+	}))
+	defer srv.Close()
+
+	n := notify.NewWebhook(srv.URL, secret)
+	if err := n.Notify(context.Background(), msg); err != nil {
+		t.Fatalf("notify: %v (signature %q)", err, sig)
+	}
+	if got.Subject != msg.Subject || got.Text != msg.Text {
+		t.Fatalf("received: %+v", got)
+	}
+
+	fail = true
+	if err := n.Notify(context.Background(), msg); err == nil {
+		t.Fatal("expected error for status 503")
+	}
+	if err := notify.NewWebhook(srv.URL, []byte("wrong")).Notify(context.Background(), msg); err == nil {
+		t.Fatal("expected error for a bad signature")
+	}
+}
+
+func TestWebhookContinuesTrace(t *testing.T) {
+	shutdown, err := tracing.Init(context.Background(), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown(context.Background())
+
+	var parent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parent = r.Header.Get("traceparent")
+	}))
+	defer srv.Close()
+
+	ctx, span := tracing.Start(context.Background(), "sponsor.request")
+	defer span.End()
+	if err := notify.NewWebhook(srv.URL, nil).Notify(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	if id := tracing.TraceID(ctx); id == "" || !strings.Contains(parent, id) {
+		t.Fatalf("traceparent %q, trace %q", parent, id)
+	}
+}
+
+func TestSMTPRejectsHeaderInjection(t *testing.T) {
+	n := &notify.SMTP{Addr: "127.0.0.1:1", From: "wifi@corp.example"}
+	m := msg
+	m.To = "bob@corp.example\r\nBcc: eve@evil.example"
+	if err := n.Notify(context.Background(), m); err == nil || err.Error() != "smtp: header injection" {
+		t.Fatalf("expected header injection error, got %v", err)
+	}
+}
+
+func TestSMTPTimesOut(t *testing.T) {
+	// a relay that accepts but never greets
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	n := &notify.SMTP{Addr: ln.Addr().String(), From: "wifi@corp.example", Timeout: 100 * time.Millisecond}
+	start := time.Now()
+	if err := n.Notify(context.Background(), msg); err == nil || time.Since(start) > 2*time.Second {
+		t.Fatalf("timeout: %v after %v", err, time.Since(start))
+	}
+
+	// the caller's deadline applies too
+	n.Timeout = 0
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	if err := n.Notify(ctx, msg); err == nil || time.Since(start) > 2*time.Second {
+		t.Fatalf("ctx deadline: %v after %v", err, time.Since(start))
+	}
+}
+
+// fakeRelay accepts one mail and sends its DATA to got.
+func fakeRelay(t *testing.T, got chan<- string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		r := bufio.NewReader(c)
+		io.WriteString(c, "220 relay\r\n")
+		var data strings.Builder
+		inData := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch {
+			case inData && line == ".\r\n":
+				inData = false
+				got <- data.String()
+				io.WriteString(c, "250 queued\r\n")
+			case inData:
+				data.WriteString(line)
+			case strings.HasPrefix(line, "EHLO"):
+				io.WriteString(c, "250 relay\r\n")
+			case strings.HasPrefix(line, "DATA"):
+				inData = true
+				io.WriteString(c, "354 go ahead\r\n")
+			case strings.HasPrefix(line, "QUIT"):
+				io.WriteString(c, "221 bye\r\n")
+				return
+			default:
+				io.WriteString(c, "250 ok\r\n")
+			}
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestSMTPSends(t *testing.T) {
+	got := make(chan string, 1)
+	n := &notify.SMTP{Addr: fakeRelay(t, got), From: "wifi@corp.example"}
+	if err := n.Notify(context.Background(), msg); err != nil {
+		t.Fatalf("notify: %v", err)
+	}
+	body := <-got
+	if !strings.Contains(body, "To: bob@corp.example\r\n") || !strings.Contains(body, "Subject: "+msg.Subject+"\r\n") {
+		t.Fatalf("mail: %q", body)
+	}
+}
@@ -0,0 +1,83 @@
+package policy_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"ap-controller-go/internal/policy"
+)
+
+func newSigner(t *testing.T, kid string) *policy.Signer {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return policy.NewSigner(priv, kid)
+}
+
+func TestSignVerify(t *testing.T) {
+	s := newSigner(t, "")
+	ks := policy.KeySet{Keys: []policy.PublicKey{s.PublicKey()}}
+	body, _ := json.Marshal(policy.RuntimePolicy{Version: policy.ControllerVersion{Version: "1.2.0", Checksum: "abc"}})
+	sig := s.Sign(body)
+
+	rp, err := policy.Verify(ks, body, s.KeyID(), sig)
+	if err != nil || rp.Version.Checksum != "abc" {
+		t.Fatalf("verify: %+v %v", rp, err)
+	}
+
+	tampered := append([]byte{}, body...)
+	tampered[len(tampered)-3] ^= 1
+	if _, err := policy.Verify(ks, tampered, s.KeyID(), sig); !errors.Is(err, policy.ErrBadSignature) {
+		t.Fatalf("tampered: %v", err)
+	}
+	if _, err := policy.Verify(ks, body, "other", sig); !errors.Is(err, policy.ErrUnknownKey) {
+		t.Fatalf("unknown kid: %v", err)
+	}
+	// a key that is published but did not sign
+	other := newSigner(t, "next")
+	ks.Keys = append(ks.Keys, other.PublicKey())
+	if _, err := policy.Verify(ks, body, "next", sig); !errors.Is(err, policy.ErrBadSignature) {
+		t.Fatalf("wrong key: %v", err)
+	}
+}
+
+func TestCheckForward(t *testing.T) {
+	prev := policy.ControllerVersion{Version: "1.9.0", Checksum: "a", Generated: 100}
+	for _, c := range []struct {
+		cur  policy.ControllerVersion
+		fail bool
+	}{
+		{policy.ControllerVersion{Version: "1.10.0", Checksum: "b", Generated: 50}, false},
+		{policy.ControllerVersion{Version: "1.9.0", Checksum: "b", Generated: 101}, false},
+		{policy.ControllerVersion{Version: "1.9.0", Checksum: "a", Generated: 90}, false}, // same policy again
+		{policy.ControllerVersion{Version: "1.9.0", Checksum: "b", Generated: 99}, true},
+		{policy.ControllerVersion{Version: "1.8.9", Checksum: "b", Generated: 200}, true},
+	} {
+		err := policy.CheckForward(prev, c.cur)
+		if c.fail != errors.Is(err, policy.ErrDowngrade) {
+			t.Errorf("%+v: %v", c.cur, err)
+		}
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	for _, c := range []struct {
+		a, b string
+		want int
+	}{
+		{"1.10", "1.9", 1},
+		{"v2", "1.99.99", 1},
+		{"1.0", "1", 0},
+		{"1.0.0-rc1", "1.0.0-rc2", -1},
+		{"", "0", 0},
+	} {
+		if got := policy.CompareVersions(c.a, c.b); got != c.want {
+			t.Errorf("%q vs %q = %d", c.a, c.b, got)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package security_test
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/url"
+	"testing"
+
+	"ap-controller-go/internal/security"
+)
+
+func TestAPIdentityFromCert(t *testing.T) {
+	uri := func(s string) []*url.URL {
+		u, _ := url.Parse(s)
+		return []*url.URL{u}
+	}
+
+	cases := []struct {
+		name       string
+		cert       *x509.Certificate
+		apID, site string
+		tenant     string
+		ok         bool
+	}{
+		{
+			name: "san uri wins",
+			cert: &x509.Certificate{
+				Subject: pkix.Name{CommonName: "cn-ap", OrganizationalUnit: []string{"cn-site"}},
+				URIs:    uri("ap://hq/ap-01"),
+			},
+			apID: "ap-01", site: "hq", ok: true,
+		},
+		{
+			name: "tenant",
+			cert: &x509.Certificate{
+				Subject: pkix.Name{CommonName: "ap-01"},
+				URIs:    uri("ap://hq/ap-01?tenant=campus-a"),
+			},
+			apID: "ap-01", site: "hq", tenant: "campus-a", ok: true,
+		},
+		{
+			name: "subject fallback",
+			cert: &x509.Certificate{
+				Subject: pkix.Name{CommonName: "ap-02", OrganizationalUnit: []string{"lab"}},
+			},
+			apID: "ap-02", site: "lab", ok: true,
+		},
+		{
+			name: "foreign uri ignored",
+			cert: &x509.Certificate{
+				Subject: pkix.Name{CommonName: "ap-03"},
+				URIs:    uri("spiffe://hq/ap-99"),
+			},
+			apID: "ap-03", ok: true,
+		},
+		{
+			name: "no identity",
+			cert: &x509.Certificate{},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.cert.SerialNumber = big.NewInt(42)
+			id, ok := security.APIdentityFromCert(tc.cert)
+			if ok != tc.ok || id.APID != tc.apID || id.Site != tc.site || id.Tenant != tc.tenant {
+				t.Fatalf("got %+v ok=%v", id, ok)
+			}
+			if ok && id.Serial != "2a" {
+				t.Fatalf("serial = %q", id.Serial)
+			}
+		})
+	}
+}
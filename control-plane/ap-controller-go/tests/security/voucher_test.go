@@ -0,0 +1,61 @@
+package security_test
+
+import (
+	"strings"
+	"testing"
+
+	"ap-controller-go/internal/security"
+)
+
+func TestVoucherCodes(t *testing.T) {
+	code, err := security.NewVoucherCode()
+	if err != nil || len(code) != security.VoucherCodeLen {
+		t.Fatalf("code %q %v", code, err)
+	}
+	printed := security.FormatVoucherCode(code)
+	if len(printed) != 14 || printed[4] != '-' || printed[9] != '-' {
+		t.Fatalf("printed %q", printed)
+	}
+
+	// case, dashes and spaces don't matter
+	for _, in := range []string{printed, strings.ToLower(printed), " " + strings.ReplaceAll(printed, "-", " ") + " "} {
+		if got, ok := security.NormalizeVoucherCode(in); !ok || got != code {
+			t.Fatalf("normalize %q = %q %v", in, got, ok)
+		}
+	}
+
+	// O / I / L are read as 0 / 1
+	var ambiguous strings.Builder
+	for _, c := range code {
+		switch c {
+		case '0':
+			c = 'O'
+		case '1':
+			c = 'l'
+		}
+		ambiguous.WriteRune(c)
+	}
+	if got, ok := security.NormalizeVoucherCode(ambiguous.String()); !ok || got != code {
+		t.Fatalf("ambiguous %q = %q %v", ambiguous.String(), got, ok)
+	}
+
+	// every single wrong symbol fails the check
+	const alphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+	for i := range code {
+		for _, c := range alphabet {
+			if byte(c) == code[i] {
+				continue
+			}
+			typo := code[:i] + string(c) + code[i+1:]
+			if _, ok := security.NormalizeVoucherCode(typo); ok {
+				t.Fatalf("typo %q accepted for %q", typo, code)
+			}
+		}
+	}
+	if _, ok := security.NormalizeVoucherCode(code[:11]); ok {
+		t.Fatal("short code accepted")
+	}
+	if _, ok := security.NormalizeVoucherCode(code[:11] + "U"); ok {
+		t.Fatal("U accepted")
+	}
+}
@@ -0,0 +1,339 @@
+package server_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ap-controller-go/internal/config"
+	"ap-controller-go/internal/security"
+	"ap-controller-go/internal/server"
+)
+
+// -------------------------------------------------------------------
+// Test PKI
+// -------------------------------------------------------------------
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newCA(t *testing.T) *testCA {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ap ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns PEM cert + key for tmpl signed by the CA.
+func (ca *testCA) issue(t *testing.T, tmpl *x509.Certificate) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kder, _ := x509.MarshalECPrivateKey(key)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kder})
+}
+
+func (ca *testCA) serverCert(t *testing.T, serial int64) (certPEM, keyPEM []byte) {
+	return ca.issue(t, &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "controller"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+}
+
+func (ca *testCA) apCert(t *testing.T, apID, site string) tls.Certificate {
+	u, _ := url.Parse("ap://" + site + "/" + apID)
+	c, k := ca.issue(t, &x509.Certificate{
+		SerialNumber: big.NewInt(100),
+		Subject:      pkix.Name{CommonName: "ignored-cn"},
+		URIs:         []*url.URL{u},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	pair, err := tls.X509KeyPair(c, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pair
+}
+
+func writeFile(t *testing.T, path string, b []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// -------------------------------------------------------------------
+// Harness
+// -------------------------------------------------------------------
+
+type harness struct {
+	ca      *testCA
+	dir     string
+	srv     *server.Server
+	url     string
+	cancel  context.CancelFunc
+	done    chan error
+	tlsConf config.TLS
+}
+
+func timeouts() config.Timeouts {
+	return config.Timeouts{ReadHeader: 5, Read: 5, Write: 5, Idle: 5, Shutdown: 5}
+}
+
+// identityHandler echoes the AP identity taken from the client cert.
+var identityHandler = security.APIdentityMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	id, ok := security.APIdentityFrom(r.Context())
+	_ = json.NewEncoder(w).Encode(map[string]any{"ok": ok, "ap_id": id.APID, "site": id.Site})
+}))
+
+func startTLS(t *testing.T, clientAuth string, verify func(*x509.Certificate) error) *harness {
+	t.Helper()
+	h := &harness{ca: newCA(t), dir: t.TempDir()}
+	c, k := h.ca.serverCert(t, 10)
+	writeFile(t, filepath.Join(h.dir, "tls.crt"), c)
+	writeFile(t, filepath.Join(h.dir, "tls.key"), k)
+	writeFile(t, filepath.Join(h.dir, "ca.crt"), h.ca.pem)
+
+	h.tlsConf = config.TLS{
+		Enabled:      true,
+		CertFile:     filepath.Join(h.dir, "tls.crt"),
+		KeyFile:      filepath.Join(h.dir, "tls.key"),
+		ClientAuth:   clientAuth,
+		ClientCAFile: filepath.Join(h.dir, "ca.crt"),
+	}
+	srv, err := server.New(server.Options{
+		Handler:      identityHandler,
+		TLS:          h.tlsConf,
+		Timeouts:     timeouts(),
+		VerifyClient: verify,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.srv = srv
+	h.serve(t)
+	return h
+}
+
+func (h *harness) serve(t *testing.T) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	scheme := "http"
+	if h.srv.TLSEnabled() {
+		scheme = "https"
+	}
+	h.url = scheme + "://" + ln.Addr().String()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	h.cancel, h.done = cancel, make(chan error, 1)
+	go func() { h.done <- h.srv.Serve(ctx, ln) }()
+	t.Cleanup(func() {
+		cancel()
+		<-h.done
+	})
+}
+
+func (h *harness) client(certs ...tls.Certificate) *http.Client {
+	roots := x509.NewCertPool()
+	roots.AddCert(h.ca.cert)
+	return &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			DisableKeepAlives: true,
+			TLSClientConfig:   &tls.Config{RootCAs: roots, Certificates: certs},
+		},
+	}
+}
+
+func getJSON(t *testing.T, c *http.Client, url string) (*http.Response, map[string]any) {
+	t.Helper()
+	resp, err := c.Get(url)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	defer resp.Body.Close()
+	var out map[string]any
+	_ = json.NewDecoder(resp.Body).Decode(&out)
+	return resp, out
+}
+
+// -------------------------------------------------------------------
+// Tests
+// -------------------------------------------------------------------
+
+func TestMutualTLSMapsCertToAPIdentity(t *testing.T) {
+	h := startTLS(t, config.ClientAuthRequire, nil)
+
+	_, out := getJSON(t, h.client(h.ca.apCert(t, "ap-01", "hq")), h.url)
+	if out["ok"] != true || out["ap_id"] != "ap-01" || out["site"] != "hq" {
+		t.Fatalf("identity = %v", out)
+	}
+
+	if _, err := h.client().Get(h.url); err == nil {
+		t.Fatal("expected handshake failure without client certificate")
+	}
+}
+
+func TestRequestModeAllowsAnonymousClients(t *testing.T) {
+	h := startTLS(t, config.ClientAuthRequest, nil)
+
+	_, out := getJSON(t, h.client(), h.url)
+	if out["ok"] != false {
+		t.Fatalf("anonymous client got identity: %v", out)
+	}
+
+	_, out = getJSON(t, h.client(h.ca.apCert(t, "ap-02", "lab")), h.url)
+	if out["ap_id"] != "ap-02" {
+		t.Fatalf("identity = %v", out)
+	}
+}
+
+func TestVerifyClientHookRejects(t *testing.T) {
+	h := startTLS(t, config.ClientAuthRequire, func(c *x509.Certificate) error {
+		return errors.New("revoked")
+	})
+
+	if _, err := h.client(h.ca.apCert(t, "ap-01", "hq")).Get(h.url); err == nil {
+		t.Fatal("expected handshake failure for rejected certificate")
+	}
+}
+
+func TestCertificateReload(t *testing.T) {
+	h := startTLS(t, config.ClientAuthNone, nil)
+
+	serial := func() int64 {
+		resp, err := h.client().Get(h.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.TLS.PeerCertificates[0].SerialNumber.Int64()
+	}
+	if got := serial(); got != 10 {
+		t.Fatalf("serial = %d, want 10", got)
+	}
+
+	c, k := h.ca.serverCert(t, 11)
+	writeFile(t, h.tlsConf.CertFile, c)
+	writeFile(t, h.tlsConf.KeyFile, k)
+	if err := h.srv.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got := serial(); got != 11 {
+		t.Fatalf("serial after reload = %d, want 11", got)
+	}
+
+	// a broken file keeps the previous certificate
+	writeFile(t, h.tlsConf.CertFile, []byte("garbage"))
+	if err := h.srv.Reload(); err == nil {
+		t.Fatal("expected reload error")
+	}
+	if got := serial(); got != 11 {
+		t.Fatalf("serial after failed reload = %d, want 11", got)
+	}
+}
+
+func TestNewFailsOnMissingCertificate(t *testing.T) {
+	_, err := server.New(server.Options{
+		TLS: config.TLS{
+			Enabled:  true,
+			CertFile: filepath.Join(t.TempDir(), "missing.crt"),
+			KeyFile:  filepath.Join(t.TempDir(), "missing.key"),
+		},
+		Timeouts: timeouts(),
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestGracefulShutdownDrainsInFlight(t *testing.T) {
+	started := make(chan struct{})
+	srv, err := server.New(server.Options{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			time.Sleep(200 * time.Millisecond)
+			w.Write([]byte("done"))
+		}),
+		Timeouts: timeouts(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &harness{srv: srv}
+	h.serve(t)
+
+	type result struct {
+		code int
+		err  error
+	}
+	resc := make(chan result, 1)
+	go func() {
+		resp, err := http.Get(h.url)
+		if err != nil {
+			resc <- result{err: err}
+			return
+		}
+		resp.Body.Close()
+		resc <- result{code: resp.StatusCode}
+	}()
+
+	<-started
+	h.cancel()
+
+	if err := <-h.done; err != nil {
+		t.Fatalf("serve returned %v", err)
+	}
+	h.done <- nil // for cleanup
+
+	res := <-resc
+	if res.err != nil || res.code != 200 {
+		t.Fatalf("in-flight request: code=%d err=%v", res.code, res.err)
+	}
+
+	if _, err := http.Get(h.url); err == nil {
+		t.Fatal("expected connection refused after shutdown")
+	}
+}
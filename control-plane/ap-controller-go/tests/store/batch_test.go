@@ -0,0 +1,99 @@
+package store_test
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"ap-controller-go/internal/config"
+	"ap-controller-go/internal/store"
+)
+
+func newStore(tb testing.TB) (*store.Store, *miniredis.Miniredis) {
+	tb.Helper()
+	mr := miniredis.RunT(tb)
+	port, _ := strconv.Atoi(mr.Port())
+	cfg := &config.Config{Redis: config.Redis{Host: mr.Host(), Port: port, Prefix: "session:"}}
+	return store.New(cfg, ""), mr
+}
+
+func seedSessions(tb testing.TB, st *store.Store, n int) []string {
+	tb.Helper()
+	macs := make([]string, n)
+	writes := make([]store.SessionWrite, n)
+	for i := range macs {
+		macs[i] = fmt.Sprintf("02:00:00:00:%02x:%02x", i/256, i%256)
+		writes[i] = store.SessionWrite{Session: store.SessionV2{MAC: macs[i], Role: "guest"}, TTL: 600}
+	}
+	if _, err := st.SetSessions(context.Background(), writes); err != nil {
+		tb.Fatal(err)
+	}
+	return macs
+}
+
+func TestGetSessions(t *testing.T) {
+	st, mr := newStore(t)
+	ctx := context.Background()
+	macs := seedSessions(t, st, 3)
+	_ = mr.Set("session:02:00:00:00:ff:ff", "{not json")
+
+	res, err := st.GetSessions(ctx, []string{macs[0], "02:00:00:00:aa:aa", macs[2], "02:00:00:00:ff:ff"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 4 {
+		t.Fatalf("len = %d", len(res))
+	}
+	if res[0].Session == nil || res[0].MAC != macs[0] || res[0].TTL != 600 || res[0].Session.Schema != 2 {
+		t.Fatalf("res[0] = %+v", res[0])
+	}
+	if res[1].Session != nil || res[3].Session != nil || res[2].Session == nil {
+		t.Fatalf("missing / corrupt sessions: %+v", res)
+	}
+}
+
+func TestDeleteSessions(t *testing.T) {
+	st, mr := newStore(t)
+	macs := seedSessions(t, st, 2)
+
+	res, err := st.DeleteSessions(context.Background(), []string{macs[0], "02:00:00:00:aa:aa", macs[1]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res[0] == nil || res[0].Role != "guest" || res[1] != nil || res[2] == nil {
+		t.Fatalf("deleted = %+v", res)
+	}
+	if mr.Exists("session:" + macs[0]) {
+		t.Fatal("session still stored")
+	}
+}
+
+// BenchmarkBatchStatus compares the old per-MAC GET + TTL loop with one
+// pipelined MGET / TTL, for an AP sync of 1000 clients.
+func BenchmarkBatchStatus(b *testing.B) {
+	st, _ := newStore(b)
+	ctx := context.Background()
+	macs := seedSessions(b, st, 1000)
+
+	b.Run("loop", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, m := range macs {
+				if _, _, err := st.GetSessionFull(ctx, m); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	b.Run("pipelined", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for j := 0; j < len(macs); j += 500 {
+				if _, err := st.GetSessions(ctx, macs[j:j+500]); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+}
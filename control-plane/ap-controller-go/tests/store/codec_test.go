@@ -0,0 +1,142 @@
+package store_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"ap-controller-go/internal/config"
+	"ap-controller-go/internal/store"
+)
+
+func newSchemaStore(t *testing.T) (*store.Store, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	port, _ := strconv.Atoi(mr.Port())
+	cfg := &config.Config{
+		Redis:    config.Redis{Host: mr.Host(), Port: port, Prefix: "session:"},
+		Roles:    map[string]config.RoleDef{"staff": {Profile: "staff-profile"}},
+		Profiles: map[string]config.Profile{"staff-profile": {VLAN: 20, FirewallGroup: "staff"}},
+	}
+	return store.New(cfg, ""), mr
+}
+
+func schemaOf(t *testing.T, mr *miniredis.Miniredis, key string) float64 {
+	t.Helper()
+	raw, err := mr.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rec map[string]any
+	if err := json.Unmarshal([]byte(raw), &rec); err != nil {
+		t.Fatalf("%s is not JSON: %q", key, raw)
+	}
+	s, _ := rec["schema"].(float64)
+	return s
+}
+
+func TestLegacySessionUpgradedOnRead(t *testing.T) {
+	st, mr := newSchemaStore(t)
+	ctx := context.Background()
+	const mac = "aa:bb:cc:00:00:01"
+
+	// the Python controller stores the bare role
+	mr.Set("session:"+mac, "staff")
+	mr.SetTTL("session:"+mac, 600*time.Second)
+
+	sess, ttl, err := st.GetSessionFull(ctx, mac)
+	if err != nil || sess == nil {
+		t.Fatalf("get: %v %v", sess, err)
+	}
+	if sess.Schema != store.SessionSchema || sess.MAC != mac || sess.Role != "staff" ||
+		sess.Profile != "staff-profile" || sess.Attrs.VLAN != 20 || ttl <= 0 {
+		t.Fatalf("decoded %+v ttl %d", sess, ttl)
+	}
+	if schemaOf(t, mr, "session:"+mac) != store.SessionSchema {
+		t.Fatal("record not upgraded")
+	}
+	if mr.TTL("session:"+mac) != 600*time.Second {
+		t.Fatalf("ttl lost: %v", mr.TTL("session:"+mac))
+	}
+
+	// batch reads upgrade too
+	mr.Set("session:aa:bb:cc:00:00:02", "staff")
+	res, err := st.GetSessions(ctx, []string{"aa:bb:cc:00:00:02"})
+	if err != nil || res[0].Session == nil || res[0].Session.Role != "staff" {
+		t.Fatalf("batch: %+v %v", res, err)
+	}
+	if schemaOf(t, mr, "session:aa:bb:cc:00:00:02") != store.SessionSchema {
+		t.Fatal("batch record not upgraded")
+	}
+}
+
+func TestNewerSessionSchemaKept(t *testing.T) {
+	st, mr := newSchemaStore(t)
+	ctx := context.Background()
+	const mac = "aa:bb:cc:00:00:03"
+
+	// written by a newer controller during a rolling upgrade
+	mr.Set("session:"+mac, `{"schema":3,"mac":"`+mac+`","role":"staff","posture":{"ok":true},"ts":{"created":1}}`)
+
+	sess, _, err := st.GetSessionFull(ctx, mac)
+	if err != nil || sess.Role != "staff" || sess.Schema != 3 {
+		t.Fatalf("get: %+v %v", sess, err)
+	}
+	if _, err := st.UpdateSession(ctx, mac, func(s *store.SessionV2) error {
+		s.Auth.Identity = "alice"
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, _ := mr.Get("session:" + mac)
+	var rec map[string]any
+	_ = json.Unmarshal([]byte(raw), &rec)
+	if rec["schema"] != float64(3) || rec["posture"] == nil || rec["auth"].(map[string]any)["identity"] != "alice" {
+		t.Fatalf("rewritten: %s", raw)
+	}
+}
+
+func TestMigrateSessions(t *testing.T) {
+	st, mr := newSchemaStore(t)
+	ctx := context.Background()
+
+	for i := 0; i < 25; i++ {
+		mr.Set(fmt.Sprintf("session:aa:bb:cc:00:01:%02x", i), "staff")
+	}
+	_ = st.SetSession(ctx, store.SessionV2{MAC: "aa:bb:cc:00:02:01", Role: "staff"}, 600)
+	mr.Set("session:aa:bb:cc:00:02:02", `{"schema":3,"role":"staff"}`)
+	mr.Set("session:aa:bb:cc:00:02:03", `{"schema":"broken"`)
+	mr.Set("session:identity:alice", "not a session")
+
+	p := store.MigrationProgress{Schema: store.SessionSchema}
+	for steps := 0; !p.Done(); steps++ {
+		if steps > 100 {
+			t.Fatal("migration does not finish")
+		}
+		if err := st.MigrateSessions(ctx, &p, 10); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if p.Scanned != 28 || p.Upgraded != 25 || p.Newer != 1 || p.Failed != 1 {
+		t.Fatalf("progress: %+v", p)
+	}
+	if schemaOf(t, mr, "session:aa:bb:cc:00:01:18") != store.SessionSchema {
+		t.Fatal("not migrated")
+	}
+	if v, _ := mr.Get("session:identity:alice"); v != "not a session" {
+		t.Fatal("migration touched a non-session key")
+	}
+
+	if err := st.SaveMigration(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := st.GetMigration(ctx); err != nil || *got != p {
+		t.Fatalf("saved progress: %+v %v", got, err)
+	}
+}
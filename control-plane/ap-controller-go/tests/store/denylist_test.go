@@ -0,0 +1,67 @@
+package store_test
+
+import (
+	"context"
+	"testing"
+
+	"ap-controller-go/internal/store"
+)
+
+func TestParseDenyPattern(t *testing.T) {
+	for _, c := range []struct {
+		in, pattern, kind string
+	}{
+		{"AA-BB-CC-DD-EE-FF", "aa:bb:cc:dd:ee:ff", store.DenyMAC},
+		{" aa:bb:cc:dd:ee:ff ", "aa:bb:cc:dd:ee:ff", store.DenyMAC},
+		{"AA:BB:CC", "aa:bb:cc", store.DenyOUI},
+		{"aa-bb-cc", "aa:bb:cc", store.DenyOUI},
+		{"aa:bb:cc:*", "aa:bb:cc", store.DenyOUI},
+	} {
+		p, k, ok := store.ParseDenyPattern(c.in)
+		if !ok || p != c.pattern || k != c.kind {
+			t.Errorf("%q: %q %q %v", c.in, p, k, ok)
+		}
+	}
+	for _, in := range []string{"", "*", "aa:bb", "aa:bb:cc:dd", "aa:bb:zz", "aa:bb:cc:dd:ee:ff:00:11"} {
+		if p, _, ok := store.ParseDenyPattern(in); ok {
+			t.Errorf("%q accepted as %q", in, p)
+		}
+	}
+}
+
+func TestDenyMatch(t *testing.T) {
+	st, _ := newStore(t)
+	ctx := context.Background()
+
+	_ = st.AddDeny(ctx, store.DenyEntry{Pattern: "aa:bb:cc", Kind: store.DenyOUI, Reason: "vendor"})
+	_ = st.AddDeny(ctx, store.DenyEntry{Pattern: "aa:bb:cc:00:00:01", Kind: store.DenyMAC, Reason: "device"})
+	_ = st.AddDeny(ctx, store.DenyEntry{Pattern: "02:00:00:00:00:01", Kind: store.DenyMAC, Expires: 100})
+
+	if e, err := st.DenyMatch(ctx, "aa:bb:cc:00:00:01", 50); err != nil || e == nil || e.Reason != "device" {
+		t.Fatalf("own entry first: %+v %v", e, err)
+	}
+	if e, _ := st.DenyMatch(ctx, "aa:bb:cc:00:00:02", 50); e == nil || e.Pattern != "aa:bb:cc" {
+		t.Fatalf("oui: %+v", e)
+	}
+	if e, _ := st.DenyMatch(ctx, "02:00:00:00:00:01", 50); e == nil {
+		t.Fatal("before expiry: no match")
+	}
+	if e, _ := st.DenyMatch(ctx, "02:00:00:00:00:01", 100); e != nil {
+		t.Fatalf("expired entry matched: %+v", e)
+	}
+
+	entries, err := st.ListDenylist(ctx, 100)
+	if err != nil || len(entries) != 2 || entries[0].Pattern != "aa:bb:cc" {
+		t.Fatalf("list: %+v %v", entries, err)
+	}
+	// the expired entry was dropped
+	if entries, _ := st.ListDenylist(ctx, 0); len(entries) != 2 {
+		t.Fatalf("expired entry kept: %+v", entries)
+	}
+	if ok, _ := st.RemoveDeny(ctx, "aa:bb:cc"); !ok {
+		t.Fatal("remove")
+	}
+	if ok, _ := st.RemoveDeny(ctx, "aa:bb:cc"); ok {
+		t.Fatal("removed twice")
+	}
+}
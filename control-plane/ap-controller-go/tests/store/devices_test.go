@@ -0,0 +1,108 @@
+package store_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"ap-controller-go/internal/store"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestClaimDevice(t *testing.T) {
+	st, mr := newStore(t)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	claimed := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ok, err := st.ClaimDevice(ctx, "alice", fmt.Sprintf("02:00:00:00:00:%02x", i), 2, 600)
+			if err != nil {
+				t.Error(err)
+			}
+			if ok {
+				mu.Lock()
+				claimed++
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	if claimed != 2 {
+		t.Fatalf("claimed %d of max 2", claimed)
+	}
+
+	// the index keeps the longest TTL it was given
+	if err := st.AddDevice(ctx, "bob", "02:00:00:00:01:01", 3600); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.AddDevice(ctx, "bob", "02:00:00:00:01:02", 60); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := mr.TTL("session:identity:bob:devices"); ttl != time.Hour {
+		t.Fatalf("index ttl %v", ttl)
+	}
+}
+
+// evalKeysHook records the keys declared by each script call.
+type evalKeysHook struct{ keys *[][]string }
+
+func (evalKeysHook) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (h evalKeysHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if n := cmd.Name(); n == "eval" || n == "evalsha" {
+			args := cmd.Args()
+			nkeys, _ := args[2].(int)
+			keys := make([]string, nkeys)
+			for i := range keys {
+				keys[i], _ = args[3+i].(string)
+			}
+			*h.keys = append(*h.keys, keys)
+		}
+		return next(ctx, cmd)
+	}
+}
+
+func (evalKeysHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func TestClaimDeviceDeclaresSessionKeys(t *testing.T) {
+	st, mr := newStore(t)
+	ctx := context.Background()
+
+	// two devices past the claim grace: one still has a session
+	old := float64(time.Now().Add(-time.Hour).Unix())
+	_, _ = mr.ZAdd("session:identity:carol:devices", old, "02:00:00:00:02:01")
+	_, _ = mr.ZAdd("session:identity:carol:devices", old, "02:00:00:00:02:02")
+	if _, err := st.SetSessions(ctx, []store.SessionWrite{{Session: store.SessionV2{MAC: "02:00:00:00:02:01", Role: "guest"}, TTL: 600}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var calls [][]string
+	st.AddHook(evalKeysHook{&calls})
+	ok, err := st.ClaimDevice(ctx, "carol", "02:00:00:00:02:03", 2, 600)
+	if err != nil || !ok {
+		t.Fatalf("claim: %v %v", ok, err)
+	}
+	want := []string{"session:identity:carol:devices", "session:02:00:00:00:02:01", "session:02:00:00:00:02:02"}
+	if len(calls) == 0 || fmt.Sprint(calls[len(calls)-1]) != fmt.Sprint(want) {
+		t.Fatalf("declared keys %v, want %v", calls, want)
+	}
+
+	// the device without a session was pruned, so the limit is reached
+	if ok, _ := st.ClaimDevice(ctx, "carol", "02:00:00:00:02:04", 2, 600); ok {
+		t.Fatal("claim beyond the limit")
+	}
+	if devs, _ := st.ActiveDevices(ctx, "carol"); len(devs) != 1 {
+		t.Fatalf("active %v", devs)
+	}
+}
@@ -0,0 +1,54 @@
+package store_test
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLeaseAcquireRenewTakeover(t *testing.T) {
+	st, mr := newStore(t)
+	ctx := context.Background()
+
+	l, err := st.AcquireLease(ctx, "jobs", "a", 10*time.Second)
+	if err != nil || l.Holder != "a" || l.Token != 1 {
+		t.Fatalf("acquire: %+v %v", l, err)
+	}
+	// b sees a's lease; a renews with the same token
+	if l, _ := st.AcquireLease(ctx, "jobs", "b", 10*time.Second); l.Holder != "a" || l.Token != 1 || l.TTL <= 0 {
+		t.Fatalf("b: %+v", l)
+	}
+	mr.FastForward(5 * time.Second)
+	if l, _ := st.AcquireLease(ctx, "jobs", "a", 10*time.Second); l.Holder != "a" || l.Token != 1 {
+		t.Fatalf("renew: %+v", l)
+	}
+	if ok, _ := st.CheckLease(ctx, "jobs", "a", 1); !ok {
+		t.Fatal("check a/1")
+	}
+
+	// once it expires b takes over with a higher token
+	mr.FastForward(11 * time.Second)
+	if l, _ := st.AcquireLease(ctx, "jobs", "b", 10*time.Second); l.Holder != "b" || l.Token != 2 {
+		t.Fatalf("takeover: %+v", l)
+	}
+	if ok, _ := st.CheckLease(ctx, "jobs", "a", 1); ok {
+		t.Fatal("a still holds the lease")
+	}
+
+	// a stale release leaves b's lease alone
+	if err := st.ReleaseLease(ctx, "jobs", "a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := st.CheckLease(ctx, "jobs", "b", 2); !ok {
+		t.Fatal("stale release dropped b's lease")
+	}
+	if err := st.ReleaseLease(ctx, "jobs", "b", 2); err != nil {
+		t.Fatal(err)
+	}
+	if mr.Exists("session:leader:jobs") {
+		t.Fatal("lease not released")
+	}
+	if l, _ := st.AcquireLease(ctx, "jobs", "a", 10*time.Second); l.Token != 3 {
+		t.Fatalf("token after release: %+v", l)
+	}
+}
@@ -0,0 +1,97 @@
+package store_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"ap-controller-go/internal/store"
+)
+
+func TestTakeToken(t *testing.T) {
+	st, _ := newStore(t)
+	ctx := context.Background()
+	sub := store.RateSubject{Scope: "mac", ID: "02:00:00:00:00:01"}
+
+	// 2 per 200ms: the burst passes, the next waits about one interval
+	for i := 0; i < 2; i++ {
+		if wait, err := st.TakeToken(ctx, sub, 2, 2, 200*time.Millisecond); err != nil || wait != 0 {
+			t.Fatalf("token %d: %v %v", i, wait, err)
+		}
+	}
+	wait, err := st.TakeToken(ctx, sub, 2, 2, 200*time.Millisecond)
+	if err != nil || wait <= 0 || wait > 100*time.Millisecond {
+		t.Fatalf("empty bucket: %v %v", wait, err)
+	}
+
+	time.Sleep(wait + 10*time.Millisecond)
+	if wait, _ := st.TakeToken(ctx, sub, 2, 2, 200*time.Millisecond); wait != 0 {
+		t.Fatalf("after refill: %v", wait)
+	}
+	other := store.RateSubject{Scope: "ip", ID: "02:00:00:00:00:01"}
+	if wait, _ := st.TakeToken(ctx, other, 2, 2, 200*time.Millisecond); wait != 0 {
+		t.Fatalf("scopes share a bucket: %v", wait)
+	}
+}
+
+func TestTakeTokensAllOrNothing(t *testing.T) {
+	st, _ := newStore(t)
+	ctx := context.Background()
+	mac := store.RateBucket{Subject: store.RateSubject{Scope: "mac", ID: "02:00:00:00:00:01"}, Rate: 2, Burst: 2, Window: time.Minute}
+	ip := store.RateBucket{Subject: store.RateSubject{Scope: "ip", ID: "10.0.0.1"}, Rate: 1, Burst: 1, Window: time.Minute}
+
+	if i, wait, err := st.TakeTokens(ctx, []store.RateBucket{mac, ip}); err != nil || i != -1 || wait != 0 {
+		t.Fatalf("first: %d %v %v", i, wait, err)
+	}
+	// the ip bucket is empty: the mac bucket keeps its last token
+	if i, wait, err := st.TakeTokens(ctx, []store.RateBucket{mac, ip}); err != nil || i != 1 || wait <= 0 {
+		t.Fatalf("ip empty: %d %v %v", i, wait, err)
+	}
+	if wait, _ := st.TakeToken(ctx, mac.Subject, mac.Rate, mac.Burst, mac.Window); wait != 0 {
+		t.Fatalf("mac token taken by a refused request: %v", wait)
+	}
+	if i, _, _ := st.TakeTokens(ctx, []store.RateBucket{mac}); i != 0 {
+		t.Fatalf("mac empty: %d", i)
+	}
+}
+
+func TestRecordFailureBackoff(t *testing.T) {
+	st, mr := newStore(t)
+	ctx := context.Background()
+	sub := store.RateSubject{Scope: "mac", ID: "02:00:00:00:00:01"}
+	fail := func() time.Duration {
+		d, err := st.RecordFailure(ctx, sub, 2, time.Minute, 10*time.Second, 25*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	// every second failure locks out: 10s, 20s, then capped at 25s
+	for _, want := range []time.Duration{10 * time.Second, 20 * time.Second, 25 * time.Second} {
+		if d := fail(); d != 0 {
+			t.Fatalf("first failure locked out: %v", d)
+		}
+		if d := fail(); d != want {
+			t.Fatalf("lockout = %v, want %v", d, want)
+		}
+	}
+	locks, err := st.LockedFor(ctx, []store.RateSubject{sub, {Scope: "ip", ID: "10.0.0.1"}})
+	if err != nil || locks[0] != 25*time.Second || locks[1] != 0 {
+		t.Fatalf("locked for %v %v", locks, err)
+	}
+
+	// a success resets the count, not the level; quiet time resets both
+	_ = fail()
+	if err := st.ClearFailures(ctx, []store.RateSubject{sub}); err != nil {
+		t.Fatal(err)
+	}
+	if d := fail(); d != 0 {
+		t.Fatalf("count survived ClearFailures: %v", d)
+	}
+	mr.FastForward(time.Hour)
+	_ = fail()
+	if d := fail(); d != 10*time.Second {
+		t.Fatalf("level did not decay: %v", d)
+	}
+}
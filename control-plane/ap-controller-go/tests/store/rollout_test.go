@@ -0,0 +1,105 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"ap-controller-go/internal/config"
+	"ap-controller-go/internal/store"
+)
+
+func TestCanarySelects(t *testing.T) {
+	c := store.Canary{APIDs: []string{"ap-01"}, Sites: []string{"hq"}}
+	for _, tc := range []struct {
+		site, apID string
+		want       bool
+	}{
+		{"branch", "ap-01", true},
+		{"hq", "ap-09", true},
+		{"branch", "ap-09", false},
+		{"", "", false},
+	} {
+		if got := c.Selects("ro_1", tc.site, tc.apID); got != tc.want {
+			t.Errorf("%s/%s: %v", tc.site, tc.apID, got)
+		}
+	}
+
+	pct := store.Canary{Percent: 30}
+	n := 0
+	for i := 0; i < 1000; i++ {
+		id := fmt.Sprintf("ap-%04d", i)
+		sel := pct.Selects("ro_1", "", id)
+		if sel != pct.Selects("ro_1", "", id) {
+			t.Fatalf("%s: not stable", id)
+		}
+		if sel {
+			n++
+		}
+	}
+	if n < 250 || n > 350 {
+		t.Fatalf("30%% selected %d of 1000", n)
+	}
+	if (store.Canary{Percent: 100}).Selects("ro_1", "", "") || !(store.Canary{Percent: 100}).Selects("ro_1", "", "ap-01") {
+		t.Fatal("100% must select every AP with an id")
+	}
+	if !(store.Canary{}).Empty() || (store.Canary{Percent: 5}).Empty() {
+		t.Fatal("Empty")
+	}
+}
+
+func TestSetRollout(t *testing.T) {
+	st, _ := newStore(t)
+	ctx := context.Background()
+
+	ro := store.Rollout{
+		ID:        "ro_1",
+		Stage:     store.RolloutCanary,
+		RoleRules: []config.RoleRule{},
+		Canary:    store.Canary{APIDs: []string{"ap-01"}},
+	}
+	if _, err := st.SetRollout(ctx, "", ro, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.SetRollout(ctx, "", store.Rollout{ID: "ro_2", Stage: store.RolloutCanary}, nil); !errors.Is(err, store.ErrRolloutChanged) {
+		t.Fatalf("second canary: %v", err)
+	}
+	if _, err := st.SetRollout(ctx, "ro_2", ro, nil); !errors.Is(err, store.ErrRolloutChanged) {
+		t.Fatalf("wrong id: %v", err)
+	}
+
+	_ = st.CountRolloutLogin(ctx, "ro_1", true, false)
+	_ = st.CountRolloutLogin(ctx, "ro_1", true, true)
+	_ = st.CountRolloutLogin(ctx, "ro_1", false, false)
+	if l, err := st.RolloutLoginCounts(ctx, "ro_1"); err != nil || l != (store.RolloutLogins{Canary: 2, CanaryErrors: 1, Baseline: 1}) {
+		t.Fatalf("counts: %+v %v", l, err)
+	}
+
+	next := ro
+	next.Stage = store.RolloutPromoted
+	p := &store.PromotedPolicy{Rollout: "ro_1", Base: "b", Bypass: config.Bypass{Enabled: true}}
+	ended, err := st.SetRollout(ctx, "ro_1", next, p)
+	if err != nil || ended.Logins == nil || ended.Logins.Canary != 2 {
+		t.Fatalf("promote: %+v %v", ended, err)
+	}
+	if l, _ := st.RolloutLoginCounts(ctx, "ro_1"); l != (store.RolloutLogins{}) {
+		t.Fatalf("counters kept: %+v", l)
+	}
+
+	gotP, gotR, err := st.PolicyRollout(ctx)
+	if err != nil || gotP == nil || gotP.Rollout != "ro_1" || !gotP.Bypass.Enabled {
+		t.Fatalf("promoted: %+v %v", gotP, err)
+	}
+	if gotR == nil || gotR.Stage != store.RolloutPromoted || gotR.RoleRules == nil || gotR.Logins.CanaryErrors != 1 {
+		t.Fatalf("rollout: %+v", gotR)
+	}
+
+	// a finished rollout neither blocks the next one nor can end again
+	if _, err := st.SetRollout(ctx, "ro_1", next, nil); !errors.Is(err, store.ErrRolloutChanged) {
+		t.Fatalf("end again: %v", err)
+	}
+	if _, err := st.SetRollout(ctx, "", store.Rollout{ID: "ro_2", Stage: store.RolloutCanary}, nil); err != nil {
+		t.Fatal(err)
+	}
+}
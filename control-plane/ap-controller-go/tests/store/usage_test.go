@@ -0,0 +1,55 @@
+package store_test
+
+import (
+	"testing"
+
+	"ap-controller-go/internal/store"
+)
+
+func TestUsageAccumulate_Deltas(t *testing.T) {
+	var u store.Usage
+
+	u.Accumulate("ap-1", store.Counters{BytesIn: 100, BytesOut: 50}, 1)
+	d := u.Accumulate("ap-1", store.Counters{BytesIn: 300, BytesOut: 60}, 2)
+
+	if d.BytesIn != 200 || d.BytesOut != 10 {
+		t.Fatalf("unexpected delta %+v", d)
+	}
+	if u.Total.Bytes() != 360 {
+		t.Fatalf("expected total 360, got %d", u.Total.Bytes())
+	}
+}
+
+func TestUsageAccumulate_CounterReset(t *testing.T) {
+	var u store.Usage
+
+	u.Accumulate("ap-1", store.Counters{BytesIn: 1000}, 1)
+	// AP rebooted: counters restart from zero
+	d := u.Accumulate("ap-1", store.Counters{BytesIn: 40}, 2)
+
+	if d.BytesIn != 40 || u.Total.BytesIn != 1040 {
+		t.Fatalf("reset not handled: delta=%+v total=%+v", d, u.Total)
+	}
+}
+
+func TestUsageAccumulate_Roaming(t *testing.T) {
+	var u store.Usage
+
+	u.Accumulate("ap-1", store.Counters{BytesIn: 1000}, 1)
+	d := u.Accumulate("ap-2", store.Counters{BytesIn: 2000}, 2)
+
+	if d.BytesIn != 2000 || u.Total.BytesIn != 3000 {
+		t.Fatalf("roaming not handled: delta=%+v total=%+v", d, u.Total)
+	}
+}
+
+func TestUsageAccumulate_IdleKeepsActive(t *testing.T) {
+	var u store.Usage
+
+	u.Accumulate("ap-1", store.Counters{BytesIn: 10, PacketsIn: 1}, 100)
+	u.Accumulate("ap-1", store.Counters{BytesIn: 10, PacketsIn: 1}, 200)
+
+	if u.Active != 100 || u.Updated != 200 {
+		t.Fatalf("expected active=100 updated=200, got %d/%d", u.Active, u.Updated)
+	}
+}
@@ -0,0 +1,64 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"ap-controller-go/internal/store"
+)
+
+// takeCodeHook claims code in redis right after the EXISTS check of a
+// batch, as a concurrent batch would.
+type takeCodeHook struct {
+	mr  *miniredis.Miniredis
+	key string
+}
+
+func (takeCodeHook) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (h takeCodeHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		err := next(ctx, cmd)
+		if cmd.Name() == "exists" {
+			_ = h.mr.Set(h.key, "other")
+		}
+		return err
+	}
+}
+
+func (takeCodeHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func TestCreateVoucherBatchAllOrNothing(t *testing.T) {
+	st, mr := newStore(t)
+	ctx := context.Background()
+
+	b := store.VoucherBatch{ID: "b1", Role: "guest", Created: 1}
+	if err := st.CreateVoucherBatch(ctx, b, []string{"v1", "v2"}, []string{"AAAA", "BBBB"}); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	b2 := store.VoucherBatch{ID: "b2", Role: "guest", Created: 2}
+	if err := st.CreateVoucherBatch(ctx, b2, []string{"v3", "v4"}, []string{"CCCC", "BBBB"}); !errors.Is(err, store.ErrVoucherCodeTaken) {
+		t.Fatalf("taken code: %v", err)
+	}
+
+	// a code taken between the check and the write fails the batch too
+	st.AddHook(takeCodeHook{mr: mr, key: "session:voucher:code:DDDD"})
+	b3 := store.VoucherBatch{ID: "b3", Role: "guest", Created: 3}
+	if err := st.CreateVoucherBatch(ctx, b3, []string{"v5"}, []string{"DDDD"}); !errors.Is(err, store.ErrVoucherCodeTaken) {
+		t.Fatalf("raced code: %v", err)
+	}
+	for _, k := range []string{"session:voucher:code:CCCC", "session:voucher:v3", "session:voucher:v5", "session:voucher:batch:b3"} {
+		if mr.Exists(k) {
+			t.Fatalf("%s written", k)
+		}
+	}
+	if v, _ := mr.Get("session:voucher:code:DDDD"); v != "other" {
+		t.Fatalf("raced code overwritten: %q", v)
+	}
+}
@@ -0,0 +1,59 @@
+package tracing_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"ap-controller-go/internal/tracing"
+)
+
+func TestMiddleware_ContinuesIncomingTrace(t *testing.T) {
+	shutdown, err := tracing.Init(context.Background(), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown(context.Background())
+
+	var gotTraceID string
+	r := chi.NewRouter()
+	r.Use(tracing.Middleware)
+	r.Get("/portal/status/{mac}", func(w http.ResponseWriter, r *http.Request) {
+		gotTraceID = tracing.TraceID(r.Context())
+	})
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest("GET", "/portal/status/aa:bb:cc:dd:ee:ff", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	if gotTraceID != traceID {
+		t.Fatalf("handler saw trace %q, want %q", gotTraceID, traceID)
+	}
+	if rr.Header().Get("X-Trace-Id") != traceID {
+		t.Fatalf("X-Trace-Id = %q", rr.Header().Get("X-Trace-Id"))
+	}
+}
+
+func TestMiddleware_StartsNewTrace(t *testing.T) {
+	shutdown, err := tracing.Init(context.Background(), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown(context.Background())
+
+	r := chi.NewRouter()
+	r.Use(tracing.Middleware)
+	r.Get("/", func(w http.ResponseWriter, r *http.Request) {})
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+	if len(rr.Header().Get("X-Trace-Id")) != 32 {
+		t.Fatalf("expected a new trace id, got %q", rr.Header().Get("X-Trace-Id"))
+	}
+}
@@ -0,0 +1,225 @@
+package transport_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"ap-controller-go/internal/config"
+	"ap-controller-go/internal/security"
+	"ap-controller-go/internal/store"
+	"ap-controller-go/internal/transport"
+	"ap-controller-go/tests/mqtttest"
+)
+
+func withKeySet(t *testing.T) {
+	t.Helper()
+	orig := security.PortalHMACProvider
+	security.PortalHMACProvider = func() *security.KeySet {
+		return &security.KeySet{CurrentKID: "k1", Keys: map[string][]byte{"k1": []byte("test-secret")}}
+	}
+	t.Cleanup(func() { security.PortalHMACProvider = orig })
+}
+
+func newBroker(t *testing.T) *mqtttest.Broker {
+	t.Helper()
+	b, err := mqtttest.NewBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(b.Close)
+	return b
+}
+
+func newStore(t *testing.T) *store.Store {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	port, _ := strconv.Atoi(mr.Port())
+	return store.New(&config.Config{Redis: config.Redis{Host: mr.Host(), Port: port, Prefix: "session:"}}, "")
+}
+
+func newTransport(t *testing.T, b *mqtttest.Broker, h transport.Handler) *transport.MQTT {
+	t.Helper()
+	m, err := transport.NewMQTT(transport.MQTTOptions{
+		Config:  config.MQTT{Broker: b.URL(), ClientID: "apc-test", TopicPrefix: "apc", QoS: 1},
+		Nonces:  newStore(t),
+		Handler: h,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(m.Close)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := m.WaitConnected(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// apClient connects a plain MQTT client, as an AP would.
+func apClient(t *testing.T, b *mqtttest.Broker) mqtt.Client {
+	t.Helper()
+	c := mqtt.NewClient(mqtt.NewClientOptions().AddBroker(b.URL()).SetClientID("ap-" + t.Name()))
+	if tok := c.Connect(); !tok.WaitTimeout(5*time.Second) || tok.Error() != nil {
+		t.Fatalf("connect: %v", tok.Error())
+	}
+	t.Cleanup(func() { c.Disconnect(50) })
+	return c
+}
+
+func TestSealOpen(t *testing.T) {
+	withKeySet(t)
+
+	raw, err := transport.Seal("ap-01", "apc/ap/ap-01/status", map[string]any{"ap_id": "ap-01"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, err := transport.Open("ap-01", "apc/ap/ap-01/status", raw)
+	if err != nil || string(env.Payload) != `{"ap_id":"ap-01"}` || env.KID != "k1" {
+		t.Fatalf("open: %v %+v", err, env)
+	}
+
+	// the signature binds the topic
+	if _, err := transport.Open("ap-01", "apc/ap/ap-02/status", raw); !errors.Is(err, security.ErrInvalidSign) {
+		t.Fatalf("other topic: %v", err)
+	}
+	// and the key: another AP's key or the fleet key don't sign for ap-01
+	forged, _ := transport.Seal("ap-02", "apc/ap/ap-01/status", map[string]any{"ap_id": "ap-01"})
+	if _, err := transport.Open("ap-01", "apc/ap/ap-01/status", forged); !errors.Is(err, security.ErrInvalidSign) {
+		t.Fatalf("other AP's key: %v", err)
+	}
+	forged, _ = transport.Seal("", "apc/ap/ap-01/status", map[string]any{"ap_id": "ap-01"})
+	if _, err := transport.Open("ap-01", "apc/ap/ap-01/status", forged); !errors.Is(err, security.ErrInvalidSign) {
+		t.Fatalf("fleet key: %v", err)
+	}
+
+	var tampered map[string]any
+	_ = json.Unmarshal(raw, &tampered)
+	tampered["payload"] = map[string]any{"ap_id": "ap-02"}
+	b, _ := json.Marshal(tampered)
+	if _, err := transport.Open("ap-01", "apc/ap/ap-01/status", b); !errors.Is(err, security.ErrInvalidSign) {
+		t.Fatalf("tampered: %v", err)
+	}
+	if _, err := transport.Open("ap-01", "apc/ap/ap-01/status", []byte("{}")); !errors.Is(err, transport.ErrBadEnvelope) {
+		t.Fatalf("empty: %v", err)
+	}
+}
+
+func TestMQTTSendTopics(t *testing.T) {
+	withKeySet(t)
+	b := newBroker(t)
+	m := newTransport(t, b, nil)
+	ctx := context.Background()
+
+	for _, msg := range []transport.Message{
+		{Target: transport.Target{APID: "ap-01"}, Kind: transport.KindSession, Body: map[string]any{"cmd": "authorize"}},
+		{Target: transport.Target{Site: "hq"}, Kind: transport.KindCert, Body: map[string]any{}},
+		{Kind: transport.KindPolicy, Retain: true, Body: map[string]any{"checksum": "abc"}},
+	} {
+		if err := m.Send(ctx, msg); err != nil {
+			t.Fatalf("send %s: %v", msg.Kind, err)
+		}
+	}
+
+	for _, want := range []struct{ topic, scope string }{
+		{"apc/ap/ap-01/session", "ap-01"}, {"apc/site/hq/cert", ""}, {"apc/all/policy", ""},
+	} {
+		select {
+		case got := <-b.Published:
+			if got.Topic != want.topic {
+				t.Fatalf("topic = %s, want %s", got.Topic, want.topic)
+			}
+			if _, err := transport.Open(want.scope, got.Topic, got.Payload); err != nil {
+				t.Fatalf("%s: %v", got.Topic, err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("no message on %s", want.topic)
+		}
+	}
+
+	// an AP subscribing later still gets the retained policy
+	got := make(chan mqtt.Message, 1)
+	apClient(t, b).Subscribe("apc/all/+", 1, func(_ mqtt.Client, msg mqtt.Message) { got <- msg })
+	select {
+	case msg := <-got:
+		env, err := transport.Open("", msg.Topic(), msg.Payload())
+		if err != nil || !msg.Retained() || string(env.Payload) != `{"checksum":"abc"}` {
+			t.Fatalf("retained: %v %v %s", err, msg.Retained(), msg.Payload())
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no retained policy")
+	}
+
+	if err := m.Send(ctx, transport.Message{Target: transport.Target{APID: "ap/+"}, Kind: transport.KindSession}); err == nil {
+		t.Fatal("wildcard ap_id accepted")
+	}
+}
+
+func TestMQTTInboundVerified(t *testing.T) {
+	withKeySet(t)
+	b := newBroker(t)
+	got := make(chan transport.Inbound, 8)
+	newTransport(t, b, func(_ context.Context, in transport.Inbound) error {
+		got <- in
+		return nil
+	})
+	ap := apClient(t, b)
+	time.Sleep(100 * time.Millisecond) // let the controller subscribe
+
+	pub := func(topic string, payload []byte) {
+		t.Helper()
+		if tok := ap.Publish(topic, 1, false, payload); !tok.WaitTimeout(2*time.Second) || tok.Error() != nil {
+			t.Fatalf("publish: %v", tok.Error())
+		}
+	}
+
+	signed, _ := transport.Seal("ap-01", "apc/ap/ap-01/status", map[string]any{"ap_id": "ap-01", "uptime": 42})
+	pub("apc/ap/ap-01/status", signed)
+	select {
+	case in := <-got:
+		if in.Kind != transport.KindStatus || in.APID != "ap-01" || string(in.Body) != `{"ap_id":"ap-01","uptime":42}` {
+			t.Fatalf("inbound = %+v", in)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("status not delivered")
+	}
+
+	// replay, unsigned, a message signed for another AP's topic and one
+	// signed by another AP for this topic
+	pub("apc/ap/ap-01/status", signed)
+	pub("apc/ap/ap-01/ack", []byte(`{"payload":{"ap_id":"ap-01"}}`))
+	pub("apc/ap/ap-02/status", signed)
+	forged, _ := transport.Seal("ap-02", "apc/ap/ap-01/status", map[string]any{"ap_id": "ap-01"})
+	pub("apc/ap/ap-01/status", forged)
+
+	select {
+	case in := <-got:
+		t.Fatalf("unexpected delivery: %+v", in)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func TestMQTTReplicasShareClientID(t *testing.T) {
+	withKeySet(t)
+	b := newBroker(t)
+	m1 := newTransport(t, b, nil)
+	m2 := newTransport(t, b, nil)
+	if m1.ClientID() == m2.ClientID() {
+		t.Fatalf("replicas use the same client id %q", m1.ClientID())
+	}
+
+	// neither replica is kicked off the broker by the other
+	time.Sleep(200 * time.Millisecond)
+	for _, m := range []*transport.MQTT{m1, m2} {
+		if err := m.Send(context.Background(), transport.Message{Kind: transport.KindPolicy, Body: map[string]any{}}); err != nil {
+			t.Fatalf("%s: %v", m.ClientID(), err)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package transport_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"ap-controller-go/internal/transport"
+)
+
+// slowTransport records messages and blocks each send until released.
+type slowTransport struct {
+	release chan struct{}
+	mu      sync.Mutex
+	sent    []transport.Message
+}
+
+func (s *slowTransport) Send(ctx context.Context, msg transport.Message) error {
+	select {
+	case <-s.release:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	s.mu.Lock()
+	s.sent = append(s.sent, msg)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *slowTransport) Close() {}
+
+func TestQueueDoesNotBlock(t *testing.T) {
+	st := &slowTransport{release: make(chan struct{})}
+	q := transport.NewQueue(st, 2, time.Second)
+
+	start := time.Now()
+	// one in flight, two buffered, the fourth is dropped
+	var errs []error
+	for i := 0; i < 4; i++ {
+		errs = append(errs, q.Enqueue(transport.Message{Kind: transport.KindSession}))
+		time.Sleep(10 * time.Millisecond)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Fatal("enqueue waited for the transport")
+	}
+	if errs[0] != nil || errs[1] != nil || errs[2] != nil || !errors.Is(errs[3], transport.ErrQueueFull) {
+		t.Fatalf("enqueue errors = %v", errs)
+	}
+
+	close(st.release)
+	q.Close()
+	if len(st.sent) != 3 {
+		t.Fatalf("sent %d messages after drain", len(st.sent))
+	}
+	if err := q.Enqueue(transport.Message{}); !errors.Is(err, transport.ErrQueueClosed) {
+		t.Fatalf("enqueue after close: %v", err)
+	}
+}
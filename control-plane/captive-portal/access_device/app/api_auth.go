@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"strings"
 	"time"
+	"tracing"
 
 	"github.com/redis/go-redis/v9"
 )
@@ -163,8 +164,8 @@ func (p *PortalServer) PortalAuthHandler(w http.ResponseWriter, r *http.Request)
 	}
 
 	log.Printf(
-		"[PORTAL_AUTH] request user=%s ip=%s auth_type=%s nas_id=%s",
-		username, clientIP, req.AuthType, req.NASID,
+		"[PORTAL_AUTH] request user=%s ip=%s auth_type=%s nas_id=%s trace_id=%s",
+		username, clientIP, req.AuthType, req.NASID, tracing.TraceID(ctx),
 	)
 
 	// 初始 hint
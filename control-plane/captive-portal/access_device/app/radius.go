@@ -7,6 +7,10 @@ import (
 	"log"
 	"time"
 
+	"tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"layeh.com/radius"
 	"layeh.com/radius/rfc2865"
 	"layeh.com/radius/rfc2866"
@@ -25,7 +29,14 @@ const (
 // - sessionTimeout: 秒，0=无
 type RadiusPAPAuth struct{}
 
-func (a *RadiusPAPAuth) RadiusPAP(ctx context.Context, username, password string) (bool, map[string]string, int64, error) {
+func (a *RadiusPAPAuth) RadiusPAP(ctx context.Context, username, password string) (ok bool, _ map[string]string, _ int64, err error) {
+	ctx, span := tracing.Start(ctx, "RadiusPAP", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("radius.server", radiusAuthServer)))
+	defer func() {
+		span.SetAttributes(attribute.Bool("radius.accept", ok))
+		tracing.EndWithError(span, err)
+	}()
+
 	secret := []byte(radiusSharedSecret)
 	packet := radius.New(radius.CodeAccessRequest, secret)
 
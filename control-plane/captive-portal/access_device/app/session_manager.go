@@ -7,7 +7,11 @@ import (
 	"log"
 	"time"
 
+	"tracing"
+
 	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // ============================
@@ -57,7 +61,11 @@ func sessionIPKey(ip string) string {
 // ============================
 
 // Save 保存 Session 到 Redis
-func (sm *SessionManager) Save(ctx context.Context, s *Session) error {
+func (sm *SessionManager) Save(ctx context.Context, s *Session) (err error) {
+	ctx, span := tracing.Start(ctx, "SessionManager.Save",
+		trace.WithAttributes(attribute.String("session.policy", s.Policy)))
+	defer func() { tracing.EndWithError(span, err) }()
+
 	if sm.rdb == nil {
 		return fmt.Errorf("redis client is nil")
 	}
@@ -5,6 +5,10 @@ import (
 	"errors"
 	"log"
 	"net/http"
+
+	"tracing"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // Factory 是认证裁决核心
@@ -31,7 +35,9 @@ func (f *Factory) SelectAndBuild(
 	ctx context.Context,
 	r *http.Request,
 	hint *Result,
-) (Strategy, *Policy, error) {
+) (_ Strategy, _ *Policy, err error) {
+	ctx, span := tracing.Start(ctx, "Factory.SelectAndBuild")
+	defer func() { tracing.EndWithError(span, err) }()
 
 	// ================================
 	// 1️⃣ Resolve Policy（YAML + Redis + RADIUS hint）
@@ -138,6 +144,10 @@ func (f *Factory) SelectAndBuild(
 		return nil, pol, err
 	}
 
+	span.SetAttributes(
+		attribute.String("auth.strategy", chosen),
+		attribute.String("auth.policy", pol.Name),
+	)
 	return st, pol, nil
 }
 
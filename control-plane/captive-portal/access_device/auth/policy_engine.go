@@ -4,6 +4,10 @@ import (
 	"context"
 	"fmt"
 	"log"
+
+	"tracing"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // PolicyEngine 负责综合决策用户策略
@@ -28,7 +32,17 @@ func (policyEngine *PolicyEngine) Resolve(
 	ctx context.Context,
 	username string,
 	replyAttrs map[string]string,
-) (*Policy, error) {
+) (pol *Policy, err error) {
+	ctx, span := tracing.Start(ctx, "PolicyEngine.Resolve")
+	defer func() {
+		if pol != nil {
+			span.SetAttributes(
+				attribute.String("auth.policy", pol.Name),
+				attribute.String("auth.policy_source", string(pol.Source)),
+			)
+		}
+		tracing.EndWithError(span, err)
+	}()
 
 	// 1. Redis Override（最高优先级）
 	if policyEngine.override != nil {
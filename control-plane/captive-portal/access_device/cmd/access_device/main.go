@@ -1,11 +1,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 
 	"access_device/app"
+	"tracing"
 
 	"github.com/redis/go-redis/v9"
 
@@ -26,6 +28,11 @@ func main() {
 	// ★ 日志全局配置：文件名 + 行号
 	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds | log.Lshortfile)
 
+	shutdownTracing, err := tracing.Init(context.Background(), "access_device")
+	if err != nil {
+		log.Fatalf("[BOOT][FATAL] init tracing failed: %v", err)
+	}
+
 	rdb := redis.NewClient(&redis.Options{
 		Addr: redisServerAddress,
 		DB:   0,
@@ -33,10 +40,12 @@ func main() {
 
 	ps := app.BuildPortalServer(rdb)
 
-	http.HandleFunc("/portal_auth", ps.PortalAuthHandler)
+	http.HandleFunc("/portal_auth", tracing.Handler("/portal_auth", ps.PortalAuthHandler))
 
 	log.Printf("接入设备 (NAS) 模拟器运行在 :%d...\n", nasServerPort)
 	log.Printf("认证重定向功能已开启 -> 目标: http://%s:%d/", portalServerAddress, portalServerPort)
 
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", nasServerPort), nil))
+	if err := tracing.ListenAndServe(&http.Server{Addr: fmt.Sprintf(":%d", nasServerPort)}, shutdownTracing); err != nil {
+		log.Fatal(err)
+	}
 }
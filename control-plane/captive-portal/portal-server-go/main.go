@@ -2,13 +2,19 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"log"
 	"net/http"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
 	"portal-server-go/web"
 	"portal-server-go/web/api"
 	"portal-server-go/web/i18n"
+	"tracing"
 )
 
 const (
@@ -19,12 +25,17 @@ const (
 func main() {
 	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds | log.Lshortfile)
 
+	shutdownTracing, err := tracing.Init(context.Background(), "portal-server")
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	renderer, err := web.NewRenderer("web/templates")
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc("/", tracing.Handler("/", func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
 			// 展示登录页
@@ -42,10 +53,12 @@ func main() {
 		default:
 			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
 		}
-	})
+	}))
 
 	log.Printf("[PORTAL] UI listening on %s", serverAddr)
-	log.Fatal(http.ListenAndServe(serverAddr, nil))
+	if err := tracing.ListenAndServe(&http.Server{Addr: serverAddr}, shutdownTracing); err != nil {
+		log.Fatal(err)
+	}
 }
 
 // ========================
@@ -116,19 +129,30 @@ func handleLogin(w http.ResponseWriter, r *http.Request, renderer *web.Renderer)
 	}
 
 	log.Printf(
-		"[PORTAL_UI] POST /portal_auth -> %s payload=%s",
+		"[PORTAL_UI] POST /portal_auth -> %s payload=%s trace_id=%s",
 		accessDeviceURL,
 		string(body),
+		tracing.TraceID(r.Context()),
 	)
 
 	// ============================
 	// 3️⃣ Call AccessDevice (NAC)
 	// ============================
-	resp, err := http.Post(
-		accessDeviceURL,
-		"application/json",
-		bytes.NewReader(body),
-	)
+	ctx, span := tracing.Start(r.Context(), "POST /portal_auth",
+		trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
+	authReq, err := http.NewRequestWithContext(ctx, http.MethodPost, accessDeviceURL, bytes.NewReader(body))
+	if err == nil {
+		authReq.Header.Set("Content-Type", "application/json")
+		// W3C traceparent: continue this trace in access_device
+		tracing.Inject(authReq)
+	}
+
+	var resp *http.Response
+	if err == nil {
+		resp, err = http.DefaultClient.Do(authReq)
+	}
 
 	if err != nil {
 		log.Printf(
@@ -136,6 +160,8 @@ func handleLogin(w http.ResponseWriter, r *http.Request, renderer *web.Renderer)
 			username,
 			err,
 		)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 
 		renderer.RenderLogin(w, web.LoginData{
 			BasePage: web.BasePage{
@@ -148,6 +174,7 @@ func handleLogin(w http.ResponseWriter, r *http.Request, renderer *web.Renderer)
 	}
 	defer resp.Body.Close()
 
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
 	log.Printf(
 		"[PORTAL_UI] access device response status=%d",
 		resp.StatusCode,
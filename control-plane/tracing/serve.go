@@ -0,0 +1,43 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// ListenAndServe runs srv until SIGINT / SIGTERM, then drains in-flight
+// requests and flushes pending spans through shutdown (the function
+// returned by Init). Unlike log.Fatal(http.ListenAndServe(...)), spans
+// of the last requests are not lost on exit.
+func ListenAndServe(srv *http.Server, shutdown func(context.Context) error) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	errc := make(chan error, 1)
+	go func() { errc <- srv.ListenAndServe() }()
+
+	var err error
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+		log.Printf("shutting down %s", srv.Addr)
+		sctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err = srv.Shutdown(sctx)
+		cancel()
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+
+	fctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if ferr := shutdown(fctx); err == nil {
+		err = ferr
+	}
+	return err
+}
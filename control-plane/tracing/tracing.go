@@ -0,0 +1,125 @@
+// Package tracing is the OpenTelemetry setup shared by ap-controller,
+// portal-server and access_device.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Exporter selection (environment):
+//
+//	OTEL_TRACES_EXPORTER = none (default) | otlp | stdout | file
+//	OTEL_EXPORTER_OTLP_ENDPOINT / OTEL_EXPORTER_OTLP_TRACES_ENDPOINT (otlp, standard)
+//	OTEL_TRACES_FILE     = path for the file exporter (JSON lines)
+//	OTEL_SERVICE_NAME    = overrides the service name passed to Init
+const (
+	envExporter = "OTEL_TRACES_EXPORTER"
+	envFile     = "OTEL_TRACES_FILE"
+	envService  = "OTEL_SERVICE_NAME"
+)
+
+// Init installs the global tracer provider and the W3C trace-context
+// propagator. With no exporter configured spans are still created (so
+// traceparent is forwarded) but never exported.
+// The returned shutdown flushes pending spans.
+func Init(ctx context.Context, service string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	scope = service
+	if v := os.Getenv(envService); v != "" {
+		service = v
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(service),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []sdktrace.TracerProviderOption{sdktrace.WithResource(res)}
+	var closer io.Closer
+
+	switch kind := strings.ToLower(os.Getenv(envExporter)); kind {
+	case "", "none":
+	case "otlp":
+		exp, err := otlptracehttp.New(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("otlp exporter: %w", err)
+		}
+		opts = append(opts, sdktrace.WithBatcher(exp))
+	case "stdout":
+		exp, err := stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, sdktrace.WithSyncer(exp))
+	case "file":
+		path := os.Getenv(envFile)
+		if path == "" {
+			return nil, fmt.Errorf("%s=file requires %s", envExporter, envFile)
+		}
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		exp, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		opts = append(opts, sdktrace.WithBatcher(exp))
+		closer = f
+	default:
+		return nil, fmt.Errorf("unknown %s %q", envExporter, kind)
+	}
+
+	tp := sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(tp)
+
+	return func(ctx context.Context) error {
+		err := tp.Shutdown(ctx)
+		if closer != nil {
+			closer.Close()
+		}
+		return err
+	}, nil
+}
+
+// scope names the tracer of this process: the service passed to Init.
+var scope = "tracing"
+
+// Tracer returns the tracer of this process.
+func Tracer() trace.Tracer {
+	return otel.Tracer(scope)
+}
+
+// Start begins a span on the tracer of this process.
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, opts...)
+}
+
+// TraceID returns the current trace id or "" (for log / audit correlation).
+func TraceID(ctx context.Context) string {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.HasTraceID() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
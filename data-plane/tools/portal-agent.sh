@@ -6,6 +6,7 @@
 # - Fetches policy/runtime from ap-controller (control-plane, VM)
 # - Generates /tmp/portal-runtime.env (atomic)
 # - Optionally triggers portal-fw.sh to apply dataplane rules
+# - Reports to the controller AP inventory (needs an mTLS client cert)
 #
 # Requirements (ImmortalWrt): curl, jsonfilter, logger
 # =========================================================
@@ -28,8 +29,17 @@ AP_ID="${AP_ID:-$(cat /proc/sys/kernel/hostname 2>/dev/null || echo ap-unknown)}
 SITE_ID="${SITE_ID:-default}"
 RADIO_ID="${RADIO_ID:-radio0}"
 
-# Where to write runtime env
+# mTLS client certificate issued by the controller enrollment CA; the
+# runtime policy is an AP route and needs it (or an AP HMAC proxy).
+# Inventory heartbeats are skipped when unset.
+CTRL_CERT="${CTRL_CERT:-}"
+CTRL_KEY="${CTRL_KEY:-}"
+CTRL_CA="${CTRL_CA:-}"
+
+# Where to write runtime env; the bypass lists and the denylist stay
+# JSON in RUNTIME_JSON and never pass through shell
 RUNTIME_ENV="${RUNTIME_ENV:-/tmp/portal-runtime.env}"
+RUNTIME_JSON="${RUNTIME_JSON:-/tmp/portal-runtime.json}"
 
 # If set to 1, apply dataplane right after refreshing runtime
 APPLY_FW="${APPLY_FW:-1}"
@@ -75,10 +85,80 @@ write_env_atomic() {
   mv -f "$tmp" "$RUNTIME_ENV"
 }
 
-# Best-effort fetch helper (returns body on stdout, non-zero on error)
+# Write the runtime JSON atomically
+write_json_atomic() {
+  tmp="${RUNTIME_JSON}.tmp.$$"
+  umask 077
+  cat >"$tmp"
+  mv -f "$tmp" "$RUNTIME_JSON"
+}
+
+# Quote a value for a shell assignment: 'it'\''s'
+sh_quote() {
+  printf "'%s'" "$(printf '%s' "$1" | sed "s/'/'\\\\''/g")"
+}
+
+# Best-effort fetch helper (returns body on stdout, non-zero on error);
+# the runtime policy is an AP route, so the client certificate is sent
+# when configured
 http_get() {
   url="$1"
-  curl -fsS --max-time 3 "$url"
+  set -- -fsS --max-time 3
+  if [ -n "$CTRL_CERT" ] && [ -n "$CTRL_KEY" ]; then
+    set -- "$@" --cert "$CTRL_CERT" --key "$CTRL_KEY"
+    [ -n "$CTRL_CA" ] && set -- "$@" --cacert "$CTRL_CA"
+  fi
+  curl "$@" "$url"
+}
+
+# POST JSON with the client certificate; prints "<http_code>"
+http_post_mtls() {
+  url="$1"
+  body="$2"
+  set -- -sS -o /dev/null -w '%{http_code}' --max-time 3 \
+    --cert "$CTRL_CERT" --key "$CTRL_KEY" \
+    -H 'Content-Type: application/json' -d "$body"
+  [ -n "$CTRL_CA" ] && set -- "$@" --cacert "$CTRL_CA"
+  curl "$@" "$url" 2>/dev/null || echo 000
+}
+
+# Escape a value for a JSON string: drop control characters, escape
+# backslash and double quote
+json_esc() {
+  printf '%s' "$1" | tr -d '\000-\037' | sed 's/\\/\\\\/g; s/"/\\"/g'
+}
+
+# Heartbeat to the controller inventory; registers on first contact
+# (or after the controller forgot this AP).
+report_inventory() {
+  checksum="$1"
+  [ -n "$CTRL_CERT" ] && [ -n "$CTRL_KEY" ] || {
+    log "event=inventory_skip reason=no_client_cert"
+    return 0
+  }
+
+  uptime_s="$(cut -d. -f1 /proc/uptime 2>/dev/null || echo 0)"
+  fw="$(. /etc/openwrt_release 2>/dev/null && echo "${DISTRIB_RELEASE:-}")"
+  case "$uptime_s" in ''|*[!0-9]*) uptime_s=0 ;; esac
+  host="$(cat /proc/sys/kernel/hostname 2>/dev/null || true)"
+  body="{\"ap_id\":\"$(json_esc "$AP_ID")\",\"site\":\"$(json_esc "$SITE_ID")\",\"hostname\":\"$(json_esc "$host")\",\"firmware\":\"$(json_esc "$fw")\",\"lan_if\":\"$(json_esc "$LAN_IF")\",\"uptime\":${uptime_s},\"policy_checksum\":\"$(json_esc "$checksum")\"}"
+
+  code="$(http_post_mtls "${CTRL_BASE}/api/v1/ap/heartbeat" "$body")"
+  if [ "$code" = "404" ]; then
+    code="$(http_post_mtls "${CTRL_BASE}/api/v1/ap/register" "$body")"
+  fi
+  log "event=inventory_report http_code=${code}"
+}
+
+# Acknowledge the policy outcome: ack_policy applied|failed [error]
+ack_policy() {
+  status="$1"
+  err="${2:-}"
+  [ -n "$CTRL_CERT" ] && [ -n "$CTRL_KEY" ] && [ -n "$POLICY_CHECKSUM" ] || return 0
+
+  body="{\"ap_id\":\"$(json_esc "$AP_ID")\",\"checksum\":\"$(json_esc "$POLICY_CHECKSUM")\",\"version\":\"$(json_esc "$POLICY_VERSION_STR")\",\"status\":\"$(json_esc "$status")\",\"error\":\"$(json_esc "$err")\"}"
+  code="$(http_post_mtls "${CTRL_BASE}/api/v1/ap/policy/ack" "$body")"
+  log "event=policy_ack status=${status} checksum=${POLICY_CHECKSUM} http_code=${code}"
 }
 
 log "event=runtime_fetch_start ctrl=${CTRL_BASE} ap_id=${AP_ID} site=${SITE_ID} radio=${RADIO_ID}"
@@ -144,40 +224,41 @@ IPSET_STAFF="$(printf '%s' "$RESP" | jsonfilter -e '@.dataplane.ipsets.allow.sta
 [ -n "$IPSET_GUEST" ] || IPSET_GUEST="portal_allow_guest"
 [ -n "$IPSET_STAFF" ] || IPSET_STAFF="portal_allow_staff"
 
-# bypass lists
-BYPASS_MACS="$(printf '%s' "$RESP" | jsonfilter -e '@.bypass.mac_whitelist' 2>/dev/null || true)"
-BYPASS_IPS="$(printf '%s' "$RESP" | jsonfilter -e '@.bypass.ip_whitelist' 2>/dev/null || true)"
-BYPASS_DOMAINS="$(printf '%s' "$RESP" | jsonfilter -e '@.bypass.domains' 2>/dev/null || true)"
 BYPASS_ENABLED="$(printf '%s' "$RESP" | jsonfilter -e '@.bypass.enabled' 2>/dev/null || true)"
-[ -n "$BYPASS_ENABLED" ] || BYPASS_ENABLED="true"
+[ "$BYPASS_ENABLED" = "false" ] || BYPASS_ENABLED="true"
+
+# bypass lists and denylist (array of {pattern, kind: mac|oui, expires})
+# are read by portal-fw.sh from the runtime JSON with jq
+printf '%s' "$RESP" | write_json_atomic
 
-# Persist runtime env (shell-friendly exports)
+# Persist runtime env (shell-friendly exports, every value quoted)
 write_env_atomic <<EOF
 # Auto-generated by portal-agent.sh at $(date -Iseconds)
-export CTRL_BASE='${CTRL_BASE}'
-export POLICY_VERSION='${POLICY_VERSION}'
-export AP_ID='${AP_ID}'
-export SITE_ID='${SITE_ID}'
-export RADIO_ID='${RADIO_ID}'
+export CTRL_BASE=$(sh_quote "$CTRL_BASE")
+export POLICY_VERSION=$(sh_quote "$POLICY_VERSION")
+export AP_ID=$(sh_quote "$AP_ID")
+export SITE_ID=$(sh_quote "$SITE_ID")
+export RADIO_ID=$(sh_quote "$RADIO_ID")
 
 # Dataplane
-export LAN_IF='${LAN_IF}'
-export PORTAL_IP='${PORTAL_IP}'
-export DNS_PORT='${DNS_PORT}'
+export LAN_IF=$(sh_quote "$LAN_IF")
+export PORTAL_IP=$(sh_quote "$PORTAL_IP")
+export DNS_PORT=$(sh_quote "$DNS_PORT")
 
 # ipsets (roles -> allow sets)
-export IPSET_GUEST='${IPSET_GUEST}'
-export IPSET_STAFF='${IPSET_STAFF}'
-
-# bypass (raw JSON arrays as strings; scripts may parse further)
-export BYPASS_ENABLED='${BYPASS_ENABLED}'
-export BYPASS_MACS='${BYPASS_MACS}'
-export BYPASS_IPS='${BYPASS_IPS}'
-export BYPASS_DOMAINS='${BYPASS_DOMAINS}'
+export IPSET_GUEST=$(sh_quote "$IPSET_GUEST")
+export IPSET_STAFF=$(sh_quote "$IPSET_STAFF")
+
+# bypass switch; the lists live in RUNTIME_JSON
+export BYPASS_ENABLED=$(sh_quote "$BYPASS_ENABLED")
+export RUNTIME_JSON=$(sh_quote "$RUNTIME_JSON")
 EOF
 
 log "event=runtime_fetch_done policy_version=${POLICY_VERSION} lan_if=${LAN_IF} portal_ip=${PORTAL_IP} ipset_guest=${IPSET_GUEST} ipset_staff=${IPSET_STAFF}"
 
+POLICY_CHECKSUM="$(printf '%s' "$RESP" | jsonfilter -e '@.controller_version.checksum' 2>/dev/null || true)"
+POLICY_VERSION_STR="$(printf '%s' "$RESP" | jsonfilter -e '@.controller_version.version' 2>/dev/null || true)"
+
 # ---------------------------
 # Apply dataplane rules
 # ---------------------------
@@ -191,9 +272,17 @@ if [ "$APPLY_FW" = "1" ] && [ -x "$PORTAL_FW" ]; then
   if "$PORTAL_FW"; then
     log "event=apply_fw_done"
   else
+    rc=$?
     log "event=apply_fw_failed"
+    # still heartbeat (without claiming the new checksum), then report why
+    report_inventory ""
+    ack_policy failed "portal-fw.sh exited ${rc}"
     exit 2
   fi
 fi
 
+# report only after the runtime was applied
+report_inventory "$POLICY_CHECKSUM"
+ack_policy applied
+
 exit 0
\ No newline at end of file
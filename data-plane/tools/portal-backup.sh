@@ -4,7 +4,8 @@
 #
 # Runs on ImmortalWRT (data-plane).
 # - Backs up iptables (nat/filter) and ipset state
-# - Also backs up portal runtime env (/tmp/portal-runtime.env) if present
+# - Also backs up portal runtime env (/tmp/portal-runtime.env) and
+#   runtime JSON (/tmp/portal-runtime.json) if present
 #
 # Logs:
 #   logread -e portal-backup
@@ -15,6 +16,7 @@ set -eu
 BACKUP_DIR="${BACKUP_DIR:-/tmp/portal-backup}"
 TAG="${TAG:-portal-backup}"
 RUNTIME_ENV="${RUNTIME_ENV:-/tmp/portal-runtime.env}"
+RUNTIME_JSON="${RUNTIME_JSON:-/tmp/portal-runtime.json}"
 
 log() { logger -t "$TAG" "$*"; }
 
@@ -36,6 +38,9 @@ ipset save > "${BACKUP_DIR}/ipset.save" 2>/dev/null || true
 if [ -f "$RUNTIME_ENV" ]; then
   cp -f "$RUNTIME_ENV" "${BACKUP_DIR}/portal-runtime.env" 2>/dev/null || true
 fi
+if [ -f "$RUNTIME_JSON" ]; then
+  cp -f "$RUNTIME_JSON" "${BACKUP_DIR}/portal-runtime.json" 2>/dev/null || true
+fi
 
 # Meta info
 HOSTNAME="$(cat /proc/sys/kernel/hostname 2>/dev/null || echo unknown)"
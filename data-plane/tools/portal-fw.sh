@@ -4,7 +4,8 @@
 # ImmortalWRT Captive Portal data-plane init script
 #
 # Runs on ImmortalWRT (data-plane).
-# - Loads /tmp/portal-runtime.env (generated by portal-agent.sh)
+# - Loads /tmp/portal-runtime.env and the bypass/deny lists from
+#   /tmp/portal-runtime.json (both generated by portal-agent.sh)
 # - Creates ipsets for allowed MACs (per role/profile)
 # - Installs iptables rules:
 #     * DNS hijack (PREROUTING/nat) for unauthorized clients
@@ -19,6 +20,7 @@
 set -eu
 
 RUNTIME_ENV="${RUNTIME_ENV:-/tmp/portal-runtime.env}"
+RUNTIME_JSON="${RUNTIME_JSON:-/tmp/portal-runtime.json}"
 LOG_TAG="${LOG_TAG:-portal-fw}"
 
 # Defaults (used if runtime env is missing/partial)
@@ -33,11 +35,13 @@ IPSET_STAFF="${IPSET_STAFF:-portal_allow_staff}"
 IPSET_BYPASS_MAC="${IPSET_BYPASS_MAC:-portal_bypass_mac}"
 IPSET_BYPASS_IP="${IPSET_BYPASS_IP:-portal_bypass_ip}"
 IPSET_BYPASS_DNS="${IPSET_BYPASS_DNS:-portal_bypass_dns}"
+IPSET_DENY_MAC="${IPSET_DENY_MAC:-portal_deny_mac}"
 
 # chains
 CHAIN_DNS="${CHAIN_DNS:-PORTAL_DNS}"
 CHAIN_FWD="${CHAIN_FWD:-PORTAL_FWD}"
 CHAIN_HTTP="${CHAIN_HTTP:-PORTAL_HTTP}"
+CHAIN_DENY_OUI="${CHAIN_DENY_OUI:-PORTAL_DENY_OUI}"
 
 log() { logger -t "$LOG_TAG" "$*"; }
 
@@ -57,6 +61,19 @@ IPSET_STAFF="${IPSET_STAFF:-portal_allow_staff}"
 IPSET_BYPASS_MAC="${IPSET_BYPASS_MAC:-portal_bypass_mac}"
 IPSET_BYPASS_IP="${IPSET_BYPASS_IP:-portal_bypass_ip}"
 IPSET_BYPASS_DNS="${IPSET_BYPASS_DNS:-portal_bypass_dns}"
+IPSET_DENY_MAC="${IPSET_DENY_MAC:-portal_deny_mac}"
+
+# runtime_list <jq path>: a JSON array from the runtime JSON ("[]" if absent)
+runtime_list() {
+  [ -f "$RUNTIME_JSON" ] || { echo '[]'; return 0; }
+  jq -c "$1 // []" "$RUNTIME_JSON" 2>/dev/null || echo '[]'
+}
+
+# Lists are data, not shell: they are never sourced
+BYPASS_MACS="$(runtime_list .bypass.mac_whitelist)"
+BYPASS_IPS="$(runtime_list .bypass.ip_whitelist)"
+BYPASS_DOMAINS="$(runtime_list .bypass.domains)"
+DENYLIST="$(runtime_list .denylist)"
 
 log "event=init_start lan_if=${LAN_IF} portal_ip=${PORTAL_IP} dns_port=${DNS_PORT}"
 
@@ -168,7 +185,9 @@ assert_ipset_hash_ip() {
 # - trims leading/trailing whitespace
 # - strips leading "*." (glob-style wildcard)
 # - strips leading "." (common misconfiguration)
-# - returns empty string for invalid input
+# - returns empty string for anything but a plain hostname (labels of
+#   [a-z0-9-], 1-63 chars, no leading/trailing hyphen), since the result
+#   is written into the dnsmasq config
 #
 # Examples:
 #   "*.microsoft.com" -> "microsoft.com"
@@ -182,16 +201,18 @@ normalize_domain() {
   d="$(echo "$d" | sed 's/^[[:space:]]*//;s/[[:space:]]*$//')"
 
   # strip leading "*."
-  d="${d#*.}"
+  d="${d#\*.}"
 
   # strip leading "."
   d="${d#.}"
 
-  # basic sanity check (must contain at least one dot)
-  case "$d" in
-    *.*) echo "$d" ;;
-    *)   echo "" ;;
-  esac
+  # strict hostname check (at least two labels)
+  if printf '%s\n' "$d" | grep -Eqx '([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?' \
+    && [ "$(printf '%s\n' "$d" | wc -l)" -eq 1 ]; then
+    echo "$d"
+  else
+    echo ""
+  fi
 }
 
 # ---------------------------------------------------------
@@ -342,10 +363,19 @@ add_bypass_ip() {
   log "event=bypass_ctrl_ip ip=${ip}"
 }
 
+add_deny_mac() {
+  mac="$1"
+  ipset -exist add "$IPSET_DENY_MAC" "$mac" timeout 0 || true
+  log "event=deny_ctrl_mac mac=${mac}"
+}
+
 add_bypass_domain() {
   raw="$1"
   domain="$(normalize_domain "$raw")"
-  [ -n "$domain" ] || return 0
+  if [ -z "$domain" ]; then
+    log "level=warn event=bypass_domain_invalid"
+    return 0
+  fi
 
   echo "ipset=/${domain}/${IPSET_BYPASS_DNS}" >> "$DNSMASQ_IPSET_CONF"
   log "event=bypass_ctrl_domain domain=${domain}"
@@ -362,6 +392,8 @@ ensure_ipset_ip  "$IPSET_BYPASS_IP"
 assert_ipset_hash_ip "$IPSET_BYPASS_IP"
 ensure_ipset_ip  "$IPSET_BYPASS_DNS"   # Domains are ultimately resolved to IPs
 assert_ipset_hash_ip "$IPSET_BYPASS_DNS"
+ensure_ipset_mac "$IPSET_DENY_MAC"
+assert_ipset_hash_mac "$IPSET_DENY_MAC"
 
 # ---------------------------------------------------------
 # 1.1) Bypass MAC reconcile
@@ -441,6 +473,40 @@ fi
 COUNT_AFTER="$(ipset_count "$IPSET_BYPASS_DNS")"
 log "event=bypass_dns_apply_done count_after=${COUNT_AFTER}"
 
+# ---------------------------------------------------------
+# 1.4) Denylist reconcile
+# ---------------------------------------------------------
+# MAC entries go to a hash:mac ipset. OUI entries ("aa:bb:cc") need a
+# masked match, which only ebtables has: they are dropped at the bridge.
+DENY_MACS="$(printf '%s' "${DENYLIST:-[]}" | jq -c '[.[]? | select(.kind=="mac") | .pattern]' 2>/dev/null || true)"
+DENY_OUIS="$(printf '%s' "${DENYLIST:-[]}" | jq -r '.[]? | select(.kind=="oui") | .pattern' 2>/dev/null || true)"
+
+reconcile_ipset \
+  "$IPSET_DENY_MAC" \
+  "deny_mac" \
+  "$DENY_MACS" \
+  add_deny_mac
+
+if command -v ebtables >/dev/null 2>&1; then
+  if ! ebtables -L "$CHAIN_DENY_OUI" >/dev/null 2>&1; then
+    ebtables -N "$CHAIN_DENY_OUI"
+    log "event=chain_created table=ebtables chain=${CHAIN_DENY_OUI}"
+  fi
+  if ! ebtables -L FORWARD | grep -q -- "-j ${CHAIN_DENY_OUI}"; then
+    ebtables -I FORWARD 1 -j "$CHAIN_DENY_OUI"
+  fi
+  if ! ebtables -L INPUT | grep -q -- "-j ${CHAIN_DENY_OUI}"; then
+    ebtables -I INPUT 1 -j "$CHAIN_DENY_OUI"
+  fi
+  ebtables -F "$CHAIN_DENY_OUI"
+  for oui in $DENY_OUIS; do
+    ebtables -A "$CHAIN_DENY_OUI" -s "${oui}:00:00:00/ff:ff:ff:00:00:00" -j DROP
+    log "event=deny_ctrl_oui oui=${oui}"
+  done
+elif [ -n "$DENY_OUIS" ]; then
+  log "level=error event=deny_oui_unsupported reason=ebtables_missing"
+fi
+
 # ---------------------------------------------------------
 # 2) NAT: HTTP captive portal (PREROUTING)
 # ---------------------------------------------------------
@@ -544,6 +610,9 @@ fi
 
 iptables -F "$CHAIN_FWD"
 
+# Drop denylisted MACs before anything is allowed
+iptables -A "$CHAIN_FWD" -m set --match-set "$IPSET_DENY_MAC" src -j DROP
+
 # Allow authorized MACs
 iptables -A "$CHAIN_FWD" -m set --match-set "$IPSET_GUEST" src -j ACCEPT
 iptables -A "$CHAIN_FWD" -m set --match-set "$IPSET_STAFF" src -j ACCEPT
@@ -5,7 +5,8 @@
 # Runs on ImmortalWRT (data-plane).
 # - Cleans up portal-related chains/ipsets first
 # - Restores iptables (nat/filter) + ipset from backup
-# - Restores /tmp/portal-runtime.env if present in backup
+# - Restores /tmp/portal-runtime.env and /tmp/portal-runtime.json if
+#   present in backup
 #
 # Logs:
 #   logread -e portal-restore
@@ -16,6 +17,7 @@ set -eu
 TAG="${TAG:-portal-restore}"
 BACKUP_DIR="${BACKUP_DIR:-/tmp/portal-backup}"
 RUNTIME_ENV="${RUNTIME_ENV:-/tmp/portal-runtime.env}"
+RUNTIME_JSON="${RUNTIME_JSON:-/tmp/portal-runtime.json}"
 
 # Portal chain/ipset naming conventions (keep consistent with portal-fw.sh)
 CHAIN_DNS="${CHAIN_DNS:-PORTAL_DNS}"
@@ -92,6 +94,10 @@ if [ -f "${BACKUP_DIR}/portal-runtime.env" ]; then
   cp -f "${BACKUP_DIR}/portal-runtime.env" "$RUNTIME_ENV" 2>/dev/null || true
   log "event=runtime_env_restored path=${RUNTIME_ENV}"
 fi
+if [ -f "${BACKUP_DIR}/portal-runtime.json" ]; then
+  cp -f "${BACKUP_DIR}/portal-runtime.json" "$RUNTIME_JSON" 2>/dev/null || true
+  log "event=runtime_json_restored path=${RUNTIME_JSON}"
+fi
 
 log "event=restore_done"
 exit 0
\ No newline at end of file
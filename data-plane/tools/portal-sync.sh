@@ -11,6 +11,8 @@
 # - Updates per-role ipsets with TTL-aware entries
 # - Implements "minimum survival threshold": remove only after N consecutive misses
 # - Optional: heartbeat linkage to extend sessions (controller-side)
+# - SYNC_MODE=feed: apply the controller client feed
+#   (GET /api/v1/ap/clients?since=<cursor>) instead, as deltas
 #
 # Logs to syslog:
 #   logread -e portal-sync
@@ -27,9 +29,9 @@ RUNTIME_ENV="${RUNTIME_ENV:-/tmp/portal-runtime.env}"
 CTRL_BASE_DEFAULT="${CTRL_BASE_DEFAULT:-http://192.168.16.118:8443}"
 CTRL_BASE="$CTRL_BASE_DEFAULT"
 
-# New Go endpoints (preferred) + legacy fallback
-BATCH_STATUS_PATH="${BATCH_STATUS_PATH:-/api/v1/session/batch_status}"
-BATCH_STATUS_LEGACY_PATH="${BATCH_STATUS_LEGACY_PATH:-/portal/batch_status}"
+# Batch status of this AP's clients (AP caller: client certificate);
+# /portal/batch_status only answers for the signed client itself
+BATCH_STATUS_PATH="${BATCH_STATUS_PATH:-/api/v1/ap/batch_status}"
 
 BATCH_HEARTBEAT_PATH="${BATCH_HEARTBEAT_PATH:-/api/v1/session/batch_heartbeat}"
 HEARTBEAT_LEGACY_PATH="${HEARTBEAT_LEGACY_PATH:-/portal/heartbeat}"
@@ -54,6 +56,16 @@ TTL_MAX_FDB="${TTL_MAX_FDB:-120}"
 # Whether to send heartbeat for authorized clients (0/1)
 ENABLE_HEARTBEAT="${ENABLE_HEARTBEAT:-1}"
 
+# scan = batch status per seen MAC; feed = client feed deltas (both need
+# the client certificate of portal-agent.sh)
+SYNC_MODE="${SYNC_MODE:-scan}"
+CLIENTS_PATH="${CLIENTS_PATH:-/api/v1/ap/clients}"
+FEED_CURSOR_FILE="${FEED_CURSOR_FILE:-/tmp/portal-sync.cursor}"
+FEED_MAX_PAGES="${FEED_MAX_PAGES:-20}"
+CTRL_CERT="${CTRL_CERT:-}"
+CTRL_KEY="${CTRL_KEY:-}"
+CTRL_CA="${CTRL_CA:-}"
+
 # -------- utils --------
 log() { logger -t "$TAG" "$*"; }
 
@@ -86,6 +98,164 @@ norm_mac() {
   echo "$1" | tr 'A-F' 'a-f'
 }
 
+# -----------------------
+# Feed mode: full snapshot into fresh sets (ipset swap), then deltas
+# -----------------------
+get_mtls() {
+  url="$1"
+  set -- -fsS --max-time 4 --cert "$CTRL_CERT" --key "$CTRL_KEY"
+  [ -n "$CTRL_CA" ] && set -- "$@" --cacert "$CTRL_CA"
+  curl "$@" "$url"
+}
+
+# Allow sets the feed manages: the configured ones plus every group the
+# controller has named (kept across runs so a full snapshot empties them)
+FEED_GROUPS_FILE="${FEED_GROUPS_FILE:-/tmp/portal-sync.groups}"
+FEED_TMP_SET="portal_sync_tmp"
+
+feed_groups() {
+  { echo "$IPSET_GUEST"; echo "$IPSET_STAFF"; cat "$FEED_GROUPS_FILE" 2>/dev/null; } | sort -u
+}
+
+# ipset names: at most 31 characters of [A-Za-z0-9_-]
+valid_set() {
+  case "$1" in
+    ''|*[!A-Za-z0-9_-]*) return 1 ;;
+  esac
+  [ "${#1}" -le 31 ]
+}
+
+# feed_entries RESP prints one "op mac group ttl" line per client of a
+# feed response in a single pass: the changes of a delta, or the clients
+# of a full snapshot as "add". Clients without a group go to $IPSET_GUEST.
+feed_entries() {
+  printf '%s\n' "$1" | awk -v guest="$IPSET_GUEST" '
+    function field(o, k,   v) {
+      if (!match(o, "\"" k "\": *\"?[^\",}]*")) return ""
+      v = substr(o, RSTART, RLENGTH)
+      sub("^\"" k "\": *\"?", "", v)
+      return v
+    }
+    {
+      s = $0
+      while (match(s, /"[^"]*": *\[|\{[^{}]*\}/)) {
+        t = substr(s, RSTART, RLENGTH)
+        s = substr(s, RSTART + RLENGTH)
+        if (t ~ /\[$/) {
+          # a snapshot group, or the changes array of a delta
+          g = t; sub(/^"/, "", g); sub(/": *\[$/, "", g)
+          if (g == "changes") g = ""
+          continue
+        }
+        mac = tolower(field(t, "mac"))
+        if (mac !~ /^[0-9a-f][0-9a-f](:[0-9a-f][0-9a-f])(:[0-9a-f][0-9a-f])(:[0-9a-f][0-9a-f])(:[0-9a-f][0-9a-f])(:[0-9a-f][0-9a-f])$/) continue
+        op = field(t, "op"); if (op == "") op = "add"
+        grp = field(t, "group"); if (grp == "") grp = g; if (grp == "") grp = guest
+        ttl = field(t, "ttl") + 0
+        print op, mac, grp, ttl
+      }
+    }'
+}
+
+# feed_full RESP rebuilds every managed set from a snapshot, each in a
+# fresh set swapped in with one ipset restore.
+feed_full() {
+  entries="$TMP_FEED/entries"
+  feed_entries "$1" >"$entries"
+  for g in $(awk '{print $3}' "$entries" | sort -u); do
+    valid_set "$g" || continue
+    grep -qx "$g" "$FEED_GROUPS_FILE" 2>/dev/null || echo "$g" >>"$FEED_GROUPS_FILE"
+  done
+  for set in $(feed_groups); do
+    valid_set "$set" || continue
+    ensure_ipset "$set"
+    ipset destroy "$FEED_TMP_SET" >/dev/null 2>&1 || true
+    {
+      echo "create $FEED_TMP_SET hash:mac timeout 0"
+      awk -v set="$set" -v tmp="$FEED_TMP_SET" '$3 == set && $4 > 0 {print "add " tmp " " $2 " timeout " $4}' "$entries"
+    } | ipset -exist restore || return 1
+    ipset swap "$FEED_TMP_SET" "$set" && ipset destroy "$FEED_TMP_SET"
+  done
+  FEED_ADDED=$((FEED_ADDED + $(awk '$4 > 0' "$entries" | wc -l)))
+}
+
+# feed_delta RESP applies the changes in order with one ipset restore: a
+# client is dropped from every managed set, then added to its own.
+feed_delta() {
+  entries="$TMP_FEED/entries"
+  feed_entries "$1" >"$entries"
+  sets="$(feed_groups)"
+  cmds="$TMP_FEED/cmds"
+  : >"$cmds"
+  while read -r op mac group ttl; do
+    for set in $sets; do
+      valid_set "$set" && echo "del $set $mac" >>"$cmds"
+    done
+    case "$op" in
+      add|refresh)
+        valid_set "$group" && [ "$ttl" -gt 0 ] || continue
+        case " $(echo $sets) " in
+          *" $group "*) ;;
+          *)
+            ensure_ipset "$group"
+            echo "$group" >>"$FEED_GROUPS_FILE"
+            sets="$(feed_groups)"
+            ;;
+        esac
+        echo "add $group $mac timeout $ttl" >>"$cmds"
+        FEED_ADDED=$((FEED_ADDED + 1))
+        ;;
+      remove)
+        FEED_REMOVED=$((FEED_REMOVED + 1))
+        ;;
+    esac
+  done <"$entries"
+  [ -s "$cmds" ] || return 0
+  ipset -exist restore <"$cmds" || log "event=feed_apply_failed"
+}
+
+feed_sync() {
+  [ -n "$CTRL_CERT" ] && [ -n "$CTRL_KEY" ] || {
+    log "event=feed_skip reason=no_client_cert"
+    return 1
+  }
+  cursor="$(cat "$FEED_CURSOR_FILE" 2>/dev/null || true)"
+  TMP_FEED="/tmp/portal-sync.feed.$$"
+  mkdir -p "$TMP_FEED"
+  trap 'rm -rf "$TMP_FEED"' EXIT
+  FEED_ADDED=0
+  FEED_REMOVED=0
+  FEED_MODE=""
+  page=0
+  while [ "$page" -lt "$FEED_MAX_PAGES" ]; do
+    url="${CTRL_BASE}${CLIENTS_PATH}?ap_id=${AP_ID:-}"
+    [ -n "$cursor" ] && url="${url}&since=${cursor}"
+    resp="$(get_mtls "$url" 2>/dev/null || true)"
+    mode="$(printf '%s' "$resp" | jsonfilter -e '@.mode' 2>/dev/null || true)"
+    next="$(printf '%s' "$resp" | jsonfilter -e '@.cursor' 2>/dev/null || true)"
+    [ -n "$mode" ] && [ -n "$next" ] || {
+      log "event=feed_failed ctrl=${CTRL_BASE} cursor=${cursor:-none}"
+      return 1
+    }
+    FEED_MODE="${FEED_MODE:-$mode}"
+    if [ "$mode" = "full" ]; then
+      feed_full "$resp" || return 1
+    else
+      feed_delta "$resp"
+    fi
+    cursor="$next"
+    echo "$cursor" >"$FEED_CURSOR_FILE"
+    page=$((page + 1))
+    [ "$(printf '%s' "$resp" | jsonfilter -e '@.more' 2>/dev/null)" = "true" ] || break
+  done
+  log "event=feed_done mode=${FEED_MODE} cursor=${cursor} pages=${page} added=${FEED_ADDED} removed=${FEED_REMOVED}"
+}
+
+if [ "$SYNC_MODE" = "feed" ]; then
+  feed_sync || exit 1
+  exit 0
+fi
+
 # Pick TTL by source (cap)
 cap_ttl() {
   ttl="$1"
@@ -229,13 +399,15 @@ ENTRIES_JSON="$TMP_DIR/entries.json"
 post_json() {
   url="$1"
   bodyfile="$2"
-  curl -fsS --max-time 4 -H 'Content-Type: application/json' -d @"$bodyfile" "$url"
+  set -- -fsS --max-time 4 -H 'Content-Type: application/json' -d @"$bodyfile"
+  if [ -n "$CTRL_CERT" ] && [ -n "$CTRL_KEY" ]; then
+    set -- "$@" --cert "$CTRL_CERT" --key "$CTRL_KEY"
+    [ -n "$CTRL_CA" ] && set -- "$@" --cacert "$CTRL_CA"
+  fi
+  curl "$@" "$url"
 }
 
 RESP="$(post_json "${CTRL_BASE}${BATCH_STATUS_PATH}" "$ENTRIES_JSON" 2>/dev/null || true)"
-if [ -z "$RESP" ]; then
-  RESP="$(post_json "${CTRL_BASE}${BATCH_STATUS_LEGACY_PATH}" "$ENTRIES_JSON" 2>/dev/null || true)"
-fi
 
 if [ -z "$RESP" ]; then
   log "event=batch_status_failed ctrl=${CTRL_BASE} active=${ACTIVE_COUNT}"